@@ -0,0 +1,120 @@
+// Package analyzer checks the scripts a Go program passes to goeval as string
+// constants, so that malformed inline scripts fail vet in CI rather than
+// evaluations at run time.
+//
+//	go install github.com/zhuyongsheng/goeval/analyzer/cmd/goevalvet
+//	go vet -vettool=$(which goevalvet) ./...
+package analyzer
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/zhuyongsheng/goeval"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+)
+
+const goevalPath = "github.com/zhuyongsheng/goeval"
+
+// Analyzer reports the syntax errors of the constant scripts passed to the
+// methods of goeval.Scope taking a script. With -undefined it also reports
+// the names those scripts use without defining them, for programs that
+// provide every variable through the scope the method is called on.
+var Analyzer = &analysis.Analyzer{
+	Name:     "goeval",
+	Doc:      "check the constant scripts passed to goeval",
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      run,
+}
+
+var undefined bool
+
+func init() {
+	Analyzer.Flags.BoolVar(&undefined, "undefined", false, "also report names the scripts do not define")
+}
+
+// scriptArgs maps the methods of goeval.Scope taking a script to the index of
+// the script argument
+var scriptArgs = map[string]int{
+	"Eval":             0,
+	"EvalContext":      1,
+	"EvalMap":          0,
+	"Compile":          0,
+	"CompilePredicate": 0,
+	"Check":            0,
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	inspect := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+	inspect.Preorder([]ast.Node{(*ast.CallExpr)(nil)}, func(n ast.Node) {
+		call := n.(*ast.CallExpr)
+		arg := scriptArg(pass, call)
+		if arg == nil {
+			return
+		}
+		tv, ok := pass.TypesInfo.Types[arg]
+		if !ok || tv.Value == nil || tv.Value.Kind() != constant.String {
+			return
+		}
+		src := constant.StringVal(tv.Value)
+		for _, d := range goeval.NewScope().Check(src) {
+			if !undefined && strings.HasPrefix(d.Message, "undefined: ") {
+				continue
+			}
+			pass.Reportf(scriptPos(arg, src, d), "goeval script %d:%d: %s", d.Line, d.Column, d.Message)
+		}
+	})
+	return nil, nil
+}
+
+// scriptArg returns the script argument of call when it calls a method of
+// goeval.Scope taking one
+func scriptArg(pass *analysis.Pass, call *ast.CallExpr) ast.Expr {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	fn, ok := pass.TypesInfo.Uses[sel.Sel].(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Pkg().Path() != goevalPath {
+		return nil
+	}
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return nil
+	}
+	if ptr, ok := recv.Type().(*types.Pointer); !ok || ptr.Elem().(*types.Named).Obj().Name() != "Scope" {
+		return nil
+	}
+	i, ok := scriptArgs[fn.Name()]
+	if !ok || i >= len(call.Args) {
+		return nil
+	}
+	return call.Args[i]
+}
+
+// scriptPos locates the diagnostic d in arg when arg is a raw string literal,
+// where the script text sits as is; elsewhere d is reported at arg
+func scriptPos(arg ast.Expr, src string, d goeval.Diagnostic) token.Pos {
+	lit, ok := arg.(*ast.BasicLit)
+	if !ok || !strings.HasPrefix(lit.Value, "`") || strings.Contains(lit.Value, "\r") {
+		return arg.Pos()
+	}
+	offset := 0
+	for line := 1; line < d.Line; line++ {
+		i := strings.IndexByte(src[offset:], '\n')
+		if i < 0 {
+			return arg.Pos()
+		}
+		offset += i + 1
+	}
+	offset += d.Column - 1
+	if offset > len(src) {
+		return arg.Pos()
+	}
+	return lit.Pos() + 1 + token.Pos(offset)
+}
@@ -0,0 +1,14 @@
+// Command goevalvet runs the goeval analyzer, standalone or as a vet tool:
+//
+//	goevalvet ./...
+//	go vet -vettool=$(which goevalvet) ./...
+package main
+
+import (
+	"github.com/zhuyongsheng/goeval/analyzer"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() {
+	singlechecker.Main(analyzer.Analyzer)
+}
@@ -0,0 +1,18 @@
+package a
+
+import (
+	"context"
+
+	"github.com/zhuyongsheng/goeval"
+)
+
+const rule = `amount > 100 &&`
+
+func rules(s *goeval.Scope, dynamic string) {
+	s.Eval(`amount > 100`)
+	s.Eval(rule) // want `goeval script 1:16: expected operand`
+	s.EvalContext(context.Background(), `x := 1
+y := (x`) // want `goeval script 2:8: expected '\)'`
+	s.Eval(dynamic)
+	s.Set("rule", `not a script (`)
+}
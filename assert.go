@@ -0,0 +1,41 @@
+package goeval
+
+import (
+	"fmt"
+	"go/token"
+	"reflect"
+)
+
+// AssertionError is returned by the assert builtins when an assertion fails.
+// Pos is the position of the failing call in the script.
+type AssertionError struct {
+	Message string
+	Pos     token.Pos
+}
+
+func (e *AssertionError) Error() string {
+	return "assertion failed: " + e.Message
+}
+
+// Assert fails with msg, formatted with args, when cond is false
+func Assert(cond bool, msg ...interface{}) error {
+	if cond {
+		return nil
+	}
+	message := "condition is false"
+	if len(msg) > 0 {
+		message = fmt.Sprint(msg...)
+		if format, ok := msg[0].(string); ok && len(msg) > 1 {
+			message = fmt.Sprintf(format, msg[1:]...)
+		}
+	}
+	return &AssertionError{Message: message}
+}
+
+// AssertEqual fails unless got and want are deeply equal
+func AssertEqual(got, want interface{}) error {
+	if reflect.DeepEqual(got, want) {
+		return nil
+	}
+	return &AssertionError{Message: fmt.Sprintf("got %#v, want %#v", got, want)}
+}
@@ -0,0 +1,67 @@
+package goeval
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// EvaluateBatch evaluates program over every row, the fields of the row bound
+// as variables, and returns the results and errors at the index of their row.
+// Only the fields the script reads are bound, which spares copying wide rows;
+// errs is nil when every row succeeded.
+func EvaluateBatch(program *Evaluator, rows []map[string]interface{}) (results []interface{}, errs []error) {
+	return EvaluateBatchParallel(program, rows, 1)
+}
+
+// EvaluateBatchParallel is EvaluateBatch spreading the rows over workers
+// goroutines, each evaluating in a scope of its own. The scope the program
+// was compiled in is shared, so the script must not assign its variables.
+func EvaluateBatchParallel(program *Evaluator, rows []map[string]interface{}, workers int) (results []interface{}, errs []error) {
+	results = make([]interface{}, len(rows))
+	all := make([]error, len(rows))
+	var failed int32
+	run := func(e *Evaluator, next func() (int, bool)) {
+		for i, ok := next(); ok; i, ok = next() {
+			if results[i], all[i] = e.Process(rows[i]); all[i] != nil {
+				atomic.StoreInt32(&failed, 1)
+			}
+		}
+	}
+	if workers <= 1 || len(rows) < 2 {
+		i := -1
+		run(program, func() (int, bool) { i++; return i, i < len(rows) })
+	} else {
+		// rows are handed out in chunks so the workers rarely contend on next
+		const chunk = 64
+		var next int64 = -1
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(e *Evaluator) {
+				defer wg.Done()
+				end := -1
+				var i int
+				run(e, func() (int, bool) {
+					if i++; i >= end {
+						i = int(atomic.AddInt64(&next, chunk)) - chunk + 1
+						end = i + chunk
+					}
+					return i, i < len(rows)
+				})
+			}(program.clone())
+		}
+		wg.Wait()
+	}
+	if failed != 0 {
+		errs = all
+	}
+	return results, errs
+}
+
+// clone returns an Evaluator of the same script with a scope of its own
+func (e *Evaluator) clone() *Evaluator {
+	c := *e
+	c.scope = e.scope.Parent.NewChild()
+	c.scope.record = make([]fieldValue, len(e.fields))
+	return &c
+}
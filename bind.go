@@ -0,0 +1,93 @@
+package goeval
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Bind sets a variable for every exported field of the struct obj, or of the
+// struct it points to. The goeval struct tag renames a field, marks it read-only
+// so that scripts cannot assign it, or skips it:
+//
+//	type Input struct {
+//		Amount float64 `goeval:"amount,readonly"`
+//		Country string `goeval:"country"`
+//		Secret string  `goeval:"-"`
+//	}
+//
+// Fields of embedded structs are bound as if they were fields of obj.
+func (s *Scope) Bind(obj interface{}) error {
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("goeval: Bind needs a struct, not %T", obj)
+	}
+	s.bindStruct(v)
+	return nil
+}
+
+func (s *Scope) bindStruct(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("goeval")
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && tag == "" {
+			embedded := v.Field(i)
+			for embedded.Kind() == reflect.Ptr && !embedded.IsNil() {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				s.bindStruct(embedded)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		name := field.Name
+		parts := strings.Split(tag, ",")
+		if parts[0] != "" {
+			name = parts[0]
+		}
+		s.Vars[name] = v.Field(i).Interface()
+		readonly := false
+		for _, opt := range parts[1:] {
+			if opt == "readonly" {
+				readonly = true
+			}
+		}
+		if readonly {
+			if s.readonly == nil {
+				s.readonly = map[string]bool{}
+			}
+			s.readonly[name] = true
+		} else {
+			delete(s.readonly, name)
+		}
+	}
+}
+
+// checkWritable reports an error if scripts may not assign name: with define set,
+// in s itself, otherwise in the scope holding it
+func (s *Scope) checkWritable(name string, define bool) error {
+	for currentScope := s; define && currentScope != nil; currentScope = currentScope.Parent {
+		if _, exists := currentScope.Vars[name]; exists && currentScope.fromContext {
+			return fmt.Errorf("goeval: cannot redefine context variable %s", name)
+		}
+	}
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		if _, exists := currentScope.Vars[name]; exists || define {
+			if currentScope.readonly[name] || currentScope.frozen {
+				return fmt.Errorf("goeval: cannot assign to read-only variable %s", name)
+			}
+			return nil
+		}
+	}
+	return nil
+}
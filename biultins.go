@@ -3,9 +3,12 @@ package goeval
 import (
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
 )
 
+// the default builtins and types, which the process-wide registry starts
+// with; they are never modified, see RegisterBuiltin
 var (
 	builtins = map[string]interface{}{
 		"nil":    nil,
@@ -14,12 +17,62 @@ var (
 		"append": Append,
 		"make":   Make,
 		"len":    Len,
+		"cap":    Cap,
+		"get":    Get,
+		"equals": reflect.DeepEqual,
+
+		"filter":   Filter,
+		"mapSlice": Map, // map is a keyword
+		"reduce":   Reduce,
+		"sort":     Sort,
+		"sortBy":   SortBy,
+
+		"ternary": Ternary,
+
+		"matches":      Matches,
+		"findAll":      FindAll,
+		"replaceRegex": ReplaceRegex,
+
+		"assert":      Assert,
+		"assertEqual": AssertEqual,
+
+		"builder": NewBuilder,
+		"concat":  Concat,
+
+		"sprintf": fmt.Sprintf,
+		"sprint":  fmt.Sprint,
+		"printf":  fmt.Printf, // writes to Options.Output when set
+
+		"toInt":    ToInt,
+		"toFloat":  ToFloat,
+		"toString": ToString,
+		"toBool":   ToBool,
+
+		"errorf":  Errorf,
+		"wrapErr": WrapErr,
+		"isErr":   IsErr,
+		"asErr":   AsErr,
+
+		"close":       Close,
+		"recvTimeout": RecvTimeout,
+		"sendTimeout": SendTimeout,
+	}
+	// pureBuiltins are the builtins free of side effects, which pure
+	// evaluations may call, see RegisterPure. They are registered as functions,
+	// not names: a builtin replaced with SetBuiltin or RegisterBuiltin is pure
+	// only if its replacement is.
+	pureBuiltins = []interface{}{
+		Len, Cap, Get, reflect.DeepEqual, Make, Concat,
+		Filter, Map, Reduce, Sort, SortBy,
+		Ternary, Matches, FindAll, ReplaceRegex,
+		fmt.Sprintf, fmt.Sprint, ToInt, ToFloat, ToString, ToBool,
+		Errorf, WrapErr, IsErr, AsErr, RuneLen,
 	}
 	builtinTypes = map[string]reflect.Type{
 		"bool":       reflect.TypeOf(true),
 		"byte":       reflect.TypeOf(byte(0)),
 		"rune":       reflect.TypeOf(rune(0)),
-		"string":     reflect.TypeOf(string(0)),
+		"string":     reflect.TypeOf(""),
 		"int":        reflect.TypeOf(0),
 		"int8":       reflect.TypeOf(int8(0)),
 		"int16":      reflect.TypeOf(int16(0)),
@@ -39,80 +92,288 @@ var (
 	}
 )
 
-// Append is a runtime replacement for the append function
+// basicTypes maps the kinds of the predeclared non-interface types to those types
+var basicTypes = map[reflect.Kind]reflect.Type{}
+
+func init() {
+	for _, t := range builtinTypes {
+		if t.Kind() != reflect.Ptr {
+			basicTypes[t.Kind()] = t
+		}
+	}
+}
+
+// underlyingType returns the predeclared type underlying a named basic type,
+// and other types unchanged
+func underlyingType(t reflect.Type) reflect.Type {
+	if basic, ok := basicTypes[t.Kind()]; ok {
+		return basic
+	}
+	return t
+}
+
+// SetBuiltin adds or replaces the builtin name for the scripts run in s and its
+// child scopes, leaving the other scopes of the process alone
+//
+//	s.SetBuiltin("len", func(v interface{}) int { ... })
+func (s *Scope) SetBuiltin(name string, fn interface{}) {
+	if s.ownBuiltins == nil {
+		s.ownBuiltins = map[string]interface{}{}
+	}
+	s.ownBuiltins[name] = fn
+}
+
+// SetBuiltinType adds or replaces the predeclared type name for the scripts run
+// in s and its child scopes
+func (s *Scope) SetBuiltinType(name string, t reflect.Type) {
+	if s.ownTypes == nil {
+		s.ownTypes = map[string]reflect.Type{}
+	}
+	s.ownTypes[name] = t
+}
+
+// DisableBuiltins turns off builtins for the scripts run in s and its child
+// scopes: using one fails with a PolicyError. "go" disables go statements.
+func (s *Scope) DisableBuiltins(names ...string) {
+	for _, name := range names {
+		s.SetBuiltin(name, disabledBuiltin(name))
+	}
+}
+
+// disabledBuiltin marks a builtin turned off by DisableBuiltins
+type disabledBuiltin string
+
+// PolicyError reports a script using a builtin or statement disabled in its scope
+type PolicyError struct {
+	Name string
+}
+
+func (e *PolicyError) Error() string {
+	return "goeval: " + e.Name + " is not allowed in this scope"
+}
+
+// disabled tells whether DisableBuiltins turned name off for s
+func (s *Scope) disabled(name string) bool {
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		if v, ok := currentScope.ownBuiltins[name]; ok {
+			_, off := v.(disabledBuiltin)
+			return off
+		}
+	}
+	return false
+}
+
+// goBuiltins are the builtins named after those of Go, which variables do
+// not shadow
+var goBuiltins = map[string]bool{
+	"nil": true, "true": true, "false": true,
+	"append": true, "make": true, "len": true, "cap": true, "close": true,
+}
+
+// resolvedBuiltin looks up the builtin an unresolved identifier of a script
+// names. Variables of s shadow the builtins other than those of Go, so that
+// the host may use names like get or filter.
+func (s *Scope) resolvedBuiltin(name string) (interface{}, bool) {
+	v, ok := s.builtin(name)
+	if ok && !goBuiltins[name] {
+		if _, shadowed := s.lookup(name); shadowed {
+			return nil, false
+		}
+	}
+	return v, ok
+}
+
+// builtinType looks up a predeclared type, preferring those set on the scope chain
+func (s *Scope) builtinType(name string) (reflect.Type, bool) {
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		if t, ok := currentScope.ownTypes[name]; ok {
+			return t, true
+		}
+	}
+	t, ok := s.currentRegistry().types[name]
+	return t, ok
+}
+
+// builtin looks up a builtin: first those set on the scope chain, then the
+// defaults, honouring the options that alter them
+func (s *Scope) builtin(name string) (interface{}, bool) {
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		if v, ok := currentScope.ownBuiltins[name]; ok {
+			if _, off := v.(disabledBuiltin); off {
+				return func(...interface{}) (interface{}, error) {
+					return nil, &PolicyError{Name: name}
+				}, true
+			}
+			return v, true
+		}
+	}
+	if name == "len" && s.Options.RuneLen {
+		return RuneLen, true
+	}
+	if name == "printf" && s.Options.Output != nil {
+		out := s.Options.Output
+		return func(format string, args ...interface{}) (int, error) {
+			return fmt.Fprintf(out, format, args...)
+		}, true
+	}
+	if name == "log" && s.Options.Logger != nil {
+		return s.logBuiltin, true
+	}
+	if name == "include" && s.Options.Loader != nil {
+		return s.include, true
+	}
+	if name == "env" && s.Options.Env != nil {
+		return s.env, true
+	}
+	if policy := s.Options.HTTP; policy != nil {
+		switch name {
+		case "httpGet":
+			return policy.get, true
+		case "httpPost":
+			return policy.post, true
+		}
+	}
+	if name == "exec" && s.Options.Exec != nil {
+		return s.Options.Exec.run, true
+	}
+	if limits := s.Options.Limits; limits != (Limits{}) {
+		switch name {
+		case "make":
+			return limits.limitedMake, true
+		case "append":
+			return limits.limitedAppend, true
+		case "builder":
+			return limits.limitedBuilder, true
+		case "concat":
+			return limits.limitedConcat, true
+		}
+	}
+	v, ok := s.currentRegistry().funcs[name]
+	return v, ok
+}
+
+// Append is a runtime replacement for the append function. Elements are
+// converted as call arguments are, so an int appends to []interface{} or
+// []float64 and a *T to a slice of an interface T implements. A slice of the
+// same type as arr is appended element by element, like with xs....
 func Append(arr interface{}, elements ...interface{}) (interface{}, error) {
 	v := reflect.ValueOf(arr)
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("goeval: first argument to append must be a slice, not %T", arr)
+	}
+	elemType := v.Type().Elem()
 	for _, e := range elements {
-		switch reflect.TypeOf(arr) {
-		case reflect.SliceOf(reflect.TypeOf(e)), reflect.TypeOf([]interface{}{}):
-			v = reflect.Append(v, reflect.ValueOf(e))
-		case reflect.TypeOf(e):
+		if ev, err := argValue(e, elemType); err == nil {
+			v = reflect.Append(v, ev)
+		} else if e != nil && reflect.TypeOf(e) == v.Type() {
 			v = reflect.AppendSlice(v, reflect.ValueOf(e))
-		default:
-			return v.Interface(), fmt.Errorf("%T cannot append to %T", e, arr)
+		} else {
+			return nil, fmt.Errorf("goeval: cannot append %T to %T", e, arr)
 		}
 	}
 	return v.Interface(), nil
 }
 
-// Make is a runtime replacement for the make function
+// Make is a runtime replacement for the make function. Sizes may be of any
+// integer kind, and t any slice, map or chan type, named ones included.
 func Make(t interface{}, args ...interface{}) (v interface{}, err error) {
 	typ, isType := t.(reflect.Type)
 	if !isType {
-		return nil, fmt.Errorf("invalid type %#v", t)
+		return nil, fmt.Errorf("goeval: make of %#v, not a type", t)
+	}
+	sizes := make([]int, len(args))
+	for i, arg := range args {
+		if sizes[i], err = getInteger(arg); err != nil {
+			return nil, fmt.Errorf("goeval: make %v: %v", typ, err)
+		}
+		if sizes[i] < 0 {
+			return nil, fmt.Errorf("goeval: make %v: negative size %d", typ, sizes[i])
+		}
 	}
 	switch typ.Kind() {
 	case reflect.Slice:
-		if len(args) < 1 || len(args) > 2 {
-			return nil, errors.New("invalid number of arguments for make slice, 1 or 2 needed")
-		}
-		length, err := getInteger(args[0])
-		if err != nil {
-			return nil, err
-		}
-		capacity := length
-		if len(args) == 2 {
-			capacity, err = getInteger(args[1])
-			if err != nil {
-				return nil, err
-			}
+		if len(sizes) < 1 || len(sizes) > 2 {
+			return nil, fmt.Errorf("goeval: make %v needs a length and an optional capacity", typ)
+		}
+		length, capacity := sizes[0], sizes[0]
+		if len(sizes) == 2 {
+			capacity = sizes[1]
+		}
+		if length > capacity {
+			return nil, fmt.Errorf("goeval: make %v: length %d larger than capacity %d", typ, length, capacity)
 		}
 		return reflect.MakeSlice(typ, length, capacity).Interface(), nil
-
-	case reflect.Chan:
-		if len(args) > 1 {
-			return nil, errors.New("too many arguments")
+	case reflect.Chan, reflect.Map:
+		if len(sizes) > 1 {
+			return nil, fmt.Errorf("goeval: make %v takes at most a size", typ)
 		}
 		size := 0
-		if len(args) == 1 {
-			size, err = getInteger(args[0])
-			if err != nil {
-				return nil, err
-			}
+		if len(sizes) == 1 {
+			size = sizes[0]
 		}
-		return reflect.MakeChan(typ, size).Interface(), nil
-	case reflect.Map:
-		size := 0
-		if len(args) > 0 {
-			size, err = getInteger(args[0])
-			if err != nil {
-				return nil, err
-			}
+		if typ.Kind() == reflect.Chan {
+			return reflect.MakeChan(typ, size).Interface(), nil
 		}
 		return reflect.MakeMapWithSize(typ, size).Interface(), nil
 	default:
-		return nil, fmt.Errorf("make unsupported type %T", t)
+		return nil, fmt.Errorf("goeval: cannot make %v", typ)
 	}
 }
 
-// Len is a runtime replacement for the len function
+// Len is a runtime replacement for the len function. Like for nil slices and
+// maps, the length of nil is 0. See RuneLen for the length of strings in runes.
 func Len(v interface{}) (interface{}, error) {
-	return reflect.ValueOf(v).Len(), nil
+	if v == nil {
+		return 0, nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Chan, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len(), nil
+	case reflect.Ptr:
+		if rv.Type().Elem().Kind() == reflect.Array {
+			return rv.Type().Elem().Len(), nil
+		}
+	}
+	return nil, fmt.Errorf("goeval: invalid argument %#v (%T) for len, not a string, slice, array, map or chan", v, v)
+}
+
+// Cap is a runtime replacement for the cap function, 0 for nil
+func Cap(v interface{}) (interface{}, error) {
+	if v == nil {
+		return 0, nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Chan, reflect.Slice:
+		return rv.Cap(), nil
+	case reflect.Ptr:
+		if rv.Type().Elem().Kind() == reflect.Array {
+			return rv.Type().Elem().Len(), nil
+		}
+	}
+	return nil, fmt.Errorf("goeval: invalid argument %#v (%T) for cap, not a slice, array or chan", v, v)
 }
 
+// getInteger returns arg, a value of any integer kind, as an int
 func getInteger(arg interface{}) (int, error) {
 	if i, ok := arg.(int); ok {
 		return i, nil
 	}
-	return 0, errors.New("error not int")
+	v := reflect.ValueOf(arg)
+	switch v.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int()
+		if int64(int(i)) != i {
+			return 0, fmt.Errorf("%d overflows int", i)
+		}
+		return int(i), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > math.MaxInt64 || int64(int(u)) != int64(u) {
+			return 0, fmt.Errorf("%d overflows int", u)
+		}
+		return int(u), nil
+	}
+	return 0, fmt.Errorf("%#v (%T) is not an integer", arg, arg)
 }
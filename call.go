@@ -0,0 +1,278 @@
+package goeval
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// paramType returns the type of the i-th argument of the function type ft,
+// accounting for variadic parameters, or nil when ft takes fewer arguments
+func paramType(ft reflect.Type, i int) reflect.Type {
+	n := ft.NumIn()
+	if ft.IsVariadic() && i >= n-1 {
+		return ft.In(n - 1).Elem()
+	}
+	if i < n {
+		return ft.In(i)
+	}
+	return nil
+}
+
+// argValue prepares an evaluated argument for a parameter of type param.
+// A nil argument becomes the typed zero value of a nilable parameter.
+// A slice such as []interface{} is converted element by element to a slice
+// parameter of another type.
+func argValue(arg interface{}, param reflect.Type) (reflect.Value, error) {
+	if param == nil {
+		return reflect.ValueOf(arg), nil
+	}
+	if arg != nil {
+		v := reflect.ValueOf(arg)
+		i, isInt := arg.(int)
+		switch {
+		case v.Type().AssignableTo(param):
+			return v, nil
+		case v.Kind() == reflect.Slice && param.Kind() == reflect.Slice:
+			return convertSlice(v, param)
+		case isInt && isNumberKind(param):
+			// plain ints behave like untyped constants
+			c, err := convertInt(i, param)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			return reflect.ValueOf(c), nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot use %#v (%T) as %v", arg, arg, param)
+	}
+	switch param.Kind() {
+	case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return reflect.Zero(param), nil
+	}
+	return reflect.Value{}, fmt.Errorf("cannot use nil as %v", param)
+}
+
+// convertSlice converts every element of the slice v to the element type of t
+func convertSlice(v reflect.Value, t reflect.Type) (reflect.Value, error) {
+	out := reflect.MakeSlice(t, v.Len(), v.Len())
+	for i := 0; i < v.Len(); i++ {
+		elem, err := valueAs(v.Index(i).Interface(), t.Elem())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("cannot use %v as %v: element %d: %v", v.Type(), t, i, err)
+		}
+		out.Index(i).Set(elem)
+	}
+	return out, nil
+}
+
+// checkArity verifies that a function of type ft can take n arguments
+func checkArity(ft reflect.Type, n int) error {
+	if ft.IsVariadic() {
+		if n < ft.NumIn()-1 {
+			return fmt.Errorf("not enough arguments, have %d, want at least %d", n, ft.NumIn()-1)
+		}
+		return nil
+	}
+	if n < ft.NumIn() {
+		return fmt.Errorf("not enough arguments, have %d, want %d", n, ft.NumIn())
+	}
+	if n > ft.NumIn() {
+		return fmt.Errorf("too many arguments, have %d, want %d", n, ft.NumIn())
+	}
+	return nil
+}
+
+// callError names the called function and its signature in err
+func callError(call *ast.CallExpr, ft reflect.Type, err error) error {
+	return fmt.Errorf("goeval: calling %s with signature %v: %v", types.ExprString(call.Fun), ft, err)
+}
+
+// argBuffers recycles the argument slices of calls, which reflect copies
+var argBuffers = sync.Pool{New: func() interface{} { return new([]reflect.Value) }}
+
+// putArgs returns the argument slice args, grown from buf, to argBuffers
+func putArgs(buf *[]reflect.Value, args []reflect.Value) {
+	for i := range args {
+		args[i] = reflect.Value{} // not to hold on to the arguments
+	}
+	*buf = args[:0]
+	argBuffers.Put(buf)
+}
+
+// assignedCall is a call whose results are assigned to n variables
+type assignedCall struct {
+	*ast.CallExpr
+	n int
+}
+
+// assignedResults evaluates the single right hand side of an assignment to n
+// variables, a call returning as many results
+func (s *Scope) assignedResults(rh ast.Expr, n int) ([]interface{}, error) {
+	call, isCall := rh.(*ast.CallExpr)
+	if !isCall {
+		return nil, fmt.Errorf("goeval: assignment mismatch: %d variables but 1 value", n)
+	}
+	v, err := s.interpret(&assignedCall{CallExpr: call, n: n})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]interface{}), nil
+}
+
+// call evaluates a call, or conversion. With assigned set, its results are
+// returned as a []interface{} of that many values: all of them, the error
+// included, or all but a trailing error which aborts the evaluation as usual.
+func (s *Scope) call(expr *ast.CallExpr, assigned int) (interface{}, error) {
+	if len(s.Options.Literals) > 0 && assigned == 0 {
+		if v, tagged, err := s.taggedLiteral(expr); tagged {
+			return v, err
+		}
+	}
+	fun, err := s.interpret(expr.Fun)
+	if err != nil {
+		return nil, err
+	}
+	if typ, isType := fun.(reflect.Type); isType {
+		if assigned > 0 {
+			return nil, fmt.Errorf("goeval: assignment mismatch: %d variables but 1 value", assigned)
+		}
+		return s.convert(typ, expr.Args)
+	}
+	if ft := reflect.TypeOf(fun); assigned > 0 && ft != nil && ft.Kind() == reflect.Func {
+		n := ft.NumOut()
+		if n != assigned && (n != assigned+1 || ft.Out(n-1) != errorType) {
+			return nil, fmt.Errorf("goeval: assignment mismatch: %d variables but %s returns %d values", assigned, types.ExprString(expr), n)
+		}
+	}
+	ctx, timeout := s.context(), s.callTimeout(expr, fun)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	buf := argBuffers.Get().(*[]reflect.Value)
+	rf, args, err := s.callArgs(expr, fun, ctx, (*buf)[:0])
+	recycled := true
+	defer func() {
+		if recycled {
+			putArgs(buf, args)
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+	if s.Options.Pure {
+		if err := s.checkPureCall(expr, fun, args); err != nil {
+			return nil, err
+		}
+	}
+	end := s.traceCall(expr.Fun)
+	var out []reflect.Value
+	if timeout > 0 {
+		// an abandoned call may still use its arguments
+		recycled = false
+		out, err = callWithin(ctx, types.ExprString(expr.Fun), rf, args)
+	} else {
+		out, err = callFunc(rf, args)
+	}
+	var result interface{}
+	switch {
+	case err != nil:
+	case assigned > 0 && len(out) == assigned:
+		result = interfaced(out)
+	default:
+		result, err = callResult(rf.Type(), out)
+	}
+	if str, ok := result.(string); ok && err == nil {
+		if err = s.Options.Limits.checkLen(reflect.String, len(str)); err != nil {
+			result = nil
+		}
+	}
+	end(err)
+	if ae, ok := err.(*AssertionError); ok && !ae.Pos.IsValid() {
+		ae.Pos = expr.Pos()
+	}
+	return result, err
+}
+
+// callResults turns the results of a call to a function of type ft into a value.
+// A trailing error result is split off and a non-nil one aborts the evaluation;
+// several remaining results are returned as a []interface{}.
+func callResults(ft reflect.Type, values []interface{}) (interface{}, error) {
+	if n := len(values); n > 0 && ft.Out(n-1) == errorType {
+		if err, _ := values[n-1].(error); err != nil {
+			return nil, err
+		}
+		values = values[:n-1]
+	}
+	switch len(values) {
+	case 0:
+		return nil, nil
+	case 1:
+		return values[0], nil
+	}
+	return values, nil
+}
+
+// callResult is callResults for the reflect values a call returned, sparing
+// the conversion of all of them for the common single result
+func callResult(ft reflect.Type, out []reflect.Value) (interface{}, error) {
+	if len(out) == 1 && ft.Out(0) != errorType {
+		return out[0].Interface(), nil
+	}
+	return callResults(ft, interfaced(out))
+}
+
+// scriptFuncCode is the code pointer shared by the functions reflect.MakeFunc
+// builds, which include every script function
+var scriptFuncCode = reflect.MakeFunc(reflect.TypeOf(func() {}), nil).Pointer()
+
+// callTimeout returns Options.CallTimeout for calls of host functions, and 0
+// for builtins and script functions, which run in the interpreter
+func (s *Scope) callTimeout(call *ast.CallExpr, fun interface{}) time.Duration {
+	timeout := s.Options.CallTimeout
+	if timeout <= 0 {
+		return 0
+	}
+	if ident, ok := call.Fun.(*ast.Ident); ok {
+		if _, isBuiltin := s.resolvedBuiltin(ident.Name); isBuiltin {
+			return 0
+		}
+	}
+	if rf := reflect.ValueOf(fun); rf.Kind() == reflect.Func && rf.Pointer() == scriptFuncCode {
+		return 0
+	}
+	return timeout
+}
+
+// callWithin calls fn in a goroutine, abandoning it when ctx is done first
+func callWithin(ctx context.Context, name string, fn reflect.Value, args []reflect.Value) ([]reflect.Value, error) {
+	type outcome struct {
+		out      []reflect.Value
+		err      error
+		panicked interface{}
+	}
+	done := make(chan outcome, 1) // buffered, so an abandoned call can finish
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- outcome{panicked: r}
+			}
+		}()
+		out, err := callFunc(fn, args)
+		done <- outcome{out: out, err: err}
+	}()
+	select {
+	case o := <-done:
+		if o.panicked != nil {
+			panic(o.panicked) // recovered by the evaluation like any other
+		}
+		return o.out, o.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("goeval: call to %s abandoned: %w", name, ctx.Err())
+	}
+}
@@ -0,0 +1,185 @@
+package goeval
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"reflect"
+	"time"
+)
+
+// chanDirs maps the directions of channel types to reflect's
+var chanDirs = map[ast.ChanDir]reflect.ChanDir{
+	ast.SEND:            reflect.SendDir,
+	ast.RECV:            reflect.RecvDir,
+	ast.SEND | ast.RECV: reflect.BothDir,
+}
+
+// send runs ch <- v, giving up when the context of the evaluation is done
+func (s *Scope) send(stmt *ast.SendStmt) error {
+	ch, err := s.interpret(stmt.Chan)
+	if err != nil {
+		return err
+	}
+	v, err := s.interpret(stmt.Value)
+	if err != nil {
+		return err
+	}
+	cv := reflect.ValueOf(ch)
+	if cv.Kind() != reflect.Chan || cv.Type().ChanDir()&reflect.SendDir == 0 {
+		return fmt.Errorf("goeval: invalid operation: cannot send to %T", ch)
+	}
+	value, err := argValue(v, cv.Type().Elem())
+	if err != nil {
+		return fmt.Errorf("goeval: send: %v", err)
+	}
+	_, _, err = chanOp(s.context(), reflect.SelectCase{Dir: reflect.SelectSend, Chan: cv, Send: value})
+	return err
+}
+
+// chanOp performs a channel operation unless ctx is done first
+func chanOp(ctx context.Context, op reflect.SelectCase) (reflect.Value, bool, error) {
+	v, ok, _, err := chanOpWithin(ctx, op, 0)
+	return v, ok, err
+}
+
+// chanOpWithin is chanOp giving up after timeout as well, when positive
+func chanOpWithin(ctx context.Context, op reflect.SelectCase, timeout time.Duration) (v reflect.Value, ok, timedOut bool, err error) {
+	cases := []reflect.SelectCase{op, {Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}}
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timer.C)})
+	}
+	chosen, v, ok := reflect.Select(cases)
+	switch chosen {
+	case 1:
+		return reflect.Value{}, false, false, ctx.Err()
+	case 2:
+		return reflect.Value{}, false, true, nil
+	}
+	return v, ok, false, nil
+}
+
+// RecvTimeout is the recvTimeout builtin: it receives from ch, waiting at
+// most d, a time.Duration, an integer of nanoseconds or a string like "250ms".
+// ok is false when d elapsed or ch is closed, the value then being the zero
+// value of the element type.
+//
+//	v, ok := recvTimeout(results, "2s")
+func RecvTimeout(ctx context.Context, ch, d interface{}) (interface{}, bool, error) {
+	cv, timeout, err := timedChanArgs("recvTimeout", ch, d, reflect.RecvDir)
+	if err != nil {
+		return nil, false, err
+	}
+	v, ok, _, err := chanOpWithin(ctx, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: cv}, timeout)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		return reflect.Zero(cv.Type().Elem()).Interface(), false, nil
+	}
+	return v.Interface(), true, nil
+}
+
+// SendTimeout is the sendTimeout builtin: it sends v to ch, waiting at most d,
+// and reports whether the value was sent
+//
+//	if !sendTimeout(jobs, job, "100ms") { ... }
+func SendTimeout(ctx context.Context, ch, v, d interface{}) (bool, error) {
+	cv, timeout, err := timedChanArgs("sendTimeout", ch, d, reflect.SendDir)
+	if err != nil {
+		return false, err
+	}
+	value, err := argValue(v, cv.Type().Elem())
+	if err != nil {
+		return false, fmt.Errorf("goeval: sendTimeout: %v", err)
+	}
+	_, _, timedOut, err := chanOpWithin(ctx, reflect.SelectCase{Dir: reflect.SelectSend, Chan: cv, Send: value}, timeout)
+	return err == nil && !timedOut, err
+}
+
+// timedChanArgs checks the channel and duration arguments of the timed
+// channel builtins
+func timedChanArgs(name string, ch, d interface{}, dir reflect.ChanDir) (reflect.Value, time.Duration, error) {
+	cv := reflect.ValueOf(ch)
+	if cv.Kind() != reflect.Chan || cv.Type().ChanDir()&dir == 0 {
+		return cv, 0, fmt.Errorf("goeval: %s on %T, not a channel it can use", name, ch)
+	}
+	timeout, err := toDuration(d)
+	if err != nil {
+		return cv, 0, fmt.Errorf("goeval: %s: %v", name, err)
+	}
+	if timeout <= 0 {
+		return cv, 0, fmt.Errorf("goeval: %s needs a positive duration, not %v", name, timeout)
+	}
+	return cv, timeout, nil
+}
+
+// toDuration takes a time.Duration, an integer of nanoseconds or a string
+// parsed by time.ParseDuration
+func toDuration(d interface{}) (time.Duration, error) {
+	switch v := d.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		return time.ParseDuration(v)
+	}
+	n, err := getInteger(d)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %#v (%T)", d, d)
+	}
+	return time.Duration(n), nil
+}
+
+// Close is the close builtin, closing a channel, and failing rather than
+// panicking when it is closed already
+func Close(ch interface{}) (err error) {
+	cv := reflect.ValueOf(ch)
+	if cv.Kind() != reflect.Chan || cv.Type().ChanDir()&reflect.SendDir == 0 {
+		return fmt.Errorf("goeval: invalid operation: cannot close %T", ch)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("goeval: %v", r)
+		}
+	}()
+	cv.Close()
+	return nil
+}
+
+// ChannelIn creates a channel the host sends values to while scripts receive
+// them from the variable name, eg to stream data into a long evaluation.
+// Closing it ends the range loops of scripts over it.
+//
+//	in := s.ChannelIn("events", 16)
+//	go func() { for e := range source { in <- e }; close(in) }()
+//	s.Eval(`for e := range events { handle(e) }`)
+func (s *Scope) ChannelIn(name string, buffer int) chan<- interface{} {
+	ch := make(chan interface{}, buffer)
+	s.Set(name, (<-chan interface{})(ch))
+	return ch
+}
+
+// ChannelOut creates a channel scripts send values to through the variable
+// name while the host receives them, eg progress events. Scripts may close it.
+func (s *Scope) ChannelOut(name string, buffer int) <-chan interface{} {
+	ch := make(chan interface{}, buffer)
+	s.Set(name, (chan<- interface{})(ch))
+	return ch
+}
+
+// BindChannel exposes a channel of the host with any element type to scripts as
+// the variable name, restricted to dir. What scripts send is converted to the
+// element type like call arguments are, so an int goes into a chan float64.
+func (s *Scope) BindChannel(name string, ch interface{}, dir reflect.ChanDir) error {
+	cv := reflect.ValueOf(ch)
+	if cv.Kind() != reflect.Chan {
+		return fmt.Errorf("goeval: BindChannel of %T, not a channel", ch)
+	}
+	if dir&cv.Type().ChanDir() != dir {
+		return fmt.Errorf("goeval: cannot bind %v as %v", cv.Type(), reflect.ChanOf(dir, cv.Type().Elem()))
+	}
+	s.Set(name, cv.Convert(reflect.ChanOf(dir, cv.Type().Elem())).Interface())
+	return nil
+}
@@ -0,0 +1,58 @@
+package goeval
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Diagnostic is a problem Check finds in a script
+type Diagnostic struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+// Check reports the problems of src found without running it: syntax errors,
+// and names which are neither defined by the script, variables of s, nor
+// builtins. The diagnostics are sorted by position.
+func (s *Scope) Check(src string) []Diagnostic {
+	body, err := s.transformed(parse(src))
+	if err != nil {
+		return syntaxDiagnostics(err)
+	}
+	var diags []Diagnostic
+	for name, pos := range s.freeIdentPos(body) {
+		if _, exists := s.lookup(name); exists {
+			continue
+		}
+		line, column := position(src, pos)
+		diags = append(diags, Diagnostic{Line: line, Column: column, Message: "undefined: " + name})
+	}
+	sort.Slice(diags, func(i, j int) bool {
+		if diags[i].Line != diags[j].Line {
+			return diags[i].Line < diags[j].Line
+		}
+		return diags[i].Column < diags[j].Column
+	})
+	return diags
+}
+
+// syntaxDiagnostics converts the error parse returned, located in the script
+func syntaxDiagnostics(err error) []Diagnostic {
+	list, ok := err.(ErrorList)
+	if !ok {
+		return []Diagnostic{{Line: 1, Column: 1, Message: err.Error()}}
+	}
+	diags := make([]Diagnostic, 0, len(list))
+	for _, e := range list {
+		pe := e.(*PosError)
+		diags = append(diags, Diagnostic{Line: pe.Line, Column: pe.Column, Message: strings.TrimPrefix(pe.Err.Error(), "goeval: ")})
+	}
+	return diags
+}
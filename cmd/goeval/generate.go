@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/zhuyongsheng/goeval"
+)
+
+// generate embeds the .gos scripts of a directory in a Go file, failing on
+// scripts that do not parse, or with -scope, that do not pass vet:
+//
+//	//go:generate goeval generate -o scripts_gen.go ./scripts
+func generate(args []string) int {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	output := flags.String("o", "scripts_gen.go", "the Go file to write")
+	pkg := flags.String("pkg", os.Getenv("GOPACKAGE"), "the package of the Go file, that of the directory of -o by default")
+	prefix := flags.String("prefix", "Script", "the prefix of the constant names")
+	scopeFile := flags.String("scope", "", "JSON object of the variables scripts may use, to vet them")
+	flags.Parse(args)
+	dir := "."
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+	if *pkg == "" {
+		abs, err := filepath.Abs(filepath.Dir(*output))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		*pkg = filepath.Base(abs)
+	}
+	var s *goeval.Scope
+	if *scopeFile != "" {
+		var code int
+		if s, _, code = flagScope("generate", []string{"-scope", *scopeFile}); s == nil {
+			return code
+		}
+	}
+	scripts := map[string]string{}
+	failed := false
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".gos") {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		src := string(b)
+		if _, err := goeval.Format(src); err != nil { // fails on syntax errors
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			failed = true
+		} else if s != nil {
+			for _, d := range s.Check(src) {
+				d.File = path
+				fmt.Fprintln(os.Stderr, d)
+				failed = true
+			}
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		scripts[filepath.ToSlash(rel)] = src
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if failed {
+		return 1
+	}
+	code, err := embedScripts(*pkg, *prefix, scripts)
+	if err == nil {
+		err = ioutil.WriteFile(*output, code, 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
+
+// embedScripts writes the Go file declaring a constant per script, and a map
+// of them all by file name
+func embedScripts(pkg, prefix string, scripts map[string]string) ([]byte, error) {
+	files := make([]string, 0, len(scripts))
+	for file := range scripts {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	names := map[string]string{}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by goeval generate; DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	for _, file := range files {
+		name := prefix + exportedName(strings.TrimSuffix(file, ".gos"))
+		if other, taken := names[name]; taken {
+			return nil, fmt.Errorf("%s and %s both map to %s", other, file, name)
+		}
+		names[name] = file
+		fmt.Fprintf(&buf, "// %s is the source of %s\nconst %s = %s\n\n", name, file, name, strconv.Quote(scripts[file]))
+	}
+	fmt.Fprintf(&buf, "// %ss maps the file names of the scripts to their sources\nvar %ss = map[string]string{\n", prefix, prefix)
+	for _, file := range files {
+		fmt.Fprintf(&buf, "%q: %s,\n", file, prefix+exportedName(strings.TrimSuffix(file, ".gos")))
+	}
+	buf.WriteString("}\n")
+	return format.Source(buf.Bytes())
+}
+
+// exportedName turns a file path like rules/discount_rate into RulesDiscountRate
+func exportedName(path string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range path {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zhuyongsheng/goeval"
+)
+
+// lsp serves the Language Server Protocol over stdin and stdout: diagnostics
+// from Check, hovers from InferType and completions from Complete
+func lsp(args []string) int {
+	s, _, code := flagScope("lsp", args)
+	if s == nil {
+		return code
+	}
+	srv := &lspServer{scope: s, docs: map[string]string{}, out: bufio.NewWriter(os.Stdout)}
+	if err := srv.serve(bufio.NewReader(os.Stdin)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return srv.exitCode
+}
+
+type lspServer struct {
+	scope    *goeval.Scope
+	docs     map[string]string // open documents by URI
+	out      *bufio.Writer
+	shutdown bool
+	exitCode int
+}
+
+type lspMessage struct {
+	ID     *json.RawMessage `json:"id,omitempty"`
+	Method string           `json:"method,omitempty"`
+	Params json.RawMessage  `json:"params,omitempty"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspTextDocumentPosition struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+}
+
+func (srv *lspServer) serve(in *bufio.Reader) error {
+	for {
+		body, err := readMessage(in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg lspMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			if !srv.shutdown {
+				srv.exitCode = 1
+			}
+			return nil
+		}
+		result, err := srv.handle(msg)
+		if msg.ID == nil {
+			continue // a notification
+		}
+		reply := map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID}
+		if err != nil {
+			reply["error"] = map[string]interface{}{"code": -32603, "message": err.Error()}
+		} else {
+			reply["result"] = result
+		}
+		if err := srv.send(reply); err != nil {
+			return err
+		}
+	}
+}
+
+func (srv *lspServer) handle(msg lspMessage) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1, // full documents
+				"hoverProvider":      true,
+				"completionProvider": map[string]interface{}{"triggerCharacters": []string{"."}},
+			},
+			"serverInfo": map[string]string{"name": "goeval"},
+		}, nil
+	case "shutdown":
+		srv.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		return nil, srv.update(p.TextDocument.URI, p.TextDocument.Text)
+	case "textDocument/didChange":
+		var p struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil || len(p.ContentChanges) == 0 {
+			return nil, err
+		}
+		return nil, srv.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var p lspTextDocumentPosition
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		delete(srv.docs, p.TextDocument.URI)
+		return nil, srv.publish(p.TextDocument.URI, nil)
+	case "textDocument/hover":
+		var p lspTextDocumentPosition
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		return srv.hover(p), nil
+	case "textDocument/completion":
+		var p lspTextDocumentPosition
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		return srv.complete(p), nil
+	}
+	if msg.ID != nil {
+		return nil, fmt.Errorf("method %q not supported", msg.Method)
+	}
+	return nil, nil
+}
+
+// update records the text of a document and publishes its diagnostics
+func (srv *lspServer) update(uri, text string) error {
+	srv.docs[uri] = text
+	diags := []map[string]interface{}{}
+	for _, d := range srv.scope.Check(text) {
+		pos := lspPosition{Line: d.Line - 1, Character: d.Column - 1}
+		diags = append(diags, map[string]interface{}{
+			"range":    lspRange{pos, pos},
+			"severity": 1,
+			"source":   "goeval",
+			"message":  d.Message,
+		})
+	}
+	return srv.publish(uri, diags)
+}
+
+func (srv *lspServer) publish(uri string, diags []map[string]interface{}) error {
+	if diags == nil {
+		diags = []map[string]interface{}{}
+	}
+	return srv.send(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "textDocument/publishDiagnostics",
+		"params":  map[string]interface{}{"uri": uri, "diagnostics": diags},
+	})
+}
+
+func (srv *lspServer) hover(p lspTextDocumentPosition) interface{} {
+	text := srv.docs[p.TextDocument.URI]
+	offset := toOffset(text, p.Position)
+	start, end := offset, offset
+	for start > 0 && isSelectorByte(text[start-1]) {
+		start--
+	}
+	for end < len(text) && isSelectorByte(text[end]) && text[end] != '.' {
+		end++
+	}
+	expr := strings.Trim(text[start:end], ".")
+	if expr == "" {
+		return nil
+	}
+	typ, err := goeval.InferType(expr, srv.scope)
+	if err != nil || typ == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"contents": map[string]string{"kind": "markdown", "value": "```go\n" + expr + " " + typ.String() + "\n```"},
+	}
+}
+
+// completionKinds maps goeval completion kinds to those of the protocol
+var completionKinds = map[goeval.CompletionKind]int{
+	goeval.CompleteVariable: 6,
+	goeval.CompleteFunction: 3,
+	goeval.CompleteType:     7,
+	goeval.CompleteField:    5,
+	goeval.CompleteMethod:   2,
+}
+
+func (srv *lspServer) complete(p lspTextDocumentPosition) interface{} {
+	text := srv.docs[p.TextDocument.URI]
+	items := []map[string]interface{}{}
+	for _, c := range srv.scope.Complete(text, toOffset(text, p.Position)) {
+		items = append(items, map[string]interface{}{
+			"label":  c.Label,
+			"kind":   completionKinds[c.Kind],
+			"detail": c.Detail,
+		})
+	}
+	return items
+}
+
+func (srv *lspServer) send(msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(srv.out, "Content-Length: %d\r\n\r\n", len(body))
+	srv.out.Write(body)
+	return srv.out.Flush()
+}
+
+// readMessage reads the body of a message framed by a Content-Length header
+func readMessage(in *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if v := strings.TrimPrefix(line, "Content-Length:"); v != line {
+			if length, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+				return nil, fmt.Errorf("invalid header %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without Content-Length")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(in, body)
+	return body, err
+}
+
+// toOffset converts a protocol position, counted in characters, to a byte
+// offset of text
+func toOffset(text string, pos lspPosition) int {
+	offset := 0
+	for line := 0; line < pos.Line; line++ {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		offset += i + 1
+	}
+	for n := 0; n < pos.Character && offset < len(text) && text[offset] != '\n'; n++ {
+		_, size := utf8.DecodeRuneInString(text[offset:])
+		offset += size
+	}
+	return offset
+}
+
+func isSelectorByte(b byte) bool {
+	return b == '.' || b == '_' || b >= 0x80 || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9'
+}
@@ -0,0 +1,133 @@
+// Command goeval works with goeval scripts.
+//
+//	goeval test [dir]                      run the test_*.gos scripts of dir, default "."
+//	goeval vet [-scope file.json] [dir]    check the .gos scripts under dir, default "."
+//	goeval lsp [-scope file.json]          serve the Language Server Protocol over stdio
+//	goeval generate [-o file.go] [dir]     embed the .gos scripts under dir in a Go file
+//
+// vet prints its findings as a JSON array of {file, line, column, message}
+// and exits with status 1 when there are any. The scope file is a JSON object
+// whose keys are the variables scripts may use, and values examples of them.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zhuyongsheng/goeval"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "test":
+		os.Exit(test(os.Args[2:]))
+	case "vet":
+		os.Exit(vet(os.Args[2:]))
+	case "lsp":
+		os.Exit(lsp(os.Args[2:]))
+	case "generate":
+		os.Exit(generate(os.Args[2:]))
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: goeval test [dir]\n       goeval vet [-scope file.json] [dir]\n       goeval lsp [-scope file.json]\n       goeval generate [-o file.go] [-pkg name] [-prefix Script] [-scope file.json] [dir]")
+	os.Exit(2)
+}
+
+func test(args []string) int {
+	dir := "."
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	results, err := goeval.RunTests(dir, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	failed := 0
+	for _, r := range results {
+		fmt.Println(r)
+		if !r.Passed() {
+			failed++
+		}
+	}
+	fmt.Printf("%d passed, %d failed\n", len(results)-failed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func vet(args []string) int {
+	s, args, code := flagScope("vet", args)
+	if s == nil {
+		return code
+	}
+	dir := "."
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	findings := []goeval.Diagnostic{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".gos") {
+			return err
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, d := range s.Check(string(src)) {
+			d.File = path
+			findings = append(findings, d)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	out.Encode(findings)
+	if len(findings) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// flagScope parses the -scope flag of a command, returning the scope holding
+// the variables of the scope file and the other arguments, or nil and an exit code
+func flagScope(name string, args []string) (*goeval.Scope, []string, int) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	scopeFile := flags.String("scope", "", "JSON object of the variables scripts may use")
+	flags.Parse(args)
+	s := goeval.NewScope()
+	if *scopeFile == "" {
+		return s, flags.Args(), 0
+	}
+	b, err := ioutil.ReadFile(*scopeFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil, 2
+	}
+	var vars map[string]interface{}
+	if err := json.Unmarshal(b, &vars); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *scopeFile, err)
+		return nil, nil, 2
+	}
+	for name, v := range vars {
+		s.Set(name, v)
+	}
+	return s, flags.Args(), 0
+}
@@ -0,0 +1,269 @@
+package goeval
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// EvalCompat evaluates an expression written in the govaluate-like syntax
+// accepted by TranslateCompat
+func (s *Scope) EvalCompat(src string) (interface{}, error) {
+	translated, err := TranslateCompat(src)
+	if err != nil {
+		return nil, err
+	}
+	return s.Eval(translated)
+}
+
+// TranslateCompat rewrites an expression using common non-Go syntax into Go:
+//   - 'single quoted' strings become "double quoted" strings
+//   - a =~ b and a !~ b become matches(a, b) and !matches(a, b)
+//   - c ? a : b becomes ternary(c, a, b); note that both branches are evaluated
+//
+// Everything else is passed through unchanged.
+func TranslateCompat(src string) (string, error) {
+	src, err := doubleQuote(src)
+	if err != nil {
+		return "", err
+	}
+	return translateTokens(src, compatTokens(src))
+}
+
+type compatToken struct {
+	pos, end int
+	text     string
+}
+
+// compatTokens scans src into tokens, merging =~ and !~ into single tokens
+func compatTokens(src string) []compatToken {
+	var sc scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	// ? and ~ are not Go tokens; other scan errors are left to the Go parser
+	sc.Init(file, []byte(src), nil, 0)
+	var toks []compatToken
+	for {
+		pos, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.SEMICOLON && lit == "\n" {
+			continue
+		}
+		text := lit
+		if text == "" {
+			text = tok.String()
+		}
+		off := file.Offset(pos)
+		t := compatToken{pos: off, end: off + len(text), text: text}
+		if n := len(toks); n > 0 && text == "~" && toks[n-1].end == off && (toks[n-1].text == "=" || toks[n-1].text == "!") {
+			toks[n-1].text += "~"
+			toks[n-1].end = t.end
+			continue
+		}
+		toks = append(toks, t)
+	}
+	return toks
+}
+
+// translateTokens translates toks, in order of increasing precedence:
+// ternaries, ||, &&, regex matches, then the operands themselves
+func translateTokens(src string, toks []compatToken) (string, error) {
+	if len(toks) == 0 {
+		return "", nil
+	}
+	if q := findTop(toks, "?"); q >= 0 {
+		colon, nested := -1, 0
+		for i, depth := q+1, 0; i < len(toks) && colon < 0; i++ {
+			switch toks[i].text {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				depth--
+			case "?":
+				if depth == 0 {
+					nested++
+				}
+			case ":":
+				if depth == 0 {
+					if nested == 0 {
+						colon = i
+					}
+					nested--
+				}
+			}
+		}
+		if colon < 0 {
+			return "", fmt.Errorf("goeval: ternary without ':' at offset %d", toks[q].pos)
+		}
+		return translateCall(src, "ternary", toks[:q], toks[q+1:colon], toks[colon+1:])
+	}
+	for _, op := range []string{"||", "&&"} {
+		if parts := splitTop(toks, op); len(parts) > 1 {
+			translated := make([]string, len(parts))
+			for i, part := range parts {
+				t, err := translateTokens(src, part)
+				if err != nil {
+					return "", err
+				}
+				translated[i] = t
+			}
+			return strings.Join(translated, " "+op+" "), nil
+		}
+	}
+	for _, op := range []string{"=~", "!~"} {
+		if i := findTop(toks, op); i >= 0 {
+			call, err := translateCall(src, "matches", toks[:i], toks[i+1:])
+			if op == "!~" {
+				call = "!" + call
+			}
+			return call, err
+		}
+	}
+	return translateOperand(src, toks)
+}
+
+// translateOperand copies toks, translating the arguments of bracketed groups
+func translateOperand(src string, toks []compatToken) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(toks); i++ {
+		if i > 0 {
+			b.WriteString(src[toks[i-1].end:toks[i].pos])
+		}
+		b.WriteString(toks[i].text)
+		if toks[i].text != "(" && toks[i].text != "[" {
+			continue
+		}
+		closing := matchingBracket(toks, i)
+		if closing < 0 {
+			return "", fmt.Errorf("goeval: unbalanced %s at offset %d", toks[i].text, toks[i].pos)
+		}
+		args := splitTop(toks[i+1:closing], ",")
+		for j, arg := range args {
+			t, err := translateTokens(src, arg)
+			if err != nil {
+				return "", err
+			}
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(t)
+		}
+		b.WriteString(toks[closing].text)
+		i = closing
+	}
+	return b.String(), nil
+}
+
+func translateCall(src, fn string, args ...[]compatToken) (string, error) {
+	translated := make([]string, len(args))
+	for i, arg := range args {
+		if len(arg) == 0 {
+			return "", fmt.Errorf("goeval: missing operand for %s", fn)
+		}
+		t, err := translateTokens(src, arg)
+		if err != nil {
+			return "", err
+		}
+		translated[i] = t
+	}
+	return fn + "(" + strings.Join(translated, ", ") + ")", nil
+}
+
+// findTop returns the index of the first op outside brackets, or -1
+func findTop(toks []compatToken, op string) int {
+	depth := 0
+	for i, t := range toks {
+		switch t.text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		case op:
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitTop splits toks around every op outside brackets
+func splitTop(toks []compatToken, op string) [][]compatToken {
+	var parts [][]compatToken
+	for i := findTop(toks, op); i >= 0; i = findTop(toks, op) {
+		parts = append(parts, toks[:i])
+		toks = toks[i+1:]
+	}
+	if len(toks) > 0 || len(parts) > 0 {
+		parts = append(parts, toks)
+	}
+	return parts
+}
+
+// matchingBracket returns the index of the bracket closing toks[open], or -1
+func matchingBracket(toks []compatToken, open int) int {
+	depth := 0
+	for i := open; i < len(toks); i++ {
+		switch toks[i].text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// doubleQuote rewrites 'single quoted' strings of src as Go string literals
+func doubleQuote(src string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; c {
+		case '"', '`':
+			end := i + 1
+			for end < len(src) && src[end] != c {
+				if c == '"' && src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return "", fmt.Errorf("goeval: unterminated string at offset %d", i)
+			}
+			b.WriteString(src[i : end+1])
+			i = end
+		case '\'':
+			var str strings.Builder
+			end := i + 1
+			for ; end < len(src) && src[end] != '\''; end++ {
+				if src[end] == '\\' && end+1 < len(src) && src[end+1] == '\'' {
+					end++
+				}
+				str.WriteByte(src[end])
+			}
+			if end >= len(src) {
+				return "", fmt.Errorf("goeval: unterminated string at offset %d", i)
+			}
+			b.WriteString(strconv.Quote(str.String()))
+			i = end
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// Ternary returns a when cond is true, else b
+func Ternary(cond bool, a, b interface{}) interface{} {
+	if cond {
+		return a
+	}
+	return b
+}
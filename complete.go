@@ -0,0 +1,194 @@
+package goeval
+
+import (
+	"go/scanner"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// CompletionKind classifies a completion
+type CompletionKind int
+
+const (
+	CompleteVariable CompletionKind = iota
+	CompleteFunction
+	CompleteType
+	CompleteField
+	CompleteMethod
+)
+
+// Completion is a name that can be typed at a position of a script
+type Completion struct {
+	Label  string
+	Kind   CompletionKind
+	Detail string // the type of the value, when known
+}
+
+// optionBuiltins are the builtins only some options provide
+var optionBuiltins = []string{"log", "include", "env", "httpGet", "httpPost", "exec"}
+
+// Complete lists the names that can complete the identifier ending at offset
+// in src: after a selector, the fields and methods of the value or the members
+// of the namespace, otherwise the variables of s and of the script, the
+// builtins and the types. Completions are sorted by label.
+func (s *Scope) Complete(src string, offset int) []Completion {
+	if offset < 0 || offset > len(src) {
+		return nil
+	}
+	start := offset
+	for start > 0 && isIdentByte(src[start-1]) {
+		start--
+	}
+	prefix := src[start:offset]
+	var all []Completion
+	if start > 0 && src[start-1] == '.' {
+		all = s.memberCompletions(selectorBefore(src, start-1))
+	} else {
+		all = s.nameCompletions(src)
+	}
+	var matches []Completion
+	seen := map[string]bool{}
+	for _, c := range all {
+		if strings.HasPrefix(c.Label, prefix) && !seen[c.Label] {
+			seen[c.Label] = true
+			matches = append(matches, c)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Label < matches[j].Label })
+	return matches
+}
+
+// selectorBefore returns the selector expression ending at end, eg a.b for "x := a.b."
+func selectorBefore(src string, end int) string {
+	start := end
+	for start > 0 && (isIdentByte(src[start-1]) || src[start-1] == '.') {
+		start--
+	}
+	return src[start:end]
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' || b >= 0x80 || unicode.IsLetter(rune(b)) || unicode.IsDigit(rune(b))
+}
+
+// memberCompletions lists the members of the value expr evaluates to
+func (s *Scope) memberCompletions(expr string) []Completion {
+	if expr == "" {
+		return nil
+	}
+	if v, ok := s.lookupPath(expr); ok {
+		if ns, isNs := v.(Namespace); isNs {
+			var out []Completion
+			for name, member := range ns {
+				out = append(out, valueCompletion(name, member))
+			}
+			return out
+		}
+	}
+	typ, err := InferType(expr, s)
+	if err != nil || typ == nil {
+		return nil
+	}
+	var out []Completion
+	for i := 0; i < typ.NumMethod(); i++ {
+		m := typ.Method(i)
+		out = append(out, Completion{Label: m.Name, Kind: CompleteMethod, Detail: m.Type.String()})
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() == reflect.Struct {
+		for i := 0; i < typ.NumField(); i++ {
+			if f := typ.Field(i); f.PkgPath == "" {
+				out = append(out, Completion{Label: f.Name, Kind: CompleteField, Detail: f.Type.String()})
+			}
+		}
+	}
+	return out
+}
+
+// nameCompletions lists the names usable in src: its variables, those of s,
+// the builtins and the types
+func (s *Scope) nameCompletions(src string) []Completion {
+	var out []Completion
+	for _, name := range scriptDefined(src) {
+		out = append(out, Completion{Label: name, Kind: CompleteVariable})
+	}
+	names := map[string]bool{}
+	for _, name := range optionBuiltins {
+		names[name] = true
+	}
+	reg := s.currentRegistry()
+	for name := range reg.funcs {
+		names[name] = true
+	}
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		for name, v := range currentScope.Vars {
+			out = append(out, valueCompletion(name, v))
+		}
+		for name := range currentScope.ownBuiltins {
+			names[name] = true
+		}
+		for name := range currentScope.ownTypes {
+			out = append(out, Completion{Label: name, Kind: CompleteType})
+		}
+	}
+	for name := range names {
+		if v, ok := s.builtin(name); ok && !s.disabled(name) {
+			out = append(out, valueCompletion(name, v))
+		}
+	}
+	for name := range reg.types {
+		out = append(out, Completion{Label: name, Kind: CompleteType})
+	}
+	return out
+}
+
+func valueCompletion(name string, v interface{}) Completion {
+	c := Completion{Label: name, Kind: CompleteVariable}
+	if _, isType := v.(reflect.Type); isType {
+		c.Kind = CompleteType
+		return c
+	}
+	if t := reflect.TypeOf(v); t != nil {
+		c.Detail = t.String()
+		if t.Kind() == reflect.Func {
+			c.Kind = CompleteFunction
+		}
+	}
+	return c
+}
+
+// scriptDefined scans src, which may not parse while being typed, for the
+// names it declares with :=, var, const or type
+func scriptDefined(src string) []string {
+	var sc scanner.Scanner
+	fset := token.NewFileSet()
+	sc.Init(fset.AddFile("", fset.Base(), len(src)), []byte(src), nil, 0)
+	var names []string
+	var pending []string // an identifier list, until the token after it
+	prev := token.ILLEGAL
+	for {
+		_, tok, lit := sc.Scan()
+		switch {
+		case tok == token.EOF:
+			return names
+		case tok == token.IDENT && (prev == token.VAR || prev == token.CONST || prev == token.TYPE):
+			names = append(names, lit)
+		case tok == token.IDENT && (prev == token.COMMA && len(pending) > 0 || prev != token.PERIOD):
+			if prev != token.COMMA {
+				pending = pending[:0]
+			}
+			pending = append(pending, lit)
+		case tok == token.DEFINE:
+			names = append(names, pending...)
+			pending = pending[:0]
+		case tok != token.COMMA:
+			pending = pending[:0]
+		}
+		prev = tok
+	}
+}
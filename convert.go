@@ -0,0 +1,168 @@
+package goeval
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ToInt converts numbers, numeric strings and bools to an int.
+// Floats are truncated toward zero.
+func ToInt(v interface{}) (interface{}, error) {
+	if i, isInt := asInt(v); isInt {
+		return i, nil
+	}
+	switch x := v.(type) {
+	case float32, float64:
+		f, _ := asFloat(x)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, fmt.Errorf("cannot convert %v to int", f)
+		}
+		return int(f), nil
+	case bool:
+		if x {
+			return 1, nil
+		}
+		return 0, nil
+	case json.Number:
+		return ToInt(string(x))
+	case string:
+		s := strings.TrimSpace(x)
+		if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return int(i), nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return ToInt(f)
+		}
+		return 0, fmt.Errorf("cannot convert %q to int", x)
+	}
+	return 0, fmt.Errorf("cannot convert %T to int", v)
+}
+
+// ToFloat converts numbers, numeric strings and bools to a float64
+func ToFloat(v interface{}) (interface{}, error) {
+	if f, ok := asFloat(v); ok {
+		return f, nil
+	}
+	switch x := v.(type) {
+	case bool:
+		if x {
+			return 1.0, nil
+		}
+		return 0.0, nil
+	case json.Number:
+		return ToFloat(string(x))
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		if err != nil {
+			return 0.0, fmt.Errorf("cannot convert %q to float", x)
+		}
+		return f, nil
+	}
+	return 0.0, fmt.Errorf("cannot convert %T to float", v)
+}
+
+// ToString formats any value as a string. Byte slices are taken as text.
+func ToString(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case []byte:
+		return string(x), nil
+	case float32:
+		return strconv.FormatFloat(float64(x), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), nil
+	case fmt.Stringer:
+		return x.String(), nil
+	case error:
+		return x.Error(), nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+// ToBool converts bools, numbers and strings such as "true", "yes" or "0" to a bool
+func ToBool(v interface{}) (interface{}, error) {
+	if f, ok := asFloat(v); ok {
+		return f != 0, nil
+	}
+	switch x := v.(type) {
+	case bool:
+		return x, nil
+	case json.Number:
+		return ToBool(string(x))
+	case string:
+		switch strings.ToLower(strings.TrimSpace(x)) {
+		case "1", "t", "true", "y", "yes", "on":
+			return true, nil
+		case "", "0", "f", "false", "n", "no", "off":
+			return false, nil
+		}
+		return false, fmt.Errorf("cannot convert %q to bool", x)
+	}
+	return false, fmt.Errorf("cannot convert %T to bool", v)
+}
+
+// valueAs converts v to a value of type t, allowing nil and numeric conversions
+func valueAs(v interface{}, t reflect.Type) (reflect.Value, error) {
+	if v == nil {
+		if !isNil(reflect.Zero(t).Interface()) {
+			return reflect.Value{}, fmt.Errorf("goeval: cannot use nil as %v", t)
+		}
+		return reflect.Zero(t), nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Type().AssignableTo(t) {
+		if t.Kind() == reflect.Chan {
+			// keeps the direction t restricts the channel to
+			return rv.Convert(t), nil
+		}
+		return rv, nil
+	}
+	if isNumberKind(rv.Type()) && isNumberKind(t) && rv.Type().ConvertibleTo(t) {
+		return rv.Convert(t), nil
+	}
+	return reflect.Value{}, fmt.Errorf("goeval: cannot use %#v as %v", v, t)
+}
+
+// EvalAs evaluates src and converts the result to target, eg to guarantee a bool
+// for conditions or a float64 for scores. Numbers convert between kinds only
+// when their value is preserved, so 2.0 becomes int 2 but 2.5 is an error.
+//
+//	v, err := s.EvalAs(cond, reflect.TypeOf(false))
+//	ok := err == nil && v.(bool)
+func (s *Scope) EvalAs(src string, target reflect.Type) (interface{}, error) {
+	result, err := s.Eval(src)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, fmt.Errorf("goeval: EvalAs needs a target type")
+	}
+	if result == nil {
+		if isNil(reflect.Zero(target).Interface()) {
+			return reflect.Zero(target).Interface(), nil
+		}
+		return nil, fmt.Errorf("goeval: result is nil, want %v", target)
+	}
+	rv := reflect.ValueOf(result)
+	if rv.Type().AssignableTo(target) {
+		v := reflect.New(target).Elem()
+		v.Set(rv)
+		return v.Interface(), nil
+	}
+	if isNumberKind(rv.Type()) && isNumberKind(target) && rv.Type().ConvertibleTo(target) {
+		converted := rv.Convert(target)
+		if back := converted.Convert(rv.Type()); back.Interface() == rv.Interface() {
+			return converted.Interface(), nil
+		}
+		return nil, fmt.Errorf("goeval: result %v (%v) cannot be represented as %v", result, rv.Type(), target)
+	}
+	if _, multi := result.([]interface{}); multi && target.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("goeval: script returns several values, want a single %v", target)
+	}
+	return nil, fmt.Errorf("goeval: result %#v (%v) is not a %v", result, rv.Type(), target)
+}
@@ -0,0 +1,158 @@
+package goeval
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrCoroutineClosed aborts a script whose Coroutine was closed while suspended
+var ErrCoroutineClosed = errors.New("goeval: coroutine closed")
+
+// CoroutineState is the serializable state of a suspended Coroutine: its
+// source and the values it was resumed with so far. Restoring it replays the
+// script from the start, feeding the recorded values to its yields, so the
+// script and the host functions it calls must behave deterministically.
+//
+// Serialized as JSON, the resumes come back with the types of JSON: numbers
+// as float64s, objects as map[string]interface{} and arrays as
+// []interface{}. Scripts resumed with other values convert them, with toInt
+// say, so that they run the same when restored.
+type CoroutineState struct {
+	Source  string        `json:"source"`
+	Resumes []interface{} `json:"resumes"`
+}
+
+// Coroutine is a script that can suspend itself with the yield builtin,
+// handing a value to the host, and be resumed later with a value that yield
+// returns to the script. Its goroutine counts among those of the scope tree
+// until the script ends: Kill or the end of the scope context makes a
+// suspended script fail with the context error, and Wait waits for it.
+type Coroutine struct {
+	state   CoroutineState
+	yielded interface{}
+	done    bool
+	result  interface{}
+	err     error
+
+	yields  chan interface{}
+	resumes chan interface{}
+	closed  chan struct{}
+	ended   chan struct{}
+}
+
+// Start runs src in a child scope of s until its first yield or its end
+func (s *Scope) Start(src string) (*Coroutine, error) {
+	return s.Restore(CoroutineState{Source: src})
+}
+
+// Restore recreates a coroutine from its state, replaying the recorded
+// resumes, and runs it until it suspends at a new yield or ends
+func (s *Scope) Restore(state CoroutineState) (*Coroutine, error) {
+	body, err := s.transformed(parse(state.Source))
+	if err != nil {
+		return nil, err
+	}
+	c := &Coroutine{
+		state:   CoroutineState{Source: state.Source},
+		yields:  make(chan interface{}),
+		resumes: make(chan interface{}),
+		closed:  make(chan struct{}),
+		ended:   make(chan struct{}),
+	}
+	replay := state.Resumes
+	ctx, cancel := context.WithCancel(s.context())
+	tg := s.taskGroup()
+	tg.mu.Lock()
+	id := tg.next
+	tg.next++
+	tg.cancels[id] = cancel
+	tg.wg.Add(1)
+	tg.mu.Unlock()
+	child := s.NewChild()
+	child.Vars["yield"] = func(v interface{}) (interface{}, error) {
+		if len(replay) > 0 {
+			resumed := replay[0]
+			replay = replay[1:]
+			c.state.Resumes = append(c.state.Resumes, resumed)
+			return resumed, nil
+		}
+		select {
+		case c.yields <- v:
+		case <-c.closed:
+			return nil, ErrCoroutineClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		select {
+		case resumed := <-c.resumes:
+			return resumed, nil
+		case <-c.closed:
+			return nil, ErrCoroutineClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	go func() {
+		defer tg.done(id)
+		c.result, c.err = child.run(body)
+		close(c.ended)
+	}()
+	c.wait()
+	return c, nil
+}
+
+// wait blocks until the script yields or ends
+func (c *Coroutine) wait() {
+	select {
+	case c.yielded = <-c.yields:
+	case <-c.ended:
+		c.done, c.yielded = true, nil
+	}
+}
+
+// Yielded returns the value passed to the pending yield, and false once the script ended
+func (c *Coroutine) Yielded() (interface{}, bool) {
+	return c.yielded, !c.done
+}
+
+// Done reports whether the script ran to its end
+func (c *Coroutine) Done() bool {
+	return c.done
+}
+
+// Result returns the outcome of the script once it is done
+func (c *Coroutine) Result() (interface{}, error) {
+	return c.result, c.err
+}
+
+// Resume continues a suspended script, making the pending yield return v,
+// until the script yields again or ends
+func (c *Coroutine) Resume(v interface{}) error {
+	if c.done {
+		return errors.New("goeval: coroutine already done")
+	}
+	select {
+	case c.resumes <- v:
+		c.state.Resumes = append(c.state.Resumes, v)
+		c.wait()
+	case <-c.ended: // killed while suspended
+		c.done, c.yielded = true, nil
+	}
+	return nil
+}
+
+// State returns the serializable state of the coroutine
+func (c *Coroutine) State() CoroutineState {
+	state := c.state
+	state.Resumes = append([]interface{}(nil), c.state.Resumes...)
+	return state
+}
+
+// Close abandons a suspended script, which then fails with ErrCoroutineClosed
+func (c *Coroutine) Close() {
+	if !c.done {
+		close(c.closed)
+		<-c.ended
+		c.done = true
+	}
+}
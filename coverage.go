@@ -0,0 +1,91 @@
+package goeval
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strings"
+	"sync"
+)
+
+// Coverage records which statements of a script run. Set it as
+// Options.Coverage of the scope evaluating the script, then ask for a Report.
+// One Coverage should track a single script source, as statements are keyed
+// by position.
+type Coverage struct {
+	mu   sync.Mutex
+	hits map[token.Pos]int
+}
+
+// NewCoverage creates an empty coverage recorder
+func NewCoverage() *Coverage {
+	return &Coverage{hits: map[token.Pos]int{}}
+}
+
+func (c *Coverage) hit(pos token.Pos) {
+	c.mu.Lock()
+	c.hits[pos]++
+	c.mu.Unlock()
+}
+
+// CoverageBlock is a statement of a covered script and how often it ran.
+// Blocks stand for the branches of if, for and range statements.
+type CoverageBlock struct {
+	Line, Column int
+	Count        int
+}
+
+// CoverageReport lists every statement of a script with its execution count
+type CoverageReport struct {
+	Blocks []CoverageBlock
+}
+
+// Report matches the recorded executions against the statements of src
+func (c *Coverage) Report(src string) (*CoverageReport, error) {
+	body, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	report := &CoverageReport{}
+	ast.Inspect(body, func(n ast.Node) bool {
+		stmt, ok := n.(ast.Stmt)
+		if !ok || n == body {
+			return true
+		}
+		if decl, ok := stmt.(*ast.DeclStmt); ok {
+			if gen, ok := decl.Decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+				return false
+			}
+		}
+		line, column := position(src, stmt.Pos())
+		report.Blocks = append(report.Blocks, CoverageBlock{Line: line, Column: column, Count: c.hits[stmt.Pos()]})
+		return true
+	})
+	return report, nil
+}
+
+// Percent is the share of statements that ran at least once
+func (r *CoverageReport) Percent() float64 {
+	if len(r.Blocks) == 0 {
+		return 100
+	}
+	covered := 0
+	for _, b := range r.Blocks {
+		if b.Count > 0 {
+			covered++
+		}
+	}
+	return 100 * float64(covered) / float64(len(r.Blocks))
+}
+
+// String renders one "line:column count" entry per statement, then the total
+func (r *CoverageReport) String() string {
+	var b strings.Builder
+	for _, block := range r.Blocks {
+		fmt.Fprintf(&b, "%d:%d %d\n", block.Line, block.Column, block.Count)
+	}
+	fmt.Fprintf(&b, "coverage: %.1f%% of statements\n", r.Percent())
+	return b.String()
+}
@@ -0,0 +1,51 @@
+package goeval
+
+import (
+	"context"
+	"fmt"
+)
+
+type contextVarsKey struct{}
+
+// ContextWithVars returns a copy of ctx carrying vars, on top of those ctx
+// already carries. EvalContext binds them as read-only variables for the
+// evaluation only, eg the request ID, tenant or user claims of a request.
+// Scripts can neither assign nor redefine them.
+//
+//	ctx = goeval.ContextWithVars(ctx, map[string]interface{}{"tenant": tenant})
+//	allowed, err := s.EvalContext(ctx, `tenant == "acme" && amount < 100`)
+func ContextWithVars(ctx context.Context, vars map[string]interface{}) context.Context {
+	merged := map[string]interface{}{}
+	for k, v := range contextVars(ctx) {
+		merged[k] = v
+	}
+	for k, v := range vars {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, contextVarsKey{}, merged)
+}
+
+// contextVars returns the vars ctx carries, which must not be modified
+func contextVars(ctx context.Context) map[string]interface{} {
+	vars, _ := ctx.Value(contextVarsKey{}).(map[string]interface{})
+	return vars
+}
+
+// contextLayer returns a scope holding vars, to insert between s and its
+// parent. A variable of s with the same name would hide one of them, which
+// is an error.
+func (s *Scope) contextLayer(vars map[string]interface{}) (*Scope, error) {
+	for name := range vars {
+		if _, exists := s.lookup(name); exists {
+			return nil, fmt.Errorf("goeval: context variable %s is hidden by a variable of the scope", name)
+		}
+	}
+	return &Scope{
+		Vars:        vars,
+		Parent:      s.Parent,
+		Options:     s.Options,
+		tasks:       s.heldTasks(),
+		frozen:      true,
+		fromContext: true,
+	}, nil
+}
@@ -0,0 +1,143 @@
+package goeval
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// FuncDescription describes a function scripts can call, eg for a rule builder
+// to render a palette of functions with argument hints
+type FuncDescription struct {
+	Name     string // dotted for the functions of namespaces
+	Doc      string // as given to RegisterFunc
+	Params   []Param
+	Results  []string // the result types
+	Variadic bool     // the last parameter takes any number of arguments
+	Builtin  bool
+}
+
+// Param is a parameter of a described function. Its name is known when given
+// to RegisterFunc, and its type is the element type for a variadic one.
+type Param struct {
+	Name string
+	Type string
+}
+
+// funcDoc is what RegisterFunc records besides the function
+type funcDoc struct {
+	doc    string
+	params []string
+}
+
+// RegisterFunc registers fn under a dotted path like Register, along with a
+// doc string and the names of its parameters for Describe. A function taking
+// a context first, which scripts do not pass, is documented without it.
+func (s *Scope) RegisterFunc(path string, fn interface{}, doc string, params ...string) error {
+	typ := reflect.TypeOf(fn)
+	if typ == nil || typ.Kind() != reflect.Func {
+		return fmt.Errorf("goeval: %s is a %T, not a function", path, fn)
+	}
+	if n := len(scriptParams(typ)); len(params) > 0 && len(params) != n {
+		return fmt.Errorf("goeval: %s takes %d parameters, %d names given", path, n, len(params))
+	}
+	if err := s.Register(path, fn); err != nil {
+		return err
+	}
+	if s.docs == nil {
+		s.docs = map[string]funcDoc{}
+	}
+	s.docs[path] = funcDoc{doc: doc, params: params}
+	return nil
+}
+
+// Describe describes the function name refers to in s, a variable, a
+// dotted path into namespaces or a builtin, and false if it is not a function
+func (s *Scope) Describe(name string) (*FuncDescription, bool) {
+	// resolved as when scripts name it
+	v, builtin := s.resolvedBuiltin(name)
+	if !builtin || s.disabled(name) {
+		var ok bool
+		if v, ok = s.lookupPath(name); !ok {
+			return nil, false
+		}
+		builtin = false
+	}
+	typ := reflect.TypeOf(v)
+	if typ == nil || typ.Kind() != reflect.Func {
+		return nil, false
+	}
+	d := &FuncDescription{Name: name, Variadic: typ.IsVariadic(), Builtin: builtin}
+	var doc funcDoc
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		if found, ok := currentScope.docs[name]; ok {
+			doc = found
+			break
+		}
+	}
+	d.Doc = doc.doc
+	params := scriptParams(typ)
+	for i, in := range params {
+		p := Param{Type: in.String()}
+		if d.Variadic && i == len(params)-1 {
+			p.Type = in.Elem().String()
+		}
+		if i < len(doc.params) {
+			p.Name = doc.params[i]
+		}
+		d.Params = append(d.Params, p)
+	}
+	for i := 0; i < typ.NumOut(); i++ {
+		d.Results = append(d.Results, typ.Out(i).String())
+	}
+	return d, true
+}
+
+// ListFunctions describes the functions scripts can call in s, the variables,
+// the members of namespaces and the builtins, sorted by name
+func (s *Scope) ListFunctions() []FuncDescription {
+	names := map[string]bool{}
+	var collect func(prefix string, vars map[string]interface{})
+	collect = func(prefix string, vars map[string]interface{}) {
+		for name, v := range vars {
+			if ns, ok := v.(Namespace); ok {
+				collect(prefix+name+".", ns)
+			} else if typ := reflect.TypeOf(v); typ != nil && typ.Kind() == reflect.Func {
+				names[prefix+name] = true
+			}
+		}
+	}
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		collect("", currentScope.Vars)
+		for name := range currentScope.ownBuiltins {
+			names[name] = true
+		}
+	}
+	for _, name := range optionBuiltins {
+		names[name] = true
+	}
+	for name := range s.currentRegistry().funcs {
+		names[name] = true
+	}
+	var out []FuncDescription
+	for name := range names {
+		if d, ok := s.Describe(name); ok {
+			out = append(out, *d)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// scriptParams lists the parameters of a function type scripts pass, leaving
+// out a leading context
+func scriptParams(typ reflect.Type) []reflect.Type {
+	params := make([]reflect.Type, 0, typ.NumIn())
+	for i := 0; i < typ.NumIn(); i++ {
+		if i == 0 && typ.In(0) == contextType {
+			continue
+		}
+		params = append(params, typ.In(i))
+	}
+	return params
+}
@@ -0,0 +1,174 @@
+package goeval
+
+import (
+	"reflect"
+	"sort"
+)
+
+// ChangeKind tells how a variable differs between two scopes
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota
+	Removed
+	Modified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	}
+	return "unknown"
+}
+
+// Change describes one variable that differs between two scopes
+type Change struct {
+	Name string
+	Kind ChangeKind
+	Old  interface{} // nil when Added
+	New  interface{} // nil when Removed
+}
+
+// Snapshot copies every variable visible from the scope into a new standalone
+// scope. Maps and slices are copied too, so later in-place updates show up in Diff.
+func (s *Scope) Snapshot() *Scope {
+	snap := NewScope()
+	snap.Options = s.Options
+	for _, name := range s.Keys() {
+		val := s.Get(name)
+		if val != nil {
+			val = deepCopy(reflect.ValueOf(val)).Interface()
+		}
+		snap.Vars[name] = val
+	}
+	return snap
+}
+
+// Diff lists the variables added, removed and modified between before and
+// after, typically a Snapshot taken before running a script and the scope
+// afterwards. Changes are sorted by name.
+func Diff(before, after *Scope) []Change {
+	var changes []Change
+	for _, name := range before.Keys() {
+		old, _ := before.lookup(name)
+		if val, exists := after.lookup(name); !exists {
+			changes = append(changes, Change{Name: name, Kind: Removed, Old: old})
+		} else if !sameValue(reflect.ValueOf(old), reflect.ValueOf(val)) {
+			changes = append(changes, Change{Name: name, Kind: Modified, Old: old, New: val})
+		}
+	}
+	for _, name := range after.Keys() {
+		if _, exists := before.lookup(name); !exists {
+			val, _ := after.lookup(name)
+			changes = append(changes, Change{Name: name, Kind: Added, New: val})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// sameValue is reflect.DeepEqual, except that functions are equal when they
+// share their code, so that scopes holding host functions compare as expected
+func sameValue(a, b reflect.Value) bool {
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Func:
+		return a.Pointer() == b.Pointer()
+	case reflect.Interface:
+		return sameValue(a.Elem(), b.Elem())
+	case reflect.Map:
+		if a.IsNil() != b.IsNil() || a.Len() != b.Len() {
+			return false
+		}
+		for _, key := range a.MapKeys() {
+			if !sameValue(a.MapIndex(key), b.MapIndex(key)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() || (a.Kind() == reflect.Slice && a.IsNil() != b.IsNil()) {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !sameValue(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// EvalResult is the outcome of EvalWithResult
+type EvalResult struct {
+	Value interface{}            // the script result
+	Vars  map[string]interface{} // the variables the script defined or modified
+}
+
+// EvalWithResult evaluates src like Eval, also reporting the variables visible
+// from s that the script defined or changed, in place or by assignment. The
+// variables are found by comparing a Snapshot taken before the run, so this
+// costs a deep copy of the scope.
+func (s *Scope) EvalWithResult(src string) (*EvalResult, error) {
+	before := s.Snapshot()
+	value, err := s.Eval(src)
+	if err != nil {
+		return nil, err
+	}
+	result := &EvalResult{Value: value, Vars: map[string]interface{}{}}
+	for _, change := range Diff(before, s) {
+		if change.Kind != Removed {
+			result.Vars[change.Name] = change.New
+		}
+	}
+	return result, nil
+}
+
+// deepCopy copies maps, slices and arrays recursively; other values are shared
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem()))
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, key := range v.MapKeys() {
+			c.SetMapIndex(key, deepCopy(v.MapIndex(key)))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return c
+	}
+	return v
+}
@@ -0,0 +1,46 @@
+package goeval
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// dumpValueWidth is the longest value representation Dump writes before truncating
+const dumpValueWidth = 80
+
+// Dump renders every variable in the scope chain, one per line, with its scope
+// depth (0 for s itself), Go type and a truncated value, for logging. Variables
+// hidden by an inner scope are marked as shadowed.
+func (s *Scope) Dump() string {
+	var b strings.Builder
+	seen := map[string]bool{}
+	depth := 0
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		names := make([]string, 0, len(currentScope.Vars))
+		for name := range currentScope.Vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			val := currentScope.Vars[name]
+			fmt.Fprintf(&b, "[%d] %s %T = %s", depth, name, val, truncate(fmt.Sprintf("%#v", val), dumpValueWidth))
+			if seen[name] {
+				b.WriteString(" (shadowed)")
+			}
+			b.WriteString("\n")
+			seen[name] = true
+		}
+		depth++
+	}
+	return b.String()
+}
+
+// truncate shortens s to at most n runes, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
@@ -0,0 +1,84 @@
+package goeval
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// encodingBuiltins holds the helpers installed by InstallEncoding
+var encodingBuiltins = map[string]interface{}{
+	"hexEncode":    HexEncode,
+	"hexDecode":    hex.DecodeString,
+	"base64Encode": Base64Encode,
+	"base64Decode": base64.StdEncoding.DecodeString,
+	"sha256":       SHA256,
+	"md5":          MD5,
+	"hmacSHA256":   HMACSHA256,
+}
+
+// InstallEncoding registers the encoding and hashing helpers in the scope, under
+// their bare names when namespace is empty, otherwise grouped under the
+// namespace (encoding.sha256(body)). They take strings or byte slices; hashes
+// come back hex encoded, so that scripts can compare them with ==.
+//
+//	encoding.hmacSHA256(secret, payload) == signature
+func (s *Scope) InstallEncoding(namespace string) {
+	s.install(namespace, encodingBuiltins)
+}
+
+// HexEncode returns the hexadecimal encoding of a string or byte slice
+func HexEncode(data interface{}) (string, error) {
+	b, err := bytesArg(data)
+	return hex.EncodeToString(b), err
+}
+
+// Base64Encode returns the standard base64 encoding of a string or byte slice
+func Base64Encode(data interface{}) (string, error) {
+	b, err := bytesArg(data)
+	return base64.StdEncoding.EncodeToString(b), err
+}
+
+// SHA256 returns the hex encoded SHA-256 hash of a string or byte slice
+func SHA256(data interface{}) (string, error) {
+	b, err := bytesArg(data)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), err
+}
+
+// MD5 returns the hex encoded MD5 hash of a string or byte slice, for
+// fingerprints only: MD5 is broken for security
+func MD5(data interface{}) (string, error) {
+	b, err := bytesArg(data)
+	sum := md5.Sum(b)
+	return hex.EncodeToString(sum[:]), err
+}
+
+// HMACSHA256 returns the hex encoded HMAC-SHA256 signature of data with key
+func HMACSHA256(key, data interface{}) (string, error) {
+	k, err := bytesArg(key)
+	if err != nil {
+		return "", err
+	}
+	b, err := bytesArg(data)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, k)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// bytesArg takes a string or byte slice argument as bytes
+func bytesArg(v interface{}) ([]byte, error) {
+	switch b := v.(type) {
+	case []byte:
+		return b, nil
+	case string:
+		return []byte(b), nil
+	}
+	return nil, fmt.Errorf("goeval: need a string or []byte, not %T", v)
+}
@@ -0,0 +1,41 @@
+package goeval
+
+import (
+	"fmt"
+	"os"
+)
+
+// EnvLookup returns the value of the environment variable name, if scripts may see it
+type EnvLookup func(name string) (string, bool)
+
+// AllowEnv exposes the process environment variables named, and only those
+func AllowEnv(names ...string) EnvLookup {
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[name] = true
+	}
+	return func(name string) (string, bool) {
+		if !allowed[name] {
+			return "", false
+		}
+		return os.Getenv(name), true
+	}
+}
+
+// EnvMap exposes the variables of vars instead of the process environment
+func EnvMap(vars map[string]string) EnvLookup {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+// env returns the env builtin of the scope: env(name) returns the value of an
+// environment variable, failing for names Options.Env does not expose
+func (s *Scope) env(name string) (string, error) {
+	v, ok := s.Options.Env(name)
+	if !ok {
+		return "", fmt.Errorf("goeval: environment variable %s is not available", name)
+	}
+	return v, nil
+}
@@ -0,0 +1,55 @@
+package goeval
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// The error builtins return errors as values, typed interface{}, since a
+// function whose last result is a non-nil error fails the script call.
+
+// Errorf is the errorf builtin, fmt.Errorf: %w wraps its argument
+//
+//	err := errorf("order %d: %w", id, ErrNotFound)
+func Errorf(format string, args ...interface{}) interface{} {
+	return fmt.Errorf(format, args...)
+}
+
+// WrapErr is the wrapErr builtin, prefixing err with msg while keeping it
+// reachable by isErr and asErr. It returns nil for a nil err.
+func WrapErr(err error, msg string) interface{} {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// IsErr is the isErr builtin, errors.Is: it reports whether err or an error
+// it wraps is target, eg a sentinel error of the host
+func IsErr(err, target error) bool {
+	return errors.Is(err, target)
+}
+
+// AsErr is the asErr builtin, errors.As: it returns the first error in the
+// chain of err having the type of target, and whether there is one. target is
+// a type, eg registered with SetBuiltinType, or a value of that type.
+//
+//	nf, ok := asErr(err, NotFoundError)
+func AsErr(err error, target interface{}) (interface{}, bool, error) {
+	typ, ok := target.(reflect.Type)
+	if !ok {
+		if target == nil {
+			return nil, false, errors.New("goeval: asErr target is nil")
+		}
+		typ = reflect.TypeOf(target)
+	}
+	if typ.Kind() != reflect.Interface && !typ.Implements(errorType) {
+		return nil, false, fmt.Errorf("goeval: asErr target %v does not implement error", typ)
+	}
+	ptr := reflect.New(typ)
+	if !errors.As(err, ptr.Interface()) {
+		return nil, false, nil
+	}
+	return ptr.Elem().Interface(), true, nil
+}
@@ -1,73 +1,230 @@
 package goeval
 
 import (
-	"encoding/json"
+	"container/list"
+	"context"
 	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
+	"go/scanner"
 	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // variable scope, recursive definition
 type Scope struct {
-	Vars   map[string]interface{} // all variables in current scope
-	Parent *Scope
+	Vars    map[string]interface{} // all variables in current scope
+	Parent  *Scope
+	Options Options // interpreter behaviour, inherited by child scopes
+
+	marshalers  map[reflect.Type]MarshalFunc
+	ctx         context.Context // set by EvalContext
+	tasks       *taskGroup      // goroutines spawned in the scope tree
+	including   *includeFrame   // set while evaluating an included script
+	readonly    map[string]bool // variables scripts cannot assign, see Bind
+	setHooks    map[string][]SetHook
+	getHooks    map[string][]GetHook
+	store       Store                   // backs Vars, see NewStoreScope
+	src         *string                 // the script being run, set when logging
+	depth       *int32                  // interpret nesting of the evaluation, see Options.MaxDepth
+	ownBuiltins map[string]interface{}  // see SetBuiltin
+	ownTypes    map[string]reflect.Type // see SetBuiltinType
+	frozen      bool                    // scripts cannot assign the variables, see ScopePool
+	fromContext bool                    // holds the context variables, see ContextWithVars
+	forked      bool                    // assignments to variables of the parents land here, see Fork
+	quota       *evalQuota              // consulted before every statement, see Options.Quota
+	expiry      map[string]time.Time    // deadlines of expiring variables, see SetWithTTL
+	explain     *explainer              // records the evaluation tree, see Explain
+	watches     map[string][]*Watch     // by the variables they depend on, see Watch
+	docs        map[string]funcDoc      // see RegisterFunc
+	registry    *registry               // a snapshot of the builtins, see WithBuiltinsSnapshot
+	pureMade    *sync.Map               // functions a pure evaluation made, see madePure
+	record      []fieldValue            // the record an Evaluator binds, by slot
+}
+
+// Options tune how scripts are interpreted
+type Options struct {
+	// MapSelectors lets m.Key read m["Key"] when m is a map with string keys
+	MapSelectors bool
+	// DeepEqual makes == and != compare slices, maps and structs with reflect.DeepEqual
+	DeepEqual bool
+	// AutoVivify makes a["x"]["y"] = v create the missing intermediate maps
+	AutoVivify bool
+	// Resolver provides the packages named by import declarations
+	Resolver Resolver
+	// Coverage, when set, records the statements executed
+	Coverage *Coverage
+	// RuneLen makes len count the runes of strings instead of their bytes
+	RuneLen bool
+	// MaxGoroutines limits the script goroutines running at once in a scope tree, 0 for no limit
+	MaxGoroutines int
+	// LockTimeout bounds the waits of the sync primitives installed by InstallSync,
+	// DefaultLockTimeout when 0
+	LockTimeout time.Duration
+	// Loader, when set, enables the include builtin and provides the included scripts
+	Loader Loader
+	// Output receives what scripts print with printf, os.Stdout when nil
+	Output io.Writer
+	// Logger, when set, logs evaluations, errors and, at debug level, the
+	// statements run; it also enables the log builtin
+	Logger Logger
+	// Tracer, when set, opens a span per evaluation and per function call
+	Tracer Tracer
+	// Env, when set, enables the env builtin and decides which environment
+	// variables it exposes, see AllowEnv and EnvMap
+	Env EnvLookup
+	// ContinueOnError keeps evaluating the statements of a script after one
+	// fails; the evaluation then returns an ErrorList of every failure
+	ContinueOnError bool
+	// VerboseErrors locates every evaluation error at the innermost failing node,
+	// and makes its message quote the source line with a caret under the node
+	VerboseErrors bool
+	// Timeout, when set, bounds every evaluation: its context is cancelled once
+	// the timeout passes, which stops loops and context aware host functions
+	Timeout time.Duration
+	// HTTP, when set, enables the httpGet and httpPost builtins under its policy
+	HTTP *HTTPPolicy
+	// Exec, when set, enables the exec builtin under its policy
+	Exec *ExecPolicy
+	// Limits caps the sizes of the slices, maps and strings scripts build
+	Limits Limits
+	// CallTimeout, when set, bounds every call of a host function: a function
+	// taking a context gets one with this deadline, and the script stops waiting
+	// for any function still running once it passes
+	CallTimeout time.Duration
+	// MaxDepth bounds the nesting of expressions, statements and script function
+	// calls an evaluation may reach, DefaultMaxDepth when 0
+	MaxDepth int
+	// Quota, when set, admits every evaluation, and every statement when it is
+	// a StatementQuota; evaluations it rejects fail with a QuotaError
+	Quota Quota
+	// Literals turn domain string literals into values, eg durations or dates,
+	// see LiteralHook
+	Literals []LiteralHook
+	// Transforms rewrite the syntax tree of scripts, in order, between
+	// parsing and interpreting, see Transform
+	Transforms []Transform
+	// JSON tunes how values are encoded as JSON, see JSONOptions
+	JSON JSONOptions
+	// Pure rejects assignments, declarations, loops, channel operations and
+	// calls to functions not registered with RegisterPure, before and while
+	// evaluating, so that an evaluation cannot change the scope or the world
+	// outside, eg to preview expressions. Failures are ImpureErrors.
+	Pure bool
 }
 
-// create a new variable scope
-func NewScope() *Scope {
+// DefaultMaxDepth is the nesting limit of evaluations when Options.MaxDepth is not set
+const DefaultMaxDepth = 10000
+
+// ErrTooDeep fails evaluations nesting deeper than Options.MaxDepth, as
+// adversarial scripts or runaway recursion would otherwise overflow the stack
+var ErrTooDeep = errors.New("goeval: evaluation nested too deeply")
+
+// create a new variable scope, configured by opts
+func NewScope(opts ...Option) *Scope {
 	s := &Scope{
 		Vars: map[string]interface{}{},
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
 	return s
 }
 
-// search variable from inner-most scope
+// search variable from inner-most scope, evicting the expired ones on the way
 func (s *Scope) Get(name string) (val interface{}) {
 	currentScope := s
 	exists := false
 	for !exists && currentScope != nil {
 		val, exists = currentScope.Vars[name]
+		if exists && currentScope.evict(name) {
+			val, exists = nil, false
+		}
 		currentScope = currentScope.Parent
 	}
 	return
 }
 
+// GetJsonString encodes the named variable as JSON, returning "null" on failure.
+// Use GetJSON to see the error.
 func (s *Scope) GetJsonString(name string) (val string) {
-	b, err := json.Marshal(s.Get(name))
+	b, err := s.GetJSON(name)
 	if err != nil {
 		return "null"
 	}
 	return string(b)
 }
 
+// lookup is like Get, but also reports whether the variable exists at all
+func (s *Scope) lookup(name string) (val interface{}, exists bool) {
+	for currentScope := s; !exists && currentScope != nil; currentScope = currentScope.Parent {
+		val, exists = currentScope.Vars[name]
+		if exists && currentScope.expired(name) {
+			val, exists = nil, false
+		}
+	}
+	return
+}
+
 // Set walks the scope and sets a value in a parent scope if it exists, else current.
+// The walk stops at a fork, see Fork.
 func (s *Scope) Set(name string, val interface{}) {
 	exists := false
 	currentScope := s
 	for !exists && currentScope != nil {
 		_, exists = currentScope.Vars[name]
-		if exists {
+		if exists || currentScope.forked {
 			currentScope.Vars[name] = val
+			currentScope.renew(name)
+			exists = true
 		}
 		currentScope = currentScope.Parent
 	}
 	if !exists {
 		s.Vars[name] = val
 	}
+	s.notifyWatches(name)
+}
+
+// Namespace groups related values under a single name, so that scripts can
+// reach them with a selector, eg strings.contains
+type Namespace map[string]interface{}
+
+// install registers funcs in the scope, either directly or grouped under
+// namespace, which may be dotted to nest it, eg "tools.math". A namespace
+// replaces a variable of the same name.
+func (s *Scope) install(namespace string, funcs map[string]interface{}) {
+	if namespace == "" {
+		for name, f := range funcs {
+			s.Vars[name] = f
+		}
+		return
+	}
+	ns, _ := s.namespace(namespace, true)
+	for name, f := range funcs {
+		ns[name] = f
+	}
 }
 
-// Keys returns all keys in scope
+// Keys returns all keys visible from the scope, each once
 func (s *Scope) Keys() (keys []string) {
-	currentScope := s
-	for currentScope != nil {
+	seen := map[string]bool{}
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
 		for k := range currentScope.Vars {
-			keys = append(keys, k)
+			if !seen[k] && !currentScope.expired(k) {
+				seen[k] = true
+				keys = append(keys, k)
+			}
 		}
-		currentScope = s.Parent
 	}
 	return
 }
@@ -76,19 +233,568 @@ func (s *Scope) Keys() (keys []string) {
 func (s *Scope) NewChild() *Scope {
 	child := NewScope()
 	child.Parent = s
+	child.Options = s.Options
+	child.tasks = s.heldTasks()
+	child.depth = s.depth
+	child.quota = s.quota
+	child.pureMade = s.pureMade
+	child.record = s.record
+	child.explain = s.explain
+	return child
+}
+
+// Fork creates a child scope for evaluating in its own goroutine. Scripts read
+// the variables of s, but their definitions and assignments, even to variables
+// of s, land in the fork, so that forks of one scope run concurrently without
+// racing. Maps, slices and pointers held by s are still shared: changing their
+// contents from concurrent forks needs locking.
+//
+//	for _, order := range orders {
+//		go func(f *goeval.Scope, order Order) {
+//			f.Set("order", order)
+//			f.Eval(rules)
+//		}(s.Fork(), order)
+//	}
+func (s *Scope) Fork() *Scope {
+	s.taskGroup() // created now, not concurrently by the forks
+	child := s.NewChild()
+	child.tasks = s.taskGroup()
+	child.forked = true
 	return child
 }
 
-// Eval evaluates a string
+// Eval evaluates a string. Leading comments of the script may tighten the
+// options of the evaluation, see withPragmas.
 func (s *Scope) Eval(src string) (interface{}, error) {
-	expr, err := parser.ParseExpr("func(){" + src + "}()")
+	s, err := s.withPragmas(src)
+	if err != nil {
+		return nil, err
+	}
+	if s.Options.Timeout > 0 {
+		return s.evalContext(context.Background(), src)
+	}
+	return s.observed(src, func(s *Scope) (interface{}, error) {
+		body, err := s.parseEval(src)
+		if err != nil {
+			return nil, err
+		}
+		return s.runScript(body)
+	})
+}
+
+// scriptPrefix opens the function literal scripts are wrapped in for parsing
+const scriptPrefix = "func(){"
+
+// resolveType evaluates an expression that must denote a type
+func (s *Scope) resolveType(expr ast.Expr) (reflect.Type, error) {
+	t, err := s.interpret(expr)
+	if err != nil {
+		return nil, err
+	}
+	typ, ok := t.(reflect.Type)
+	if !ok {
+		return nil, fmt.Errorf("goeval: %s is not a type", types.ExprString(expr))
+	}
+	return typ, nil
+}
+
+// run interprets body, turning a panic of the interpreter or of a host function
+// into an error so that no public entry point panics, and a return statement
+// into the result
+func (s *Scope) run(body ast.Node) (interface{}, error) {
+	return returned(s.execute(body))
+}
+
+// execute is run leaving a return statement as its signal, for the statements
+// of a script run one by one
+func (s *Scope) execute(body ast.Node) (result interface{}, err error) {
+	if s.depth == nil {
+		run := *s // shares Vars, only adds the depth counter
+		run.tasks = s.taskGroup()
+		run.depth = new(int32)
+		if s.Options.Pure {
+			run.pureMade = new(sync.Map)
+		}
+		s = &run
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if p, ok := r.(scriptPanic); ok {
+				result, err = nil, p.err
+				return
+			}
+			result, err = nil, fmt.Errorf("goeval: panic: %v", r)
+		}
+	}()
+	result, err = s.interpret(body)
+	if b, ok := err.(*branchSignal); ok {
+		// a stray break or continue must not end a loop of the caller
+		err = errors.New(b.Error())
+	}
+	return result, err
+}
+
+// runScript runs the body of a script, statement by statement when
+// Options.ContinueOnError is set
+func (s *Scope) runScript(body *ast.BlockStmt) (interface{}, error) {
+	if s.Options.Pure {
+		if err := checkPure(body); err != nil {
+			return nil, err
+		}
+	}
+	if !s.Options.ContinueOnError {
+		return s.run(body)
+	}
+	var result interface{}
+	var errs ErrorList
+	for _, stmt := range body.List {
+		var err error
+		result, err = s.execute(stmt)
+		if r, ok := err.(*returnSignal); ok {
+			result = r.result
+			break
+		}
+		if err != nil {
+			errs = append(errs, positioned(stmt, err))
+		}
+	}
+	if errs != nil {
+		return result, errs
+	}
+	return result, nil
+}
+
+// ErrorList holds the errors of an evaluation with Options.ContinueOnError, in
+// the order of the statements that failed
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the errors, for errors.Is and errors.As
+func (l ErrorList) Unwrap() []error {
+	return l
+}
+
+// scriptBody returns the body of the function literal called by expr. Scripts
+// closing the wrapper early, eg with "}+func(){", parse to something else.
+func scriptBody(expr ast.Expr) (*ast.BlockStmt, bool) {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok || len(call.Args) > 0 {
+		return nil, false
+	}
+	lit, ok := call.Fun.(*ast.FuncLit)
+	if !ok {
+		return nil, false
+	}
+	return lit.Body, true
+}
+
+// condition evaluates the condition of an if or for statement
+func (s *Scope) condition(expr ast.Expr) (bool, error) {
+	v, err := s.interpret(expr)
+	if err != nil {
+		return false, err
+	}
+	cond, ok := v.(bool)
+	if !ok {
+		return false, &PosError{Pos: expr.Pos(), Err: fmt.Errorf("goeval: non-bool %s (%T) used as condition", types.ExprString(expr), v)}
+	}
+	return cond, nil
+}
+
+// sliceValue evaluates x[low:high] and x[low:high:max]
+func (s *Scope) sliceValue(x interface{}, expr *ast.SliceExpr) (interface{}, error) {
+	xVal := reflect.ValueOf(x)
+	for xVal.IsValid() && xVal.Kind() == reflect.Ptr && xVal.Elem().Kind() == reflect.Array {
+		xVal = xVal.Elem()
+	}
+	switch xVal.Kind() {
+	case reflect.Slice, reflect.String:
+	case reflect.Array:
+		if !xVal.CanAddr() {
+			// slicing needs an addressable array
+			arr := reflect.New(xVal.Type()).Elem()
+			arr.Set(xVal)
+			xVal = arr
+		}
+	default:
+		return nil, fmt.Errorf("goeval: cannot slice %s (%T)", types.ExprString(expr.X), x)
+	}
+	bound := xVal.Len()
+	if xVal.Kind() != reflect.String {
+		bound = xVal.Cap()
+	}
+	indexes := []int{0, xVal.Len(), bound}
+	for i, e := range []ast.Expr{expr.Low, expr.High, expr.Max} {
+		if e == nil {
+			continue
+		}
+		v, err := s.interpret(e)
+		if err != nil {
+			return nil, err
+		}
+		n, ok := v.(int)
+		if !ok {
+			return nil, fmt.Errorf("goeval: slice index %s must be an int, not %T", types.ExprString(e), v)
+		}
+		indexes[i] = n
+	}
+	low, high, max := indexes[0], indexes[1], indexes[2]
+	if low < 0 || high < low || max < high || max > bound {
+		return nil, fmt.Errorf("goeval: slice bounds out of range [%d:%d:%d] with capacity %d", low, high, max, bound)
+	}
+	if expr.Slice3 {
+		if xVal.Kind() == reflect.String {
+			return nil, errors.New("goeval: 3-index slice of string")
+		}
+		return xVal.Slice3(low, high, max).Interface(), nil
+	}
+	return xVal.Slice(low, high).Interface(), nil
+}
+
+// PosError is an evaluation error located in the script. Line and Column are
+// filled in when the error leaves Eval, 0 until then. File names the source
+// of scripts read by EvalReader and CompileReader. With Options.VerboseErrors,
+// Snippet holds the source line, which the message quotes.
+type PosError struct {
+	Pos          token.Pos
+	File         string
+	Line, Column int
+	Snippet      string
+	Err          error
+}
+
+func (e *PosError) Error() string {
+	var msg string
+	switch {
+	case e.Line == 0:
+		return e.Err.Error()
+	case e.File != "":
+		msg = fmt.Sprintf("%v at %s:%d:%d", e.Err, e.File, e.Line, e.Column)
+	default:
+		msg = fmt.Sprintf("%v at line %d, column %d", e.Err, e.Line, e.Column)
+	}
+	if e.Snippet == "" {
+		return msg
+	}
+	// the caret keeps the tabs of the line, so it lines up however they render
+	caret := []byte(e.Snippet)
+	if e.Column-1 < len(caret) {
+		caret = caret[:e.Column-1]
+	}
+	for i, c := range caret {
+		if c != '\t' {
+			caret[i] = ' '
+		}
+	}
+	return msg + "\n\t" + e.Snippet + "\n\t" + string(caret) + "^"
+}
+
+// Unwrap returns the underlying error
+func (e *PosError) Unwrap() error {
+	return e.Err
+}
+
+// locate fills in the line and column of the PosErrors raised evaluating src,
+// and their snippet when verbose
+func locate(src string, err error, verbose bool) error {
+	if errs, ok := err.(ErrorList); ok {
+		for _, err := range errs {
+			locate(src, err, verbose)
+		}
+	}
+	if pe, ok := err.(*PosError); ok {
+		if pe.Line == 0 {
+			pe.Line, pe.Column = position(src, pe.Pos)
+		}
+		if verbose && pe.Line > 0 && pe.Snippet == "" {
+			if lines := strings.Split(src, "\n"); pe.Line <= len(lines) {
+				pe.Snippet = lines[pe.Line-1]
+			}
+		}
+	}
+	return err
+}
+
+// positioned locates err at node unless it already has a position
+func positioned(node ast.Node, err error) error {
+	switch err.(type) {
+	case *PosError, *branchSignal, *returnSignal:
+		return err
+	}
+	if node == nil || !node.Pos().IsValid() {
+		return err
+	}
+	return &PosError{Pos: node.Pos(), Err: err}
+}
+
+// readSource reads a whole script, as parsing needs all of it
+func readSource(r io.Reader) (string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// EvalReader evaluates the script read from r. name identifies the source in
+// the errors located in the script, eg a file name or URL.
+func (s *Scope) EvalReader(name string, r io.Reader) (interface{}, error) {
+	src, err := readSource(r)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.Eval(src)
+	return result, nameSource(name, err)
+}
+
+// nameSource sets the File of the PosErrors in err
+func nameSource(name string, err error) error {
+	switch e := err.(type) {
+	case *PosError:
+		e.File = name
+	case ErrorList:
+		for _, err := range e {
+			nameSource(name, err)
+		}
+	}
+	return err
+}
+
+// position converts a position in a script parsed by parse to a line and column of src
+func position(src string, pos token.Pos) (line, column int) {
+	offset := int(pos) - 1 - len(scriptPrefix)
+	if !pos.IsValid() || offset < 0 || offset > len(src) {
+		return 0, 0
+	}
+	line = 1 + strings.Count(src[:offset], "\n")
+	return line, offset - strings.LastIndex(src[:offset], "\n")
+}
+
+// EvalContext evaluates a string like Eval. Goroutines started by the script
+// get a context derived from ctx, so they are cancelled when ctx ends.
+func (s *Scope) EvalContext(ctx context.Context, src string) (interface{}, error) {
+	s, err := s.withPragmas(src)
+	if err != nil {
+		return nil, err
+	}
+	return s.evalContext(ctx, src)
+}
+
+func (s *Scope) evalContext(ctx context.Context, src string) (interface{}, error) {
+	if s.Options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Options.Timeout)
+		defer cancel()
+	}
+	run := *s
+	run.tasks = s.taskGroup()
+	run.ctx = ctx
+	if vars := contextVars(ctx); len(vars) > 0 {
+		layer, err := s.contextLayer(vars)
+		if err != nil {
+			return nil, err
+		}
+		run.Parent = layer
+	}
+	return run.observed(src, func(s *Scope) (interface{}, error) {
+		body, err := s.parseEval(src)
+		if err != nil {
+			return nil, err
+		}
+		return s.runScript(body)
+	})
+}
+
+// context returns the context of the evaluation running in the scope
+func (s *Scope) context() context.Context {
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		if currentScope.ctx != nil {
+			return currentScope.ctx
+		}
+	}
+	return context.Background()
+}
+
+// parse parses a script into the body of a function literal. Syntax errors
+// come as an ErrorList of every one, located in src.
+func parse(src string) (*ast.BlockStmt, error) {
+	body, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Parse parses a script as Eval does, for tools: when the script has syntax
+// errors, it returns them all as an ErrorList of PosErrors located in src,
+// along with the tree parsed in spite of them, where Bad nodes stand for the
+// broken parts, or nil when the script is too broken for one. Positions of
+// the tree convert to lines and columns of src with ScriptPosition.
+func Parse(src string) (*ast.BlockStmt, error) {
+	imports, script, err := splitImports(src)
+	if err != nil {
+		return nil, syntaxErrors(src, err, len(importPrefix))
+	}
+	expr, err := parser.ParseExprFrom(token.NewFileSet(), "", scriptPrefix+script+"\n}()", parser.AllErrors) // the newline ends a trailing comment
+	body, ok := scriptBody(expr)
+	if err != nil {
+		if lit, partial := expr.(*ast.FuncLit); partial {
+			body = lit.Body // the parser gave up before the call
+		}
+		return body, syntaxErrors(src, err, len(scriptPrefix))
+	}
+	if !ok {
+		return nil, errors.New("goeval: invalid script, unbalanced braces")
+	}
+	if imports != nil {
+		body.List = append([]ast.Stmt{imports}, body.List...)
+	}
+	return body, nil
+}
+
+// syntaxErrors turns the errors of the parser into an ErrorList located in
+// src, which starts at offset prefix of the source parsed. Errors past the end
+// of src, as at a missing closing brace, are located at its end. Like the Go
+// compiler, it keeps the first error of a line, the others mostly following
+// from it.
+func syntaxErrors(src string, err error, prefix int) error {
+	list, ok := err.(scanner.ErrorList)
+	if !ok {
+		return err
+	}
+	errs := make(ErrorList, 0, len(list))
+	lines := map[int]bool{}
+	for _, e := range list {
+		pos := token.Pos(len(scriptPrefix) + e.Pos.Offset - prefix + 1)
+		line, column := position(src, pos)
+		if line == 0 {
+			pos = token.Pos(len(scriptPrefix) + len(src) + 1)
+			line, column = position(src, pos)
+		}
+		if lines[line] {
+			continue
+		}
+		lines[line] = true
+		errs = append(errs, &PosError{Pos: pos, Line: line, Column: column, Err: errors.New("goeval: " + e.Msg)})
+	}
+	return errs
+}
+
+// ScriptPosition converts a position of a tree returned by Parse to a line and
+// column of the script, both 0 when it is not in the script
+func ScriptPosition(src string, pos token.Pos) (line, column int) {
+	return position(src, pos)
+}
+
+// parseCacheSize bounds the scripts parseCached keeps
+const parseCacheSize = 512
+
+// parseCache holds the parsed scripts of Eval, which are shared between
+// evaluations: the interpreter never modifies a syntax tree. Past
+// parseCacheSize scripts, the least recently used is dropped.
+var parseCache = struct {
+	sync.Mutex
+	bodies map[string]*list.Element
+	recent list.List // of *parsedScript, most recently used first
+}{bodies: map[string]*list.Element{}}
+
+type parsedScript struct {
+	src  string
+	body *ast.BlockStmt
+}
+
+// parseCached parses src, reusing the tree of a previous parse when there is
+// one, so that evaluating the same text repeatedly does not parse it again
+func parseCached(src string) (*ast.BlockStmt, error) {
+	parseCache.Lock()
+	if e, ok := parseCache.bodies[src]; ok {
+		parseCache.recent.MoveToFront(e)
+		body := e.Value.(*parsedScript).body
+		parseCache.Unlock()
+		return body, nil
+	}
+	parseCache.Unlock()
+	body, err := parse(src)
 	if err != nil {
 		return nil, err
 	}
-	return s.interpret(expr.(*ast.CallExpr).Fun.(*ast.FuncLit).Body)
+	parseCache.Lock()
+	defer parseCache.Unlock()
+	if e, ok := parseCache.bodies[src]; ok {
+		// parsed meanwhile by another evaluation
+		parseCache.recent.MoveToFront(e)
+		return body, nil
+	}
+	parseCache.bodies[src] = parseCache.recent.PushFront(&parsedScript{src, body})
+	for parseCache.recent.Len() > parseCacheSize {
+		oldest := parseCache.recent.Back()
+		parseCache.recent.Remove(oldest)
+		delete(parseCache.bodies, oldest.Value.(*parsedScript).src)
+	}
+	return body, nil
+}
+
+// maxDepth returns Options.MaxDepth, or its default
+func (s *Scope) maxDepth() int32 {
+	if s.Options.MaxDepth <= 0 {
+		return DefaultMaxDepth
+	}
+	return int32(s.Options.MaxDepth)
+}
+
+func (s *Scope) interpret(body ast.Node) (result interface{}, err error) {
+	if s.depth != nil {
+		// shared with the goroutines of the evaluation, hence atomic
+		defer atomic.AddInt32(s.depth, -1)
+		if atomic.AddInt32(s.depth, 1) > s.maxDepth() {
+			return nil, ErrTooDeep
+		}
+	}
+	if s.quota != nil {
+		if _, ok := body.(ast.Stmt); ok {
+			if err := s.quota.check(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if s.Options.Pure {
+		if err := impureNode(body); err != nil {
+			return nil, err
+		}
+	}
+	if s.Options.Coverage != nil {
+		if stmt, ok := body.(ast.Stmt); ok && stmt != nil {
+			s.Options.Coverage.hit(stmt.Pos())
+		}
+	}
+	if s.Options.Logger != nil {
+		if stmt, ok := body.(ast.Stmt); ok && stmt != nil {
+			if _, isBlock := stmt.(*ast.BlockStmt); !isBlock {
+				s.traceStmt(stmt)
+			}
+		}
+	}
+	if s.explain != nil && s.including == nil && explained(body) {
+		s.explain.enter(body)
+		defer func() { s.explain.leave(result, err) }()
+	}
+	result, err = s.interpretNode(body)
+	if err != nil && s.Options.VerboseErrors {
+		err = positioned(body, err)
+	}
+	return result, err
 }
 
-func (s *Scope) interpret(body ast.Node) (interface{}, error) {
+// interpretNode evaluates a node; interpret adds the checks and instrumentation
+// common to all nodes
+func (s *Scope) interpretNode(body ast.Node) (interface{}, error) {
 	switch node := body.(type) {
 	case ast.Decl:
 		switch decl := node.(type) {
@@ -100,7 +806,7 @@ func (s *Scope) interpret(body ast.Node) (interface{}, error) {
 			}
 			return nil, nil
 		default:
-			return nil, fmt.Errorf("goeval: unknown DECL %#v", decl)
+			return nil, fmt.Errorf("goeval: unsupported declaration %T", decl)
 		}
 	case ast.Expr:
 		switch expr := node.(type) {
@@ -109,90 +815,112 @@ func (s *Scope) interpret(body ast.Node) (interface{}, error) {
 			if err != nil {
 				return nil, err
 			}
-			arrType := reflect.SliceOf(typ.(reflect.Type))
-			return arrType, nil
+			elem, ok := typ.(reflect.Type)
+			if !ok {
+				return nil, fmt.Errorf("goeval: %s is not a type", types.ExprString(expr.Elt))
+			}
+			if expr.Len == nil {
+				return reflect.SliceOf(elem), nil
+			}
+			if _, ok := expr.Len.(*ast.Ellipsis); ok {
+				return nil, errors.New("goeval: invalid use of [...] array outside a composite literal")
+			}
+			n, err := s.interpret(expr.Len)
+			if err != nil {
+				return nil, err
+			}
+			length, ok := n.(int)
+			if !ok || length < 0 {
+				return nil, fmt.Errorf("goeval: invalid array length %s", types.ExprString(expr.Len))
+			}
+			if err := s.Options.Limits.checkLen(reflect.Array, length); err != nil {
+				return nil, err
+			}
+			return reflect.ArrayOf(length, elem), nil
 		case *ast.BasicLit:
 			switch expr.Kind {
 			case token.INT:
-				n, err := strconv.ParseInt(expr.Value, 0, 64)
-				return int(n), err
+				return parseInt(expr.Value)
 			case token.FLOAT, token.IMAG:
 				return strconv.ParseFloat(expr.Value, 64)
 			case token.CHAR:
-				return (rune)(expr.Value[1]), nil
+				r, _, tail, err := strconv.UnquoteChar(expr.Value[1:len(expr.Value)-1], '\'')
+				if err == nil && tail != "" {
+					err = fmt.Errorf("goeval: invalid char literal %s", expr.Value)
+				}
+				return r, err
 			case token.STRING:
-				return strconv.Unquote(expr.Value)
+				str, err := strconv.Unquote(expr.Value)
+				if err != nil || len(s.Options.Literals) == 0 {
+					return str, err
+				}
+				return s.stringLiteral(str)
 			default:
 				return nil, fmt.Errorf("goeval: unknown BasicLit %#v", expr)
 			}
 		case *ast.BinaryExpr:
-			x, err := s.interpret(expr.X)
-			if err != nil {
-				return nil, err
-			}
-			y, err := s.interpret(expr.Y)
+			v, err := s.binaryExpr(expr)
 			if err != nil {
 				return nil, err
 			}
-			return binaryOp(x, y, expr.Op)
+			return v.boxed(), nil
 		case *ast.CallExpr:
-			fun, err := s.interpret(expr.Fun)
+			return s.call(expr, 0)
+		case *assignedCall:
+			return s.call(expr.CallExpr, expr.n)
+		case *ast.ChanType:
+			typ, err := s.resolveType(expr.Value)
 			if err != nil {
 				return nil, err
 			}
-			rf := reflect.ValueOf(fun)
-			// make sure fun is a function
-			if rf.Kind() != reflect.Func {
-				return nil, fmt.Errorf("goeval: %#v not a function", fun)
-			}
-			// interpret args
-			args := make([]reflect.Value, len(expr.Args))
-			for i, arg := range expr.Args {
-				av, err := s.interpret(arg)
-				if err != nil {
-					return nil, err
+			return reflect.ChanOf(chanDirs[expr.Dir], typ), nil
+		case *ast.CompositeLit:
+			litType := expr.Type
+			if arr, ok := litType.(*ast.ArrayType); ok {
+				if _, ok := arr.Len.(*ast.Ellipsis); ok {
+					// [...]T takes its length from the elements
+					litType = &ast.ArrayType{Lbrack: arr.Lbrack, Len: &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(len(expr.Elts))}, Elt: arr.Elt}
 				}
-				args[i] = reflect.ValueOf(av)
-			}
-			// call
-			values := interfaced(rf.Call(args))
-			if len(values) == 0 {
-				return nil, nil
-			}
-			if len(values) == 1 {
-				return values[0], nil
-			}
-			err, _ = values[1].(error)
-			return values[0], err
-		case *ast.ChanType:
-			typeI, err := s.interpret(expr.Value)
-			if err != nil {
-				return nil, err
 			}
-			typ, isType := typeI.(reflect.Type)
-			if !isType {
-				return nil, fmt.Errorf("goeval: %#v not a type for chan", typ)
+			if litType == nil {
+				return nil, errors.New("goeval: composite literals need an explicit type")
 			}
-			return reflect.ChanOf(reflect.BothDir, typ), nil
-		case *ast.CompositeLit:
-			typ, err := s.interpret(expr.Type)
+			typ, err := s.resolveType(litType)
 			if err != nil {
 				return nil, err
 			}
 			switch t := expr.Type.(type) {
 			case *ast.ArrayType:
 				l := len(expr.Elts)
-				slice := reflect.MakeSlice(typ.(reflect.Type), l, l)
+				if err := s.Options.Limits.checkLen(reflect.Slice, l); err != nil {
+					return nil, err
+				}
+				var seq reflect.Value
+				if arrType := typ; arrType.Kind() == reflect.Array {
+					if l > arrType.Len() {
+						return nil, fmt.Errorf("goeval: index %d out of bounds [0:%d]", arrType.Len(), arrType.Len())
+					}
+					seq = reflect.New(arrType).Elem()
+				} else {
+					seq = reflect.MakeSlice(arrType, l, l)
+				}
 				for i, elt := range expr.Elts {
 					elemValue, err := s.interpret(elt)
 					if err != nil {
 						return nil, err
 					}
-					slice.Index(i).Set(reflect.ValueOf(elemValue))
+					ev, err := valueAs(elemValue, seq.Type().Elem())
+					if err != nil {
+						return nil, err
+					}
+					seq.Index(i).Set(ev)
 				}
-				return slice.Interface(), nil
+				return seq.Interface(), nil
 			case *ast.MapType:
-				nMap := reflect.MakeMap(typ.(reflect.Type))
+				if typ.Kind() != reflect.Map {
+					return nil, fmt.Errorf("goeval: invalid composite literal type %v", typ)
+				}
+				nMap := reflect.MakeMap(typ)
 				for _, elt := range expr.Elts {
 					switch eT := elt.(type) {
 					case *ast.KeyValueExpr:
@@ -204,39 +932,30 @@ func (s *Scope) interpret(body ast.Node) (interface{}, error) {
 						if err != nil {
 							return nil, err
 						}
-						nMap.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(val))
-					default:
-						return nil, fmt.Errorf("goeval: invalid element type %#v to map", eT)
-					}
-				}
-				return nMap.Interface(), nil
-			case *ast.StructType:
-				nStruct := reflect.New(typ.(reflect.Type)).Interface()
-				rv := reflect.ValueOf(nStruct).Elem()
-				for _, elt := range expr.Elts {
-					switch eT := elt.(type) {
-					case *ast.KeyValueExpr:
-						key, err := s.interpret(eT.Key)
+						k, err := mapKey(nMap, key)
 						if err != nil {
 							return nil, err
 						}
-						val, err := s.interpret(eT.Value)
+						v, err := valueAs(val, typ.Elem())
 						if err != nil {
 							return nil, err
 						}
-						rv.FieldByName(key.(string)).Set(reflect.ValueOf(val))
+						nMap.SetMapIndex(k, v)
 					default:
-						return nStruct, fmt.Errorf("goeval: unknown element %#v", elt)
+						return nil, fmt.Errorf("goeval: invalid element type %#v to map", eT)
 					}
 				}
-				return nStruct, nil
-			case *ast.Ident:
-				nStruct := reflect.New(typ.(reflect.Type))
-				rv := reflect.ValueOf(nStruct.Interface()).Elem()
+				return nMap.Interface(), nil
+			case *ast.StructType, *ast.Ident, *ast.SelectorExpr:
+				structType := typ
+				if structType.Kind() != reflect.Struct {
+					return nil, fmt.Errorf("goeval: invalid composite literal type %s", types.ExprString(expr.Type))
+				}
+				rv := reflect.New(structType).Elem()
 				for _, elt := range expr.Elts {
 					switch eT := elt.(type) {
 					case *ast.KeyValueExpr:
-						key, err := s.interpret(eT.Key)
+						key, err := fieldName(eT.Key)
 						if err != nil {
 							return nil, err
 						}
@@ -244,28 +963,37 @@ func (s *Scope) interpret(body ast.Node) (interface{}, error) {
 						if err != nil {
 							return nil, err
 						}
-						rv.FieldByName(key.(string)).Set(reflect.ValueOf(val))
+						if err := setField(rv, key, val); err != nil {
+							return nil, err
+						}
 					default:
-						return nStruct.Elem(), fmt.Errorf("goeval: unknown element %#v", elt)
+						return nil, fmt.Errorf("goeval: unsupported element %s", types.ExprString(elt))
 					}
 				}
-				return nStruct.Elem(), nil
+				return rv.Interface(), nil
 			default:
 				return nil, fmt.Errorf("goeval: unknown composite literal %#v", t)
 			}
 		case *ast.Ident: // An Ident node represents an identifier.
-			if expr.Obj == nil {
-				return expr.Name, nil
+			kind := ast.Bad // unresolved identifiers carry no object
+			if expr.Obj != nil {
+				kind = expr.Obj.Kind
+				if slot, isField := expr.Obj.Data.(fieldSlot); isField {
+					if v, bound := s.field(slot); bound {
+						return v, nil
+					}
+					kind = ast.Bad // not in the record, resolved as other free identifiers
+				}
 			}
-			switch expr.Obj.Kind {
+			switch kind {
 			case ast.Bad:
-				if v, ok := builtinTypes[expr.Name]; ok {
+				if v, ok := s.builtinType(expr.Name); ok {
 					return v, nil
 				}
-				if v, ok := builtins[expr.Name]; ok {
+				if v, ok := s.resolvedBuiltin(expr.Name); ok {
 					return v, nil
 				}
-				if v := s.Get(expr.Name); v != nil {
+				if v, ok := s.getVar(expr.Name); ok {
 					return v, nil
 				}
 				return expr.Name, nil
@@ -280,6 +1008,11 @@ func (s *Scope) interpret(body ast.Node) (interface{}, error) {
 					return v, nil
 				}
 			}
+		case *ast.FuncLit:
+			return s.funcLit(expr)
+		case *ast.FuncType:
+			typ, _, err := s.funcType(expr)
+			return typ, err
 		case *ast.IndexExpr:
 			X, err := s.interpret(expr.X)
 			if err != nil {
@@ -289,186 +1022,365 @@ func (s *Scope) interpret(body ast.Node) (interface{}, error) {
 			if err != nil {
 				return nil, err
 			}
-			xVal := reflect.ValueOf(X)
-			if reflect.TypeOf(X).Kind() == reflect.Map {
-				val := xVal.MapIndex(reflect.ValueOf(i))
-				if !val.IsValid() {
-					// If not valid key, return the "zero" type. Eg for int 0, string ""
-					return reflect.Zero(xVal.Type().Elem()).Interface(), nil
-				}
-				return val.Interface(), nil
-			}
-
-			iVal, isInt := i.(int)
-			if !isInt {
-				return nil, fmt.Errorf("goeval: index must be an int not %T", i)
-			}
-			if iVal >= xVal.Len() || iVal < 0 {
-				return nil, errors.New("slice index result of range")
-			}
-			return xVal.Index(iVal).Interface(), nil
+			return indexValue(X, i)
 		case *ast.MapType:
-			keyType, err := s.interpret(expr.Key)
+			keyType, err := s.resolveType(expr.Key)
 			if err != nil {
 				return nil, err
 			}
-			valType, err := s.interpret(expr.Value)
+			valType, err := s.resolveType(expr.Value)
 			if err != nil {
 				return nil, err
 			}
-			mapType := reflect.MapOf(keyType.(reflect.Type), valType.(reflect.Type))
-			return mapType, nil
+			if !keyType.Comparable() {
+				return nil, fmt.Errorf("goeval: invalid map key type %v", keyType)
+			}
+			return reflect.MapOf(keyType, valType), nil
 		case *ast.ParenExpr:
 			return s.interpret(expr.X)
 		case *ast.SelectorExpr:
-			x, err := s.interpret(expr.X)
-			if err != nil {
-				return nil, err
+			var x interface{}
+			if ident, ok := expr.X.(*ast.Ident); ok {
+				// an imported package shadows the builtin of the same name, eg sort
+				if v, ok := s.lookup(ident.Name); ok {
+					if _, isNs := v.(Namespace); isNs {
+						x, _ = s.getVar(ident.Name)
+					}
+				}
+			}
+			if x == nil {
+				var err error
+				if x, err = s.interpret(expr.X); err != nil {
+					return nil, err
+				}
 			}
 			sel := expr.Sel
+			if ns, ok := x.(Namespace); ok {
+				if v, ok := ns[sel.Name]; ok {
+					return v, nil
+				}
+				return nil, fmt.Errorf("goeval: unknown name %#v in namespace", sel.Name)
+			}
 			rVal := reflect.ValueOf(x)
-			if rVal.Kind() != reflect.Struct && rVal.Kind() != reflect.Ptr {
-				return nil, fmt.Errorf("goeval: %#v is not a struct or has no field %#v", x, sel.Name)
+			// look through reflect.Value holders and interfaces to the dynamic value
+			for rVal.IsValid() && rVal.CanInterface() {
+				if inner, ok := rVal.Interface().(reflect.Value); ok {
+					rVal = inner
+					continue
+				}
+				if rVal.Kind() != reflect.Interface || rVal.IsNil() {
+					break
+				}
+				rVal = rVal.Elem()
 			}
-			if method := rVal.MethodByName(sel.Name); method.IsValid() {
+			if !rVal.IsValid() {
+				return nil, fmt.Errorf("goeval: cannot select %#v from nil", sel.Name)
+			}
+			if method := methodByName(rVal, sel.Name); method.IsValid() {
+				if s.Options.Pure && !pureMethod(rVal.Type(), sel.Name) {
+					return nil, &ImpureError{Op: fmt.Sprintf("calling method %s of %v", sel.Name, rVal.Type())}
+				}
+				if s.Options.Pure {
+					fn := method.Interface()
+					s.madePure(fn)
+					return fn, nil
+				}
 				return method.Interface(), nil
 			}
-			if rVal.Kind() == reflect.Ptr {
+			if s.Options.MapSelectors && rVal.Kind() == reflect.Map && rVal.Type().Key().Kind() == reflect.String {
+				val := rVal.MapIndex(reflect.ValueOf(sel.Name).Convert(rVal.Type().Key()))
+				if !val.IsValid() {
+					return reflect.Zero(rVal.Type().Elem()).Interface(), nil
+				}
+				return val.Interface(), nil
+			}
+			for rVal.Kind() == reflect.Ptr || rVal.Kind() == reflect.Interface {
+				if rVal.IsNil() {
+					return nil, fmt.Errorf("goeval: cannot select %#v from nil %v", sel.Name, rVal.Type())
+				}
 				rVal = rVal.Elem()
 			}
-			if field := rVal.FieldByName(sel.Name); field.IsValid() {
+			if rVal.Kind() != reflect.Struct {
+				return nil, fmt.Errorf("goeval: %#v is not a struct or has no field %#v", x, sel.Name)
+			}
+			if field := rVal.FieldByName(sel.Name); field.IsValid() && field.CanInterface() {
 				return field.Interface(), nil
 			}
 			return nil, fmt.Errorf("goeval: unknown field %#v", sel.Name)
 		case *ast.SliceExpr:
-			low, err := s.interpret(expr.Low)
-			if err != nil {
-				return nil, err
-			}
-			high, err := s.interpret(expr.High)
-			if err != nil {
-				return nil, err
-			}
 			x, err := s.interpret(expr.X)
 			if err != nil {
 				return nil, err
 			}
-			xVal := reflect.ValueOf(x)
-			if low == nil {
-				low = 0
-			}
-			if high == nil {
-				high = xVal.Len()
-			}
-			lowVal, isLowInt := low.(int)
-			highVal, isHighInt := high.(int)
-			if !isLowInt || !isHighInt {
-				return nil, fmt.Errorf("goeval: slice indexe must be an ints not %T and %T", low, high)
-			}
-			if lowVal < 0 || highVal >= xVal.Len() {
-				return nil, errors.New("slice: index result of bounds")
-			}
-			return xVal.Slice(lowVal, highVal).Interface(), nil
+			return s.sliceValue(x, expr)
 		case *ast.StructType:
-			structFields := make([]reflect.StructField, len(expr.Fields.List))
-			for i, field := range expr.Fields.List {
-				typ, err := s.interpret(field.Type)
+			var structFields []reflect.StructField
+			for _, field := range expr.Fields.List {
+				t, err := s.interpret(field.Type)
 				if err != nil {
 					return nil, err
 				}
-				structFields[i] = reflect.StructField{
-					Name:      field.Names[0].Name,
-					Type:      typ.(reflect.Type),
-					Anonymous: false,
+				typ, ok := t.(reflect.Type)
+				if !ok {
+					return nil, fmt.Errorf("goeval: %s is not a type", types.ExprString(field.Type))
+				}
+				for _, name := range field.Names {
+					if !ast.IsExported(name.Name) {
+						return nil, fmt.Errorf("goeval: struct field %s must be exported", name.Name)
+					}
+					structFields = append(structFields, reflect.StructField{
+						Name:      name.Name,
+						Type:      typ,
+						Anonymous: false,
+					})
 				}
 			}
 			return reflect.StructOf(structFields), nil
 		case *ast.UnaryExpr:
+			if expr.Op == token.AND {
+				return s.addressOf(expr.X)
+			}
 			x, err := s.interpret(expr.X)
 			if err != nil {
 				return nil, err
 			}
-			return unaryOp(x, expr.Op)
+			return s.unaryOp(x, expr.Op)
 		case *ast.InterfaceType:
 			// todo: cover the ugly implement
 			typ := reflect.TypeOf([]interface{}{}).Elem()
 			return typ, nil
 		default:
-			return nil, fmt.Errorf("goeval: unknown EXPR %#v", expr)
+			return nil, fmt.Errorf("goeval: unsupported expression %s (%T)", types.ExprString(expr), expr)
 		}
 	case ast.Spec:
 		switch spec := node.(type) {
+		case *ast.ImportSpec:
+			return nil, s.importSpec(spec)
 		case *ast.TypeSpec:
-			typ, err := s.interpret(spec.Type)
+			t, err := s.interpret(spec.Type)
 			if err != nil {
 				return nil, err
 			}
-			s.Vars[spec.Name.Name] = typ.(reflect.Type)
-			return typ.(reflect.Type), nil
+			typ, ok := t.(reflect.Type)
+			if !ok {
+				return nil, fmt.Errorf("goeval: %s is not a type", types.ExprString(spec.Type))
+			}
+			// reflect cannot create named types, so a defined type is its underlying
+			// type, dropping the methods of a named basic type; an alias keeps them
+			if !spec.Assign.IsValid() {
+				typ = underlyingType(typ)
+			}
+			s.Vars[spec.Name.Name] = typ
+			return typ, nil
 		case *ast.ValueSpec:
-			typ, err := s.interpret(spec.Type)
-			if err != nil {
-				return nil, err
+			var typ reflect.Type
+			if spec.Type != nil {
+				t, err := s.interpret(spec.Type)
+				if err != nil {
+					return nil, err
+				}
+				var ok bool
+				if typ, ok = t.(reflect.Type); !ok {
+					return nil, fmt.Errorf("goeval: %s is not a type", types.ExprString(spec.Type))
+				}
+			} else if len(spec.Values) == 0 {
+				return nil, fmt.Errorf("goeval: missing type or init expr for %s", spec.Names[0].Name)
+			}
+			values := make([]interface{}, len(spec.Names))
+			switch len(spec.Values) {
+			case 0:
+				zero := reflect.Zero(typ).Interface()
+				for i := range values {
+					values[i] = zero
+				}
+			case len(spec.Names):
+				for i, value := range spec.Values {
+					v, err := s.interpret(value)
+					if err != nil {
+						return nil, err
+					}
+					values[i] = v
+				}
+			case 1:
+				// var a, b = f() takes the results of a multi-valued call
+				multi, err := s.assignedResults(spec.Values[0], len(spec.Names))
+				if err != nil {
+					return nil, err
+				}
+				copy(values, multi)
+			default:
+				return nil, fmt.Errorf("goeval: assignment mismatch: %d variables but %d values", len(spec.Names), len(spec.Values))
 			}
-			zero := reflect.Zero(typ.(reflect.Type)).Interface()
 			for i, name := range spec.Names {
-				if len(spec.Values) > i {
-					v, err := s.interpret(spec.Values[i])
+				v := values[i]
+				if typ != nil {
+					rv, err := valueAs(v, typ)
 					if err != nil {
 						return nil, err
 					}
-					s.Set(name.Name, v)
-				} else {
-					s.Set(name.Name, zero)
+					v = rv.Interface()
+				}
+				if name.Name != "_" {
+					if err := s.setVar(name.Name, v, true); err != nil {
+						return nil, err
+					}
 				}
 			}
 			return nil, nil
 		default:
-			return nil, fmt.Errorf("goeval: unknown SPEC %#v", spec)
+			return nil, fmt.Errorf("goeval: unsupported declaration %T", spec)
 		}
 	case ast.Stmt:
 		switch stmt := node.(type) {
 		case *ast.AssignStmt:
-			if len(stmt.Lhs) != len(stmt.Rhs) {
-				return nil, fmt.Errorf("goeval: assignment mismatch: %d != %d", len(stmt.Lhs), len(stmt.Rhs))
-			}
-			for i, lh := range stmt.Lhs {
-				rh, err := s.interpret(stmt.Rhs[i])
+			// every right hand side is evaluated before any assignment, so a, b = b, a swaps
+			values := make([]interface{}, len(stmt.Lhs))
+			switch len(stmt.Rhs) {
+			case len(stmt.Lhs):
+				for i, rh := range stmt.Rhs {
+					v, err := s.interpret(rh)
+					if err != nil {
+						return nil, err
+					}
+					values[i] = v
+				}
+			case 1:
+				// a, b = f() takes the results of a multi-valued call
+				multi, err := s.assignedResults(stmt.Rhs[0], len(stmt.Lhs))
 				if err != nil {
 					return nil, err
 				}
+				copy(values, multi)
+			default:
+				return nil, fmt.Errorf("goeval: assignment mismatch: %d != %d", len(stmt.Lhs), len(stmt.Rhs))
+			}
+			compound := token.ADD_ASSIGN <= stmt.Tok && stmt.Tok <= token.AND_NOT_ASSIGN
+			op := stmt.Tok + (token.ADD - token.ADD_ASSIGN)
+			for i, lh := range stmt.Lhs {
+				rh := values[i]
+				var err error
 				switch variable := lh.(type) {
 				case *ast.Ident:
 					varName := variable.Name
-					v := s.Get(varName)
-					if v == nil && (stmt.Tok != token.DEFINE) {
+					if varName == "_" {
+						continue
+					}
+					// := always declares in the current scope, shadowing any parent binding
+					if stmt.Tok == token.DEFINE {
+						if err := s.setVar(varName, rh, true); err != nil {
+							return nil, err
+						}
+						continue
+					}
+					var v interface{}
+					var exists bool
+					if compound {
+						v, exists = s.getVar(varName)
+					} else {
+						v, exists = s.lookup(varName)
+					}
+					if !exists {
 						return nil, fmt.Errorf("goeval: variable %#v not defined", variable)
 					}
-					if token.ADD_ASSIGN <= stmt.Tok && stmt.Tok <= token.AND_NOT_ASSIGN {
-						rh, err = binaryOp(v, rh, stmt.Tok+(token.ADD-token.ADD_ASSIGN))
+					if compound {
+						rh, err = s.binaryOp(v, rh, op)
 						if err != nil {
 							return nil, err
 						}
 					}
-					s.Set(varName, rh)
+					if err := s.setVar(varName, rh, false); err != nil {
+						return nil, err
+					}
 				case *ast.IndexExpr:
-					x, err := s.interpret(variable.X)
-					xVal := reflect.ValueOf(x)
+					var x interface{}
+					if s.Options.AutoVivify {
+						x, err = s.vivify(variable.X)
+					} else {
+						x, err = s.interpret(variable.X)
+					}
+					if err != nil {
+						return nil, err
+					}
+					xVal := unwrap(reflect.ValueOf(x))
 					index, err := s.interpret(variable.Index)
 					if err != nil {
 						return nil, err
 					}
-					rhV := reflect.ValueOf(rh)
-					switch reflect.TypeOf(x).Kind() {
+					switch xVal.Kind() {
 					case reflect.Map:
-						xVal.SetMapIndex(reflect.ValueOf(index), rhV)
+						key, err := mapKey(xVal, index)
+						if err != nil {
+							return nil, err
+						}
+						if xVal.IsNil() {
+							return nil, fmt.Errorf("goeval: assignment to entry in nil map %s", types.ExprString(variable.X))
+						}
+						if compound {
+							current := xVal.MapIndex(key)
+							if !current.IsValid() {
+								current = reflect.Zero(xVal.Type().Elem())
+							}
+							if rh, err = s.binaryOp(current.Interface(), rh, op); err != nil {
+								return nil, err
+							}
+						}
+						rhV, err := valueAs(rh, xVal.Type().Elem())
+						if err != nil {
+							return nil, err
+						}
+						if !xVal.MapIndex(key).IsValid() {
+							if err := s.Options.Limits.checkLen(reflect.Map, xVal.Len()+1); err != nil {
+								return nil, err
+							}
+						}
+						xVal.SetMapIndex(key, rhV)
 					case reflect.Slice:
-						xVal.Index(index.(int)).Set(rhV)
+						i, ok := index.(int)
+						if !ok || i < 0 || i >= xVal.Len() {
+							return nil, fmt.Errorf("goeval: index %v out of range [0:%d]", index, xVal.Len())
+						}
+						if compound {
+							if rh, err = s.binaryOp(xVal.Index(i).Interface(), rh, op); err != nil {
+								return nil, err
+							}
+						}
+						rhV, err := valueAs(rh, xVal.Type().Elem())
+						if err != nil {
+							return nil, err
+						}
+						xVal.Index(i).Set(rhV)
+					case reflect.Array:
+						// arrays are values, so update a copy and store it back
+						ident, ok := variable.X.(*ast.Ident)
+						if !ok {
+							return nil, fmt.Errorf("goeval: cannot assign to %s", types.ExprString(variable))
+						}
+						i, ok := index.(int)
+						if !ok || i < 0 || i >= xVal.Len() {
+							return nil, fmt.Errorf("goeval: invalid array index %v", index)
+						}
+						if compound {
+							if rh, err = s.binaryOp(xVal.Index(i).Interface(), rh, op); err != nil {
+								return nil, err
+							}
+						}
+						elem, err := valueAs(rh, xVal.Type().Elem())
+						if err != nil {
+							return nil, err
+						}
+						arr := reflect.New(xVal.Type()).Elem()
+						arr.Set(xVal)
+						arr.Index(i).Set(elem)
+						s.Set(ident.Name, arr.Interface())
 					default:
-						return nil, fmt.Errorf("goeval: unknown type %v", reflect.TypeOf(x).Kind())
+						return nil, fmt.Errorf("goeval: unknown type %v", xVal.Kind())
+					}
+				case *ast.SelectorExpr:
+					if err := s.assignField(variable, stmt.Tok, rh); err != nil {
+						return nil, err
 					}
 				default:
-					return nil, fmt.Errorf("goeval: unknown assignment type %#v", variable)
+					return nil, fmt.Errorf("goeval: cannot assign to %s", types.ExprString(variable))
 
 				}
 			}
@@ -484,74 +1396,48 @@ func (s *Scope) interpret(body ast.Node) (interface{}, error) {
 			return s.interpret(stmt.Decl)
 		case *ast.ExprStmt:
 			return s.interpret(stmt.X)
-		case *ast.ForStmt:
-			_, err := s.interpret(stmt.Init)
-			if err != nil {
-				return nil, err
+		case *ast.IncDecStmt:
+			op := token.ADD
+			if stmt.Tok == token.DEC {
+				op = token.SUB
 			}
-			for {
-				ok, err := s.interpret(stmt.Cond)
-				if err != nil {
+			return s.interpret(&ast.AssignStmt{
+				Lhs: []ast.Expr{stmt.X},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{&ast.BinaryExpr{X: stmt.X, Op: op, Y: &ast.BasicLit{Kind: token.INT, Value: "1"}}},
+			})
+		case *ast.GoStmt:
+			return nil, s.spawn(stmt.Call)
+		case *ast.SendStmt:
+			return nil, s.send(stmt)
+		case *ast.SwitchStmt:
+			return s.switchStmt(stmt)
+		case *ast.ForStmt:
+			return nil, s.forStmt(stmt, "")
+		case *ast.IfStmt:
+			// the init statement and both branches get their own scope
+			cur := s.NewChild()
+			if stmt.Init != nil {
+				if _, err := cur.interpret(stmt.Init); err != nil {
 					return nil, err
 				}
-				if !ok.(bool) {
-					break
-				}
-				_, _ = s.interpret(stmt.Body)
-				_, _ = s.interpret(stmt.Post)
 			}
-			return nil, nil
-		case *ast.IfStmt:
-			_, _ = s.interpret(stmt.Init)
-			cond, err := s.interpret(stmt.Cond)
+			cond, err := cur.condition(stmt.Cond)
 			if err != nil {
 				return nil, err
 			}
-			if cond.(bool) {
-				return s.interpret(stmt.Body)
+			if cond {
+				return cur.interpret(stmt.Body)
 			}
 			if stmt.Else != nil {
-				return s.interpret(stmt.Else)
+				return cur.interpret(stmt.Else)
 			}
 		case *ast.RangeStmt:
-			ranger, err := s.interpret(stmt.X)
-			if err != nil {
-				return nil, err
-			}
-			var key, value string
-			if stmt.Key != nil {
-				key = stmt.Key.(*ast.Ident).Name
-			}
-			if stmt.Value != nil {
-				value = stmt.Value.(*ast.Ident).Name
-			}
-			rv := reflect.ValueOf(ranger)
-			switch rv.Type().Kind() {
-			case reflect.Array, reflect.Slice:
-				for i := 0; i < rv.Len(); i++ {
-					if len(key) > 0 {
-						s.Set(key, i)
-					}
-					if len(value) > 0 {
-						s.Set(value, rv.Index(i).Interface())
-					}
-					_, _ = s.interpret(stmt.Body)
-				}
-			case reflect.Map:
-				keys := rv.MapKeys()
-				for _, keyV := range keys {
-					if len(key) > 0 {
-						s.Set(key, keyV.Interface())
-					}
-					if len(value) > 0 {
-						s.Set(value, rv.MapIndex(keyV).Interface())
-					}
-					_, _ = s.interpret(stmt.Body)
-				}
-			default:
-				return nil, fmt.Errorf("goeval: range unsupported on %s", rv.Type().Kind().String())
-			}
-			return nil, nil
+			return nil, s.rangeStmt(stmt, "")
+		case *ast.LabeledStmt:
+			return s.labeled(stmt)
+		case *ast.BranchStmt:
+			return nil, branch(stmt)
 		case *ast.ReturnStmt:
 			results := make([]interface{}, len(stmt.Results))
 			for i, result := range stmt.Results {
@@ -562,22 +1448,413 @@ func (s *Scope) interpret(body ast.Node) (interface{}, error) {
 				results[i] = out
 			}
 
+			// unwinds up to the function or script body
 			if len(results) == 0 {
-				return nil, nil
+				return nil, &returnSignal{}
 			}
 			if len(results) == 1 {
-				return results[0], nil
+				return nil, &returnSignal{result: results[0]}
 			}
-			return results, nil
+			return nil, &returnSignal{result: results}
 		default:
-			return nil, fmt.Errorf("goeval: unknown STMT %#v", stmt)
+			return nil, fmt.Errorf("goeval: unsupported statement %T", stmt)
 		}
 	default:
-		return nil, fmt.Errorf("goeval: unknown NODE %#v", node)
+		return nil, fmt.Errorf("goeval: unsupported syntax %T", node)
 	}
 	return nil, nil
 }
 
+// callArgs checks that fun is a function and evaluates the arguments of call
+// for it, appending them to args. When ctx is not nil, it is passed as the
+// first argument of functions taking a context.Context, unless the script
+// passes one itself.
+func (s *Scope) callArgs(call *ast.CallExpr, fun interface{}, ctx context.Context, args []reflect.Value) (reflect.Value, []reflect.Value, error) {
+	rf := reflect.ValueOf(fun)
+	// make sure fun is a function
+	if rf.Kind() != reflect.Func {
+		return rf, args, fmt.Errorf("goeval: %#v not a function", fun)
+	}
+	ft := rf.Type()
+	if call.Ellipsis.IsValid() {
+		return s.spreadArgs(call, rf, ctx, args)
+	}
+	// arguments are converted as they are evaluated, but for the first, which
+	// tells whether the context is passed
+	n := len(call.Args)
+	var first interface{}
+	if n > 0 {
+		var err error
+		if first, err = s.interpret(call.Args[0]); err != nil {
+			return rf, args, err
+		}
+	}
+	start := len(args)
+	if ctx != nil && ft.NumIn() > 0 && ft.In(0) == contextType {
+		if _, given := first.(context.Context); !given {
+			args = append(args, reflect.ValueOf(&ctx).Elem())
+		}
+	}
+	if err := checkArity(ft, len(args)-start+n); err != nil {
+		return rf, args, callError(call, ft, err)
+	}
+	for i, arg := range call.Args {
+		av := first
+		if i > 0 {
+			var err error
+			if av, err = s.interpret(arg); err != nil {
+				return rf, args, err
+			}
+		}
+		v, err := argValue(av, paramType(ft, len(args)-start))
+		if err != nil {
+			return rf, args, callError(call, ft, fmt.Errorf("argument %d: %v", len(args)-start+1, err))
+		}
+		args = append(args, v)
+	}
+	return rf, args, nil
+}
+
+// spreadArgs is callArgs for a call passing a slice as the variadic arguments,
+// as in f(xs...)
+func (s *Scope) spreadArgs(call *ast.CallExpr, rf reflect.Value, ctx context.Context, args []reflect.Value) (reflect.Value, []reflect.Value, error) {
+	ft := rf.Type()
+	values := make([]interface{}, 0, len(call.Args))
+	for _, arg := range call.Args {
+		av, err := s.interpret(arg)
+		if err != nil {
+			return rf, args, err
+		}
+		values = append(values, av)
+	}
+	if !ft.IsVariadic() {
+		return rf, args, callError(call, ft, errors.New("cannot use ... in call to non-variadic function"))
+	}
+	last := values[len(values)-1]
+	values = values[:len(values)-1]
+	if str, ok := last.(string); ok {
+		// as in append(bs, s...), a string spreads into its bytes
+		last = []byte(str)
+	}
+	if last != nil {
+		lv := reflect.ValueOf(last)
+		if lv.Kind() != reflect.Slice {
+			return rf, args, callError(call, ft, fmt.Errorf("cannot use %T with ..., not a slice", last))
+		}
+		for i := 0; i < lv.Len(); i++ {
+			values = append(values, lv.Index(i).Interface())
+		}
+	}
+	start := len(args)
+	if ctx != nil && ft.NumIn() > 0 && ft.In(0) == contextType {
+		given := false
+		if len(values) > 0 {
+			_, given = values[0].(context.Context)
+		}
+		if !given {
+			args = append(args, reflect.ValueOf(&ctx).Elem())
+		}
+	}
+	if err := checkArity(ft, len(args)-start+len(values)); err != nil {
+		return rf, args, callError(call, ft, err)
+	}
+	for _, av := range values {
+		v, err := argValue(av, paramType(ft, len(args)-start))
+		if err != nil {
+			return rf, args, callError(call, ft, fmt.Errorf("argument %d: %v", len(args)-start+1, err))
+		}
+		args = append(args, v)
+	}
+	return rf, args, nil
+}
+
+// convert evaluates a type conversion such as uint8(x)
+func (s *Scope) convert(typ reflect.Type, args []ast.Expr) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("goeval: conversion to %v needs exactly one argument", typ)
+	}
+	arg, err := s.interpret(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if arg == nil {
+		if isNil(reflect.Zero(typ).Interface()) {
+			return reflect.Zero(typ).Interface(), nil
+		}
+		return nil, fmt.Errorf("goeval: cannot convert nil to %v", typ)
+	}
+	v := reflect.ValueOf(arg)
+	if !v.Type().ConvertibleTo(typ) {
+		return nil, fmt.Errorf("goeval: cannot convert %#v to %v", arg, typ)
+	}
+	return v.Convert(typ).Interface(), nil
+}
+
+// addressOf evaluates &x. Only composite literals can be addressed, as script
+// variables are not addressable, and each yields a pointer to a new value.
+func (s *Scope) addressOf(x ast.Expr) (interface{}, error) {
+	if paren, ok := x.(*ast.ParenExpr); ok {
+		return s.addressOf(paren.X)
+	}
+	if _, ok := x.(*ast.CompositeLit); !ok {
+		return nil, fmt.Errorf("goeval: cannot take the address of %s", types.ExprString(x))
+	}
+	v, err := s.interpret(x)
+	if err != nil {
+		return nil, err
+	}
+	rv := reflect.ValueOf(v)
+	ptr := reflect.New(rv.Type())
+	ptr.Elem().Set(rv)
+	return ptr.Interface(), nil
+}
+
+// assignField performs x.f = val, or x.f op= val. Fields are set in place through
+// pointers; a struct held by value in a variable is updated by storing a copy back.
+func (s *Scope) assignField(sel *ast.SelectorExpr, tok token.Token, val interface{}) error {
+	x, err := s.interpret(sel.X)
+	if err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(x)
+	for rv.IsValid() && rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	if inner, ok := x.(reflect.Value); ok {
+		rv = inner
+	}
+	var store func(reflect.Value)
+	switch {
+	case rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.Struct:
+		rv = rv.Elem()
+	case rv.Kind() == reflect.Struct && rv.CanAddr():
+	case rv.Kind() == reflect.Struct:
+		ident, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return fmt.Errorf("goeval: cannot assign to %s", types.ExprString(sel))
+		}
+		cp := reflect.New(rv.Type()).Elem()
+		cp.Set(rv)
+		rv = cp
+		store = func(v reflect.Value) { s.Set(ident.Name, v.Interface()) }
+	default:
+		return fmt.Errorf("goeval: cannot assign to %s", types.ExprString(sel))
+	}
+	if token.ADD_ASSIGN <= tok && tok <= token.AND_NOT_ASSIGN {
+		field := rv.FieldByName(sel.Sel.Name)
+		if !field.IsValid() {
+			return fmt.Errorf("goeval: unknown field %s in %v", sel.Sel.Name, rv.Type())
+		}
+		if val, err = s.binaryOp(field.Interface(), val, tok+(token.ADD-token.ADD_ASSIGN)); err != nil {
+			return err
+		}
+	}
+	if err := setField(rv, sel.Sel.Name, val); err != nil {
+		return err
+	}
+	if store != nil {
+		store(rv)
+	}
+	return nil
+}
+
+// setField sets the named field of the struct rv, converting val to the field type
+func setField(rv reflect.Value, name string, val interface{}) error {
+	field := rv.FieldByName(name)
+	if !field.IsValid() {
+		return fmt.Errorf("goeval: unknown field %s in %v", name, rv.Type())
+	}
+	v, err := valueAs(val, field.Type())
+	if err != nil {
+		return err
+	}
+	field.Set(v)
+	return nil
+}
+
+// methodByName finds a method of v, including the pointer receiver methods of a
+// non-pointer v. Those are called on v itself when it is addressable, otherwise
+// on a copy, so their changes to the receiver are not visible to the script.
+func methodByName(v reflect.Value, name string) reflect.Value {
+	if method := v.MethodByName(name); method.IsValid() || v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		return method
+	}
+	ptr := reflect.New(v.Type())
+	if v.CanAddr() {
+		ptr = v.Addr()
+	} else {
+		ptr.Elem().Set(v)
+	}
+	return ptr.MethodByName(name)
+}
+
+// parseInt parses an integer literal as an int, falling back to int64 and
+// then uint64 for values that do not fit
+func parseInt(lit string) (interface{}, error) {
+	n, err := strconv.ParseInt(lit, 0, 64)
+	if err == nil {
+		if int64(int(n)) == n {
+			return int(n), nil
+		}
+		return n, nil
+	}
+	u, uErr := strconv.ParseUint(lit, 0, 64)
+	if uErr != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// fieldName returns the field name used as key in a struct composite literal
+func fieldName(key ast.Expr) (string, error) {
+	ident, ok := key.(*ast.Ident)
+	if !ok {
+		return "", fmt.Errorf("goeval: invalid field name %#v in struct literal", key)
+	}
+	return ident.Name, nil
+}
+
+// indexValue evaluates X[i] on maps, slices, arrays and strings
+func indexValue(X, i interface{}) (interface{}, error) {
+	xVal := unwrap(reflect.ValueOf(X))
+	if !xVal.IsValid() {
+		return nil, fmt.Errorf("goeval: cannot index nil %#v", X)
+	}
+	if xVal.Kind() == reflect.Map {
+		key, err := mapKey(xVal, i)
+		if err != nil {
+			return nil, err
+		}
+		val := xVal.MapIndex(key)
+		if !val.IsValid() {
+			// If not valid key, return the "zero" type. Eg for int 0, string ""
+			return reflect.Zero(xVal.Type().Elem()).Interface(), nil
+		}
+		return val.Interface(), nil
+	}
+	switch xVal.Kind() {
+	case reflect.Slice, reflect.Array, reflect.String:
+	default:
+		return nil, fmt.Errorf("goeval: cannot index %T", X)
+	}
+	iVal, isInt := i.(int)
+	if !isInt {
+		return nil, fmt.Errorf("goeval: index must be an int not %T", i)
+	}
+	if iVal >= xVal.Len() || iVal < 0 {
+		return nil, errors.New("slice index result of range")
+	}
+	return xVal.Index(iVal).Interface(), nil
+}
+
+// vivify evaluates the container of an index assignment, creating the missing
+// intermediate maps of a chain such as a["x"]["y"] along the way
+func (s *Scope) vivify(expr ast.Expr) (interface{}, error) {
+	index, ok := expr.(*ast.IndexExpr)
+	if !ok {
+		return s.interpret(expr)
+	}
+	X, err := s.vivify(index.X)
+	if err != nil {
+		return nil, err
+	}
+	i, err := s.interpret(index.Index)
+	if err != nil {
+		return nil, err
+	}
+	xVal := unwrap(reflect.ValueOf(X))
+	if xVal.Kind() != reflect.Map {
+		return indexValue(X, i)
+	}
+	key, err := mapKey(xVal, i)
+	if err != nil {
+		return nil, err
+	}
+	if val := xVal.MapIndex(key); val.IsValid() && !isNil(val.Interface()) {
+		return val.Interface(), nil
+	}
+	var child reflect.Value
+	switch elemType := xVal.Type().Elem(); {
+	case elemType.Kind() == reflect.Map:
+		child = reflect.MakeMap(elemType)
+	case elemType.Kind() == reflect.Interface && elemType.NumMethod() == 0:
+		child = reflect.ValueOf(map[string]interface{}{})
+	default:
+		return indexValue(X, i)
+	}
+	xVal.SetMapIndex(key, child)
+	return child.Interface(), nil
+}
+
+// unwrap looks through interfaces, pointers and reflect.Value holders down to
+// the dynamic value, so that nested data can be indexed whatever its static type.
+// A nil pointer or interface yields the invalid Value.
+func unwrap(v reflect.Value) reflect.Value {
+	for v.IsValid() {
+		if inner, ok := v.Interface().(reflect.Value); ok {
+			v = inner
+			continue
+		}
+		if v.Kind() != reflect.Interface && v.Kind() != reflect.Ptr {
+			break
+		}
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// mapKey converts key to the key type of the map m
+func mapKey(m reflect.Value, key interface{}) (reflect.Value, error) {
+	keyType := m.Type().Key()
+	if key == nil {
+		switch keyType.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Chan:
+			return reflect.Zero(keyType), nil
+		}
+		return reflect.Value{}, fmt.Errorf("goeval: cannot use nil as %v map key", keyType)
+	}
+	k := reflect.ValueOf(key)
+	if err := hashable(k); err != nil {
+		return reflect.Value{}, err
+	}
+	if k.Type().AssignableTo(keyType) {
+		return k, nil
+	}
+	if k.Kind() == keyType.Kind() && k.Type().ConvertibleTo(keyType) {
+		return k.Convert(keyType), nil
+	}
+	return reflect.Value{}, fmt.Errorf("goeval: cannot use %#v as %v map key", key, keyType)
+}
+
+// hashable reports an error when v cannot be a map key, as it is or holds a
+// slice, map or func, which would make reflect panic
+func hashable(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Func:
+		return fmt.Errorf("goeval: invalid map key type %v", v.Type())
+	case reflect.Interface:
+		if !v.IsNil() {
+			return hashable(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if err := hashable(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := hashable(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // interfaced converts a slice of []reflect.Value to []interface{}
 func interfaced(values []reflect.Value) []interface{} {
 	iValues := make([]interface{}, len(values))
@@ -592,7 +1869,11 @@ func (s *Scope) Assemble(src string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	_, err = s.interpret(expr.(*ast.CallExpr).Fun.(*ast.FuncLit).Body)
+	body, ok := scriptBody(expr)
+	if !ok {
+		return "", errors.New("goeval: invalid map literal")
+	}
+	_, err = s.run(body)
 	if err != nil {
 		return "", err
 	}
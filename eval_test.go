@@ -1,11 +1,30 @@
 package goeval
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"plugin"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -61,6 +80,27 @@ func BenchmarkEvalCompare(b *testing.B) {
 	}
 }
 
+func BenchmarkEvalArithmetic(b *testing.B) {
+	e, err := NewScope().Compile(`price*quantity + fee*2 > 1000`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	record := map[string]interface{}{"price": 300, "quantity": 4, "fee": 500}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = e.Process(record)
+	}
+}
+
+var arithmeticResult bool
+
+func BenchmarkEvalArithmeticCompare(b *testing.B) {
+	price, quantity, fee := 300, 4, 500
+	for i := 0; i < b.N; i++ {
+		arithmeticResult = price*quantity+fee*2 > 1000
+	}
+}
+
 func TestFor(t *testing.T) {
 	s := NewScope()
 	s.Set("print", fmt.Println)
@@ -143,15 +183,35 @@ func TestMakeMap(t *testing.T) {
 	println(s.GetJsonString("a"))
 }
 
-// todo: try to handle import
 func TestImport(t *testing.T) {
 
 	s := NewScope()
-	//s.Set("ToUpper", strings.ToUpper)
+	s.Options.Resolver = Packages{"strings": Namespace{"ToUpper": strings.ToUpper}}
 	t.Log(s.Eval(`import "strings"
 	a := strings.ToUpper("abc")`))
 	println(s.GetJsonString("a"))
+	if s.Get("a") != "ABC" {
+		t.Fatalf("unexpected %#v", s.Get("a"))
+	}
 
+	tenant := "acme"
+	s.Options.Resolver = ResolverFunc(func(path string) (Namespace, error) {
+		if path != "tenant/policies" {
+			return nil, fmt.Errorf("unknown package %s", path)
+		}
+		return Namespace{"Limit": map[string]int{"acme": 5}[tenant]}, nil
+	})
+	v, err := s.Eval(`import (
+		p "tenant/policies"
+		. "tenant/policies"
+	)
+	p.Limit + Limit`)
+	if err != nil || v != 10 {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+	if _, err = s.Eval(`import "os"`); err == nil {
+		t.Fatal("expected resolve error")
+	}
 }
 
 func TestConcurrent(t *testing.T) {
@@ -194,7 +254,7 @@ func BenchmarkEvalStringContact(b *testing.B) {
 
 func TestStringToType(t *testing.T) {
 	fmt.Printf("%v\n", reflect.TypeOf(""))
-	println(reflect.TypeOf("") == reflect.TypeOf(string(0)))
+	println(reflect.TypeOf("") == reflect.TypeOf(""))
 	var a interface{}
 	a = map[string]int{}
 	fmt.Printf("%v", reflect.TypeOf(a).Kind())
@@ -209,42 +269,3804 @@ func TestAppend(t *testing.T) {
 	fmt.Println(s.GetJsonString("a"))
 }
 
-func TestAstPrint(t *testing.T) {
-	fSet := token.NewFileSet() // positions are relative to fSet
-	f, err := parser.ParseFile(fSet, "", `package main
-	import "strings"
-	import "fmt"
+func TestAppendAssignable(t *testing.T) {
+	s := NewScope()
+	s.Set("readers", []io.Reader{})
+	s.Set("buf", &bytes.Buffer{})
+	got, err := s.Eval(`return []interface{}{
+	append([]interface{}{}, 1, "a", nil),
+	append([]float64{0.5}, 1),
+	len(append(readers, buf)),
+	string(append([]byte("a"), "bc"...)),
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{[]interface{}{1, "a", nil}, []float64{0.5, 1}, 1, "abc"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	for _, src := range []string{`append([]int{}, "x")`, `append([]int{}, 1.5)`, `append(nil, 1)`} {
+		if _, err := s.Eval(src); err == nil {
+			t.Errorf("%s: no error", src)
+		}
+	}
+}
 
-	func main() {
-		a := map[string]interface{}{"a":1}
-	}`, 0)
+func TestMake(t *testing.T) {
+	s := NewScope()
+	s.Set("n", int64(3))
+	s.Set("c", uint8(5))
+	s.SetBuiltinType("Ints", reflect.TypeOf([]int{}))
+	got, err := s.Eval(`type Counts map[string]int
+xs := make([]string, n, c)
+return []interface{}{xs, len(make(Ints, n)), len(make(Counts, n))}`)
 	if err != nil {
-		panic(err)
+		t.Fatal(err)
+	}
+	if want := []interface{}{[]string{"", "", ""}, 3, 0}; !reflect.DeepEqual(got, want) || cap(got.([]interface{})[0].([]string)) != 5 {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	for _, src := range []string{`make([]int, -1)`, `make([]int, 3, 2)`, `make([]int, "3")`, `make([]int, 1.5)`, `make(int)`} {
+		if _, err := s.Eval(src); err == nil {
+			t.Errorf("%s: no error", src)
+		}
 	}
-	//Print the AST.
-	_ = ast.Print(fSet, f)
 }
 
-func TestMakeMapType(t *testing.T) {
-	m := map[string]interface{}{"a": 1}
-	//v := reflect.MakeMap()
-	fmt.Printf("%T\n", m)
-	fmt.Printf("%v\n", reflect.TypeOf(m))
+func TestLenCap(t *testing.T) {
+	s := NewScope()
+	s.Set("arr", &[4]int{})
+	got, err := s.Eval(`xs := make([]int, 2, 8)
+return []interface{}{len(xs), cap(xs), len(nil), cap(nil), len(arr), cap(arr), len("héllo"), cap(make(chan int, 3))}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{2, 8, 0, 0, 4, 4, 6, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	for _, src := range []string{`len(3)`, `cap("abc")`, `cap(map[string]int{})`} {
+		if _, err := s.Eval(src); err == nil || !strings.Contains(err.Error(), "invalid argument") {
+			t.Errorf("%s: got %v", src, err)
+		}
+	}
 }
 
-func TestInterface(t *testing.T) {
-	a := map[string]interface{}{}
-	mapTyp := reflect.TypeOf(a)
-	fmt.Printf("%v\n", mapTyp.Key())
-	fmt.Printf("%#v\n", mapTyp.Elem())
+type codeError struct{ Code int }
+
+func (e *codeError) Error() string { return fmt.Sprintf("code %d", e.Code) }
+
+func TestErrorBuiltins(t *testing.T) {
+	notFound := errors.New("not found")
+	s := NewScope()
+	s.Set("ErrNotFound", notFound)
+	s.Set("fetchErr", fmt.Errorf("fetch: %w", &codeError{404}))
+	s.SetBuiltinType("CodeError", reflect.TypeOf(&codeError{}))
+	got, err := s.Eval(`err := wrapErr(errorf("user %d: %w", 7, ErrNotFound), "loading")
+ce, ok := asErr(fetchErr, CodeError)
+_, none := asErr(err, CodeError)
+return []interface{}{toString(err), isErr(err, ErrNotFound), isErr(errorf("x"), ErrNotFound), ok, ce.Code, none, wrapErr(nil, "x") == nil}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{"loading: user 7: not found", true, false, true, 404, false, true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
 }
 
-func TestInterfaceSlice(t *testing.T) {
+func TestChannels(t *testing.T) {
+	s := NewScope()
+	s.Set("drain", func(ch <-chan int) int {
+		sum := 0
+		for v := range ch {
+			sum += v
+		}
+		return sum
+	})
+	s.Set("closeChan", func(ch chan int) { close(ch) })
+	got, err := s.Eval(`ch := make(chan int, 4)
+produce := func(out chan<- int, n int) { out <- n }
+produce(ch, 1)
+produce(ch, 2)
+ch <- 3
+closeChan(ch)
+return []interface{}{len(ch), cap(ch), drain(ch)}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{3, 4, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	got, err = s.Eval(`ch := make(chan int, 2)
+ch <- 4
+ch <- 5
+closeChan(ch)
+total := 0
+for v := range ch { total += v }
+total`)
+	if err != nil || got != 9 {
+		t.Errorf("got %v, %v", got, err)
+	}
+	if _, err := s.Eval(`var r <-chan int = make(chan int, 1)
+r <- 1`); err == nil {
+		t.Error("sent to a receive-only channel")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.EvalContext(ctx, `ch := make(chan int)
+ch <- 1`); err != context.DeadlineExceeded {
+		t.Errorf("blocked send: got %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.EvalContext(ctx, `ch := make(chan int)
+<-ch`); err != context.DeadlineExceeded {
+		t.Errorf("blocked receive: got %v", err)
+	}
+	s.Options.Timeout = 20 * time.Millisecond
+	if _, err := s.Eval(`v := <-make(chan int); v`); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("blocked receive past Options.Timeout: got %v", err)
+	}
+}
 
+func TestChannelTimeouts(t *testing.T) {
 	s := NewScope()
-	t.Log(s.Eval(`a := []interface{}{1,2,3}
-	a = append(a, 6)
-	b := []interface{}{4,5}
-	a = append(a, b...)`))
-	fmt.Println(s.GetJsonString("a"))
+	got, err := s.Eval(`ch := make(chan string, 1)
+sent := sendTimeout(ch, "a", "10ms")
+full := sendTimeout(ch, "b", 10000000)
+v, ok := recvTimeout(ch, "10ms")
+none, waited := recvTimeout(ch, "10ms")
+return []interface{}{sent, full, v, ok, none, waited}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{true, false, "a", true, "", false}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	for _, src := range []string{`recvTimeout(1, "1s")`, `recvTimeout(make(chan int), "soon")`, `sendTimeout(make(chan int), "x", "1s")`} {
+		if _, err := s.Eval(src); err == nil {
+			t.Errorf("%s: no error", src)
+		}
+	}
+}
+
+func TestChannelBridge(t *testing.T) {
+	s := NewScope()
+	in := s.ChannelIn("numbers", 0)
+	progress := s.ChannelOut("progress", 0)
+	go func() {
+		for i := 1; i <= 4; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+	var seen []interface{}
+	done := make(chan struct{})
+	go func() {
+		for p := range progress {
+			seen = append(seen, p)
+		}
+		close(done)
+	}()
+	got, err := s.Eval(`sum := 0
+for n := range numbers {
+	sum += n
+	progress <- sum
+}
+close(progress)
+sum`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if got != 10 || !reflect.DeepEqual(seen, []interface{}{1, 3, 6, 10}) {
+		t.Errorf("got %v, progress %v", got, seen)
+	}
+	prices := make(chan float64, 1)
+	if err := s.BindChannel("prices", prices, reflect.SendDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Eval(`prices <- 3`); err != nil || <-prices != 3.0 {
+		t.Errorf("send converted: %v", err)
+	}
+	if _, err := s.Eval(`<-prices`); err == nil {
+		t.Error("received from a send-only binding")
+	}
+	if err := s.BindChannel("out", (<-chan int)(make(chan int)), reflect.SendDir); err == nil {
+		t.Error("bound a receive-only channel for sending")
+	}
+	if _, err := s.Eval(`close(progress)`); err == nil {
+		t.Error("closed a channel twice")
+	}
+}
+
+func TestMapKeys(t *testing.T) {
+	type pair struct{ A, B interface{} }
+	s := NewScope()
+	s.Set("p", pair{1, []int{2}})
+	for src, want := range map[string]string{
+		`map[[]int]bool{}`: "invalid map key type []int",
+		`m := map[interface{}]int{}
+m[[]int{1}] = 1`: "invalid map key type []int",
+		`map[interface{}]int{map[string]int{}: 1}`: "invalid map key type map[string]int",
+		`m := make(map[interface{}]bool)
+m[p]`: "invalid map key type []int",
+		`m := map[interface{}]int{}
+m[func() {}] = 1`: "invalid map key type func()",
+	} {
+		if _, err := s.Eval(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", src, err, want)
+		}
+	}
+	got, err := s.Eval(`m := map[interface{}]float64{1: 2, "a": 3}
+m[[2]int{1, 2}] = 4
+len(m)`)
+	if err != nil || got != 3 {
+		t.Errorf("got %v, %v", got, err)
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	s := NewScope()
+	grade := func(score int) interface{} {
+		s.Set("score", score)
+		got, err := s.Eval(`grade := ""
+switch {
+case score >= 90:
+	grade = "A"
+case score >= 75:
+	grade = "B"
+	fallthrough
+case score >= 60:
+	grade += "pass"
+default:
+	grade = "fail"
+}
+grade`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	for score, want := range map[int]string{95: "A", 80: "Bpass", 65: "pass", 10: "fail"} {
+		if got := grade(score); got != want {
+			t.Errorf("%d: got %v, want %v", score, got, want)
+		}
+	}
+	got, err := s.Eval(`out := []string{}
+for i := 0; i < 6; i++ {
+	switch n := i % 3; n {
+	case 0:
+		continue
+	case 1, "one":
+		if i > 3 {
+			break
+		}
+		out = append(out, "one")
+	default:
+		out = append(out, sprint(n))
+	}
+	out = append(out, "|")
+}
+out`)
+	if want := []string{"one", "|", "2", "|", "|", "2", "|"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, %v, want %v", got, err, want)
+	}
+	for _, src := range []string{
+		`switch { case 1: }`,
+		`switch 1 { case 1: fallthrough }`,
+		`fallthrough`,
+	} {
+		if _, err := s.Eval(src); err == nil {
+			t.Errorf("%s: no error", src)
+		}
+	}
+}
+
+func TestSpreadArgs(t *testing.T) {
+	s := NewScope()
+	s.Set("sum", func(base int, nums ...int) int {
+		for _, n := range nums {
+			base += n
+		}
+		return base
+	})
+	got, err := s.Eval(`a := []int{1, 2}
+a = append(a, []int{3, 4}...)
+var none []int
+return []interface{}{a, sum(100, a...), sum(1, none...), sprintf("%d-%d", []interface{}{1, 2}...)}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{[]int{1, 2, 3, 4}, 110, 1, "1-2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	if _, err := s.Eval(`toInt([]interface{}{"1"}...)`); err == nil {
+		t.Error("spread into a non-variadic function was accepted")
+	}
+}
+
+func TestContextInjection(t *testing.T) {
+	type key struct{}
+	s := NewScope()
+	s.Set("user", func(ctx context.Context, prefix string) string {
+		name, _ := ctx.Value(key{}).(string)
+		return prefix + name
+	})
+	s.Set("ctxOf", func(ctx context.Context) context.Context { return ctx })
+	ctx := context.WithValue(context.Background(), key{}, "ann")
+	got, err := s.EvalContext(ctx, `user("hi ") + user(ctxOf(), "bye ")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "hi annbye ann" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestFilterMapReduce(t *testing.T) {
+	s := NewScope()
+	double, err := s.Lambda(`return x * 2`, func(x int) int { return 0 }, "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("double", double)
+	got, err := s.Eval(`xs := []int{1, 2, 3, 4}
+evens := filter(xs, func(x int) bool { return x%2 == 0 })
+mixed := filter([]interface{}{1, "a", 2}, func(x interface{}) bool { return toString(x) != "a" })
+strs := mapSlice(xs, func(x int) string { return sprint(x) })
+doubled := mapSlice(evens, double)
+sum := reduce(xs, func(acc, x int) int { return acc + x }, 0)
+return []interface{}{evens, mixed, strs, doubled, sum, reduce(nil, func(acc, x int) int { return 0 }, 7)}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{[]int{2, 4}, []interface{}{1, 2}, []string{"1", "2", "3", "4"}, []int{4, 8}, 10, 7}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	if _, err := s.Eval(`filter([]int{1}, func(x int) int { return x })`); err == nil {
+		t.Error("non-bool filter function was accepted")
+	}
+	for _, src := range []string{
+		`mapSlice([]int{1}, func(x int) (int, int) { return x, x })`,
+		`mapSlice([]int{1}, func(x int) (int, string, error) { return x, "", nil })`,
+	} {
+		if _, err := s.Eval(src); err == nil || !strings.HasPrefix(err.Error(), "goeval: mapSlice function") {
+			t.Errorf("%s: got %v", src, err)
+		}
+	}
+	s.Set("inc", func(x int) (int, error) { return x + 1, nil })
+	if got, err := s.Eval(`mapSlice([]int{1, 2}, inc)`); err != nil || !reflect.DeepEqual(got, []int{2, 3}) {
+		t.Errorf("mapper with an error result: got %#v, %v", got, err)
+	}
+}
+
+func TestSort(t *testing.T) {
+	s := NewScope()
+	s.Set("xs", []int{3, 1, 2})
+	got, err := s.Eval(`words := sort([]string{"b", "c", "a"})
+byLen := sortBy([]string{"ccc", "a", "bb", "d"}, func(a, b string) bool { return len(a) < len(b) })
+return []interface{}{sort(xs), words, byLen, sort([]interface{}{2.5, 1, 2})}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{[]int{1, 2, 3}, []string{"a", "b", "c"}, []string{"a", "d", "bb", "ccc"}, []interface{}{1, 2, 2.5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	if xs := s.Get("xs"); !reflect.DeepEqual(xs, []int{3, 1, 2}) {
+		t.Errorf("sort changed its argument: %v", xs)
+	}
+	s.Options.Resolver = Packages{"sort": Namespace{"Ints": func(xs []int) {}}}
+	if _, err := s.Eval(`import "sort"
+sort.Ints([]int{2, 1})`); err != nil {
+		t.Errorf("imported sort package: %v", err)
+	}
+	if _, err := s.Eval(`sort([]bool{true, false})`); err == nil {
+		t.Error("sorting bools was accepted")
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	s := NewScope()
+	s.Set("base", 40)
+	sc := NewScheduler(s)
+	results := make(chan interface{}, 10)
+	sc.OnResult = func(job string, result interface{}, err error) {
+		if err != nil {
+			t.Errorf("%s: %v", job, err)
+		}
+		results <- result
+	}
+	if err := sc.Add(Job{Name: "tick", Src: `base + n`, Every: 10 * time.Millisecond, Vars: map[string]interface{}{"n": 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.Add(Job{Name: "spin", Src: `for {}`, Cron: "0 0 1 1 *", Timeout: 20 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.Add(Job{Name: "bad", Src: `1`, Cron: "61 * * * *"}); err == nil {
+		t.Error("invalid cron expression was accepted")
+	}
+	sc.Start()
+	for i := 0; i < 2; i++ {
+		select {
+		case got := <-results:
+			if got != 42 {
+				t.Errorf("got %v, want 42", got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("job did not run")
+		}
+	}
+	sc.Stop()
+	if _, err := sc.Run(context.Background(), "spin"); err != context.DeadlineExceeded {
+		t.Errorf("timed out run: got %v", err)
+	}
+
+	cron, err := parseCron("*/15 9-17 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2024, 3, 8, 17, 50, 0, 0, time.UTC) // a Friday
+	if next := cron.next(from); !next.Equal(time.Date(2024, 3, 11, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("next run after %v: got %v", from, next)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	os.Setenv("GOEVAL_TEST_REGION", "eu")
+	os.Setenv("GOEVAL_TEST_SECRET", "hunter2")
+	s := NewScope()
+	if _, err := s.Eval(`env("GOEVAL_TEST_REGION")`); err == nil {
+		t.Error("env is available without Options.Env")
+	}
+	s.Options.Env = AllowEnv("GOEVAL_TEST_REGION")
+	if got, err := s.Eval(`env("GOEVAL_TEST_REGION")`); err != nil || got != "eu" {
+		t.Errorf("got %v, %v", got, err)
+	}
+	if _, err := s.Eval(`env("GOEVAL_TEST_SECRET")`); err == nil {
+		t.Error("env exposed a variable outside the allowlist")
+	}
+	s.Options.Env = EnvMap(map[string]string{"STAGE": "prod"})
+	if got, err := s.Eval(`env("STAGE")`); err != nil || got != "prod" {
+		t.Errorf("got %v, %v", got, err)
+	}
+}
+
+func TestEvalReader(t *testing.T) {
+	s := NewScope()
+	got, err := s.EvalReader("rules/ok.go", strings.NewReader("x := 20\nx * 2"))
+	if err != nil || got != 40 {
+		t.Errorf("got %v, %v", got, err)
+	}
+	_, err = s.EvalReader("rules/bad.go", strings.NewReader("x := 1\nif x {\n}"))
+	if err == nil || !strings.HasSuffix(err.Error(), "at rules/bad.go:2:4") {
+		t.Errorf("got %v", err)
+	}
+	e, err := s.CompileReader("rules/filter.go", strings.NewReader("if age {\n}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pe *PosError
+	if _, err := e.Process(map[string]interface{}{"age": 3}); !errors.As(err, &pe) || pe.File != "rules/filter.go" || pe.Line != 1 {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	s := NewScope()
+	deep := strings.Repeat("(1+", 20000) + "1" + strings.Repeat(")", 20000)
+	if _, err := s.Eval(deep); err != ErrTooDeep {
+		t.Errorf("deep expression: got %v", err)
+	}
+	if _, err := s.Eval(`var f func(n int) int
+f = func(n int) int { return f(n + 1) }
+f(0)`); !errors.Is(err, ErrTooDeep) {
+		t.Errorf("runaway recursion: got %v", err)
+	}
+	if got, err := s.Eval(`var fact func(n int) int
+fact = func(n int) int {
+	r := 1
+	if n > 1 {
+		r = n * fact(n-1)
+	}
+	return r
+}
+fact(10)`); err != nil || got != 3628800 {
+		t.Errorf("got %v, %v", got, err)
+	}
+	s.Options.MaxDepth = 5
+	if _, err := s.Eval(`((((((1))))))`); err != ErrTooDeep {
+		t.Errorf("MaxDepth 5: got %v", err)
+	}
+}
+
+func TestVerboseErrors(t *testing.T) {
+	s := NewScope()
+	s.Set("fail", func(int) error { return errors.New("boom") })
+	src := "x := 1\n\ty := x + fail(2)"
+	if _, err := s.Eval(src); err == nil || err.Error() != "boom" {
+		t.Errorf("without VerboseErrors: got %v", err)
+	}
+	s.Options.VerboseErrors = true
+	_, err := s.Eval(src)
+	want := "boom at line 2, column 11\n\t\ty := x + fail(2)\n\t\t         ^"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+	var pe *PosError
+	if !errors.As(err, &pe) || pe.Err.Error() != "boom" {
+		t.Errorf("got %#v", err)
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	s := NewScope()
+	s.Options.ContinueOnError = true
+	got, err := s.Eval(`a := 1
+b := missing(1)
+a = a + 1
+c := 1 / 0
+a * 10`)
+	if got != 20 {
+		t.Errorf("got %v, want 20", got)
+	}
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("got %#v", err)
+	}
+	var pe *PosError
+	if !errors.As(errs[1], &pe) || pe.Line != 4 {
+		t.Errorf("second error: got %v", errs[1])
+	}
+}
+
+func TestScopeOptions(t *testing.T) {
+	var out bytes.Buffer
+	s := NewScope(
+		WithVars(map[string]interface{}{"n": 3}),
+		WithOutput(&out),
+		WithTimeout(50*time.Millisecond),
+	)
+	if _, err := s.Eval(`printf("n=%d", n)`); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "n=3" {
+		t.Errorf("got output %q", out.String())
+	}
+	if _, err := s.Eval(`for {}`); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want the deadline error", err)
+	}
+}
+
+func TestPresetScopes(t *testing.T) {
+	s := NewScopeWithStdlib()
+	got, err := s.Eval(`mu := sync.newMutex()
+mu.Lock()
+mu.Unlock()
+strings.upper("a") + toString(math.max(1, 2))`)
+	if err != nil || got != "A2" {
+		t.Errorf("got %v, %v", got, err)
+	}
+	sandbox := NewPresetScope(Sandboxed, WithTimeout(20*time.Millisecond))
+	if _, err := sandbox.Eval(`sync.newMutex()`); err == nil {
+		t.Error("sandboxed scope has the sync primitives")
+	}
+	if _, err := sandbox.Eval(`for {}`); err != context.DeadlineExceeded {
+		t.Errorf("sandboxed loop: got %v", err)
+	}
+}
+
+func TestScopeBuiltins(t *testing.T) {
+	s := NewScope()
+	s.SetBuiltin("len", func(v interface{}) int { return 42 })
+	s.SetBuiltin("double", func(n int) int { return n * 2 })
+	s.SetBuiltinType("id", reflect.TypeOf(int64(0)))
+	child := s.NewChild()
+	got, err := child.Eval(`[]interface{}{len("abc"), double(2), id(7)}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{42, 4, int64(7)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	other := NewScope()
+	if got, err := other.Eval(`len("abc")`); err != nil || got != 3 {
+		t.Errorf("override leaked into another scope: got %v, %v", got, err)
+	}
+	if _, err := other.Eval(`double(2)`); err == nil {
+		t.Error("builtin leaked into another scope")
+	}
+}
+
+func TestDisableBuiltins(t *testing.T) {
+	s := NewScope()
+	s.DisableBuiltins("make", "go")
+	child := s.NewChild()
+	var pe *PolicyError
+	if _, err := child.Eval(`m := make(map[string]int)`); !errors.As(err, &pe) || pe.Name != "make" {
+		t.Errorf("make: got %v", err)
+	}
+	if _, err := child.Eval(`go func() {}()`); !errors.As(err, &pe) || pe.Name != "go" {
+		t.Errorf("go: got %v", err)
+	}
+	if got, err := child.Eval(`len(append([]int{}, 1))`); err != nil || got != 1 {
+		t.Errorf("other builtins: got %v, %v", got, err)
+	}
+	if _, err := NewScope().Eval(`make(map[string]int)`); err != nil {
+		t.Errorf("other scopes: %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	s := NewScope()
+	s.Options.Limits = Limits{MaxSliceLen: 100, MaxMapLen: 3, MaxStringLen: 16}
+	cases := []struct {
+		src, limit string
+	}{
+		{`make([]int, 1000)`, "MaxSliceLen"},
+		{`make([]int, 0, 1000)`, "MaxSliceLen"},
+		{`var a [1 << 40]int`, "MaxSliceLen"},
+		{`xs := []int{}
+for i := 0; i < 200; i++ { xs = append(xs, i) }`, "MaxSliceLen"},
+		{`m := map[int]int{}
+for i := 0; i < 10; i++ { m[i] = i }`, "MaxMapLen"},
+		{`s := "ab"
+for i := 0; i < 10; i++ { s += s }`, "MaxStringLen"},
+		{`for i := 0; i < 10; i++ { p.S += p.S }`, "MaxStringLen"},
+		{`xs := make([]int, 60)
+append(xs, xs)`, "MaxSliceLen"},
+		{`xs := make([]int, 60)
+append(xs, xs...)`, "MaxSliceLen"},
+		{`sprint(p.S, p.S, p.S, p.S, p.S, p.S, p.S, p.S, p.S)`, "MaxStringLen"},
+		{`sprintf("%20d", 1)`, "MaxStringLen"},
+		{`strings.join([]string{"abcdefgh", "abcdefgh", "!"}, "")`, "MaxStringLen"},
+		{`strings.replace("aaaa", "a", "abcde")`, "MaxStringLen"},
+	}
+	s.Set("p", &struct{ S string }{"ab"})
+	s.InstallStrings("strings")
+	for _, c := range cases {
+		_, err := s.Eval(c.src)
+		var le *LimitError
+		if !errors.Is(err, ErrLimitExceeded) || !errors.As(err, &le) || le.Limit != c.limit {
+			t.Errorf("%s: got %v, want %s exceeded", c.src, err, c.limit)
+		}
+	}
+	if got, err := s.Eval(`m := map[int]int{}
+for i := 0; i < 3; i++ { m[i] = i }
+m[0] = 5
+len(append(make([]int, 10), 1, 2)) + len(sprint("abc"))`); err != nil || got != 15 {
+		t.Errorf("within limits: got %v, %v", got, err)
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	s := NewScope(WithVars(map[string]interface{}{
+		"hang": func() int { <-release; return 1 },
+		"wait": func(ctx context.Context) error { <-ctx.Done(); return ctx.Err() },
+		"fast": func() int { return 2 },
+		"nap":  func() { time.Sleep(50 * time.Millisecond) },
+	}))
+	s.Options.CallTimeout = 20 * time.Millisecond
+	start := time.Now()
+	if _, err := s.Eval(`hang()`); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("hanging call: got %v", err)
+	}
+	if _, err := s.Eval(`wait()`); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("context aware call: got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Error("calls were not abandoned at the timeout")
+	}
+	// script functions run in the interpreter, only their host calls are bounded
+	s.Options.CallTimeout = 100 * time.Millisecond
+	if got, err := s.Eval(`slow := func() int {
+	for i := 0; i < 3; i++ {
+		nap()
+	}
+	return fast()
+}
+slow()`); err != nil || got != 2 {
+		t.Errorf("script function: got %v, %v", got, err)
+	}
+}
+
+func TestHTTPBuiltins(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/echo":
+			b, _ := ioutil.ReadAll(r.Body)
+			fmt.Fprintf(w, "%s %s %s %s", r.Method, r.Header.Get("Accept"), r.Header.Get("X-Key"), b)
+		case "/api/big":
+			w.Write(make([]byte, 100))
+		case "/api/away":
+			http.Redirect(w, r, "/private", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	s := NewScope()
+	if _, err := s.Eval(`httpGet("` + srv.URL + `/api/echo")`); err == nil {
+		t.Error("http builtins are available without a policy")
+	}
+	s.Options.HTTP = &HTTPPolicy{
+		Allow:           []string{srv.URL + "/api/", srv.URL + "/public"},
+		AllowHeaders:    []string{"accept"},
+		Headers:         map[string]string{"X-Key": "secret"},
+		MaxResponseSize: 50,
+	}
+	got, err := s.Eval(`resp := httpPost("` + srv.URL + `/api/echo", "text/plain", "hi", map[string]string{"Accept": "text/plain"})
+return resp.Body`)
+	if err != nil || got != "POST text/plain secret hi" {
+		t.Errorf("got %v, %v", got, err)
+	}
+	var pe *PolicyError
+	for _, src := range []string{
+		`httpGet("` + srv.URL + `/private")`,
+		`httpGet("` + srv.URL + `/api/echo", map[string]string{"X-Key": "forged"})`,
+		`httpGet("` + srv.URL + `/api/away")`,
+		`httpGet("` + srv.URL + `/publicsecret")`,
+		`httpGet("` + srv.URL + `/api/../private")`,
+		`httpGet("` + srv.URL + `/api/%2e%2e/private")`,
+		`httpGet("` + srv.URL + `/public/./../private")`,
+	} {
+		if _, err := s.Eval(src); !errors.As(err, &pe) {
+			t.Errorf("%s: got %v, want a policy error", src, err)
+		}
+	}
+	if _, err := s.Eval(`httpGet("` + srv.URL + `/api/big")`); err == nil {
+		t.Error("oversized response was accepted")
+	}
+}
+
+// tableDriver is a database/sql driver whose queries return the table named
+// by the query text
+type tableDriver map[string][][]driver.Value
+
+func (d tableDriver) Open(string) (driver.Conn, error) { return d, nil }
+func (d tableDriver) Prepare(query string) (driver.Stmt, error) {
+	return tableStmt{d[query]}, nil
+}
+func (d tableDriver) Close() error              { return nil }
+func (d tableDriver) Begin() (driver.Tx, error) { return nil, errors.New("no transactions") }
+
+type tableStmt struct{ table [][]driver.Value }
+
+func (st tableStmt) Close() error  { return nil }
+func (st tableStmt) NumInput() int { return 0 }
+func (st tableStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("no exec")
+}
+func (st tableStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &tableRows{table: st.table}, nil
+}
+
+// tableRows serves the first row of the table as column names
+type tableRows struct {
+	table [][]driver.Value
+	next  int
+}
+
+func (r *tableRows) Columns() []string {
+	columns := make([]string, len(r.table[0]))
+	for i, v := range r.table[0] {
+		columns[i] = v.(string)
+	}
+	return columns
+}
+func (r *tableRows) Close() error { return nil }
+func (r *tableRows) Next(dest []driver.Value) error {
+	r.next++
+	if r.next >= len(r.table) {
+		return io.EOF
+	}
+	copy(dest, r.table[r.next])
+	return nil
+}
+
+func TestRows(t *testing.T) {
+	sql.Register("goeval-table", tableDriver{"people": {
+		{"name", "age"},
+		{[]byte("ann"), int64(34)},
+		{[]byte("bob"), int64(12)},
+		{[]byte("cid"), int64(19)},
+	}})
+	db, err := sql.Open("goeval-table", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := ScanRows(rows)
+	if err != nil || len(records) != 3 || records[0]["name"] != "ann" || records[2]["age"] != int64(19) {
+		t.Fatalf("got %v, %v", records, err)
+	}
+	e, err := NewScope().Compile(`age >= 18`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adults, err := e.FilterRecords(records)
+	if err != nil || len(adults) != 2 || adults[1]["name"] != "cid" {
+		t.Errorf("got %v, %v", adults, err)
+	}
+	if rows, err = db.Query("people"); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	e, _ = NewScope().Compile(`name + "!"`)
+	err = e.EachRow(rows, func(record map[string]interface{}, result interface{}) error {
+		names = append(names, result.(string))
+		return nil
+	})
+	if err != nil || strings.Join(names, " ") != "ann! bob! cid!" {
+		t.Errorf("got %v, %v", names, err)
+	}
+	e, _ = NewScope().Compile(`name`)
+	if _, err := e.FilterRecords(records); err == nil {
+		t.Error("a non bool condition is accepted")
+	}
+}
+
+func TestScopePool(t *testing.T) {
+	base := NewScope()
+	base.Vars["rate"] = 2
+	pool := NewScopePool(base)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got, err := pool.Eval(`y := x * rate
+y`, map[string]interface{}{"x": i})
+			if err != nil || got != i*2 {
+				t.Errorf("got %v, %v", got, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	s := pool.Get()
+	s.Vars["x"] = 1
+	pool.Put(s)
+	s = pool.Get()
+	if len(s.Vars) != 0 || s.Parent != base {
+		t.Errorf("pooled scope not reset: %v", s.Vars)
+	}
+	if _, err := s.Eval(`rate = 3`); err == nil || base.Vars["rate"] != 2 {
+		t.Errorf("a script assigned the pool parent: %v", err)
+	}
+	pool.Put(s)
+}
+
+func TestCheck(t *testing.T) {
+	s := NewScope()
+	s.Set("age", 30)
+	diags := s.Check(`type Person struct{ Name string }
+grow := func(n int) int { return n + step }
+L:
+for i := 0; i < 3; i++ { if i > age { break L } }
+grow(age) > limit`)
+	want := []string{":2:38: undefined: step", ":5:13: undefined: limit"}
+	if len(diags) != len(want) {
+		t.Fatalf("got %v", diags)
+	}
+	for i, d := range diags {
+		if d.String() != want[i] {
+			t.Errorf("got %v, want %v", d, want[i])
+		}
+	}
+	if diags := s.Check("x := (1"); len(diags) != 1 || diags[0].Line != 1 {
+		t.Errorf("got %v", diags)
+	}
+}
+
+func TestPlayground(t *testing.T) {
+	srv := httptest.NewServer(Playground(nil))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "<textarea") {
+		t.Errorf("got page %q", page)
+	}
+	for _, c := range []struct {
+		src  string
+		want PlaygroundResponse
+	}{
+		{`printf("hi %v", n)
+n * 2`, PlaygroundResponse{Result: 3.0, Type: "float64", Output: "hi 1.5"}},
+		{`undefined()`, PlaygroundResponse{Error: "goeval: undefined: undefined"}},
+	} {
+		body, _ := json.Marshal(PlaygroundRequest{Src: c.src, Vars: map[string]interface{}{"n": 1.5}})
+		resp, err := http.Post(srv.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got PlaygroundResponse
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil || got.Result != c.want.Result || got.Type != c.want.Type || got.Output != c.want.Output || (c.want.Error != "") != (got.Error != "") {
+			t.Errorf("%s: got %+v, %v", c.src, got, err)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	type Order struct {
+		Total float64
+		Items []string
+	}
+	s := NewScope()
+	s.InstallStrings("strings")
+	s.Set("order", &Order{})
+	s.Set("orderID", 7)
+	labels := func(src string) string {
+		var out []string
+		for _, c := range s.Complete(src, len(src)) {
+			out = append(out, c.Label)
+		}
+		return strings.Join(out, " ")
+	}
+	for src, want := range map[string]string{
+		"ord":                    "order orderID",
+		"order.T":                "Total",
+		"strings.hasP":           "hasPrefix",
+		"ordinal, n := 1, 2\nor": "order orderID ordinal",
+		"x := 1\nle":             "len",
+	} {
+		if got := labels(src); got != want {
+			t.Errorf("%q: got %q, want %q", src, got, want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for src, want := range map[string]string{
+		"x:=1\nif x>0{x++}\n// done\nx":                            "x := 1\nif x > 0 {\n\tx++\n}\n// done\nx\n",
+		"import (\"strings\";\"math\")\nstrings.ToUpper(`a\n\tb`)": "import (\n\t\"math\"\n\t\"strings\"\n)\n\nstrings.ToUpper(`a\n\tb`)\n",
+		"use \"lib/rates\"\nrates.get( 1 )":                        "use \"lib/rates\"\n\nrates.get(1)\n",
+	} {
+		got, err := Format(src)
+		if err != nil || got != want {
+			t.Errorf("%q: got %q, %v, want %q", src, got, err, want)
+		}
+		if again, _ := Format(got); again != got {
+			t.Errorf("%q: formatting is not idempotent: %q", src, again)
+		}
+	}
+	if _, err := Format("x := 1\ny := (x"); err == nil || !strings.Contains(err.Error(), "2:") {
+		t.Errorf("got %v", err)
+	}
+}
+
+type comparator interface {
+	Compare(a, b int) int
+	Name() string
+}
+
+type comparatorAdapter struct {
+	CompareFunc func(a, b int) int
+	NameFunc    func() string
+}
+
+func (c *comparatorAdapter) Compare(a, b int) int { return c.CompareFunc(a, b) }
+func (c *comparatorAdapter) Name() string         { return c.NameFunc() }
+
+func TestMakeInterface(t *testing.T) {
+	if err := RegisterAdapter((*comparator)(nil), &comparatorAdapter{}); err != nil {
+		t.Fatal(err)
+	}
+	s := NewScope()
+	s.Set("descending", true)
+	v, err := s.MakeInterface((*comparator)(nil), map[string]string{
+		"Compare": `func(a, b int) int {
+	d := a - b
+	if descending { d = -d }
+	return d
+}`,
+		"Name": `func() string { return "by value" }`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmp := v.(comparator)
+	xs := []int{2, 3, 1}
+	sort.Slice(xs, func(i, j int) bool { return cmp.Compare(xs[i], xs[j]) < 0 })
+	if !reflect.DeepEqual(xs, []int{3, 2, 1}) || cmp.Name() != "by value" {
+		t.Errorf("got %v, %q", xs, cmp.Name())
+	}
+	for _, methods := range []map[string]string{
+		{"Compare": `func(a, b int) int { return a - b }`},
+		{"Compare": `func(a, b int) string { return "" }`, "Name": `func() string { return "" }`},
+		{"Compare": `func(a, b int) int { return a - b }`, "Name": `func() string { return "" }`, "Other": `1`},
+	} {
+		if _, err := s.MakeInterface((*comparator)(nil), methods); err == nil {
+			t.Errorf("%v: no error", methods)
+		}
+	}
+	if err := RegisterAdapter((*comparator)(nil), comparatorAdapter{}); err == nil {
+		t.Error("registered an adapter not implementing the interface")
+	}
+	if _, err := s.MakeInterface((*fmt.Stringer)(nil), nil); err == nil {
+		t.Error("made an interface without adapter")
+	}
+}
+
+func TestContextVars(t *testing.T) {
+	s := NewScope()
+	ctx := ContextWithVars(context.Background(), map[string]interface{}{"tenant": "acme"})
+	ctx = ContextWithVars(ctx, map[string]interface{}{"requestID": 7})
+	got, err := s.EvalContext(ctx, `tenant + "-" + string(rune('0'+requestID))`)
+	if err != nil || got != "acme-7" {
+		t.Fatalf("got %v, %v", got, err)
+	}
+	for _, src := range []string{`tenant = "other"`, `tenant := "other"`, `func() { tenant := 1 }()`} {
+		if _, err := s.EvalContext(ctx, src); err == nil {
+			t.Errorf("%s: redefined a context variable", src)
+		}
+	}
+	if _, ok := s.lookup("tenant"); ok {
+		t.Error("context variable outlived its evaluation")
+	}
+	s.Set("tenant", "host")
+	if _, err := s.EvalContext(ctx, `tenant`); err == nil {
+		t.Error("a scope variable hid a context variable")
+	}
+}
+
+func TestFork(t *testing.T) {
+	s := NewScope()
+	s.Set("total", 100)
+	s.Set("rate", 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(f *Scope, i int) {
+			defer wg.Done()
+			f.Set("n", i)
+			got, err := f.Eval(`total = total + n * rate
+x := total
+x`)
+			if err != nil || got != 100+i*2 {
+				t.Errorf("fork %d: got %v, %v", i, got, err)
+			}
+		}(s.Fork(), i)
+	}
+	wg.Wait()
+	if s.Get("total") != 100 || len(s.Vars) != 2 {
+		t.Errorf("forks changed their parent: %v", s.Vars)
+	}
+	f := s.Fork()
+	f.Set("rate", 3)
+	if s.Get("rate") != 2 || f.Get("rate") != 3 {
+		t.Errorf("Set on a fork reached its parent")
+	}
+}
+
+// tenantQuota admits limit evaluations per tenant, of at most steps statements each
+type tenantQuota struct {
+	mu     sync.Mutex
+	limit  int
+	steps  int
+	counts map[string]int
+	stmts  int
+	done   int
+}
+
+func (q *tenantQuota) Admit(ctx context.Context, eval QuotaEval) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.counts[eval.Tenant] >= q.limit {
+		return errors.New("rate limited")
+	}
+	q.counts[eval.Tenant]++
+	q.stmts = 0
+	return nil
+}
+
+func (q *tenantQuota) Done(eval QuotaEval, elapsed time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.done++
+}
+
+func (q *tenantQuota) Statement(eval QuotaEval) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stmts++; q.stmts > q.steps {
+		return errors.New("statement budget spent")
+	}
+	return nil
+}
+
+func TestQuota(t *testing.T) {
+	q := &tenantQuota{limit: 2, steps: 100, counts: map[string]int{}}
+	s := NewScope(WithQuota(q))
+	acme := ContextWithTenant(context.Background(), "acme")
+	for i := 0; i < 2; i++ {
+		if got, err := s.EvalContext(acme, `1 + 1`); err != nil || got != 2 {
+			t.Fatalf("got %v, %v", got, err)
+		}
+	}
+	_, err := s.EvalContext(acme, `1 + 1`)
+	var qe *QuotaError
+	if !errors.As(err, &qe) || qe.Tenant != "acme" || qe.Script == "" {
+		t.Fatalf("over the rate: %v", err)
+	}
+	if _, err := s.Eval(`1 + 1`); err != nil {
+		t.Errorf("another tenant was limited: %v", err)
+	}
+	_, err = s.Eval(`for {}`)
+	if !errors.As(err, &qe) || !strings.Contains(err.Error(), "statement budget spent") {
+		t.Errorf("endless loop: %v", err)
+	}
+	if q.done != 4 {
+		t.Errorf("Done called %d times for 4 admitted evaluations", q.done)
+	}
+}
+
+func TestPredicate(t *testing.T) {
+	s := NewScope()
+	s.Set("minAge", 18)
+	p, err := s.CompilePredicate(`country == "FR" && age >= minAge`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields := fmt.Sprint(p.Fields()); fields != "[age country minAge]" {
+		t.Errorf("fields %s", fields)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(age int) {
+			defer wg.Done()
+			ok, err := p.Match(map[string]interface{}{"country": "FR", "age": age})
+			if err != nil || ok != (age >= 18) {
+				t.Errorf("age %d: got %v, %v", age, ok, err)
+			}
+		}(i + 8)
+	}
+	wg.Wait()
+	p, err = s.CompilePredicate(`limit := 10
+n < limit`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := p.Match(map[string]interface{}{"n": 3}); !ok || err != nil {
+		t.Errorf("got %v, %v", ok, err)
+	}
+	p, _ = s.CompilePredicate(`n + 1`)
+	if _, err := p.Match(map[string]interface{}{"n": 3}); err == nil {
+		t.Error("a non-bool result matched")
+	}
+	if _, err := s.CompilePredicate(`x := 1`); err == nil {
+		t.Error("compiled a predicate without a condition")
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	s := NewScope()
+	s.Set("names", []string{"ann", "bob", "cy"})
+	got, err := s.Eval(`b := builder()
+for i, name := range names {
+	if i > 0 {
+		b.Write(", ")
+	}
+	b.Write(name, "#", i)
+}
+b.String()`)
+	if err != nil || got != "ann#0, bob#1, cy#2" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	got, err = s.Eval(`concat("n=", 3, " ok=", true)`)
+	if err != nil || got != "n=3 ok=true" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	s.Options.Limits = Limits{MaxStringLen: 8}
+	for _, src := range []string{`b := builder()
+for i := 0; i < 10; i++ { b.Write("ab") }`, `concat("abcdef", "ghi")`} {
+		if _, err := s.Eval(src); !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("%s: %v", src, err)
+		}
+	}
+}
+
+func BenchmarkBuilder(b *testing.B) {
+	s := NewScope()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Eval(`sb := builder()
+for i := 0; i < 1000; i++ { sb.Write("x") }
+sb.String()`); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTimeOps(t *testing.T) {
+	s := NewScope()
+	then := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.Set("then", then)
+	s.Set("now", then.Add(90*time.Minute))
+	s.Set("second", time.Second)
+	s.Set("hour", time.Hour)
+	for src, want := range map[string]interface{}{
+		`now - then`:                  90 * time.Minute,
+		`now - then > hour`:           true,
+		`now - then <= 5400*second`:   true,
+		`then < now && !(now < then)`: true,
+		`then + hour`:                 then.Add(time.Hour),
+		`hour + then == then + hour`:  true,
+		`now - 2*hour`:                then.Add(-30 * time.Minute),
+		`hour / 2`:                    30 * time.Minute,
+		`hour % (7 * second)`:         time.Hour % (7 * time.Second),
+		`then != nil`:                 true,
+	} {
+		if got, err := s.Eval(src); err != nil || got != want {
+			t.Errorf("%s: got %v, %v, want %v", src, got, err, want)
+		}
+	}
+	if _, err := s.Eval(`now * 2`); err == nil {
+		t.Error("multiplied a time")
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	s := NewScope()
+	s.InstallEncoding("")
+	s.Set("payload", []byte(`{"id":1}`))
+	for src, want := range map[string]interface{}{
+		`hexEncode([]byte{1, 171})`:                   "01ab",
+		`string(hexDecode("6869"))`:                   "hi",
+		`base64Encode("hi")`:                          "aGk=",
+		`string(base64Decode(base64Encode(payload)))`: `{"id":1}`,
+		`sha256("abc")`:                               "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		`md5([]byte("abc"))`:                          "900150983cd24fb0d6963f7d28e17f72",
+		`hmacSHA256("key", "The quick brown fox jumps over the lazy dog")`: "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		`len(sha256(payload))`: 64,
+	} {
+		if got, err := s.Eval(src); err != nil || got != want {
+			t.Errorf("%s: got %v, %v", src, got, err)
+		}
+	}
+	if _, err := s.Eval(`hexDecode("zz")`); err == nil {
+		t.Error("decoded invalid hex")
+	}
+	if _, err := s.Eval(`sha256(1)`); err == nil {
+		t.Error("hashed an int")
+	}
+}
+
+func TestRegexpBuiltins(t *testing.T) {
+	s := NewScope()
+	got, err := s.Eval(`findAll("ship #go and #rules", "#\\w+")`)
+	if err != nil || fmt.Sprint(got) != "[#go #rules]" {
+		t.Errorf("got %v, %v", got, err)
+	}
+	got, err = s.Eval(`replaceRegex("4111111111111111", "\\d{12}(\\d{4})", "************$1")`)
+	if err != nil || got != "************1111" {
+		t.Errorf("got %v, %v", got, err)
+	}
+	if got, err := s.Eval(`matches("abc", "^a.c$")`); err != nil || got != true {
+		t.Errorf("got %v, %v", got, err)
+	}
+	for _, pattern := range []string{`"("`, `"((a{1000}){1000}){1000}"`, `"(x{100}){100}"`} {
+		if _, err := s.Eval(`matches("x", ` + pattern + `)`); err == nil {
+			t.Errorf("%s compiled", pattern)
+		}
+	}
+}
+
+func TestEvalMap(t *testing.T) {
+	s := NewScope()
+	s.Set("age", 16)
+	s.Set("score", -1)
+	out, err := s.EvalMap(`score = 10
+if age < 18 {
+	reason = "minor"
+}`, "score", "reason", "tags")
+	if err != nil || fmt.Sprint(out) != "map[reason:minor score:10 tags:<nil>]" {
+		t.Errorf("got %v, %v", out, err)
+	}
+	if s.Get("score") != -1 {
+		t.Errorf("a named result assigned the scope: %v", s.Get("score"))
+	}
+	out, err = s.EvalMap(`return age * 2, "doubled"`, "score", "reason")
+	if err != nil || out["score"] != 32 || out["reason"] != "doubled" {
+		t.Errorf("got %v, %v", out, err)
+	}
+	out, err = s.EvalMap(`map[string]int{"score": age + 1}`)
+	if err != nil || out["score"] != 17 {
+		t.Errorf("got %v, %v", out, err)
+	}
+	if _, err := s.EvalMap(`age`); err == nil {
+		t.Error("an int evaluated to a map")
+	}
+}
+
+func TestPure(t *testing.T) {
+	s := NewScope(WithPure())
+	s.InstallMath("math")
+	calls := 0
+	s.Set("xs", []int{3, 1, 2})
+	s.Set("deadline", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	s.Set("record", func() int { calls++; return calls })
+	s.Set("double", func(n int) int { return n * 2 })
+	if err := RegisterPure(s.Get("double")); err != nil {
+		t.Fatal(err)
+	}
+	for src, want := range map[string]interface{}{
+		`len(xs) + math.max(1, 2) + double(1)`:                7,
+		`sort(xs)[0]`:                                         1,
+		`len(filter(xs, func(x int) bool { return x > 1 }))`:  2,
+		`deadline.Year()`:                                     2024,
+		`ternary(deadline.Before(deadline), "early", "late")`: "late",
+		`sprintf("%d items", len(xs))`:                        "3 items",
+	} {
+		if got, err := s.Eval(src); err != nil || got != want {
+			t.Errorf("%s: got %v, %v", src, got, err)
+		}
+	}
+	for _, src := range []string{
+		`ys := xs`,
+		`xs[0] = 5`,
+		`var n = 1`,
+		`for i := 0; i < 3; i++ {}`,
+		`len(xs) > 0 && record() > 0`,
+		`filter(xs, record)`,
+		`append(xs, 4)`,
+		`printf("x")`,
+		`func() { xs = nil }()`,
+	} {
+		if _, err := s.Eval(src); !errors.Is(err, ErrImpure) {
+			t.Errorf("%s: %v", src, err)
+		}
+	}
+	if calls != 0 || fmt.Sprint(s.Get("xs")) != "[3 1 2]" {
+		t.Errorf("a pure evaluation had side effects: %d calls, xs %v", calls, s.Get("xs"))
+	}
+	b := NewBuilder()
+	s.Set("b", b)
+	if _, err := s.Eval(`b.Write("x")`); !errors.Is(err, ErrImpure) {
+		t.Errorf("called an unregistered method: %v", err)
+	}
+	// functions sharing the code pointer of MakeFunc or of method values
+	bump := reflect.MakeFunc(reflect.TypeOf(func() int { return 0 }), func([]reflect.Value) []reflect.Value {
+		calls++
+		return []reflect.Value{reflect.ValueOf(calls)}
+	}).Interface()
+	s.Set("bump", bump)
+	s.Set("write", reflect.ValueOf(b).MethodByName("Write").Interface())
+	lambda, err := NewScope().Lambda(`record()`, func() int { return 0 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("lambda", lambda)
+	for _, src := range []string{`bump()`, `write("x")`, `lambda()`} {
+		if _, err := s.Eval(src); !errors.Is(err, ErrImpure) {
+			t.Errorf("%s: %v", src, err)
+		}
+	}
+	if calls != 0 || b.Len() != 0 {
+		t.Errorf("a pure evaluation had side effects: %d calls, %q written", calls, b.String())
+	}
+	y, err := s.Lambda(`double(2)`, func() int { return 0 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Set("y", y)
+	if got, err := s.Eval(`y() + deadline.Year()`); err != nil || got != 2028 {
+		t.Errorf("pure script functions and methods: got %v, %v", got, err)
+	}
+	// builtins are pure as functions, not by name
+	s.SetBuiltin("sprint", func(args ...interface{}) string { calls++; return fmt.Sprint(args...) })
+	s.SetBuiltin("format", fmt.Sprint)
+	for src, impure := range map[string]bool{`sprint(1)`: true, `format(1)`: false, `assert(true)`: true} {
+		if _, err := s.Eval(src); errors.Is(err, ErrImpure) != impure {
+			t.Errorf("%s: %v", src, err)
+		}
+	}
+	if calls != 0 {
+		t.Errorf("a pure evaluation called the replaced sprint")
+	}
+}
+
+func TestLiteralHooks(t *testing.T) {
+	s := NewScope(WithLiterals(DurationLiterals, DateLiterals("date", "2006-01-02")))
+	s.Set("elapsed", 90*time.Second)
+	s.Set("created", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC))
+	for src, want := range map[string]interface{}{
+		`elapsed > "1m"`:                       true,
+		`"1h30m"`:                              90 * time.Minute,
+		`created < date("2024-01-02")`:         false,
+		`created - date("2024-02-29") < "25h"`: true,
+		`"hello"`:                              "hello",
+	} {
+		if got, err := s.Eval(src); err != nil || got != want {
+			t.Errorf("%s: got %v, %v", src, got, err)
+		}
+	}
+	for _, src := range []string{`date("yesterday")`, `date(1)`} {
+		if _, err := s.Eval(src); err == nil {
+			t.Errorf("%s evaluated", src)
+		}
+	}
+	if diags := s.Check(`date("2024-01-02")`); len(diags) != 0 {
+		t.Errorf("literal tag reported: %v", diags)
+	}
+}
+
+func TestPluginBundle(t *testing.T) {
+	builtins := map[string]interface{}{"discount": func(x float64) float64 { return x * 0.9 }}
+	name := "billing"
+	symbols := map[string]plugin.Symbol{"Builtins": &builtins, "Name": &name}
+	lookup := func(sym string) (plugin.Symbol, error) {
+		if v, ok := symbols[sym]; ok {
+			return v, nil
+		}
+		return nil, errors.New("not found")
+	}
+	p, err := pluginBundle("/plugins/billing_v2.so", lookup)
+	if err != nil || p.Name != "billing" {
+		t.Fatalf("got %+v, %v", p, err)
+	}
+	s := NewScope()
+	s.InstallPlugin(p, p.Name)
+	if got, err := s.Eval(`billing.discount(100.0)`); err != nil || got != 90.0 {
+		t.Errorf("got %v, %v", got, err)
+	}
+	delete(symbols, "Name")
+	if p, _ := pluginBundle("/plugins/billing_v2.so", lookup); p.Name != "billing_v2" {
+		t.Errorf("default name %q", p.Name)
+	}
+	symbols["Builtins"] = builtins
+	if _, err := pluginBundle("x.so", lookup); err == nil {
+		t.Error("accepted Builtins that is not a variable")
+	}
+	if _, err := LoadPlugin("testdata/missing.so"); err == nil {
+		t.Error("loaded a missing plugin")
+	}
+}
+
+func TestExec(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("no /bin/sh")
+	}
+	s := NewScope()
+	if _, err := s.Eval(`exec("echo", "hi")`); err == nil {
+		t.Fatal("exec ran without a policy")
+	}
+	s.Options.Exec = &ExecPolicy{
+		Allow: map[string]string{"echo": "/bin/echo", "sh": "/bin/sh"},
+		Args: map[string]func([]string) error{"echo": func(args []string) error {
+			for _, arg := range args {
+				if strings.HasPrefix(arg, "-") {
+					return errors.New("no flags")
+				}
+			}
+			return nil
+		}},
+		Timeout:       time.Second,
+		MaxOutputSize: 64,
+		Env:           []string{"PATH=/usr/bin:/bin"},
+	}
+	got, err := s.Eval(`res := exec("echo", "hello", "world")
+res.Stdout`)
+	if err != nil || got != "hello world\n" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	got, err = s.Eval(`res := exec("sh", "-c", "echo oops >&2; exit 3")
+sprintf("%d %s", res.ExitCode, res.Stderr)`)
+	if err != nil || got != "3 oops\n" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	for _, src := range []string{
+		`exec("/bin/echo", "x")`,
+		`exec("rm", "-rf", "/tmp/x")`,
+		`exec("echo", "-e", "x")`,
+		`exec("sh", "-c", "exec sleep 5")`,
+		`exec("sh", "-c", "yes | head -c 100000")`,
+	} {
+		if _, err := s.Eval(src); err == nil {
+			t.Errorf("%s ran", src)
+		}
+	}
+}
+
+func TestRegisterNamespaced(t *testing.T) {
+	s := NewScope()
+	for path, v := range map[string]interface{}{
+		"billing.discount":     func(x float64) float64 { return x * 0.9 },
+		"billing.tax.rate":     0.2,
+		"geo.metric.distance":  func(a, b float64) float64 { return math.Abs(a - b) },
+		"geo.imperial.feet":    3.28,
+		"billing.tax.currency": "EUR",
+	} {
+		if err := s.Register(path, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.InstallMath("tools.math")
+	for src, want := range map[string]interface{}{
+		`billing.discount(100.0) * (1 + billing.tax.rate)`:  108.0,
+		`geo.metric.distance(3.0, 5.0) * geo.imperial.feet`: 6.56,
+		`tools.math.max(1, 4)`:                              4,
+		`billing.tax.currency`:                              "EUR",
+	} {
+		if got, err := s.Eval(src); err != nil || got != want {
+			t.Errorf("%s: got %v, %v", src, got, err)
+		}
+	}
+	if typ, err := InferType(`billing.tax.rate`, s); err != nil || typ.Kind() != reflect.Float64 {
+		t.Errorf("inferred %v, %v", typ, err)
+	}
+	if comps := s.Complete(`billing.tax.`, 12); len(comps) != 2 {
+		t.Errorf("completions %v", comps)
+	}
+	for _, path := range []string{"billing.tax.rate.x", "billing..x", ""} {
+		if err := s.Register(path, 1); err == nil {
+			t.Errorf("registered %q", path)
+		}
+	}
+	if _, err := s.Eval(`billing.missing(1)`); err == nil {
+		t.Error("called a missing member")
+	}
+}
+
+func TestPragmas(t *testing.T) {
+	s := NewScope()
+	s.Set("xs", []int{1, 2})
+	_, err := s.Eval(`//goeval:timeout=50ms
+for {}`)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("timeout pragma: %v", err)
+	}
+	_, err = s.Eval(`// keeps the preview side effect free
+//goeval:pure
+xs[0] = 5`)
+	if !errors.Is(err, ErrImpure) || s.Get("xs").([]int)[0] != 1 {
+		t.Errorf("pure pragma: %v", err)
+	}
+	if _, err := s.Eval(`//goeval:strict
+if false { missing() }`); err == nil || !strings.Contains(err.Error(), "undefined: missing") {
+		t.Errorf("strict pragma: %v", err)
+	}
+	if s.Options.Pure || s.Options.Timeout != 0 {
+		t.Error("pragmas changed the scope options")
+	}
+	got, err := s.Eval(`len(xs)
+//goeval:pure`)
+	if err != nil || got != 2 {
+		t.Errorf("pragma after the first statement applied: %v, %v", got, err)
+	}
+	s.Options.Timeout = 10 * time.Millisecond
+	if _, err := s.Eval(`//goeval:timeout=1h
+for {}`); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("pragma loosened the timeout: %v", err)
+	}
+	for _, src := range []string{"//goeval:fast\n1", "//goeval:timeout=soon\n1", "//goeval:pure=yes\n1"} {
+		if _, err := s.Eval(src); err == nil {
+			t.Errorf("%q evaluated", src)
+		}
+	}
+}
+
+func TestTTL(t *testing.T) {
+	s := NewScope()
+	s.Set("user", "root")
+	child := s.NewChild()
+	child.SetWithTTL("user", "alice", 20*time.Millisecond)
+	child.SetWithTTL("token", "abc", 20*time.Millisecond)
+	child.SetWithTTL("keep", 1, time.Hour)
+	if v, err := child.Eval(`user + token`); err != nil || v != "aliceabc" {
+		t.Fatal(v, err)
+	}
+	if left, ok := child.TTL("token"); !ok || left <= 0 {
+		t.Fatal(left, ok)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if v, err := child.Eval(`user`); err != nil || v != "root" {
+		t.Fatal("expired variable should uncover its parent's", v, err)
+	}
+	for _, k := range child.Keys() {
+		if k == "token" {
+			t.Fatal("expired variable should not be listed")
+		}
+	}
+	if v := child.Get("token"); v != nil {
+		t.Fatal(v)
+	}
+	if _, ok := child.Vars["token"]; ok {
+		t.Fatal("Get should evict the expired variable")
+	}
+	if n := child.Expire(); n != 1 || len(child.Vars) != 1 {
+		t.Fatal(n, child.Vars)
+	}
+
+	child.SetWithTTL("n", 1, 20*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	child.Set("n", 2)
+	if _, ok := child.TTL("n"); ok || child.Get("n") != 2 {
+		t.Fatal("assigning an expired variable should drop its deadline")
+	}
+
+	var mu sync.Mutex
+	child.SetWithTTL("session", 1, 10*time.Millisecond)
+	stop := child.StartJanitor(5*time.Millisecond, &mu)
+	defer stop()
+	for i := 0; ; i++ {
+		mu.Lock()
+		_, ok := child.Vars["session"]
+		mu.Unlock()
+		if !ok {
+			break
+		}
+		if i == 200 {
+			t.Fatal("janitor did not expire the variable")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	s := NewScope()
+	s.Set("age", 17)
+	s.Set("country", "FR")
+	s.Set("vip", false)
+	e, err := s.Explain(`limit := 18
+if (age >= limit && country == "FR") || vip {
+	return "allow"
+}
+return "deny"`)
+	if err != nil || e.Value != "deny" {
+		t.Fatal(e, err)
+	}
+	if len(e.Children) != 3 {
+		t.Fatal(e.Children)
+	}
+	cond := e.Children[1]
+	if cond.Source != `(age >= limit && country == "FR") || vip` || cond.Value != false ||
+		cond.Contribution != ContributionCondition || cond.Line != 2 || cond.Column != 4 {
+		t.Fatalf("%+v", cond)
+	}
+	and := cond.Children[0]
+	if and.Source != `age >= limit && country == "FR"` || len(and.Children) != 2 {
+		t.Fatalf("%+v", and)
+	}
+	if age := and.Children[0]; age.Contribution != ContributionDecisive || age.Value != false ||
+		age.Children[0].Source != "age" || age.Children[0].Value != 17 {
+		t.Fatalf("%+v", age)
+	}
+	if and.Children[1].Contribution != ContributionNone {
+		t.Fatalf("%+v", and.Children[1])
+	}
+	if last := e.Children[2]; last.Source != `"deny"` || last.Contribution != ContributionResult {
+		t.Fatalf("%+v", last)
+	}
+
+	e, err = s.Explain("x := []int{age, 2}\nlen(x) + missing(1)")
+	if err == nil || e.Err != err {
+		t.Fatal(err)
+	}
+	sum := e.Children[1]
+	if sum.Err == nil || sum.Children[0].Source != "len(x)" || sum.Children[0].Value != 2 || len(sum.Children[0].Children) != 1 {
+		t.Fatalf("%+v", sum)
+	}
+	if s.explain != nil {
+		t.Fatal("Explain should not keep explaining the scope")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	s := NewScope()
+	s.Set("price", 3)
+	s.Set("quantity", 2)
+	var changes []string
+	w, err := s.Watch(`price * quantity`, func(val, old interface{}, err error) {
+		changes = append(changes, fmt.Sprint(old, "->", val, err))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := w.Value(); v != 6 || err != nil {
+		t.Fatal(v, err)
+	}
+	if deps := w.Deps(); !reflect.DeepEqual(deps, []string{"price", "quantity"}) {
+		t.Fatal(deps)
+	}
+	s.Set("price", 4)
+	s.Set("other", 1)
+	s.Set("quantity", 2) // unchanged value, no callback
+	if _, err := s.Eval(`if true { quantity = 3 }`); err != nil {
+		t.Fatal(err)
+	}
+	s.SetWithTTL("price", "x", time.Hour)
+	want := []string{"6->8 <nil>", "8->12 <nil>"}
+	if len(changes) != 3 || !reflect.DeepEqual(changes[:2], want) || !strings.HasPrefix(changes[2], "12-><nil> ") {
+		t.Fatal(changes)
+	}
+	if _, err := w.Value(); err == nil {
+		t.Fatal("failed re-evaluation should be reported")
+	}
+	w.Stop()
+	s.Set("price", 5)
+	if len(changes) != 3 || len(s.watches) != 0 {
+		t.Fatal(changes, s.watches)
+	}
+
+	// a watch setting its own dependency does not recurse
+	s.Set("n", 0)
+	calls := 0
+	if _, err = s.Watch(`n = n + 1; n`, func(val, old interface{}, err error) { calls++ }); err != nil {
+		t.Fatal(err)
+	}
+	s.Set("n", 10)
+	if calls == 0 || calls > maxWatchRounds {
+		t.Fatal(calls)
+	}
+}
+
+func TestJSONOptions(t *testing.T) {
+	s := NewScope(WithJSON(JSONOptions{
+		BigIntsAsStrings: true,
+		FloatFormat:      'f',
+		FloatPrecision:   -1,
+		TimeLayout:       "2006-01-02",
+		OmitEmpty:        true,
+		NoHTMLEscape:     true,
+	}))
+	type user struct {
+		ID    int64
+		Score float64
+		Note  string
+	}
+	s.Set("v", map[string]interface{}{
+		"id":      int64(9007199254740993),
+		"small":   42,
+		"big":     1e21,
+		"created": time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		"html":    "<a&b>",
+		"empty":   "",
+		"none":    nil,
+		"user":    user{ID: 1, Score: 0.5},
+	})
+	want := `{"big":1000000000000000000000,"created":"2020-01-02","html":"<a&b>","id":"9007199254740993","small":42,"user":{"ID":1,"Score":0.5}}`
+	if got := s.NewChild().GetJsonString("v"); got != want {
+		t.Fatal(got)
+	}
+	if got, err := s.Assemble(`{"n": 1.5, "s": ""}`); err != nil || got != `{"n":1.5}` {
+		t.Fatal(got, err)
+	}
+	plain := NewScope()
+	plain.Set("v", map[string]interface{}{"id": int64(9007199254740993), "html": "<a>", "empty": ""})
+	if got := plain.GetJsonString("v"); got != `{"empty":"","html":"\u003ca\u003e","id":9007199254740993}` {
+		t.Fatal(got)
+	}
+}
+
+func TestEvaluateBatch(t *testing.T) {
+	s := NewScope()
+	s.Set("minAge", 18)
+	e, err := s.Compile(`age >= minAge && country == "FR"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make([]map[string]interface{}, 1000)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"age": i % 40, "country": "FR", "unused": i}
+	}
+	rows[7] = map[string]interface{}{"age": "old", "country": "FR"}
+	for _, workers := range []int{1, 4} {
+		results, errs := EvaluateBatchParallel(e, rows, workers)
+		if len(results) != len(rows) || len(errs) != len(rows) || errs[7] == nil {
+			t.Fatal(workers, len(results), len(errs))
+		}
+		for i, result := range results {
+			if i != 7 && (errs[i] != nil || result != (i%40 >= 18)) {
+				t.Fatal(workers, i, result, errs[i])
+			}
+		}
+	}
+	results, errs := EvaluateBatch(e, rows[:3])
+	if errs != nil || !reflect.DeepEqual(results, []interface{}{false, false, false}) {
+		t.Fatal(results, errs)
+	}
+}
+
+func BenchmarkEvaluateBatch(b *testing.B) {
+	e, _ := NewScope().Compile(`age >= 18 && country == "FR"`)
+	rows := make([]map[string]interface{}, 10000)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"age": i % 40, "country": "FR", "name": "x", "city": "Paris"}
+	}
+	b.Run("Process", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, row := range rows {
+				e.Process(row)
+			}
+		}
+	})
+	b.Run("Batch", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			EvaluateBatch(e, rows)
+		}
+	})
+	b.Run("Parallel", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			EvaluateBatchParallel(e, rows, 4)
+		}
+	})
+}
+
+func TestTransforms(t *testing.T) {
+	var order []string
+	trace := func(name string) Transform {
+		return func(body *ast.BlockStmt) (*ast.BlockStmt, error) {
+			order = append(order, name)
+			return body, nil
+		}
+	}
+	noLoops := func(body *ast.BlockStmt) (*ast.BlockStmt, error) {
+		var err error
+		ast.Inspect(body, func(n ast.Node) bool {
+			if _, ok := n.(*ast.ForStmt); ok {
+				err = errors.New("loops are not allowed")
+			}
+			return err == nil
+		})
+		return body, err
+	}
+	s := NewScope(WithTransforms(trace("first"), RenameFuncs(map[string]string{"oldDouble": "double"}), trace("second"), noLoops))
+	s.Set("double", func(n int) int { return 2 * n })
+	if v, err := s.Eval(`oldDouble(21)`); err != nil || v != 42 {
+		t.Fatal(v, err)
+	}
+	if !reflect.DeepEqual(order, []string{"first", "second"}) {
+		t.Fatal(order)
+	}
+	if _, err := s.NewChild().Eval(`for {}`); err == nil || err.Error() != "loops are not allowed" {
+		t.Fatal(err)
+	}
+	e, err := s.Compile(`oldDouble(n)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := e.Process(map[string]interface{}{"n": 2}); err != nil || v != 4 {
+		t.Fatal(v, err)
+	}
+	if diags := s.Check(`oldDouble(1)`); len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	// the cached tree of the script is left untouched
+	plain := NewScope()
+	plain.Set("oldDouble", func(n int) int { return n })
+	if v, err := plain.Eval(`oldDouble(21)`); err != nil || v != 21 {
+		t.Fatal(v, err)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	s := NewScope()
+	err := s.RegisterFunc("geo.distance", func(ctx context.Context, a, b string, precision ...int) (float64, error) {
+		return 0, nil
+	}, "distance in km between two places", "from", "to", "precision")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterFunc("bad", 1, ""); err == nil {
+		t.Fatal("registering a non function should fail")
+	}
+	if err := s.RegisterFunc("short", func(a, b int) {}, "", "a"); err == nil {
+		t.Fatal("a missing parameter name should fail")
+	}
+	s.Set("double", func(n int) int { return 2 * n })
+	s.Set("limit", 3)
+	d, ok := s.NewChild().Describe("geo.distance")
+	want := &FuncDescription{
+		Name:     "geo.distance",
+		Doc:      "distance in km between two places",
+		Params:   []Param{{"from", "string"}, {"to", "string"}, {"precision", "int"}},
+		Results:  []string{"float64", "error"},
+		Variadic: true,
+	}
+	if !ok || !reflect.DeepEqual(d, want) {
+		t.Fatalf("%+v", d)
+	}
+	if d, ok := s.Describe("double"); !ok || d.Params[0] != (Param{Type: "int"}) || d.Builtin {
+		t.Fatalf("%+v", d)
+	}
+	if _, ok := s.Describe("limit"); ok {
+		t.Fatal("a variable is not a function")
+	}
+	if d, ok := s.Describe("len"); !ok || !d.Builtin {
+		t.Fatalf("%+v", d)
+	}
+	var names []string
+	for _, f := range s.ListFunctions() {
+		names = append(names, f.Name)
+	}
+	listed := " " + strings.Join(names, " ") + " "
+	if !sort.StringsAreSorted(names) || !strings.Contains(listed, " double ") || !strings.Contains(listed, " geo.distance ") {
+		t.Fatal(names)
+	}
+	for _, name := range names {
+		if name == "limit" || name == "exec" {
+			t.Fatal(name, "is not callable")
+		}
+	}
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	before := NewScope(WithBuiltinsSnapshot())
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := RegisterBuiltin(fmt.Sprintf("testTriple%d", i), func(n int) int { return 3 * n }); err != nil {
+				t.Error(err)
+			}
+			NewScope().Eval(`len("abc")`)
+		}(i)
+	}
+	wg.Wait()
+	type celsius float64
+	if err := RegisterBuiltinType("testCelsius", reflect.TypeOf(celsius(0))); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterBuiltinType("int", reflect.TypeOf("")); err == nil {
+		t.Fatal("predeclared types should not be replaceable")
+	}
+	s := NewScope()
+	if v, err := s.NewChild().Eval(`testTriple3(2) + int(testCelsius(1.5))`); err != nil || v != 7 {
+		t.Fatal(v, err)
+	}
+	if v, err := before.NewChild().Eval(`testTriple3(2)`); err == nil {
+		t.Fatal("snapshot should not see later builtins", v)
+	}
+	after := NewScope(WithBuiltinsSnapshot())
+	RegisterBuiltin("testTriple0", func(n int) int { return 0 })
+	if v, err := after.Eval(`testTriple0(2)`); err != nil || v != 6 {
+		t.Fatal(v, err)
+	}
+	if v, err := s.Eval(`testTriple0(2)`); err != nil || v != 0 {
+		t.Fatal(v, err)
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	src := "x := 1\ny := (x\nz := [3\nok := 4"
+	body, err := Parse(src)
+	errs, ok := err.(ErrorList)
+	if !ok || len(errs) < 2 {
+		t.Fatal(err)
+	}
+	first := errs[0].(*PosError)
+	if first.Line != 2 || first.Column != 8 || first.Err.Error() != "goeval: expected ')', found newline" {
+		t.Fatalf("%+v", first)
+	}
+	if last := errs[len(errs)-1].(*PosError); last.Line != 4 {
+		t.Fatalf("%+v", last)
+	}
+	if body == nil || len(body.List) == 0 {
+		t.Fatal("expected a partial tree")
+	}
+	if line, column := ScriptPosition(src, body.List[0].Pos()); line != 1 || column != 1 {
+		t.Fatal(line, column)
+	}
+
+	if _, err := NewScope().Eval(src); !reflect.DeepEqual(err, errs) {
+		t.Fatal(err)
+	}
+	diags := NewScope().Check("a := 1 +\nb = 2 2\nok := 4")
+	if len(diags) < 2 || diags[0] != (Diagnostic{Line: 2, Column: 3, Message: "expected '==', found '='"}) {
+		t.Fatal(diags)
+	}
+	_, err = NewScope(WithVerboseErrors()).Eval("x := 1\ny := (x")
+	if err == nil || !strings.Contains(err.Error(), "\ty := (x\n\t       ^") {
+		t.Fatalf("%q", err)
+	}
+	if _, err := Parse("x := 1\nx"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEarlyReturn(t *testing.T) {
+	s := NewScope()
+	for src, want := range map[string]interface{}{
+		`func(a int) int { if a > 0 { return 1 }; return 2 }(4)`:                           1,
+		`func(a int) int { if a > 0 { return 1 }; return 2 }(-4)`:                          2,
+		`func() int { for i := 0; ; i++ { if i == 3 { return i } }; return -1 }()`:         3,
+		`func() string { for _, w := range []string{"a", "b"} { return w }; return "" }()`: "a",
+		`func(n int) string { switch { case n > 1: return "many" }; return "few" }(2)`:     "many",
+		`if true { return "early" }; "late"`:                                               "early",
+	} {
+		if got, err := s.Eval(src); err != nil || got != want {
+			t.Errorf("%s: got %v, %v", src, got, err)
+		}
+	}
+	s.Options.ContinueOnError = true
+	if got, err := s.Eval(`n := 1
+if n > 0 { return "early" }
+n = missing()
+"late"`); err != nil || got != "early" {
+		t.Errorf("continuing on errors: got %v, %v", got, err)
+	}
+}
+
+func TestCompoundIndexAssign(t *testing.T) {
+	s := NewScope()
+	for src, want := range map[string]interface{}{
+		`xs := []int{1, 2}; xs[0] += 5; xs[0]`:                       6,
+		`m := map[string]int{"a": 2}; m["a"] *= 7; m["a"]`:           14,
+		`m := map[string]int{}; m["b"] -= 3; m["b"]`:                 -3,
+		`a := [2]string{"x", "y"}; a[1] += "z"; a[1]`:                "yz",
+		`xs := []int{1, 2}; xs[1]++; xs[1]`:                          3,
+		`m := map[string]string{"k": "v"}; m["k"] += m["k"]; m["k"]`: "vv",
+	} {
+		if got, err := s.Eval(src); err != nil || got != want {
+			t.Errorf("%s: got %v, %v", src, got, err)
+		}
+	}
+	s.Set("xs", []interface{}{1, 2})
+	if _, err := s.Eval(`a, b := xs`); err == nil {
+		t.Error("a plain slice was destructured")
+	}
+}
+
+func TestAssignedResults(t *testing.T) {
+	s := NewScope()
+	s.Set("atoi", strconv.Atoi)
+	s.Set("divmod", func(a, b int) (int, int, error) {
+		if b == 0 {
+			return 0, 0, errors.New("division by zero")
+		}
+		return a / b, a % b, nil
+	})
+	for src, want := range map[string]interface{}{
+		`n, err := atoi("5"); n + 1`:                                   6,
+		`n, err := atoi("x"); err != nil && n == 0`:                    true,
+		`var n, err = atoi("7"); err == nil && n == 7`:                 true,
+		`q, r := divmod(7, 2); q*10 + r`:                               31,
+		`q, r, err := divmod(7, 0); err.Error()`:                       "division by zero",
+		`f := func() (int, error) { return 3, nil }; n, err := f(); n`: 3,
+	} {
+		if got, err := s.Eval(src); err != nil || got != want {
+			t.Errorf("%s: got %v, %v", src, got, err)
+		}
+	}
+	for src, msg := range map[string]string{
+		`n := atoi("x")`:             "invalid syntax",
+		`q, r := divmod(7, 0)`:       "division by zero",
+		`a, b, c, d := divmod(7, 2)`: "4 variables but divmod(7, 2) returns 3 values",
+		`var a, b, c = atoi("5")`:    "3 variables but atoi(\"5\") returns 2 values",
+	} {
+		if _, err := s.Eval(src); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: got %v, want %q", src, err, msg)
+		}
+	}
+}
+
+func TestVariablesShadowBuiltins(t *testing.T) {
+	s := NewScope()
+	s.Set("get", func(key string) string { return "host " + key })
+	s.Set("filter", 42)
+	s.Set("len", func(interface{}) int { return -1 })
+	for src, want := range map[string]interface{}{
+		`get("k")`:               "host k",
+		`filter + 1`:             43,
+		`len("abc")`:             3,
+		`len(sort([]int{2, 1}))`: 2,
+		`concat("a", "b")`:       "ab",
+	} {
+		if got, err := s.Eval(src); err != nil || got != want {
+			t.Errorf("%s: got %v, %v", src, got, err)
+		}
+	}
+	if d, ok := s.Describe("get"); !ok || d.Builtin {
+		t.Errorf("get described as %+v", d)
+	}
+}
+
+func TestBinaryValues(t *testing.T) {
+	s := NewScope()
+	s.Set("n", 300)
+	s.Set("x", 1.5)
+	s.Set("u", uint8(200))
+	for src, want := range map[string]interface{}{
+		`n*4 + 2*(n-1)`:               1798,
+		`(n + 2) % 7 << 1`:            2,
+		`n / 7 * 7 == n - n%7`:        true,
+		`x*2 + 0.25`:                  3.25,
+		`n > 1 && x < 2 || false`:     true,
+		`u + 100`:                     uint8(44),
+		`9223372036854775807 + n`:     -9223372036854775509,
+		`"a" + "b" == "ab" && n != 1`: true,
+		`float64(n) / 2.0`:            150.0,
+	} {
+		got, err := s.Eval(src)
+		if err != nil || got != want {
+			t.Errorf("%s: got %#v, %v; want %#v", src, got, err, want)
+		}
+	}
+	if _, err := s.Eval(`1 + n/(n-300)`); err == nil || !strings.Contains(err.Error(), "divide by zero") {
+		t.Errorf("divide by zero: got %v", err)
+	}
+	if _, err := NewScope(WithVerboseErrors()).Eval("1 +\n(2 + 3/0)"); err == nil || !strings.Contains(err.Error(), "line 2, column 6") {
+		t.Errorf("positioned error: got %v", err)
+	}
+}
+
+func TestParseCacheEviction(t *testing.T) {
+	kept, err := parseCached("1 + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	evicted, _ := parseCached("2 + 2")
+	for i := 0; i < parseCacheSize-2; i++ {
+		_, _ = parseCached(fmt.Sprintf("%d", i))
+	}
+	if again, _ := parseCached("1 + 1"); again != kept {
+		t.Fatal("recently used script reparsed")
+	}
+	_, _ = parseCached("eviction") // past the size, drops the least recently used
+	if again, _ := parseCached("1 + 1"); again != kept {
+		t.Error("recently used script evicted")
+	}
+	if again, _ := parseCached("2 + 2"); again == evicted {
+		t.Error("least recently used script kept")
+	}
+	parseCache.Lock()
+	n := parseCache.recent.Len()
+	parseCache.Unlock()
+	if n > parseCacheSize {
+		t.Errorf("cache holds %d scripts", n)
+	}
+}
+
+func TestAstPrint(t *testing.T) {
+	fSet := token.NewFileSet() // positions are relative to fSet
+	f, err := parser.ParseFile(fSet, "", `package main
+	import "strings"
+	import "fmt"
+
+	func main() {
+		a := map[string]interface{}{"a":1}
+	}`, 0)
+	if err != nil {
+		panic(err)
+	}
+	//Print the AST.
+	_ = ast.Print(fSet, f)
+}
+
+func TestMakeMapType(t *testing.T) {
+	m := map[string]interface{}{"a": 1}
+	//v := reflect.MakeMap()
+	fmt.Printf("%T\n", m)
+	fmt.Printf("%v\n", reflect.TypeOf(m))
+}
+
+func TestInterface(t *testing.T) {
+	a := map[string]interface{}{}
+	mapTyp := reflect.TypeOf(a)
+	fmt.Printf("%v\n", mapTyp.Key())
+	fmt.Printf("%#v\n", mapTyp.Elem())
+}
+
+func TestInterfaceSlice(t *testing.T) {
+
+	s := NewScope()
+	t.Log(s.Eval(`a := []interface{}{1,2,3}
+	a = append(a, 6)
+	b := []interface{}{4,5}
+	a = append(a, b...)`))
+	fmt.Println(s.GetJsonString("a"))
+}
+
+func TestInstallStrings(t *testing.T) {
+	s := NewScope()
+	s.InstallStrings("")
+	s.Set("name", " Tom ")
+	v, err := s.Eval(`upper(trim(name))`)
+	if err != nil || v != "TOM" {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+
+	ns := NewScope()
+	ns.InstallStrings("strings")
+	v, err = ns.Eval(`strings.hasPrefix(strings.format("%s-%d", "a", 1), "a-")`)
+	if err != nil || v != true {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+}
+
+func TestInstallMath(t *testing.T) {
+	s := NewScope()
+	s.InstallMath("math")
+	s.Set("prices", []interface{}{3, 1.5, 4})
+	for src, want := range map[string]interface{}{
+		`math.abs(-3)`:           3,
+		`math.floor(2.7)`:        2.0,
+		`math.max(prices)`:       4,
+		`math.min(2, 0.5, 7)`:    0.5,
+		`math.sum([]int{1,2})`:   3,
+		`math.sum(prices)`:       8.5,
+		`math.avg(prices) > 2.0`: true,
+		`math.sqrt(16)`:          4.0,
+	} {
+		v, err := s.Eval(src)
+		if err != nil || v != want {
+			t.Errorf("%s: got %#v %v, want %#v", src, v, err, want)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	s := NewScope()
+	s.Set("data", map[string]interface{}{"qty": 3.0, "price": "12.5", "active": "yes"})
+	v, err := s.Eval(`toFloat(data["price"]) * toFloat(toInt(data["qty"]))`)
+	if err != nil || v != 37.5 {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+	v, err = s.Eval(`toBool(data["active"])`)
+	if err != nil || v != true {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+	if _, err = s.Eval(`toInt("abc")`); err == nil {
+		t.Fatal("expected conversion error")
+	}
+}
+
+func TestGetPath(t *testing.T) {
+	s := NewScope()
+	s.Set("data", map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"price": map[string]interface{}{"amount": 9.5}},
+		},
+	})
+	v, err := s.Eval(`get(data, "items[0].price.amount")`)
+	if err != nil || v != 9.5 {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+	v, err = s.Eval(`get(data, "items[3].price", "none")`)
+	if err != nil || v != "none" {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+}
+
+func TestMapSelector(t *testing.T) {
+	s := NewScope()
+	s.Set("order", map[string]interface{}{"Total": 12, "Customer": map[string]interface{}{"Name": "tom"}})
+	if _, err := s.Eval(`order.Total`); err == nil {
+		t.Fatal("expected error without MapSelectors")
+	}
+	s.Options.MapSelectors = true
+	v, err := s.Eval(`order.Customer.Name`)
+	if err != nil || v != "tom" {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+}
+
+func TestNestedIndex(t *testing.T) {
+	s := NewScope()
+	var data interface{} = map[string]interface{}{
+		"items": []interface{}{map[string]interface{}{"price": 3}},
+	}
+	s.Set("data", &data)
+	v, err := s.Eval(`data["items"][0]["price"]`)
+	if err != nil || v != 3 {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+	if _, err = s.Eval(`data["items"][0]["price"][1]`); err == nil {
+		t.Fatal("expected error indexing an int")
+	}
+}
+
+func TestDeepEqual(t *testing.T) {
+	s := NewScope()
+	s.Set("a", []int{1, 2})
+	s.Set("b", []int{1, 2})
+	if _, err := s.Eval(`a == b`); err == nil {
+		t.Fatal("expected error comparing slices")
+	}
+	v, err := s.Eval(`equals(a, b)`)
+	if err != nil || v != true {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+	s.Options.DeepEqual = true
+	v, err = s.Eval(`a != b`)
+	if err != nil || v != false {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+}
+
+func TestNilCompare(t *testing.T) {
+	s := NewScope()
+	var m map[string]int
+	var p *int
+	s.Set("m", m)
+	s.Set("p", p)
+	s.Set("err", nil)
+	s.Set("f", Add)
+	for src, want := range map[string]bool{
+		`m == nil`:   true,
+		`nil == p`:   true,
+		`err != nil`: false,
+		`f != nil`:   true,
+		`nil == nil`: true,
+	} {
+		v, err := s.Eval(src)
+		if err != nil || v != want {
+			t.Errorf("%s: got %#v %v, want %v", src, v, err, want)
+		}
+	}
+}
+
+func TestBitwise(t *testing.T) {
+	s := NewScope()
+	s.Set("perms", uint64(1<<63|4))
+	s.Set("n", uint(2))
+	for src, want := range map[string]interface{}{
+		`perms & 4 != 0`:  true,
+		`perms >> 63`:     uint64(1),
+		`perms &^ 4`:      uint64(1 << 63),
+		`1 << n`:          4,
+		`int8(0) | 5`:     int8(5),
+		`uint8(255) ^ 15`: uint8(240),
+		`-8 >> 1`:         -4,
+	} {
+		v, err := s.Eval(src)
+		if err != nil || v != want {
+			t.Errorf("%s: got %#v %v, want %#v", src, v, err, want)
+		}
+	}
+	for _, src := range []string{`1 << -1`, `perms & -1`, `1 << 1.5`} {
+		if _, err := s.Eval(src); err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+}
+
+func TestStringCompare(t *testing.T) {
+	s := NewScope()
+	for src, want := range map[string]bool{
+		`"a" < "b"`:       true,
+		`"1.10" >= "1.9"`: false,
+		`"abc" <= "abc"`:  true,
+		`"b" > "abc"`:     true,
+	} {
+		v, err := s.Eval(src)
+		if err != nil || v != want {
+			t.Errorf("%s: got %#v %v, want %v", src, v, err, want)
+		}
+	}
+	if _, err := s.Eval(`"2" < 3`); err == nil {
+		t.Error("expected error comparing string and int")
+	}
+}
+
+func TestNumericPromotion(t *testing.T) {
+	s := NewScope()
+	s.Set("total", int64(40))
+	s.Set("qty", int32(2))
+	s.Set("price", 2.5)
+	s.Set("small", uint8(3))
+	for src, want := range map[string]interface{}{
+		`total + 2`:       int64(42),
+		`total * qty`:     int64(80),
+		`price > 2`:       true,
+		`qty == 2`:        true,
+		`price * qty`:     5.0,
+		`5 / 2.0`:         2.5,
+		`small + uint(1)`: uint64(4),
+	} {
+		v, err := s.Eval(src)
+		if err != nil || v != want {
+			t.Errorf("%s: got %#v %v, want %#v", src, v, err, want)
+		}
+	}
+	if _, err := s.Eval(`small + 300`); err == nil {
+		t.Error("expected overflow error")
+	}
+}
+
+func TestAutoVivify(t *testing.T) {
+	s := NewScope()
+	s.Set("a", map[string]interface{}{})
+	if _, err := s.Eval(`a["x"]["y"] = 1`); err == nil {
+		t.Fatal("expected error without AutoVivify")
+	}
+	s.Options.AutoVivify = true
+	if _, err := s.Eval(`a["x"]["y"] = 1
+	a["x"]["z"] = 2
+	a["p"]["q"]["r"] = 3`); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.GetJsonString("a"); got != `{"p":{"q":{"r":3}},"x":{"y":1,"z":2}}` {
+		t.Fatalf("unexpected %s", got)
+	}
+}
+
+func TestGetJSON(t *testing.T) {
+	s := NewScope()
+	s.RegisterMarshaler(time.Time{}, func(v interface{}) (interface{}, error) {
+		return v.(time.Time).Format("2006-01-02"), nil
+	})
+	s.Set("order", map[string]interface{}{
+		"created": []time.Time{time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+	})
+	b, err := s.NewChild().GetJSON("order")
+	if err != nil || string(b) != `{"created":["2020-01-02"]}` {
+		t.Fatalf("unexpected %s %v", b, err)
+	}
+	s.Set("ch", make(chan int))
+	if _, err = s.GetJSON("ch"); err == nil {
+		t.Fatal("expected marshal error")
+	}
+	b, err = s.GetJSONIndent("order", "", "  ")
+	if err != nil || string(b) != "{\n  \"created\": [\n    \"2020-01-02\"\n  ]\n}" {
+		t.Fatalf("unexpected %s %v", b, err)
+	}
+}
+
+func TestGetYAML(t *testing.T) {
+	s := NewScope()
+	s.Set("cfg", map[string]interface{}{
+		"name":  "api server",
+		"ports": []int{80, 443},
+		"tls":   map[string]interface{}{"enabled": true, "cert": ""},
+		"tags":  []interface{}{map[string]interface{}{"k": "yes"}},
+	})
+	want := `name: api server
+ports:
+  - 80
+  - 443
+tags:
+  -
+    k: "yes"
+tls:
+  cert: ""
+  enabled: true
+`
+	if got := s.GetYamlString("cfg"); got != want {
+		t.Fatalf("unexpected\n%s", got)
+	}
+}
+
+func TestDump(t *testing.T) {
+	s := NewScope()
+	s.Set("x", 1)
+	s.Set("name", "tom")
+	c := s.NewChild()
+	c.Vars["x"] = 2.5
+	want := `[0] x float64 = 2.5
+[1] name string = "tom"
+[1] x int = 1 (shadowed)
+`
+	if got := c.Dump(); got != want {
+		t.Fatalf("unexpected\n%s", got)
+	}
+	if keys := c.Keys(); len(keys) != 2 {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	s := NewScope()
+	s.Set("count", 1)
+	s.Set("tags", map[string]interface{}{"a": 1})
+	s.Set("gone", true)
+	s.Set("none", nil)
+	before := s.Snapshot()
+	if _, err := s.Eval(`count = count + 1
+	tags["b"] = 2
+	fresh := "x"`); err != nil {
+		t.Fatal(err)
+	}
+	delete(s.Vars, "gone")
+	var got []string
+	for _, c := range Diff(before, s) {
+		got = append(got, c.Name+" "+c.Kind.String())
+	}
+	if fmt.Sprint(got) != "[count modified fresh added gone removed tags modified]" {
+		t.Fatalf("unexpected %v", got)
+	}
+}
+
+func TestLambda(t *testing.T) {
+	s := NewScope()
+	s.Set("people", []string{"bob", "al", "christine"})
+	l, err := s.Lambda(`len(people[i]) < len(people[j])`, func(i, j int) bool { return false }, "i", "j")
+	if err != nil {
+		t.Fatal(err)
+	}
+	people := s.Get("people").([]string)
+	sort.Slice(people, l.(func(i, j int) bool))
+	if fmt.Sprint(people) != "[al bob christine]" {
+		t.Fatalf("unexpected %v", people)
+	}
+
+	l, err = s.Lambda(`100 / n`, reflect.TypeOf(func(int) (int64, error) { return 0, nil }), "n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	div := l.(func(int) (int64, error))
+	if v, err := div(4); v != 25 || err != nil {
+		t.Fatalf("unexpected %v %v", v, err)
+	}
+	l, err = s.Lambda(`missing(n)`, func(n int) error { return nil }, "n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.(func(int) error)(1); err == nil {
+		t.Fatal("expected evaluation error")
+	}
+	if _, err = s.Lambda(`x`, func() {}, "y"); err == nil {
+		t.Fatal("expected parameter count error")
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	s := NewScope()
+	bus := NewEventBus(s)
+	bus.Define("order.created", map[string]reflect.Type{"total": reflect.TypeOf(0.0)})
+	if err := bus.On("order.created", `total * 2`); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.On("order.*", `event`); err != nil {
+		t.Fatal(err)
+	}
+	results, err := bus.Emit("order.created", map[string]interface{}{"total": 2.5})
+	if err != nil || len(results) != 2 || results[0].Value != 5.0 || results[1].Value != "order.created" {
+		t.Fatalf("unexpected %#v %v", results, err)
+	}
+	if _, err = bus.Emit("order.created", map[string]interface{}{"total": "x"}); err == nil {
+		t.Fatal("expected schema error")
+	}
+}
+
+func TestEvaluator(t *testing.T) {
+	s := NewScope()
+	s.Set("limit", 10)
+	e, err := s.Compile(`over := amount > limit
+	over && len(tags) > 0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(e.Fields()) != "[amount limit tags]" {
+		t.Fatalf("unexpected fields %v", e.Fields())
+	}
+	var matched int
+	for i := 0; i < 20; i++ {
+		v, err := e.Process(map[string]interface{}{"amount": i, "tags": []string{"a"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v == true {
+			matched++
+		}
+	}
+	if matched != 9 {
+		t.Fatalf("unexpected matches %d", matched)
+	}
+	// fields read through slots, in closures too, and fields assigned
+	e, err = s.Compile(`total += fee
+	ok := func() bool { return total > limit }
+	sprintf("%v %v %v", total, ok(), note)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		record map[string]interface{}
+		want   string
+	}{
+		{map[string]interface{}{"total": 8, "fee": 3, "note": "a"}, "11 true a"},
+		{map[string]interface{}{"total": 1, "fee": 1}, "2 false note"},
+	} {
+		if got, err := e.Process(c.record); err != nil || got != c.want {
+			t.Errorf("%v: got %v, %v", c.record, got, err)
+		}
+	}
+	var reads []string
+	s.OnGet("", func(name string, _ interface{}) { reads = append(reads, name) })
+	if _, err := e.Process(map[string]interface{}{"total": 1, "fee": 1, "note": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(fmt.Sprint(reads), "note") {
+		t.Errorf("get hooks missed the fields: %v", reads)
+	}
+}
+
+func TestCompat(t *testing.T) {
+	s := NewScope()
+	s.Set("name", "order-42")
+	s.Set("total", 120)
+	for src, want := range map[string]interface{}{
+		`total > 100 ? 'big' : 'small'`:                      "big",
+		`name =~ '^order-[0-9]+$' && total > 0`:              true,
+		`name !~ 'x'`:                                        true,
+		`total < 10 ? 'tiny' : total < 200 ? 'mid' : 'huge'`: "mid",
+		`len(total > 1 ? 'ab' : 'abc')`:                      2,
+	} {
+		v, err := s.EvalCompat(src)
+		if err != nil || v != want {
+			tr, _ := TranslateCompat(src)
+			t.Errorf("%s (%s): got %#v %v, want %#v", src, tr, v, err, want)
+		}
+	}
+}
+
+type pet struct {
+	Name  string
+	Owner *pet
+}
+
+func (p pet) Greeting() string {
+	return "hi " + p.Name
+}
+
+func TestInterfaceSelector(t *testing.T) {
+	s := NewScope()
+	var wrapped interface{} = &pet{Name: "tom", Owner: &pet{Name: "ann"}}
+	s.Set("data", map[string]interface{}{"pet": pet{Name: "rex"}, "ptr": &wrapped})
+	for src, want := range map[string]interface{}{
+		`data["pet"].Name`:       "rex",
+		`data["pet"].Greeting()`: "hi rex",
+		`data["ptr"].Owner.Name`: "ann",
+	} {
+		v, err := s.Eval(src)
+		if err != nil || v != want {
+			t.Errorf("%s: got %#v %v, want %#v", src, v, err, want)
+		}
+	}
+	if _, err := s.Eval(`data["ptr"].Owner.Owner.Name`); err == nil {
+		t.Error("expected nil pointer error")
+	}
+}
+
+func (p *pet) Rename(name string) string {
+	p.Name = name
+	return p.Name
+}
+
+func TestPointerMethodOnValue(t *testing.T) {
+	s := NewScope()
+	s.Set("p", pet{Name: "rex"})
+	v, err := s.Eval(`p.Rename("max")`)
+	if err != nil || v != "max" {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+}
+
+func TestNilArgument(t *testing.T) {
+	s := NewScope()
+	s.Set("describe", func(v interface{}, m map[string]int, p *pet, rest ...error) string {
+		return fmt.Sprint(v == nil, m == nil, p == nil, len(rest))
+	})
+	v, err := s.Eval(`describe(nil, nil, nil, nil, nil)`)
+	if err != nil || v != "true true true 2" {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+	s.Set("double", func(n int) int { return n * 2 })
+	if _, err = s.Eval(`double(nil)`); err == nil {
+		t.Fatal("expected error passing nil as int")
+	}
+}
+
+func TestSliceArgument(t *testing.T) {
+	s := NewScope()
+	s.InstallStrings("")
+	s.Set("total", func(xs []int64) (n int64) {
+		for _, x := range xs {
+			n += x
+		}
+		return
+	})
+	v, err := s.Eval(`join([]interface{}{"a", "b"}, ",")`)
+	if err != nil || v != "a,b" {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+	v, err = s.Eval(`total([]interface{}{1, int8(2), uint(3)})`)
+	if err != nil || v != int64(6) {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+	if _, err = s.Eval(`total([]interface{}{1, "2"})`); err == nil {
+		t.Fatal("expected element conversion error")
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	s := NewScope()
+	s.Set("add", Add)
+	s.Set("scale", func(f float64, n int8) float64 { return f * float64(n) })
+	for src, want := range map[string]string{
+		`add(1)`:         "goeval: calling add with signature func(int, int) int: not enough arguments, have 1, want 2",
+		`add(1, 2, 3)`:   "goeval: calling add with signature func(int, int) int: too many arguments, have 3, want 2",
+		`add(1, "2")`:    `goeval: calling add with signature func(int, int) int: argument 2: cannot use "2" (string) as int`,
+		`scale(2, 1000)`: "goeval: calling scale with signature func(float64, int8) float64: argument 2: 1000 overflows int8",
+	} {
+		if _, err := s.Eval(src); err == nil || err.Error() != want {
+			t.Errorf("%s: got %v", src, err)
+		}
+	}
+	v, err := s.Eval(`scale(2, 3)`)
+	if err != nil || v != 6.0 {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+}
+
+func TestCallErrorAborts(t *testing.T) {
+	s := NewScope()
+	calls := 0
+	s.Set("save", func() error { calls++; return fmt.Errorf("disk full") })
+	s.Set("pair", func() (int, string, error) { return 1, "a", nil })
+	if _, err := s.Eval(`save()
+	save()`); err == nil || err.Error() != "disk full" || calls != 1 {
+		t.Fatalf("unexpected %v after %d calls", err, calls)
+	}
+	v, err := s.Eval(`pair()`)
+	if err != nil || fmt.Sprint(v) != "[1 a]" {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+}
+
+func TestRunTests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goeval")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_ = ioutil.WriteFile(filepath.Join(dir, "test_ok.gos"), []byte(`assertEqual(limit * 2, 20)`), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "test_bad.gos"), []byte("x := 1\n  assert(x > limit, \"x too small\")"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, "helper.gos"), []byte(`assert(false)`), 0644)
+	results, err := RunTests(dir, func(s *Scope) { s.Set("limit", 10) })
+	if err != nil || len(results) != 2 {
+		t.Fatalf("unexpected %v %v", results, err)
+	}
+	if results[0].Passed() || results[0].Line != 2 || results[0].Column != 3 || !results[1].Passed() {
+		t.Fatalf("unexpected %v", results)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	src := `level := "low"
+if amount > 100 {
+	level = "high"
+}
+level`
+	s := NewScope()
+	s.Options.Coverage = NewCoverage()
+	s.Set("amount", 5)
+	if _, err := s.Eval(src); err != nil {
+		t.Fatal(err)
+	}
+	report, err := s.Options.Coverage.Report(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `1:1 1
+2:1 1
+2:17 0
+3:2 0
+5:1 1
+coverage: 60.0% of statements
+`
+	if report.String() != want {
+		t.Fatalf("unexpected\n%s", report)
+	}
+}
+
+func TestCoroutine(t *testing.T) {
+	src := `approved := yield("manager")
+	if approved {
+		approved = yield("director")
+	}
+	approved`
+	s := NewScope()
+	c, err := s.Start(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := c.Yielded(); !ok || v != "manager" {
+		t.Fatalf("unexpected %#v %v", v, ok)
+	}
+	_ = c.Resume(true)
+	state := c.State()
+	c.Close()
+	if _, err = c.Result(); err != ErrCoroutineClosed {
+		t.Fatalf("unexpected %v", err)
+	}
+
+	// restore from the serialized state in a new process
+	b, _ := json.Marshal(state)
+	var restored CoroutineState
+	_ = json.Unmarshal(b, &restored)
+	c, err = s.Restore(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := c.Yielded(); !ok || v != "director" {
+		t.Fatalf("unexpected %#v %v", v, ok)
+	}
+	_ = c.Resume(false)
+	if v, err := c.Result(); !c.Done() || v != false || err != nil {
+		t.Fatalf("unexpected %#v %v", v, err)
+	}
+
+	// resumes come back from JSON with the types of JSON
+	for src, want := range map[string]interface{}{
+		`yield(0) + 1`:        3.0,
+		`toInt(yield(0)) + 1`: 3,
+	} {
+		c, _ = s.Start(src)
+		_ = c.Resume(2)
+		if v, _ := c.Result(); v != 3 {
+			t.Errorf("%s: got %#v", src, v)
+		}
+		b, _ = json.Marshal(c.State())
+		restored = CoroutineState{}
+		_ = json.Unmarshal(b, &restored)
+		c, _ = s.Restore(restored)
+		if v, err := c.Result(); !c.Done() || v != want {
+			t.Errorf("%s restored: got %#v, %v, want %#v", src, v, err, want)
+		}
+	}
+
+	// a coroutine never closed is a goroutine of the scope tree, which Kill ends
+	s = NewScope()
+	c, _ = s.Start(`yield(1)`)
+	if n := s.Running(); n != 1 {
+		t.Fatalf("running %d", n)
+	}
+	s.Kill()
+	if err := s.Wait(); err != nil || s.Running() != 0 {
+		t.Fatalf("wait: %v, running %d", err, s.Running())
+	}
+	if err := c.Resume(2); err != nil || !c.Done() {
+		t.Fatalf("resume: %v, done %v", err, c.Done())
+	}
+	if _, err := c.Result(); err != context.Canceled {
+		t.Fatalf("unexpected %v", err)
+	}
+}
+
+func TestGoroutines(t *testing.T) {
+	s := NewScope()
+	s.Options.MaxGoroutines = 2
+	results := make(chan int, 10)
+	s.Set("work", func(ctx context.Context, n int) error {
+		<-ctx.Done()
+		results <- n
+		return ctx.Err()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := s.EvalContext(ctx, `go work(1)
+	go work(2)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Eval(`go work(3)`); err == nil {
+		t.Fatal("expected goroutine limit error")
+	}
+	if s.Running() != 2 {
+		t.Fatalf("unexpected running %d", s.Running())
+	}
+	cancel()
+	if err := s.Wait(); err != context.Canceled || len(results) != 2 {
+		t.Fatalf("unexpected %v %d", err, len(results))
+	}
+	if _, err := s.Eval(`go work(4)`); err != nil {
+		t.Fatal(err)
+	}
+	s.Kill()
+	_ = s.Wait()
+	if len(results) != 3 {
+		t.Fatalf("unexpected %d", len(results))
+	}
+	// the first evaluations of fresh scopes, which copy the scope to run
+	for _, fresh := range []*Scope{NewScope(), NewScope(WithLogger(&recordLogger{}))} {
+		fresh.Set("work", s.Get("work"))
+		if _, err := fresh.Eval(`go work(5)`); err != nil {
+			t.Fatal(err)
+		}
+		if fresh.Running() != 1 {
+			t.Fatalf("unexpected running %d", fresh.Running())
+		}
+		fresh.Kill()
+		if err := fresh.Wait(); err != context.Canceled {
+			t.Fatalf("unexpected %v", err)
+		}
+	}
+}
+
+func TestRunes(t *testing.T) {
+	s := NewScope()
+	s.InstallStrings("")
+	s.Set("name", "héllo世界")
+	for src, want := range map[string]interface{}{
+		`len(name)`:             12,
+		`runeLen(name)`:         7,
+		`runeAt(name, 5)`:       "世",
+		`runeSlice(name, 1, 6)`: "éllo世",
+	} {
+		v, err := s.Eval(src)
+		if err != nil || v != want {
+			t.Errorf("%s: got %#v %v, want %#v", src, v, err, want)
+		}
+	}
+	s.Options.RuneLen = true
+	if v, err := s.Eval(`len(name)`); err != nil || v != 7 {
+		t.Errorf("unexpected %#v %v", v, err)
+	}
+}
+
+func TestLiterals(t *testing.T) {
+	s := NewScope()
+	for src, want := range map[string]interface{}{
+		`"line1\nline2"`: "line1\nline2",
+		`"世\t\x41"`:      "世\tA",
+		"`raw\\n`":       `raw\n`,
+		`'\n'`:           '\n',
+		`'é'`:            'é',
+		`'\''`:           '\'',
+	} {
+		v, err := s.Eval(src)
+		if err != nil || v != want {
+			t.Errorf("%s: got %#v %v, want %#v", src, v, err, want)
+		}
+	}
+}
+
+func TestLargeIntLiteral(t *testing.T) {
+	s := NewScope()
+	for src, want := range map[string]interface{}{
+		`0xFFFFFFFFFFFFFFFF`:       uint64(math.MaxUint64),
+		`0xFFFFFFFFFFFFFFFF >> 60`: uint64(15),
+		`1_000_000`:                1000000,
+	} {
+		v, err := s.Eval(src)
+		if err != nil || v != want {
+			t.Errorf("%s: got %#v %v, want %#v", src, v, err, want)
+		}
+	}
+	if _, err := s.Eval(`99999999999999999999999`); err == nil {
+		t.Error("expected out of range error")
+	}
+}
+
+func TestLoopVarsPerIteration(t *testing.T) {
+	s := NewScope()
+	got, err := s.Eval(`funcs := []func() int{}
+for i := 0; i < 3; i++ {
+	funcs = append(funcs, func() int { return i })
+}
+for _, v := range []int{10, 20} {
+	funcs = append(funcs, func() int { return v })
+}
+return funcs[0]() + funcs[1]()*10 + funcs[2]()*100 + funcs[3]() + funcs[4]()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 210+30 {
+		t.Errorf("got %v, want 240", got)
+	}
+	if _, ok := s.Vars["i"]; ok {
+		t.Error("loop variable leaked into the enclosing scope")
+	}
+}
+
+func TestIfStatement(t *testing.T) {
+	s := NewScope()
+	s.Set("f", func() int { return 3 })
+	got, err := s.Eval(`r := 0
+if v := f(); v > 0 {
+	r = v * 2
+}
+return r`)
+	if err != nil || got != 6 {
+		t.Errorf("got %v, %v, want 6", got, err)
+	}
+	if _, ok := s.Vars["v"]; ok {
+		t.Error("if init variable leaked into the enclosing scope")
+	}
+	_, err = s.Eval(`x := 1
+if  x {
+}`)
+	var pe *PosError
+	if !errors.As(err, &pe) || pe.Line != 2 || pe.Column != 5 {
+		t.Errorf("non-bool condition: got %v", err)
+	}
+	if _, err := s.Eval(`if v := undefinedFunc(); v {
+}`); err == nil {
+		t.Error("if init error was discarded")
+	}
+}
+
+func TestTupleAssignment(t *testing.T) {
+	s := NewScope()
+	s.Set("divmod", func(a, b int) (int, int) { return a / b, a % b })
+	got, err := s.Eval(`a, b := 1, 2
+a, b = b, a
+xs := []int{10, 20}
+xs[0], xs[1] = xs[1], xs[0]
+q, r := divmod(17, 5)
+i, j := 0, 1
+i, j = j, i+j
+return []int{a, b, xs[0], xs[1], q, r, i, j}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{2, 1, 20, 10, 3, 2, 1, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLoopControl(t *testing.T) {
+	s := NewScope()
+	s.Set("fail", func(i int) error {
+		if i == 2 {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	if _, err := s.Eval(`n := 0
+for i := 0; i < 5; i++ {
+	fail(i)
+	n++
+}`); err == nil || err.Error() != "boom" {
+		t.Errorf("for body error: got %v", err)
+	}
+	if _, err := s.Eval(`for _, i := range []int{1, 2, 3} { fail(i) }`); err == nil {
+		t.Error("range body error was swallowed")
+	}
+	got, err := s.Eval(`sum := 0
+outer:
+for i := 0; i < 5; i++ {
+	for _, j := range []int{1, 2, 3} {
+		if j == 2 {
+			continue
+		}
+		if i == 3 {
+			break outer
+		}
+		if j == 3 && i == 1 {
+			continue outer
+		}
+		sum += j * 10
+	}
+	sum++
+}
+return sum`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// i=0: 10+30+1, i=1: 10, i=2: 10+30+1
+	if got != 92 {
+		t.Errorf("got %v, want 92", got)
+	}
+	if _, err := s.Eval(`f := func() { break }
+for i := 0; i < 3; i++ { f() }`); err == nil {
+		t.Error("break outside a loop was accepted")
+	}
+}
+
+func TestDefineShadowsParent(t *testing.T) {
+	host := NewScope()
+	host.Set("x", 1)
+	child := host.NewChild()
+	got, err := child.Eval(`x := 2
+if y := x * 10; y > 5 {
+	x := y
+	x = x + 1
+}
+return x`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 2 {
+		t.Errorf("got %v, want 2", got)
+	}
+	if host.Get("x") != 1 {
+		t.Errorf("host x changed to %v", host.Get("x"))
+	}
+	if _, err := child.Eval(`z = 1`); err == nil {
+		t.Error("expected an error assigning an undefined variable")
+	}
+}
+
+func TestVarWithoutType(t *testing.T) {
+	s := NewScope()
+	s.Set("pair", func() (int, string) { return 7, "seven" })
+	got, err := s.Eval(`var a = 5
+var n, name = pair()
+var (
+	f float64 = 2
+	b, c int64
+)
+return a, n, name, f, b + c`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{5, 7, "seven", 2.0, int64(0)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	if _, err := s.Eval(`var x, y = 1, 2, 3`); err == nil {
+		t.Error("expected an assignment mismatch error")
+	}
+}
+
+func TestTypeDeclarations(t *testing.T) {
+	s := NewScope()
+	s.Set("time", Namespace{"Duration": reflect.TypeOf(time.Duration(0))})
+	got, err := s.Eval(`type ID int
+type Alias = time.Duration
+type Plain time.Duration
+ids := map[ID]string{1: "a"}
+ids[ID(2) * 2] = "b"
+return len(ids), ids[4], Alias(1500000000).String(), Plain(3) + 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{2, "b", "1.5s", int64(4)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestFuncTypes(t *testing.T) {
+	s := NewScope()
+	s.Set("shout", func(n int) string { return strings.Repeat("!", n) })
+	got, err := s.Eval(`type Handler func(int) string
+type Table struct {
+	Name       string
+	OnA, OnB   Handler
+}
+tbl := Table{Name: "t", OnA: shout, OnB: func(n int) string { return toString(n * 2) }}
+var h Handler = tbl.OnB
+return tbl.OnA(3) + h(21)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "!!!42" {
+		t.Errorf("got %#v", got)
+	}
+}
+
+func TestArrayTypes(t *testing.T) {
+	s := NewScope()
+	got, err := s.Eval(`a := [3]int{1, 2}
+var b [4]byte
+b[1] = 7
+c := [...]string{"x", "y"}
+c2 := c
+c2[0] = "z"
+return a, b, len(c), c[0] + c2[0]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{[3]int{1, 2, 0}, [4]byte{0, 7, 0, 0}, 2, "xz"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestPointerCompositeLit(t *testing.T) {
+	s := NewScope()
+	got, err := s.Eval(`type Animal struct {
+	Name string
+	Age  int
+}
+cat := &Animal{Name: "Tom"}
+cat.Name = "Tim"
+cat.Age += 2
+dog := Animal{Name: "Rex"}
+dog.Age = 4
+return cat, dog.Age`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := got.([]interface{})
+	cat := reflect.ValueOf(res[0])
+	if cat.Kind() != reflect.Ptr {
+		t.Fatalf("got %T, want a pointer", res[0])
+	}
+	if name := cat.Elem().FieldByName("Name").String(); name != "Tim" {
+		t.Errorf("name = %q", name)
+	}
+	if age := cat.Elem().FieldByName("Age").Int(); age != 2 {
+		t.Errorf("age = %d", age)
+	}
+	if res[1] != 4 {
+		t.Errorf("dog age = %v", res[1])
+	}
+	if _, err := s.Eval(`x := 1
+return &x`); err == nil {
+		t.Error("expected an error taking the address of a variable")
+	}
+}
+
+func TestSyncPrimitives(t *testing.T) {
+	s := NewScope()
+	s.InstallSync("sync")
+	got, err := s.Eval(`mu := sync.newMutex()
+wg := sync.newWaitGroup()
+once := sync.newOnce()
+total := 0
+inits := 0
+for i := 1; i <= 10; i++ {
+	wg.Add(1)
+	go func(n int) {
+		once.Do(func() { inits = inits + 1 })
+		mu.Lock()
+		total = total + n
+		mu.Unlock()
+		wg.Done()
+	}(i)
+}
+wg.Wait()
+return total, inits`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{55, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	s = NewScope()
+	s.Options.LockTimeout = 20 * time.Millisecond
+	s.InstallSync("")
+	_, err = s.Eval(`mu := newMutex()
+mu.Lock()
+mu.Lock()`)
+	if err == nil || !strings.Contains(err.Error(), "deadlock") {
+		t.Errorf("expected a deadlock error, got %v", err)
+	}
+
+	// the waits end with the context of the evaluation calling them
+	s.Options.LockTimeout = 5 * time.Second
+	s.Set("mu", nil)
+	if _, err := s.Eval(`mu = newMutex(); mu.Lock()`); err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []string{`mu.Lock()`, `wg := newWaitGroup(); wg.Add(1); wg.Wait()`} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		_, err := s.EvalContext(ctx, src)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+			t.Errorf("%s: got %v after %v", src, err, time.Since(start))
+		}
+	}
+}
+
+func TestModules(t *testing.T) {
+	modules := NewModules(nil)
+	modules.Register("pricing/common", `Rate := 0.5
+helper := 2.0
+Discount := func(p float64) float64 { return p * Rate * helper }`)
+	modules.Register("pricing/vip", `use "pricing/common"
+Price := func(p float64) float64 { return common.Discount(p) - 1 }`)
+	modules.Register("a", `use "b"`)
+	modules.Register("b", `use "a"`)
+
+	s := NewScope()
+	s.Options.Resolver = modules
+	got, err := s.Eval(`use "pricing/vip"
+use "pricing/common"
+return vip.Price(10.0), common.Rate`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{9.0, 0.5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, ok := s.Get("common").(Namespace)["helper"]; ok {
+		t.Error("unexported module name leaked")
+	}
+	if _, err := s.Eval(`use "a"`); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected an import cycle error, got %v", err)
+	}
+	if got, err := s.Eval(`use := 3
+return use`); err != nil || got != 3 {
+		t.Errorf("use as a variable: %v, %v", got, err)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goeval")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"helpers.gos": `double := func(n int) int { return n * 2 }
+return "helpers"`,
+		"loop.gos": `include("loop.gos")`,
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewScope()
+	s.Options.Loader = DirLoader(dir)
+	got, err := s.Eval(`name := include("helpers.gos")
+return name, double(21)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"helpers", 42}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := s.Eval(`include("loop.gos")`); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected an include cycle error, got %v", err)
+	}
+	if _, err := s.Eval(`include("../secret")`); err == nil {
+		t.Error("expected an error loading outside the directory")
+	}
+}
+
+func TestManagerReload(t *testing.T) {
+	var mu sync.Mutex
+	src := `return x * 2`
+	source := func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return src, nil
+	}
+	setSource := func(s string) {
+		mu.Lock()
+		src = s
+		mu.Unlock()
+	}
+	m, err := NewManager(NewScope(), source, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloads := make(chan error, 10)
+	m.OnReload = func(version int, err error) { reloads <- err }
+	m.Start()
+	defer m.Stop()
+
+	if got, err := m.Run(map[string]interface{}{"x": 2}); err != nil || got != 4 {
+		t.Fatalf("got %v, %v", got, err)
+	}
+	setSource(`return x * 3`)
+	if err := <-reloads; err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := m.Run(map[string]interface{}{"x": 2}); got != 6 || m.Version() != 2 {
+		t.Errorf("got %v at version %d after reload", got, m.Version())
+	}
+	setSource(`return x *`)
+	if err := <-reloads; err == nil {
+		t.Error("expected the broken script to be rejected")
+	}
+	if got, _ := m.Run(map[string]interface{}{"x": 2}); got != 6 {
+		t.Errorf("got %v, the previous script should stay active", got)
+	}
+}
+
+func TestBind(t *testing.T) {
+	type Base struct {
+		Region string
+	}
+	type Input struct {
+		Base
+		Amount  float64 `goeval:"amount,readonly"`
+		Country string  `goeval:"country"`
+		Secret  string  `goeval:"-"`
+		hidden  int
+	}
+	s := NewScope()
+	if err := s.Bind(&Input{Base{"eu"}, 9.5, "fr", "pw", 1}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Eval(`country = "de"
+return amount, country, Region`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{9.5, "de", "eu"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, name := range []string{"Secret", "secret", "hidden"} {
+		if _, ok := s.Vars[name]; ok {
+			t.Errorf("%s should not be bound", name)
+		}
+	}
+	for _, src := range []string{`amount = 1.0`, `amount += 1.0`, `var amount = 2.0`, `for _, amount = range []float64{1} {}`} {
+		if _, err := s.Eval(src); err == nil || !strings.Contains(err.Error(), "read-only") {
+			t.Errorf("%s: expected a read-only error, got %v", src, err)
+		}
+	}
+	if got, err := s.NewChild().Eval(`amount := 1.0
+return amount`); err != nil || got != 1.0 {
+		t.Errorf("shadowing in a child scope: %v, %v", got, err)
+	}
+	if err := s.Bind(3); err == nil {
+		t.Error("expected an error binding a non-struct")
+	}
+}
+
+func TestEvalWithResult(t *testing.T) {
+	s := NewScope()
+	s.InstallStrings("strings")
+	s.Set("limit", 10)
+	s.Set("tags", map[string]int{"a": 1})
+	s.Set("unchanged", []int{1, 2})
+	res, err := s.EvalWithResult(`total := limit * 2
+tags["b"] = 2
+limit = 5
+for i := 0; i < 2; i++ {
+	tmp := i
+}
+return strings.upper("ok")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Value != "OK" {
+		t.Errorf("value = %v", res.Value)
+	}
+	want := map[string]interface{}{"total": 20, "limit": 5, "tags": map[string]int{"a": 1, "b": 2}}
+	if !reflect.DeepEqual(res.Vars, want) {
+		t.Errorf("vars = %v, want %v", res.Vars, want)
+	}
+}
+
+func TestVariableHooks(t *testing.T) {
+	s := NewScope()
+	s.Set("limit", 10)
+	var reads []string
+	var writes []string
+	s.OnGet("", func(name string, val interface{}) { reads = append(reads, name) })
+	s.OnSet("", func(name string, old, new interface{}) error {
+		writes = append(writes, fmt.Sprintf("%s:%v->%v", name, old, new))
+		return nil
+	})
+	s.OnSet("limit", func(name string, old, new interface{}) error {
+		if new.(int) < 0 {
+			return fmt.Errorf("limit must not be negative")
+		}
+		return nil
+	})
+	if _, err := s.Eval(`x := limit
+limit += 5`); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"limit", "limit"}; !reflect.DeepEqual(reads, want) {
+		t.Errorf("reads = %v, want %v", reads, want)
+	}
+	if want := []string{"x:<nil>->10", "limit:10->15"}; !reflect.DeepEqual(writes, want) {
+		t.Errorf("writes = %v, want %v", writes, want)
+	}
+	if _, err := s.NewChild().Eval(`limit = -1`); err == nil || !strings.Contains(err.Error(), "negative") {
+		t.Errorf("expected the write to be vetoed, got %v", err)
+	}
+	if s.Get("limit") != 15 {
+		t.Errorf("limit = %v after a vetoed write", s.Get("limit"))
+	}
+}
+
+func TestStoreScope(t *testing.T) {
+	store := NewMemoryStore()
+	store.Set("visits", 1)
+	a, err := NewStoreScope(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewStoreScope(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Eval(`visits += 1
+user := "ann"`); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.NewChild().Eval(`return user, visits`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"ann", 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if names, _ := store.List(); !reflect.DeepEqual(names, []string{"user", "visits"}) {
+		t.Errorf("stored %v", names)
+	}
+}
+
+func TestDecodeStored(t *testing.T) {
+	got, err := decodeStored([]byte(`{"n": 3, "f": 1.5, "l": [1, "x"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"n": 3, "f": 1.5, "l": []interface{}{1, "x"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v", got)
+	}
+}
+
+func TestInferType(t *testing.T) {
+	s := NewScope()
+	s.Set("age", 30)
+	s.Set("score", 1.5)
+	s.Set("name", "ann")
+	s.Set("tags", map[string][]string{})
+	s.Set("p", pet{Name: "rex"})
+	s.Set("data", nil)
+	s.InstallStrings("strings")
+	for src, want := range map[string]reflect.Type{
+		`age > 18 && name != ""`:      reflect.TypeOf(true),
+		`age + 1`:                     reflect.TypeOf(0),
+		`age * score`:                 reflect.TypeOf(0.0),
+		`int64(age) + 2`:              reflect.TypeOf(int64(0)),
+		`tags["x"][0]`:                reflect.TypeOf(""),
+		`p.Greeting()`:                reflect.TypeOf(""),
+		`p.Name + "!"`:                reflect.TypeOf(""),
+		`strings.contains(name, "a")`: reflect.TypeOf(true),
+		`len(name) + toInt(data)`:     reflect.TypeOf(0),
+		`data`:                        dynamicType,
+		`[]float64{1, 2}[:1]`:         reflect.TypeOf([]float64{}),
+		`!(age < 3)`:                  reflect.TypeOf(true),
+	} {
+		got, err := InferType(src, s)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+		} else if got != want {
+			t.Errorf("%s: got %v, want %v", src, got, want)
+		}
+	}
+	for _, src := range []string{`name + age`, `missing > 1`, `!age`, `p.Nope`} {
+		if got, err := InferType(src, s); err == nil {
+			t.Errorf("%s: expected an error, got %v", src, got)
+		}
+	}
+}
+
+func TestEvalAs(t *testing.T) {
+	s := NewScope()
+	s.Set("age", 20)
+	boolType, floatType, intType := reflect.TypeOf(true), reflect.TypeOf(0.0), reflect.TypeOf(0)
+	if got, err := s.EvalAs(`age >= 18`, boolType); err != nil || got != true {
+		t.Errorf("bool: %v, %v", got, err)
+	}
+	if got, err := s.EvalAs(`age * 2`, floatType); err != nil || got != 40.0 {
+		t.Errorf("float64: %v, %v", got, err)
+	}
+	if got, err := s.EvalAs(`4.0 / 2`, intType); err != nil || got != 2 {
+		t.Errorf("int: %v, %v", got, err)
+	}
+	for _, c := range []struct {
+		src    string
+		target reflect.Type
+		msg    string
+	}{
+		{`age`, boolType, "is not a bool"},
+		{`2.5`, intType, "cannot be represented"},
+		{`nil`, floatType, "nil"},
+		{`return 1, 2`, intType, "several values"},
+	} {
+		if _, err := s.EvalAs(c.src, c.target); err == nil || !strings.Contains(err.Error(), c.msg) {
+			t.Errorf("%s as %v: got %v, want an error containing %q", c.src, c.target, err, c.msg)
+		}
+	}
+	var e error = errors.New("x")
+	if got, err := s.EvalAs(`nil`, reflect.TypeOf(&e).Elem()); err != nil || got != nil {
+		t.Errorf("nil error: %v, %v", got, err)
+	}
+}
+
+func TestUnaryOperators(t *testing.T) {
+	ch := make(chan string, 1)
+	ch <- "msg"
+	for _, c := range []struct {
+		src  string
+		want interface{}
+	}{
+		{`^5`, ^5},
+		{`^uint8(5)`, uint8(250)},
+		{`-uint8(1)`, uint8(255)},
+		{`-int16(3)`, int16(-3)},
+		{`+float32(1.5)`, float32(1.5)},
+		{`-complex(1, 2)`, complex(-1, -2)},
+		{`!(1 > 2)`, true},
+		{`-d`, -time.Second},
+		{`<-ch`, "msg"},
+	} {
+		s := NewScope()
+		s.Set("complex", func(r, i float64) complex128 { return complex(r, i) })
+		s.Set("d", time.Second)
+		s.Set("ch", ch)
+		got, err := s.Eval(c.src)
+		if err != nil {
+			t.Errorf("%s: %v", c.src, err)
+		} else if got != c.want {
+			t.Errorf("%s: got %#v, want %#v", c.src, got, c.want)
+		}
+	}
+	for _, src := range []string{`!1`, `^1.5`, `-"s"`} {
+		if _, err := NewScope().Eval(src); err == nil || !strings.Contains(err.Error(), "not defined") {
+			t.Errorf("%s: expected an operator error, got %v", src, err)
+		}
+	}
+}
+
+func TestFormatBuiltins(t *testing.T) {
+	var out strings.Builder
+	s := NewScope()
+	s.Options.Output = &out
+	got, err := s.NewChild().Eval(`printf("%d items\n", 3)
+return sprintf("%.1f%%", 12.34) + sprint(" ", 1, "a")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "12.3% 1a" {
+		t.Errorf("got %q", got)
+	}
+	if out.String() != "3 items\n" {
+		t.Errorf("printed %q", out.String())
+	}
+}
+
+// recordLogger collects log records like a slog handler would
+type recordLogger struct {
+	records []string
+}
+
+func (l *recordLogger) log(level, msg string, args ...interface{}) {
+	l.records = append(l.records, strings.TrimSpace(fmt.Sprintln(append([]interface{}{level, msg}, args...)...)))
+}
+func (l *recordLogger) Debug(msg string, args ...interface{}) { l.log("DEBUG", msg, args...) }
+func (l *recordLogger) Info(msg string, args ...interface{})  { l.log("INFO", msg, args...) }
+func (l *recordLogger) Error(msg string, args ...interface{}) { l.log("ERROR", msg, args...) }
+
+func TestLogger(t *testing.T) {
+	logger := &recordLogger{}
+	s := NewScope()
+	s.Options.Logger = logger
+	if _, err := s.Eval(`x := 1
+log("computed", "x", x)`); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"DEBUG goeval: statement line 1 column 1",
+		"DEBUG goeval: statement line 2 column 1",
+		"INFO computed source script x 1",
+	}
+	if len(logger.records) != 4 || !reflect.DeepEqual(logger.records[:3], want) || !strings.HasPrefix(logger.records[3], "INFO goeval: evaluated duration") {
+		t.Errorf("records = %q", logger.records)
+	}
+	logger.records = nil
+	if _, err := s.Eval(`assert(false, "boom")`); err == nil {
+		t.Fatal("expected an error")
+	}
+	last := logger.records[len(logger.records)-1]
+	if !strings.HasPrefix(last, "ERROR goeval: evaluation failed error") || !strings.HasSuffix(last, "line 1 column 1") {
+		t.Errorf("last record = %q", last)
+	}
+}
+
+type spanKey struct{}
+
+// recordTracer keeps the spans it opened, with their parent
+type recordTracer struct {
+	spans []*recordSpan
+}
+
+type recordSpan struct {
+	name, parent string
+	attrs        map[string]interface{}
+	err          error
+	ended        bool
+}
+
+func (t *recordTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &recordSpan{name: name, attrs: map[string]interface{}{}}
+	if parent, ok := ctx.Value(spanKey{}).(*recordSpan); ok {
+		span.parent = parent.name
+	}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (s *recordSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+func (s *recordSpan) RecordError(err error)                      { s.err = err }
+func (s *recordSpan) End()                                       { s.ended = true }
+
+func TestTracer(t *testing.T) {
+	tracer := &recordTracer{}
+	s := NewScope()
+	s.Options.Tracer = tracer
+	s.Set("fail", func() error { return errors.New("boom") })
+	if _, err := s.Eval(`x := toString(1)
+fail()`); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(tracer.spans) != 3 {
+		t.Fatalf("got %d spans", len(tracer.spans))
+	}
+	eval, call, failed := tracer.spans[0], tracer.spans[1], tracer.spans[2]
+	if eval.name != "goeval.Eval" || eval.err == nil || !eval.ended || eval.attrs["goeval.script.hash"] == nil {
+		t.Errorf("eval span = %+v", eval)
+	}
+	if call.name != "goeval.call toString" || call.parent != "goeval.Eval" || call.err != nil || !call.ended {
+		t.Errorf("call span = %+v", call)
+	}
+	if failed.name != "goeval.call fail" || failed.err == nil {
+		t.Errorf("failed call span = %+v", failed)
+	}
+}
+
+func TestNoPanics(t *testing.T) {
+	s := NewScope()
+	s.Set("m", map[string]int(nil))
+	s.Set("boom", func() { panic("host failure") })
+	for _, src := range []string{
+		`}+func(){`,
+		`if 1 { }`,
+		`for "x" { }`,
+		`x := []int{1}; x[3] = 2`,
+		`m["a"] = 1`,
+		`1 / 0`,
+		`5 % (1 - 1)`,
+		`"abc"[2:1]`,
+		`[]int{1}[:5]`,
+		`n := 3; n[1:]`,
+		`for k.x = range []int{1} {}`,
+		`type T struct{ A int }; T{B: 1}`,
+		`boom()`,
+	} {
+		if _, err := s.Eval(src); err == nil {
+			t.Errorf("%s: expected an error", src)
+		}
+	}
+	if _, err := s.Assemble(`{}}+func(){`); err == nil {
+		t.Error("Assemble: expected an error")
+	}
+}
+
+// TestMutatedScripts feeds randomly damaged scripts to the interpreter, which
+// must answer with errors of its own rather than by panicking
+func TestMutatedScripts(t *testing.T) {
+	corpus := []string{
+		`a := []int{1, 2, 3}; b := a[1:2]; return len(b) + a[0]`,
+		`m := map[string]interface{}{"x": 1}; m["y"] = "z"; return m["x"]`,
+		`type P struct{ N string; A int }; p := &P{N: "x"}; p.A += 2; return p`,
+		`x := 3; if x > 1 { x = -x } else { x = ^x }; return x * 2 / 3 % 2`,
+		`f := func(n int) int { return n << 2 }; return f(3), "s" + "t", 1.5 * 2`,
+		`var a, b = 1, "x"; return toString(a) + b, !true, [2]int{1}`,
+	}
+	tokens := []string{"(", ")", "{", "}", "[", "]", ",", ";", ":", "+", "-", "*", "/", "%", "!", "^", "&", "<<", "=", ":=", "nil", "0", "-1", `""`, "x", "a", ".", "..."}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 3000; i++ {
+		src := []rune(corpus[rnd.Intn(len(corpus))])
+		for n := rnd.Intn(3) + 1; n > 0; n-- {
+			pos := rnd.Intn(len(src) + 1)
+			switch rnd.Intn(3) {
+			case 0:
+				if pos < len(src) {
+					src = append(src[:pos], src[pos+1:]...)
+				}
+			default:
+				tok := []rune(tokens[rnd.Intn(len(tokens))])
+				src = append(src[:pos], append(tok, src[pos:]...)...)
+			}
+		}
+		// Eval recovers, so look for the panics it turned into errors
+		if _, err := NewScope().Eval(string(src)); err != nil && strings.HasPrefix(err.Error(), "goeval: panic:") {
+			t.Errorf("%q panicked: %v", string(src), err)
+		}
+	}
 }
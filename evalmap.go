@@ -0,0 +1,86 @@
+package goeval
+
+import (
+	"fmt"
+	"go/ast"
+	"reflect"
+)
+
+// EvalMap evaluates src for results keyed by name, eg a score, a reason and
+// tags. Without names, the script ends with a map with string keys:
+//
+//	map[string]interface{}{"score": score, "reason": "new account"}
+//
+// With names, the script runs as if the function it is wrapped in had named
+// results: it assigns them as variables, or ends with a return of one value
+// per name.
+//
+//	out, err := s.EvalMap(`score = 10
+//	if age < 18 { reason = "minor" }`, "score", "reason")
+//
+// The script runs in a child scope of s, which holds the results.
+func (s *Scope) EvalMap(src string, names ...string) (map[string]interface{}, error) {
+	run := s.NewChild()
+	for _, name := range names {
+		run.Vars[name] = nil
+	}
+	var body *ast.BlockStmt
+	result, err := run.observed(src, func(run *Scope) (interface{}, error) {
+		var err error
+		if body, err = s.parseEval(src); err != nil {
+			return nil, err
+		}
+		return run.runScript(body)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return resultMap(result)
+	}
+	out := make(map[string]interface{}, len(names))
+	if values, ok := finalReturn(body, result, len(names)); ok {
+		for i, name := range names {
+			out[name] = values[i]
+		}
+		return out, nil
+	}
+	for _, name := range names {
+		out[name] = run.Vars[name]
+	}
+	return out, nil
+}
+
+// finalReturn returns the values of the return statement ending body, if it
+// returns n of them
+func finalReturn(body *ast.BlockStmt, result interface{}, n int) ([]interface{}, bool) {
+	if len(body.List) == 0 {
+		return nil, false
+	}
+	ret, ok := body.List[len(body.List)-1].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != n {
+		return nil, false
+	}
+	if n == 1 {
+		return []interface{}{result}, true
+	}
+	values, ok := result.([]interface{})
+	return values, ok
+}
+
+// resultMap converts the map with string keys a script ended with
+func resultMap(result interface{}) (map[string]interface{}, error) {
+	if m, ok := result.(map[string]interface{}); ok {
+		return m, nil
+	}
+	rv := reflect.ValueOf(result)
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("goeval: script evaluated to %T, not a map with string keys", result)
+	}
+	out := make(map[string]interface{}, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		out[iter.Key().String()] = iter.Value().Interface()
+	}
+	return out, nil
+}
@@ -0,0 +1,294 @@
+package goeval
+
+import (
+	"go/ast"
+	"go/token"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Evaluator evaluates one pre-parsed script over many records, as in ETL
+// filtering or enrichment. It reuses a single child scope between records,
+// so an Evaluator must not be used by several goroutines at once; compile one
+// per goroutine instead.
+//
+// The reads of record fields are resolved when compiling, to the slot of the
+// field in the record the scope holds, so that records are bound without
+// variables. Fields the script assigns are bound as variables.
+type Evaluator struct {
+	body     *ast.BlockStmt
+	scope    *Scope
+	fields   []string
+	assigned []bool // by field, bound as a variable
+	name     string // of the source, for error positions
+	src      string
+}
+
+// fieldSlot is the Data of the objects compile gives the identifiers reading
+// a record field: the index of the field in Evaluator.fields
+type fieldSlot int
+
+// fieldValue is the value of a record field, bound unless the record lacks it
+type fieldValue struct {
+	v     interface{}
+	bound bool
+}
+
+// Compile parses src once into an Evaluator whose records are bound in a child
+// scope of s
+func (s *Scope) Compile(src string) (*Evaluator, error) {
+	return s.compile("", src)
+}
+
+// CompileReader is Compile for a script read from r; name identifies the
+// source in the errors located in the script
+func (s *Scope) CompileReader(name string, r io.Reader) (*Evaluator, error) {
+	src, err := readSource(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.compile(name, src)
+}
+
+func (s *Scope) compile(name, src string) (*Evaluator, error) {
+	body, err := s.transformed(parse(src))
+	if err != nil {
+		return nil, err
+	}
+	e := &Evaluator{
+		body:   body,
+		scope:  s.NewChild(),
+		fields: s.freeIdents(body),
+		name:   name,
+		src:    src,
+	}
+	e.resolveFields()
+	return e, nil
+}
+
+// resolveFields gives the identifiers reading the fields the script does not
+// assign the slot of their field
+func (e *Evaluator) resolveFields() {
+	assigned := assignedIdents(e.body)
+	e.assigned = make([]bool, len(e.fields))
+	slots := make(map[string]*ast.Object, len(e.fields))
+	for i, name := range e.fields {
+		if e.assigned[i] = assigned[name]; !assigned[name] {
+			slots[name] = &ast.Object{Kind: ast.Var, Name: name, Data: fieldSlot(i)}
+		}
+	}
+	freeReads(e.body, definedIdents(e.body), func(ident *ast.Ident) {
+		if obj, ok := slots[ident.Name]; ok && ident.Obj == nil {
+			ident.Obj = obj
+		}
+	})
+	e.scope.record = make([]fieldValue, len(e.fields))
+}
+
+// Fields lists the identifiers the script reads without defining them, sorted.
+// Hosts can use it to decode only the record fields the script needs.
+func (e *Evaluator) Fields() []string {
+	return e.fields
+}
+
+// Process evaluates the script with the fields of record it reads bound, see
+// Fields. Variables defined by the previous record are discarded.
+func (e *Evaluator) Process(record map[string]interface{}) (interface{}, error) {
+	s := e.scope
+	if s.depth == nil {
+		s.depth = new(int32) // spares run copying the scope for every record
+	}
+	if s.Options.Pure {
+		s.pureMade = new(sync.Map)
+	}
+	vars := s.Vars
+	for k := range vars {
+		delete(vars, k)
+	}
+	hooked := s.getHooked() // reads through slots would bypass the hooks
+	for i, field := range e.fields {
+		v, ok := record[field]
+		if ok && (hooked || e.assigned[i]) {
+			vars[field] = v
+			ok = false
+		}
+		s.record[i] = fieldValue{v: v, bound: ok}
+	}
+	result, err := s.runScript(e.body)
+	if err != nil {
+		err = nameSource(e.name, locate(e.src, err, s.Options.VerboseErrors))
+	}
+	return result, err
+}
+
+// field returns the value of the record field of slot, if the record has it
+func (s *Scope) field(slot fieldSlot) (interface{}, bool) {
+	if int(slot) >= len(s.record) {
+		return nil, false
+	}
+	f := s.record[slot]
+	return f.v, f.bound
+}
+
+// freeIdents lists, sorted, the identifiers body reads that it does not define
+// and that are not builtins of s, those a script expects from its scope
+func (s *Scope) freeIdents(body ast.Node) []string {
+	free := s.freeIdentPos(body)
+	fields := make([]string, 0, len(free))
+	for name := range free {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// freeIdentPos is freeIdents with the position of the first read of each
+func (s *Scope) freeIdentPos(body ast.Node) map[string]token.Pos {
+	seen := map[string]token.Pos{}
+	freeReads(body, definedIdents(body), func(ident *ast.Ident) {
+		if _, dup := seen[ident.Name]; !dup {
+			seen[ident.Name] = ident.Pos()
+		}
+	})
+	for name := range seen {
+		_, isType := s.builtinType(name)
+		_, isBuiltin := s.builtin(name)
+		if isType || isBuiltin || s.literalTag(name) != nil {
+			delete(seen, name)
+		}
+	}
+	return seen
+}
+
+// freeReads calls fn with the identifiers of body naming variables, other
+// than those of defined
+func freeReads(body ast.Node, defined map[string]bool, fn func(*ast.Ident)) {
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.SelectorExpr:
+			// the selected name is a field or method, not a variable
+			ast.Inspect(node.X, visit)
+			return false
+		case *ast.KeyValueExpr:
+			// a bare key is taken as a struct field name
+			if _, ok := node.Key.(*ast.Ident); ok {
+				ast.Inspect(node.Value, visit)
+				return false
+			}
+		case *ast.LabeledStmt:
+			ast.Inspect(node.Stmt, visit)
+			return false
+		case *ast.Field:
+			// the names are parameters or struct fields
+			ast.Inspect(node.Type, visit)
+			return false
+		case *ast.BranchStmt, *ast.ImportSpec:
+			return false
+		case *ast.Ident:
+			if !defined[node.Name] {
+				fn(node)
+			}
+		}
+		return true
+	}
+	ast.Inspect(body, visit)
+}
+
+// assignedIdents collects the variables body assigns or updates in place,
+// through a field or an index, with the names it defines
+func assignedIdents(body ast.Node) map[string]bool {
+	assigned := map[string]bool{}
+	add := func(target ast.Expr) {
+		for {
+			switch e := target.(type) {
+			case *ast.Ident:
+				assigned[e.Name] = true
+				return
+			case *ast.SelectorExpr:
+				target = e.X
+			case *ast.IndexExpr:
+				target = e.X
+			case *ast.ParenExpr:
+				target = e.X
+			case *ast.StarExpr:
+				target = e.X
+			default:
+				return
+			}
+		}
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.AssignStmt:
+			for _, lh := range node.Lhs {
+				add(lh)
+			}
+		case *ast.IncDecStmt:
+			add(node.X)
+		case *ast.RangeStmt:
+			if node.Key != nil {
+				add(node.Key)
+			}
+			if node.Value != nil {
+				add(node.Value)
+			}
+		}
+		return true
+	})
+	return assigned
+}
+
+// definedIdents collects the names body declares with :=, var, const, type,
+// import, or as function parameters
+func definedIdents(body ast.Node) map[string]bool {
+	defined := map[string]bool{}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.AssignStmt:
+			if node.Tok == token.DEFINE {
+				for _, lh := range node.Lhs {
+					if ident, ok := lh.(*ast.Ident); ok {
+						defined[ident.Name] = true
+					}
+				}
+			}
+		case *ast.RangeStmt:
+			if node.Tok == token.DEFINE {
+				for _, e := range []ast.Expr{node.Key, node.Value} {
+					if ident, ok := e.(*ast.Ident); ok {
+						defined[ident.Name] = true
+					}
+				}
+			}
+		case *ast.ValueSpec:
+			for _, name := range node.Names {
+				defined[name.Name] = true
+			}
+		case *ast.TypeSpec:
+			defined[node.Name.Name] = true
+		case *ast.FuncType:
+			for _, fields := range []*ast.FieldList{node.Params, node.Results} {
+				if fields == nil {
+					continue
+				}
+				for _, field := range fields.List {
+					for _, name := range field.Names {
+						defined[name.Name] = true
+					}
+				}
+			}
+		case *ast.ImportSpec:
+			if node.Name != nil {
+				defined[node.Name.Name] = true
+			} else if importPath, err := strconv.Unquote(node.Path.Value); err == nil {
+				defined[path.Base(importPath)] = true
+			}
+		}
+		return true
+	})
+	return defined
+}
@@ -0,0 +1,98 @@
+package goeval
+
+import (
+	"fmt"
+	"go/ast"
+	"path"
+	"reflect"
+	"sync"
+)
+
+// EventBus runs script handlers for named events. Each handler is evaluated in
+// its own child scope of the bus scope, with the payload fields bound as
+// variables and the event name bound as "event".
+type EventBus struct {
+	scope *Scope
+
+	mu       sync.RWMutex
+	handlers []eventHandler
+	schemas  map[string]map[string]reflect.Type
+}
+
+type eventHandler struct {
+	pattern string
+	body    *ast.BlockStmt
+}
+
+// HandlerResult is the outcome of one handler run by Emit
+type HandlerResult struct {
+	Pattern string // the pattern the handler was registered with
+	Value   interface{}
+	Err     error
+}
+
+// NewEventBus creates an event bus whose handlers see the variables of s
+func NewEventBus(s *Scope) *EventBus {
+	return &EventBus{
+		scope:   s,
+		schemas: map[string]map[string]reflect.Type{},
+	}
+}
+
+// Define declares the payload schema of an event: every field must be present
+// in the payload of Emit, with a value assignable to its type.
+func (b *EventBus) Define(event string, schema map[string]reflect.Type) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.schemas[event] = schema
+}
+
+// On registers a script handler for the events matching pattern, which is
+// either an event name or a path.Match pattern such as "order.*"
+func (b *EventBus) On(pattern, src string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+	body, err := b.scope.transformed(parse(src))
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, eventHandler{pattern: pattern, body: body})
+	return nil
+}
+
+// Emit validates the payload against the event schema, then runs every handler
+// matching the event in registration order and collects their results.
+// Handler failures are reported in the results and do not stop other handlers.
+func (b *EventBus) Emit(event string, payload map[string]interface{}) ([]HandlerResult, error) {
+	b.mu.RLock()
+	schema := b.schemas[event]
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	for field, typ := range schema {
+		v, ok := payload[field]
+		if !ok {
+			return nil, fmt.Errorf("goeval: event %s payload misses field %s", event, field)
+		}
+		if _, err := valueAs(v, typ); err != nil {
+			return nil, fmt.Errorf("goeval: event %s field %s: %v", event, field, err)
+		}
+	}
+	var results []HandlerResult
+	for _, h := range handlers {
+		if matched, _ := path.Match(h.pattern, event); !matched {
+			continue
+		}
+		child := b.scope.NewChild()
+		child.Vars["event"] = event
+		for k, v := range payload {
+			child.Vars[k] = v
+		}
+		v, err := child.run(h.body)
+		results = append(results, HandlerResult{Pattern: h.pattern, Value: v, Err: err})
+	}
+	return results, nil
+}
@@ -0,0 +1,118 @@
+package goeval
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultExecTimeout bounds the commands of the exec builtin when
+// ExecPolicy.Timeout is not set
+const DefaultExecTimeout = 30 * time.Second
+
+// DefaultMaxOutputSize caps the output kept by the exec builtin when
+// ExecPolicy.MaxOutputSize is not set
+const DefaultMaxOutputSize = 1 << 20
+
+// ExecPolicy enables the exec builtin, for ops automation scripts that need to
+// run commands, and restricts what it may run:
+//
+//	res := exec("systemctl", "is-active", service)
+//	if res.ExitCode != 0 { ... res.Stderr ... }
+//
+// Commands run without a shell, so arguments reach them as they are.
+type ExecPolicy struct {
+	// Allow maps the names scripts may run to the paths of their binaries, eg
+	// "git" to "/usr/bin/git"; scripts cannot run paths of their own
+	Allow map[string]string
+	// Args, when set for a name, vets the arguments of its runs; an error
+	// rejects the run
+	Args map[string]func(args []string) error
+	// Timeout bounds each run, after which the command is killed
+	Timeout time.Duration
+	// MaxOutputSize caps the bytes of stdout and of stderr, more fail the run
+	MaxOutputSize int
+	// Dir is the working directory of the commands
+	Dir string
+	// Env is the environment of the commands, empty when nil rather than the
+	// environment of the host
+	Env []string
+}
+
+// ExecResult is the result of the exec builtin. Commands exiting with a
+// status other than 0 are returned too, only failures to run are errors.
+type ExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// cappedBuffer keeps up to max bytes, discarding the rest so that the command
+// does not block on a full pipe
+type cappedBuffer struct {
+	buf      bytes.Buffer // not embedded, io.Copy would use its ReadFrom
+	max      int
+	overflow bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.buf.Len()+len(p) > b.max {
+		b.overflow = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (p *ExecPolicy) run(ctx context.Context, name string, args ...string) (*ExecResult, error) {
+	path, ok := p.Allow[name]
+	if !ok {
+		return nil, &PolicyError{Name: "running " + name}
+	}
+	for _, arg := range args {
+		if strings.ContainsRune(arg, 0) {
+			return nil, fmt.Errorf("goeval: exec %s: argument with a NUL byte", name)
+		}
+	}
+	if vet := p.Args[name]; vet != nil {
+		if err := vet(args); err != nil {
+			return nil, &PolicyError{Name: fmt.Sprintf("running %s with %q (%v)", name, args, err)}
+		}
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultExecTimeout
+	}
+	max := p.MaxOutputSize
+	if max <= 0 {
+		max = DefaultMaxOutputSize
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Dir = p.Dir
+	cmd.Env = p.Env
+	if cmd.Env == nil {
+		cmd.Env = []string{}
+	}
+	stdout, stderr := &cappedBuffer{max: max}, &cappedBuffer{max: max}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("goeval: exec %s: %v", name, ctx.Err())
+	}
+	if stdout.overflow || stderr.overflow {
+		return nil, fmt.Errorf("goeval: exec %s: output larger than %d bytes", name, max)
+	}
+	res := &ExecResult{Stdout: stdout.buf.String(), Stderr: stderr.buf.String()}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ProcessState.Exited() {
+		res.ExitCode = exitErr.ExitCode()
+		return res, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("goeval: exec %s: %v", name, err)
+	}
+	return res, nil
+}
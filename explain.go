@@ -0,0 +1,180 @@
+package goeval
+
+import (
+	"errors"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"sync"
+)
+
+// Contribution tells how an explained expression bore on the value of its
+// parent, or on the result of the script for the top level expressions
+type Contribution string
+
+const (
+	// ContributionResult marks the expression whose value the script returned
+	ContributionResult Contribution = "result"
+	// ContributionCondition marks the condition or tag that chose the branch taken
+	ContributionCondition Contribution = "condition"
+	// ContributionEffect marks a top level expression evaluated for an
+	// assignment or a side effect, which the result may still depend on
+	ContributionEffect Contribution = "effect"
+	// ContributionOperand marks an operand or argument combined into its parent's value
+	ContributionOperand Contribution = "operand"
+	// ContributionDecisive marks an operand of && or || which alone decided
+	// the value, a false one of && or a true one of ||
+	ContributionDecisive Contribution = "decisive"
+	// ContributionNone marks an operand of && or || which did not decide the value
+	ContributionNone Contribution = "none"
+)
+
+// Explanation is a node of the evaluation tree Explain returns: an expression
+// of the script, what it evaluated to and how it contributed to its parent.
+// The root stands for the whole script and holds the top level expressions
+// in the order they were evaluated, those of loops once per iteration.
+type Explanation struct {
+	Source       string // the text of the expression
+	Line, Column int    // where it starts in the script, 0 for the root
+	Value        interface{}
+	Err          error // set when evaluating the expression failed
+	Contribution Contribution
+	Children     []*Explanation
+}
+
+// explainer builds the evaluation tree of an Explain
+type explainer struct {
+	mu    sync.Mutex
+	src   string
+	root  Explanation
+	stack []explainFrame
+}
+
+// explainFrame is a statement or explained expression being interpreted
+type explainFrame struct {
+	node ast.Node
+	exp  *Explanation // nil for statements
+}
+
+// errExplainGo fails go statements in Explain, whose tree follows one goroutine
+var errExplainGo = errors.New("goeval: go statements cannot be explained")
+
+// Explain evaluates src like Eval and returns the evaluation tree of its
+// expressions with their values, to show why a rule decided what it
+// decided. Types, functions called and literals within expressions are
+// left out. When the evaluation fails, the
+// tree stops at the failing expression and the error is returned along.
+func (s *Scope) Explain(src string) (*Explanation, error) {
+	run := *s // shares Vars, only carries the explainer
+	run.tasks = s.taskGroup()
+	run.explain = &explainer{src: src, root: Explanation{Source: src, Contribution: ContributionResult}}
+	result, err := run.Eval(src)
+	e := run.explain
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.root.Value, e.root.Err = result, err
+	if n := len(e.root.Children); n > 0 && err == nil {
+		if last := e.root.Children[n-1]; last.Contribution == ContributionEffect && reflect.DeepEqual(last.Value, result) {
+			last.Contribution = ContributionResult // the final expression statement
+		}
+	}
+	return &e.root, err
+}
+
+// explained reports whether an Explain records node
+func explained(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.ParenExpr, *ast.FuncLit, *ast.ArrayType, *ast.MapType,
+		*ast.ChanType, *ast.FuncType, *ast.StructType, *ast.InterfaceType, *ast.Ellipsis:
+		return false
+	}
+	_, isExpr := node.(ast.Expr)
+	_, isStmt := node.(ast.Stmt)
+	return isExpr || isStmt
+}
+
+// enter pushes node on the stack, opening an Explanation for an expression
+func (e *explainer) enter(node ast.Node) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	frame := explainFrame{node: node}
+	if expr, ok := node.(ast.Expr); ok {
+		line, column := position(e.src, expr.Pos())
+		frame.exp = &Explanation{Source: e.text(expr), Line: line, Column: column}
+	}
+	e.stack = append(e.stack, frame)
+}
+
+// leave pops node, attaching its Explanation to the enclosing expression or
+// to the root
+func (e *explainer) leave(value interface{}, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	frame := e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+	exp := frame.exp
+	if exp == nil {
+		return
+	}
+	if _, isType := value.(reflect.Type); isType {
+		return
+	}
+	exp.Value, exp.Err = value, err
+	if bin, ok := frame.node.(*ast.BinaryExpr); ok && (bin.Op == token.LAND || bin.Op == token.LOR) {
+		decisive := bin.Op == token.LOR // the value deciding a || is true, a && false
+		for _, child := range exp.Children {
+			child.Contribution = ContributionNone
+			if b, ok := child.Value.(bool); ok && b == decisive && value == decisive {
+				child.Contribution = ContributionDecisive
+			} else if value != decisive {
+				child.Contribution = ContributionOperand // every operand was needed
+			}
+		}
+	}
+	for i := len(e.stack) - 1; i >= 0; i-- {
+		if parent := e.stack[i].exp; parent != nil {
+			if _, isLit := frame.node.(*ast.BasicLit); isLit {
+				return // speaks for itself in the source of its parent
+			}
+			node := e.stack[i].node
+			if assigned, ok := node.(*assignedCall); ok {
+				node = assigned.CallExpr
+			}
+			if call, ok := node.(*ast.CallExpr); ok && call.Fun == frame.node {
+				return // the function called, not a value
+			}
+			if exp.Contribution == "" {
+				exp.Contribution = ContributionOperand
+			}
+			parent.Children = append(parent.Children, exp)
+			return
+		}
+	}
+	exp.Contribution = ContributionEffect
+	if len(e.stack) > 0 {
+		exp.Contribution = topContribution(e.stack[len(e.stack)-1].node)
+	}
+	e.root.Children = append(e.root.Children, exp)
+}
+
+// topContribution tells how an expression evaluated directly by stmt bears
+// on the result of the script; init and post statements have frames of their own
+func topContribution(stmt ast.Node) Contribution {
+	switch stmt.(type) {
+	case *ast.ReturnStmt:
+		return ContributionResult
+	case *ast.IfStmt, *ast.ForStmt, *ast.SwitchStmt, *ast.CaseClause:
+		return ContributionCondition
+	}
+	return ContributionEffect
+}
+
+// text returns the source of expr in the script explained
+func (e *explainer) text(expr ast.Expr) string {
+	start := int(expr.Pos()) - 1 - len(scriptPrefix)
+	end := int(expr.End()) - 1 - len(scriptPrefix)
+	if start < 0 || end > len(e.src) || start > end {
+		return ""
+	}
+	return e.src[start:end]
+}
@@ -0,0 +1,83 @@
+package goeval
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// formatPrefix wraps the statements of a script into a file go/format accepts
+const formatPrefix = "package script\n\nfunc _() {\n"
+
+// Format pretty-prints a script like gofmt, keeping its comments and its
+// statement-list form. Import declarations are formatted too, use declarations
+// are kept as they are.
+//
+//	formatted, err := goeval.Format("x:=1\nif x>0{x++}\nx")
+func Format(src string) (string, error) {
+	if _, err := parse(src); err != nil {
+		d := syntaxDiagnostics(err)[0]
+		return "", fmt.Errorf("goeval: %d:%d: %s", d.Line, d.Column, d.Message)
+	}
+	end, uses := scanImports(src)
+	head, body := strings.TrimSpace(src[:end]), src[end:]
+	if head != "" && len(uses) == 0 {
+		out, err := format.Source([]byte("package script\n\n" + head))
+		if err != nil {
+			return "", err
+		}
+		head = strings.TrimSpace(strings.TrimPrefix(string(out), "package script\n"))
+	}
+	out, err := format.Source([]byte(formatPrefix + body + "\n}\n"))
+	if err != nil {
+		return "", err
+	}
+	formatted, err := unwrapFormatted(string(out))
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case head == "":
+		return formatted, nil
+	case formatted == "":
+		return head + "\n", nil
+	}
+	return head + "\n\n" + formatted, nil
+}
+
+// unwrapFormatted takes the statements back out of the formatted wrapper,
+// unindenting them except for the lines inside raw strings
+func unwrapFormatted(out string) (string, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", out, 0)
+	if err != nil {
+		return "", err
+	}
+	verbatim := map[int]bool{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.BasicLit); ok && lit.Kind == token.STRING && strings.HasPrefix(lit.Value, "`") {
+			start, end := fset.Position(lit.Pos()).Line, fset.Position(lit.End()).Line
+			for line := start + 1; line <= end; line++ {
+				verbatim[line] = true
+			}
+		}
+		return true
+	})
+	lines := strings.Split(out, "\n")
+	// lines holds the prefix lines, the body, "}" and a final empty string
+	first, last := strings.Count(formatPrefix, "\n"), len(lines)-2
+	body := lines[first:last]
+	for i, line := range body {
+		if !verbatim[first+i+1] {
+			body[i] = strings.TrimPrefix(line, "\t")
+		}
+	}
+	formatted := strings.TrimSpace(strings.Join(body, "\n"))
+	if formatted == "" {
+		return "", nil
+	}
+	return formatted + "\n", nil
+}
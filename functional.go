@@ -0,0 +1,195 @@
+package goeval
+
+import (
+	"fmt"
+	"go/token"
+	"reflect"
+	"sort"
+)
+
+// Filter returns the elements of slice for which keep returns true, in a slice
+// of the same element type. keep takes an element and returns a bool, optionally
+// followed by an error, which aborts the filtering.
+//
+//	adults := filter(people, func(p Person) bool { return p.Age >= 18 })
+func Filter(slice, keep interface{}) (interface{}, error) {
+	sv, fn, err := functionalArgs("filter", slice, keep, 1)
+	if err != nil || !sv.IsValid() {
+		return nil, err
+	}
+	out := reflect.MakeSlice(sv.Type(), 0, sv.Len())
+	for i := 0; i < sv.Len(); i++ {
+		elem := sv.Index(i)
+		res, err := apply(fn, elem.Interface())
+		if err != nil {
+			return nil, err
+		}
+		ok, isBool := res.(bool)
+		if !isBool {
+			return nil, fmt.Errorf("goeval: filter function returned %T, not bool", res)
+		}
+		if ok {
+			out = reflect.Append(out, elem)
+		}
+	}
+	return out.Interface(), nil
+}
+
+// Map returns the results of f applied to every element of slice, in a slice of
+// the result type of f. f returns a single value, optionally followed by an
+// error, which aborts the mapping.
+//
+//	names := mapSlice(people, func(p Person) string { return p.Name })
+func Map(slice, f interface{}) (interface{}, error) {
+	sv, fn, err := functionalArgs("mapSlice", slice, f, 1)
+	if err != nil || !sv.IsValid() {
+		return nil, err
+	}
+	ft := fn.Type()
+	if ft.NumOut() == 0 || ft.Out(0) == errorType {
+		return nil, fmt.Errorf("goeval: mapSlice function %v returns no value", ft)
+	}
+	if ft.NumOut() > 2 || ft.NumOut() == 2 && ft.Out(1) != errorType {
+		return nil, fmt.Errorf("goeval: mapSlice function %v returns several values", ft)
+	}
+	elemType := ft.Out(0)
+	out := reflect.MakeSlice(reflect.SliceOf(elemType), sv.Len(), sv.Len())
+	for i := 0; i < sv.Len(); i++ {
+		res, err := apply(fn, sv.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		if res != nil {
+			v, err := argValue(res, elemType)
+			if err != nil {
+				return nil, fmt.Errorf("goeval: mapSlice function returned %T, not %v", res, elemType)
+			}
+			out.Index(i).Set(v)
+		}
+	}
+	return out.Interface(), nil
+}
+
+// Reduce folds slice into a single value: starting from initial, f is called
+// with the accumulated value and each element in turn, returning the next one
+//
+//	total := reduce(prices, func(sum, p float64) float64 { return sum + p }, 0.0)
+func Reduce(slice, f, initial interface{}) (interface{}, error) {
+	sv, fn, err := functionalArgs("reduce", slice, f, 2)
+	if err != nil || !sv.IsValid() {
+		return initial, err
+	}
+	acc := initial
+	for i := 0; i < sv.Len(); i++ {
+		if acc, err = apply(fn, acc, sv.Index(i).Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return acc, nil
+}
+
+// Sort returns a sorted copy of slice, whose elements must be numbers or strings
+//
+//	sorted := sort([]int{3, 1, 2})
+func Sort(slice interface{}) (interface{}, error) {
+	return sortSlice("sort", slice, func(a, b interface{}) (bool, error) {
+		less, err := binaryOp(a, b, token.LSS)
+		if err != nil {
+			return false, err
+		}
+		ok, isBool := less.(bool)
+		if !isBool {
+			return false, fmt.Errorf("goeval: cannot order %T", a)
+		}
+		return ok, nil
+	})
+}
+
+// SortBy returns a copy of slice sorted with less, which reports whether its
+// first argument goes before its second. The sort is stable.
+//
+//	byAge := sortBy(people, func(a, b Person) bool { return a.Age < b.Age })
+func SortBy(slice, less interface{}) (interface{}, error) {
+	fn := reflect.ValueOf(less)
+	if fn.Kind() != reflect.Func || fn.Type().NumIn() != 2 || fn.Type().IsVariadic() {
+		return nil, fmt.Errorf("goeval: sortBy needs a function of 2 arguments, not %T", less)
+	}
+	return sortSlice("sortBy", slice, func(a, b interface{}) (bool, error) {
+		res, err := apply(fn, a, b)
+		if err != nil {
+			return false, err
+		}
+		ok, isBool := res.(bool)
+		if !isBool {
+			return false, fmt.Errorf("goeval: sortBy function returned %T, not bool", res)
+		}
+		return ok, nil
+	})
+}
+
+// sortSlice sorts a copy of slice, stopping at the first error of less
+func sortSlice(name string, slice interface{}, less func(a, b interface{}) (bool, error)) (interface{}, error) {
+	if slice == nil {
+		return nil, nil
+	}
+	sv := reflect.ValueOf(slice)
+	if sv.Kind() != reflect.Slice && sv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("goeval: %s of %T, not a slice", name, slice)
+	}
+	sorted := reflect.MakeSlice(reflect.SliceOf(sv.Type().Elem()), sv.Len(), sv.Len())
+	reflect.Copy(sorted, sv)
+	var err error
+	sort.SliceStable(sorted.Interface(), func(i, j int) bool {
+		if err != nil {
+			return false
+		}
+		var ok bool
+		ok, err = less(sorted.Index(i).Interface(), sorted.Index(j).Interface())
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sorted.Interface(), nil
+}
+
+// functionalArgs checks the arguments of the higher-order builtins: a slice or
+// array, which is invalid when nil, and a function taking params arguments
+func functionalArgs(name string, slice, f interface{}, params int) (reflect.Value, reflect.Value, error) {
+	fn := reflect.ValueOf(f)
+	if fn.Kind() != reflect.Func || fn.Type().NumIn() != params || fn.Type().IsVariadic() {
+		return reflect.Value{}, fn, fmt.Errorf("goeval: %s needs a function of %d arguments, not %T", name, params, f)
+	}
+	if slice == nil {
+		return reflect.Value{}, fn, nil
+	}
+	sv := reflect.ValueOf(slice)
+	if sv.Kind() != reflect.Slice && sv.Kind() != reflect.Array {
+		return reflect.Value{}, fn, fmt.Errorf("goeval: %s over %T, not a slice", name, slice)
+	}
+	if sv.Kind() == reflect.Array {
+		arr := reflect.New(sv.Type()).Elem() // addressable, so it can be sliced
+		arr.Set(sv)
+		sv = arr.Slice(0, arr.Len())
+	}
+	return sv, fn, nil
+}
+
+// apply calls fn with args converted to its parameter types, returning its
+// result like a script call does
+func apply(fn reflect.Value, args ...interface{}) (interface{}, error) {
+	ft := fn.Type()
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		v, err := argValue(arg, ft.In(i))
+		if err != nil {
+			return nil, fmt.Errorf("goeval: calling %v: argument %d: %v", ft, i+1, err)
+		}
+		in[i] = v
+	}
+	out, err := callFunc(fn, in)
+	if err != nil {
+		return nil, err
+	}
+	return callResults(ft, interfaced(out))
+}
@@ -0,0 +1,139 @@
+package goeval
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"reflect"
+	"sync"
+)
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// taskGroup tracks the goroutines spawned by go statements in a scope tree
+type taskGroup struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	cancels map[int]context.CancelFunc
+	next    int
+	err     error
+}
+
+var tasksMu sync.Mutex
+
+// taskGroup returns the goroutine tracker of the scope tree
+func (s *Scope) taskGroup() *taskGroup {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+	root := s
+	for root.tasks == nil && root.Parent != nil {
+		root = root.Parent
+	}
+	if root.tasks == nil {
+		root.tasks = &taskGroup{cancels: map[int]context.CancelFunc{}}
+	}
+	return root.tasks
+}
+
+// heldTasks returns the goroutine tracker of s itself, nil until taskGroup
+// creates it, for the scopes sharing it
+func (s *Scope) heldTasks() *taskGroup {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+	return s.tasks
+}
+
+// spawn runs a go statement. The function and its arguments are evaluated
+// right away; the call itself runs in a new goroutine, with a context derived
+// from the evaluation context when the function takes one first.
+func (s *Scope) spawn(call *ast.CallExpr) error {
+	if s.disabled("go") {
+		return &PolicyError{Name: "go"}
+	}
+	if s.explain != nil {
+		return errExplainGo
+	}
+	fun, err := s.interpret(call.Fun)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(s.context())
+	rf, args, err := s.callArgs(call, fun, ctx, nil)
+	if err != nil {
+		cancel()
+		return err
+	}
+	tg := s.taskGroup()
+	tg.mu.Lock()
+	if max := s.Options.MaxGoroutines; max > 0 && len(tg.cancels) >= max {
+		tg.mu.Unlock()
+		cancel()
+		return fmt.Errorf("goeval: goroutine limit of %d reached", max)
+	}
+	id := tg.next
+	tg.next++
+	tg.cancels[id] = cancel
+	tg.wg.Add(1)
+	tg.mu.Unlock()
+
+	go func() {
+		defer tg.done(id)
+		defer func() {
+			if r := recover(); r != nil {
+				tg.fail(fmt.Errorf("goeval: goroutine panicked: %v", r))
+			}
+		}()
+		if _, err := callResults(rf.Type(), interfaced(rf.Call(args))); err != nil {
+			tg.fail(err)
+		}
+	}()
+	return nil
+}
+
+func (tg *taskGroup) done(id int) {
+	tg.mu.Lock()
+	tg.cancels[id]()
+	delete(tg.cancels, id)
+	tg.mu.Unlock()
+	tg.wg.Done()
+}
+
+func (tg *taskGroup) fail(err error) {
+	tg.mu.Lock()
+	if tg.err == nil {
+		tg.err = err
+	}
+	tg.mu.Unlock()
+}
+
+// Running returns the number of script goroutines still running in the scope tree
+func (s *Scope) Running() int {
+	tg := s.taskGroup()
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	return len(tg.cancels)
+}
+
+// Wait blocks until every goroutine started by scripts in the scope tree has
+// returned, then reports the first error one of them returned or panicked with
+func (s *Scope) Wait() error {
+	tg := s.taskGroup()
+	tg.wg.Wait()
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	err := tg.err
+	tg.err = nil
+	return err
+}
+
+// Kill cancels the contexts of the running script goroutines. Go cannot stop
+// a goroutine, so only functions watching their context.Context stop early;
+// use Wait to know when they are all gone.
+func (s *Scope) Kill() {
+	tg := s.taskGroup()
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	for _, cancel := range tg.cancels {
+		cancel()
+	}
+}
@@ -0,0 +1,91 @@
+package goeval
+
+// SetHook is called before a script assigns a variable, with its current and
+// new values; old is nil for a new variable. A non-nil error vetoes the
+// assignment and aborts the evaluation.
+type SetHook func(name string, old, new interface{}) error
+
+// GetHook is called when a script reads a variable
+type GetHook func(name string, val interface{})
+
+// OnSet registers a hook for the assignments scripts make to the variable name,
+// or to any variable when name is empty, while evaluating in s or its children
+func (s *Scope) OnSet(name string, hook SetHook) {
+	if s.setHooks == nil {
+		s.setHooks = map[string][]SetHook{}
+	}
+	s.setHooks[name] = append(s.setHooks[name], hook)
+}
+
+// OnGet registers a hook for the reads scripts make of the variable name, or of
+// any variable when name is empty, while evaluating in s or its children
+func (s *Scope) OnGet(name string, hook GetHook) {
+	if s.getHooks == nil {
+		s.getHooks = map[string][]GetHook{}
+	}
+	s.getHooks[name] = append(s.getHooks[name], hook)
+}
+
+// setVar assigns a variable for a script: with define set, in s itself,
+// otherwise in the scope holding it. Read-only variables and set hooks are honoured,
+// and the value is written through to the store backing that scope.
+func (s *Scope) setVar(name string, val interface{}, define bool) error {
+	if err := s.checkWritable(name, define); err != nil {
+		return err
+	}
+	owner := s
+	if !define {
+		for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+			if _, exists := currentScope.Vars[name]; exists || currentScope.forked {
+				owner = currentScope // copied into a fork rather than assigned in its parents
+				break
+			}
+		}
+	}
+	old := owner.Vars[name]
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		for _, hooks := range [][]SetHook{currentScope.setHooks[name], currentScope.setHooks[""]} {
+			for _, hook := range hooks {
+				if err := hook(name, old, val); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if owner.store != nil {
+		if err := owner.store.Set(name, val); err != nil {
+			return err
+		}
+	}
+	owner.Vars[name] = val
+	owner.renew(name)
+	s.notifyWatches(name)
+	return nil
+}
+
+// getVar reads a variable for a script, calling the get hooks
+func (s *Scope) getVar(name string) (interface{}, bool) {
+	val, exists := s.lookup(name)
+	if !exists {
+		return nil, false
+	}
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		for _, hook := range currentScope.getHooks[name] {
+			hook(name, val)
+		}
+		for _, hook := range currentScope.getHooks[""] {
+			hook(name, val)
+		}
+	}
+	return val, true
+}
+
+// getHooked tells whether reads in s run get hooks
+func (s *Scope) getHooked() bool {
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		if len(currentScope.getHooks) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package goeval
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultHTTPTimeout bounds the requests of the http builtins when
+// HTTPPolicy.Timeout is not set
+const DefaultHTTPTimeout = 10 * time.Second
+
+// DefaultMaxResponseSize caps the bodies read by the http builtins when
+// HTTPPolicy.MaxResponseSize is not set
+const DefaultMaxResponseSize = 1 << 20
+
+// HTTPPolicy enables the httpGet and httpPost builtins and restricts what they
+// may do:
+//
+//	resp := httpGet("https://api.example.com/v1/users/42", map[string]string{"Accept": "application/json"})
+//	resp = httpPost("https://api.example.com/v1/events", "application/json", body)
+//	if resp.Status == 200 { ... resp.Body ... }
+type HTTPPolicy struct {
+	// Allow lists the URLs scripts may request, as prefixes: a URL is allowed
+	// when its scheme and host equal those of an entry and its path is the
+	// entry's path or below it, segment by segment. Paths with . or ..
+	// segments, encoded or not, are refused. Redirects are checked too.
+	Allow []string
+	// Timeout bounds each request, including reading the body
+	Timeout time.Duration
+	// MaxResponseSize caps the bytes of a response body, larger ones fail
+	MaxResponseSize int64
+	// Headers are set on every request, overriding those given by scripts
+	Headers map[string]string
+	// AllowHeaders names the headers scripts may set, none when empty
+	AllowHeaders []string
+	// Client sends the requests, http.DefaultClient when nil
+	Client *http.Client
+}
+
+// HTTPResponse is the result of the http builtins. Statuses other than 2xx are
+// returned too, only transport failures are errors.
+type HTTPResponse struct {
+	Status int
+	Header map[string]string // the first value of each header
+	Body   string
+}
+
+func (p *HTTPPolicy) allowed(u *url.URL) bool {
+	if dotSegments(u.Path) {
+		return false // servers resolve them, maybe out of the allowed path
+	}
+	for _, entry := range p.Allow {
+		a, err := url.Parse(entry)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(a.Scheme, u.Scheme) && strings.EqualFold(a.Host, u.Host) && pathWithin(u.Path, a.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// dotSegments tells whether the decoded path has . or .. segments
+func dotSegments(path string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "." || segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// pathWithin tells whether path is prefix or below it, matching whole segments
+func pathWithin(path, prefix string) bool {
+	if prefix == "" || prefix == "/" || path == prefix {
+		return true
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return strings.HasPrefix(path, prefix)
+}
+
+func (p *HTTPPolicy) get(ctx context.Context, rawURL string, headers ...map[string]string) (*HTTPResponse, error) {
+	return p.do(ctx, http.MethodGet, rawURL, "", nil, headers)
+}
+
+func (p *HTTPPolicy) post(ctx context.Context, rawURL, contentType, body string, headers ...map[string]string) (*HTTPResponse, error) {
+	return p.do(ctx, http.MethodPost, rawURL, contentType, strings.NewReader(body), headers)
+}
+
+func (p *HTTPPolicy) do(ctx context.Context, method, rawURL, contentType string, body io.Reader, headers []map[string]string) (*HTTPResponse, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("goeval: %s %s: %v", method, rawURL, err)
+	}
+	if !p.allowed(u) {
+		return nil, &PolicyError{Name: "request to " + rawURL}
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHTTPTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	allowedHeaders := map[string]bool{}
+	for _, h := range p.AllowHeaders {
+		allowedHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, hs := range headers {
+		for k, v := range hs {
+			if !allowedHeaders[http.CanonicalHeaderKey(k)] {
+				return nil, &PolicyError{Name: "header " + k}
+			}
+			req.Header.Set(k, v)
+		}
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range p.Headers {
+		req.Header.Set(k, v)
+	}
+	client := http.DefaultClient
+	if p.Client != nil {
+		client = p.Client
+	}
+	limited := *client
+	limited.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !p.allowed(req.URL) {
+			return &PolicyError{Name: "redirect to " + req.URL.String()}
+		}
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(req, via)
+		}
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after %d redirects", len(via))
+		}
+		return nil
+	}
+	resp, err := limited.Do(req)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			if pe, ok := ue.Err.(*PolicyError); ok {
+				return nil, pe
+			}
+		}
+		return nil, fmt.Errorf("goeval: %s %s: %v", method, rawURL, err)
+	}
+	defer resp.Body.Close()
+	max := p.MaxResponseSize
+	if max <= 0 {
+		max = DefaultMaxResponseSize
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, fmt.Errorf("goeval: %s %s: %v", method, rawURL, err)
+	}
+	if int64(len(b)) > max {
+		return nil, fmt.Errorf("goeval: %s %s: response larger than %d bytes", method, rawURL, max)
+	}
+	header := make(map[string]string, len(resp.Header))
+	for k, vs := range resp.Header {
+		header[k] = vs[0]
+	}
+	return &HTTPResponse{Status: resp.StatusCode, Header: header, Body: string(b)}, nil
+}
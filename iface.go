@@ -0,0 +1,116 @@
+package goeval
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// adapterSuffix ends the names of the func fields of adapters
+const adapterSuffix = "Func"
+
+// adapters holds the adapter types registered by RegisterAdapter, by interface
+var adapters sync.Map // reflect.Type -> reflect.Type
+
+// RegisterAdapter registers the adapter through which MakeInterface implements
+// iface, a pointer to an interface type like (*Comparator)(nil). Go cannot
+// create methods at run time, so an adapter is a struct with a func field per
+// method of iface, named after it with the Func suffix, and methods calling
+// them. adapter is a value of it, or a pointer when its methods have pointer
+// receivers.
+//
+//	type comparatorAdapter struct{ CompareFunc func(a, b Item) int }
+//
+//	func (c comparatorAdapter) Compare(a, b Item) int { return c.CompareFunc(a, b) }
+//
+//	goeval.RegisterAdapter((*Comparator)(nil), comparatorAdapter{})
+func RegisterAdapter(iface, adapter interface{}) error {
+	it, err := interfaceType(iface)
+	if err != nil {
+		return err
+	}
+	at := reflect.TypeOf(adapter)
+	if at == nil || !at.Implements(it) {
+		return fmt.Errorf("goeval: adapter %v does not implement %v", at, it)
+	}
+	st := at
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	if st.Kind() != reflect.Struct {
+		return fmt.Errorf("goeval: adapter %v is not a struct", at)
+	}
+	for i := 0; i < it.NumMethod(); i++ {
+		m := it.Method(i)
+		f, ok := st.FieldByName(m.Name + adapterSuffix)
+		if !ok || f.Type != m.Type {
+			return fmt.Errorf("goeval: adapter %v needs a field %s%s %v", at, m.Name, adapterSuffix, m.Type)
+		}
+	}
+	adapters.Store(it, at)
+	return nil
+}
+
+// MakeInterface builds a value implementing iface, a pointer to an interface
+// type registered with RegisterAdapter, whose methods run scripts: each
+// method maps to the source of a function literal of its signature, evaluated
+// in s, so the scripts see the variables of s.
+//
+//	cmp, err := s.MakeInterface((*Comparator)(nil), map[string]string{
+//		"Compare": `func(a, b Item) int { return a.Rank - b.Rank }`,
+//	})
+//	sortItems(items, cmp.(Comparator))
+func (s *Scope) MakeInterface(iface interface{}, methodScripts map[string]string) (interface{}, error) {
+	it, err := interfaceType(iface)
+	if err != nil {
+		return nil, err
+	}
+	at, ok := adapters.Load(it)
+	if !ok {
+		return nil, fmt.Errorf("goeval: no adapter registered for %v", it)
+	}
+	adapterType := at.(reflect.Type)
+	ptr := reflect.New(adapterType)
+	st := ptr.Elem()
+	if adapterType.Kind() == reflect.Ptr {
+		st.Set(reflect.New(adapterType.Elem()))
+		st = st.Elem()
+	}
+	for name := range methodScripts {
+		if _, ok := it.MethodByName(name); !ok {
+			return nil, fmt.Errorf("goeval: %v has no method %s", it, name)
+		}
+	}
+	for i := 0; i < it.NumMethod(); i++ {
+		m := it.Method(i)
+		src, ok := methodScripts[m.Name]
+		if !ok {
+			return nil, fmt.Errorf("goeval: no script for method %s of %v", m.Name, it)
+		}
+		fn, err := s.Eval(src)
+		if err != nil {
+			return nil, fmt.Errorf("goeval: method %s: %w", m.Name, err)
+		}
+		fv := reflect.ValueOf(fn)
+		if fv.Kind() != reflect.Func || !fv.Type().AssignableTo(m.Type) {
+			return nil, fmt.Errorf("goeval: method %s: script is %T, not %v", m.Name, fn, m.Type)
+		}
+		st.FieldByName(m.Name + adapterSuffix).Set(fv)
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+// interfaceType returns the interface type iface points to
+func interfaceType(iface interface{}) (reflect.Type, error) {
+	t, ok := iface.(reflect.Type)
+	if !ok {
+		t = reflect.TypeOf(iface)
+		if t != nil && t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	}
+	if t == nil || t.Kind() != reflect.Interface {
+		return nil, fmt.Errorf("goeval: %v is not an interface type, use a pointer like (*I)(nil)", t)
+	}
+	return t, nil
+}
@@ -0,0 +1,144 @@
+package goeval
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Resolver provides the symbols of the packages imported by scripts. It is
+// called for every import when the import statement is evaluated, so it may
+// answer differently per tenant, request, etc.
+type Resolver interface {
+	Resolve(importPath string) (Namespace, error)
+}
+
+// ResolverFunc adapts a function to the Resolver interface
+type ResolverFunc func(importPath string) (Namespace, error)
+
+// Resolve calls f
+func (f ResolverFunc) Resolve(importPath string) (Namespace, error) {
+	return f(importPath)
+}
+
+// Packages is a static Resolver mapping import paths to their symbols
+type Packages map[string]Namespace
+
+// Resolve looks the import path up in the map
+func (p Packages) Resolve(importPath string) (Namespace, error) {
+	if ns, ok := p[importPath]; ok {
+		return ns, nil
+	}
+	return nil, fmt.Errorf("goeval: package %q not found", importPath)
+}
+
+// importSpec binds an imported package in the scope: under its alias, under the
+// last element of its path, or symbol by symbol for a dot-import
+func (s *Scope) importSpec(spec *ast.ImportSpec) error {
+	importPath, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return err
+	}
+	if s.Options.Resolver == nil {
+		return fmt.Errorf("goeval: cannot import %q, no resolver configured", importPath)
+	}
+	ns, err := s.Options.Resolver.Resolve(importPath)
+	if err != nil {
+		return err
+	}
+	name := path.Base(importPath)
+	if spec.Name != nil {
+		name = spec.Name.Name
+	}
+	switch name {
+	case "_":
+	case ".":
+		for k, v := range ns {
+			s.Vars[k] = v
+		}
+	default:
+		s.Vars[name] = ns
+	}
+	return nil
+}
+
+// importPrefix makes the import declarations of a script a Go file
+const importPrefix = "package script;"
+
+// splitImports separates the import declarations heading src, and the use
+// declarations which are their synonym for script modules, from the rest of
+// the script, returning them as a declaration statement, or nil if there are none.
+// The imports are blanked out of the returned script so that positions still match src.
+func splitImports(src string) (*ast.DeclStmt, string, error) {
+	end, uses := scanImports(src)
+	if end == 0 {
+		return nil, src, nil
+	}
+	head := src[:end]
+	for i := len(uses) - 1; i >= 0; i-- {
+		head = head[:uses[i]] + "import" + head[uses[i]+len("use"):]
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "", importPrefix+head, parser.ImportsOnly)
+	if err != nil {
+		return nil, "", err
+	}
+	decl := &ast.GenDecl{Tok: token.IMPORT}
+	for _, spec := range f.Imports {
+		decl.Specs = append(decl.Specs, spec)
+	}
+	blank := []byte(src[:end])
+	for i, c := range blank {
+		if c != '\n' {
+			blank[i] = ' '
+		}
+	}
+	return &ast.DeclStmt{Decl: decl}, string(blank) + src[end:], nil
+}
+
+// scanImports returns the end offset of the import and use declarations
+// heading src, 0 if there are none, and the offsets of the use keywords
+func scanImports(src string) (end int, uses []int) {
+	if !strings.Contains(src, "import") && !strings.Contains(src, "use") {
+		return 0, nil
+	}
+	var sc scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	sc.Init(file, []byte(src), nil, 0)
+	for {
+		pos, tok, lit := sc.Scan()
+		if tok == token.SEMICOLON {
+			continue
+		}
+		if tok == token.IDENT && lit == "use" {
+			// use "path" is an import of a script module
+			next, nextTok, nextLit := sc.Scan()
+			if nextTok != token.STRING {
+				break
+			}
+			uses = append(uses, file.Offset(pos))
+			end = file.Offset(next) + len(nextLit)
+			continue
+		}
+		if tok != token.IMPORT {
+			break
+		}
+		closing := token.STRING
+		for tok != token.EOF && tok != closing {
+			pos, tok, lit = sc.Scan()
+			if tok == token.LPAREN {
+				closing = token.RPAREN
+			}
+		}
+		end = file.Offset(pos) + len(lit)
+		if tok == token.RPAREN {
+			end++
+		}
+	}
+	return end, uses
+}
@@ -0,0 +1,64 @@
+package goeval
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Loader returns the source of the script included by name
+type Loader func(name string) (string, error)
+
+// DirLoader loads included scripts from files under dir. Names are slash
+// separated paths relative to dir and may not escape it.
+func DirLoader(dir string) Loader {
+	return func(name string) (string, error) {
+		clean := filepath.Clean("/" + name)
+		if clean == "/" || strings.Contains(name, "\x00") {
+			return "", fmt.Errorf("goeval: invalid include name %q", name)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(clean)))
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
+
+// includeFrame records a script being included, to report include cycles
+type includeFrame struct {
+	name   string
+	parent *includeFrame
+}
+
+// include returns the include builtin of the scope: include(name) loads a script
+// with Options.Loader and evaluates it in the calling scope, so that what it
+// defines is visible to the caller, and returns its result.
+func (s *Scope) include(name string) (interface{}, error) {
+	var frame *includeFrame
+	for currentScope := s; currentScope != nil && frame == nil; currentScope = currentScope.Parent {
+		frame = currentScope.including
+	}
+	for f := frame; f != nil; f = f.parent {
+		if f.name == name {
+			return nil, fmt.Errorf("goeval: include cycle through %q", name)
+		}
+	}
+	src, err := s.Options.Loader(name)
+	if err != nil {
+		return nil, fmt.Errorf("goeval: include %q: %v", name, err)
+	}
+	body, err := s.transformed(parse(src))
+	if err != nil {
+		return nil, fmt.Errorf("goeval: include %q: %v", name, err)
+	}
+	run := *s // shares Vars, so definitions land in s
+	run.tasks = s.taskGroup()
+	run.including = &includeFrame{name: name, parent: frame}
+	result, err := returned(run.interpret(body))
+	if err != nil {
+		return nil, fmt.Errorf("goeval: include %q: %v", name, err)
+	}
+	return result, nil
+}
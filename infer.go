@@ -0,0 +1,297 @@
+package goeval
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+)
+
+// dynamicType stands for values whose type is only known at run time
+var dynamicType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// inferredBuiltins gives the result types of the builtins returning interface{}
+var inferredBuiltins = map[string]reflect.Type{
+	"len":      builtinTypes["int"],
+	"cap":      builtinTypes["int"],
+	"toInt":    builtinTypes["int"],
+	"toFloat":  builtinTypes["float64"],
+	"toString": builtinTypes["string"],
+	"toBool":   builtinTypes["bool"],
+	"env":      builtinTypes["string"],
+}
+
+// InferType determines the type of the expression src without evaluating it,
+// from the types of the current values of the variables of scope, which may be
+// nil. Rule builders use it to check that a condition yields a bool before
+// saving it. Expressions whose type depends on run time values, like calls
+// returning interface{}, or variables holding nil, infer as interface{}.
+func InferType(src string, scope *Scope) (reflect.Type, error) {
+	expr, err := parser.ParseExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	if scope == nil {
+		scope = NewScope()
+	}
+	return scope.inferType(expr)
+}
+
+func (s *Scope) inferType(expr ast.Expr) (reflect.Type, error) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		switch e.Kind {
+		case token.INT:
+			v, err := parseInt(e.Value)
+			if err != nil {
+				return nil, err
+			}
+			return reflect.TypeOf(v), nil
+		case token.FLOAT:
+			return builtinTypes["float64"], nil
+		case token.IMAG:
+			return builtinTypes["complex128"], nil
+		case token.CHAR:
+			return builtinTypes["rune"], nil
+		case token.STRING:
+			return builtinTypes["string"], nil
+		}
+	case *ast.ParenExpr:
+		return s.inferType(e.X)
+	case *ast.Ident:
+		if _, isType := s.builtinType(e.Name); isType {
+			return nil, fmt.Errorf("goeval: %s is a type, not an expression", e.Name)
+		}
+		if v, ok := s.resolvedBuiltin(e.Name); ok {
+			return typeOf(v), nil
+		}
+		if v, ok := s.lookup(e.Name); ok {
+			if _, isType := v.(reflect.Type); isType {
+				return nil, fmt.Errorf("goeval: %s is a type, not an expression", e.Name)
+			}
+			return typeOf(v), nil
+		}
+		return nil, fmt.Errorf("goeval: undefined: %s", e.Name)
+	case *ast.UnaryExpr:
+		x, err := s.inferType(e.X)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case token.NOT:
+			if x.Kind() != reflect.Bool && x != dynamicType {
+				return nil, fmt.Errorf("goeval: operator ! not defined on %s (%v)", types.ExprString(e.X), x)
+			}
+			return builtinTypes["bool"], nil
+		case token.AND:
+			return reflect.PtrTo(x), nil
+		}
+		return x, nil
+	case *ast.BinaryExpr:
+		return s.inferBinary(e)
+	case *ast.CallExpr:
+		return s.inferCall(e)
+	case *ast.SelectorExpr:
+		return s.inferSelector(e)
+	case *ast.IndexExpr:
+		x, err := s.inferType(e.X)
+		if err != nil {
+			return nil, err
+		}
+		for x.Kind() == reflect.Ptr {
+			x = x.Elem()
+		}
+		switch x.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return x.Elem(), nil
+		case reflect.String:
+			return builtinTypes["byte"], nil
+		case reflect.Interface:
+			return dynamicType, nil
+		}
+		return nil, fmt.Errorf("goeval: cannot index %s (%v)", types.ExprString(e.X), x)
+	case *ast.SliceExpr:
+		return s.inferType(e.X)
+	case *ast.CompositeLit, *ast.FuncLit:
+		// building the type does not run any script code
+		if lit, ok := e.(*ast.CompositeLit); ok {
+			typ, err := s.interpret(lit.Type)
+			if err != nil {
+				return nil, err
+			}
+			if t, ok := typ.(reflect.Type); ok {
+				return t, nil
+			}
+			return nil, fmt.Errorf("goeval: %s is not a type", types.ExprString(lit.Type))
+		}
+		typ, _, err := s.funcType(e.(*ast.FuncLit).Type)
+		return typ, err
+	}
+	return nil, fmt.Errorf("goeval: cannot infer the type of %s", types.ExprString(expr))
+}
+
+// typeOf is reflect.TypeOf, with nil as interface{}
+func typeOf(v interface{}) reflect.Type {
+	if v == nil {
+		return dynamicType
+	}
+	if rv, ok := v.(reflect.Value); ok && rv.IsValid() {
+		return rv.Type()
+	}
+	return reflect.TypeOf(v)
+}
+
+func (s *Scope) inferBinary(e *ast.BinaryExpr) (reflect.Type, error) {
+	x, err := s.inferType(e.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := s.inferType(e.Y)
+	if err != nil {
+		return nil, err
+	}
+	switch e.Op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		return builtinTypes["bool"], nil
+	case token.LAND, token.LOR:
+		for _, t := range []reflect.Type{x, y} {
+			if t.Kind() != reflect.Bool && t != dynamicType {
+				return nil, fmt.Errorf("goeval: operator %s not defined on %v", e.Op, t)
+			}
+		}
+		return builtinTypes["bool"], nil
+	case token.SHL, token.SHR:
+		return x, nil
+	}
+	if x == dynamicType || y == dynamicType {
+		return dynamicType, nil
+	}
+	if x == y {
+		return x, nil
+	}
+	// mirrors promoteNumbers
+	if isNumberKind(x) && isNumberKind(y) {
+		switch {
+		case x.Kind() == reflect.Int:
+			return y, nil
+		case y.Kind() == reflect.Int:
+			return x, nil
+		case isRealKind(x) || isRealKind(y):
+			return builtinTypes["float64"], nil
+		case isUintKind(x) && isUintKind(y):
+			return builtinTypes["uint64"], nil
+		}
+		return builtinTypes["int64"], nil
+	}
+	return nil, fmt.Errorf("goeval: mismatched types %v and %v for %s", x, y, e.Op)
+}
+
+func (s *Scope) inferCall(e *ast.CallExpr) (reflect.Type, error) {
+	if ident, ok := e.Fun.(*ast.Ident); ok {
+		if t, ok := s.builtinType(ident.Name); ok {
+			return t, nil // conversion
+		}
+		if _, isBuiltin := s.resolvedBuiltin(ident.Name); isBuiltin {
+			switch ident.Name {
+			case "append":
+				if len(e.Args) > 0 {
+					return s.inferType(e.Args[0])
+				}
+			case "make":
+				if len(e.Args) > 0 {
+					if t, err := s.interpret(e.Args[0]); err == nil {
+						if typ, ok := t.(reflect.Type); ok {
+							return typ, nil
+						}
+					}
+				}
+			}
+			if t, ok := inferredBuiltins[ident.Name]; ok {
+				return t, nil
+			}
+		}
+		if v, ok := s.lookup(ident.Name); ok {
+			if t, isType := v.(reflect.Type); isType {
+				return t, nil // conversion to a declared type
+			}
+		}
+	}
+	fun, err := s.inferType(e.Fun)
+	if err != nil {
+		return nil, err
+	}
+	if fun == dynamicType {
+		return dynamicType, nil
+	}
+	if fun.Kind() != reflect.Func {
+		return nil, fmt.Errorf("goeval: cannot call non-function %s (%v)", types.ExprString(e.Fun), fun)
+	}
+	n := fun.NumOut()
+	if n > 0 && fun.Out(n-1) == errorType {
+		n-- // the error aborts evaluation instead of being returned
+	}
+	switch n {
+	case 0:
+		return nil, fmt.Errorf("goeval: %s is used as a value but returns nothing", types.ExprString(e))
+	case 1:
+		return fun.Out(0), nil
+	}
+	return reflect.TypeOf([]interface{}{}), nil
+}
+
+func (s *Scope) inferSelector(e *ast.SelectorExpr) (reflect.Type, error) {
+	if ns, ok := s.namespaceExpr(e.X); ok {
+		v, ok := ns[e.Sel.Name]
+		if !ok {
+			return nil, fmt.Errorf("goeval: undefined: %s.%s", types.ExprString(e.X), e.Sel.Name)
+		}
+		return typeOf(v), nil
+	}
+	x, err := s.inferType(e.X)
+	if err != nil {
+		return nil, err
+	}
+	if x == dynamicType {
+		return dynamicType, nil
+	}
+	if method, ok := x.MethodByName(e.Sel.Name); ok {
+		if x.Kind() == reflect.Interface {
+			return method.Type, nil // interface methods have no receiver
+		}
+		return methodType(method.Type), nil
+	}
+	if x.Kind() != reflect.Ptr {
+		if method, ok := reflect.PtrTo(x).MethodByName(e.Sel.Name); ok {
+			return methodType(method.Type), nil
+		}
+	}
+	for x.Kind() == reflect.Ptr {
+		x = x.Elem()
+	}
+	switch x.Kind() {
+	case reflect.Struct:
+		if field, ok := x.FieldByName(e.Sel.Name); ok {
+			return field.Type, nil
+		}
+	case reflect.Map:
+		if s.Options.MapSelectors && x.Key().Kind() == reflect.String {
+			return x.Elem(), nil
+		}
+	}
+	return nil, fmt.Errorf("goeval: %s undefined (type %v has no field or method %s)", types.ExprString(e), x, e.Sel.Name)
+}
+
+// methodType drops the receiver from the type of a method expression
+func methodType(t reflect.Type) reflect.Type {
+	in := make([]reflect.Type, 0, t.NumIn()-1)
+	for i := 1; i < t.NumIn(); i++ {
+		in = append(in, t.In(i))
+	}
+	out := make([]reflect.Type, t.NumOut())
+	for i := range out {
+		out[i] = t.Out(i)
+	}
+	return reflect.FuncOf(in, out, t.IsVariadic())
+}
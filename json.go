@@ -0,0 +1,247 @@
+package goeval
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSONOptions tune how GetJSON, GetJsonString, GetJSONIndent and Assemble
+// encode values, see Options.JSON. The zero value encodes like encoding/json.
+type JSONOptions struct {
+	// BigIntsAsStrings writes the integers beyond ±2^53 as strings, which
+	// JavaScript and other float64 consumers would otherwise round, eg int64 IDs
+	BigIntsAsStrings bool
+	// FloatFormat and FloatPrecision, when FloatFormat is set, format floats
+	// like strconv.FormatFloat, eg 'f' and -1 for no exponent
+	FloatFormat    byte
+	FloatPrecision int
+	// TimeLayout, when set, formats time.Time values, eg time.RFC3339 to drop
+	// the fractional seconds; registered marshalers still take precedence
+	TimeLayout string
+	// OmitEmpty leaves out the map entries and struct fields holding nil or
+	// zero values
+	OmitEmpty bool
+	// NoHTMLEscape keeps <, > and & as they are in strings instead of escaping them
+	NoHTMLEscape bool
+}
+
+// maxExactInt is the largest integer a float64 holds exactly
+const maxExactInt = 1 << 53
+
+// MarshalFunc turns a value into a representation that the encoders understand,
+// eg a time.Time into a formatted string
+type MarshalFunc func(v interface{}) (interface{}, error)
+
+// RegisterMarshaler makes GetJSON and the other exporters encode values of the
+// same type as sample with fn. Marshalers are inherited by child scopes.
+func (s *Scope) RegisterMarshaler(sample interface{}, fn MarshalFunc) {
+	if s.marshalers == nil {
+		s.marshalers = map[reflect.Type]MarshalFunc{}
+	}
+	s.marshalers[reflect.TypeOf(sample)] = fn
+}
+
+// marshaler finds the marshaler registered for typ in the scope chain
+func (s *Scope) marshaler(typ reflect.Type) (MarshalFunc, bool) {
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		if fn, ok := currentScope.marshalers[typ]; ok {
+			return fn, true
+		}
+	}
+	return nil, false
+}
+
+func (s *Scope) hasMarshalers() bool {
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		if len(currentScope.marshalers) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// GetJSON encodes the named variable as JSON, under Options.JSON
+func (s *Scope) GetJSON(name string) ([]byte, error) {
+	return s.marshalJSON(s.Get(name), "", "")
+}
+
+// GetJSONIndent is like GetJSON but indents the output like json.MarshalIndent
+func (s *Scope) GetJSONIndent(name, prefix, indent string) ([]byte, error) {
+	return s.marshalJSON(s.Get(name), prefix, indent)
+}
+
+// marshalJSON encodes v under Options.JSON, indenting it when indent is set
+func (s *Scope) marshalJSON(v interface{}, prefix, indent string) ([]byte, error) {
+	opts := &s.Options.JSON
+	if *opts == (JSONOptions{}) {
+		opts = nil
+	}
+	v, err := s.exportWith(v, opts)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(opts == nil || !opts.NoHTMLEscape)
+	if indent != "" || prefix != "" {
+		enc.SetIndent(prefix, indent)
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// exportValue applies the registered marshalers throughout v, returning a
+// tree of maps, slices and plain values ready for encoding
+func (s *Scope) exportValue(v interface{}) (interface{}, error) {
+	return s.exportWith(v, nil)
+}
+
+// exportWith is exportValue also applying the JSON options, when not nil
+func (s *Scope) exportWith(v interface{}, opts *JSONOptions) (interface{}, error) {
+	if opts == nil && !s.hasMarshalers() {
+		return v, nil
+	}
+	return s.export(reflect.ValueOf(v), opts)
+}
+
+func (s *Scope) export(v reflect.Value, opts *JSONOptions) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+	if fn, ok := s.marshaler(v.Type()); ok {
+		return fn(v.Interface())
+	}
+	if opts != nil {
+		if out, ok := exportJSONScalar(v, opts); ok {
+			return out, nil
+		}
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return s.export(v.Elem(), opts)
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		out := make(map[string]interface{}, v.Len())
+		for _, key := range v.MapKeys() {
+			if opts != nil && opts.OmitEmpty && isEmptyValue(v.MapIndex(key)) {
+				continue
+			}
+			val, err := s.export(v.MapIndex(key), opts)
+			if err != nil {
+				return nil, err
+			}
+			out[fmt.Sprint(key.Interface())] = val
+		}
+		return out, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && (v.IsNil() || v.Type().Elem().Kind() == reflect.Uint8) {
+			return v.Interface(), nil
+		}
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			val, err := s.export(v.Index(i), opts)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = val
+		}
+		return out, nil
+	case reflect.Struct:
+		if _, isMarshaler := v.Interface().(json.Marshaler); isMarshaler {
+			return v.Interface(), nil
+		}
+		out := map[string]interface{}{}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name, omitEmpty := jsonFieldName(field)
+			if name == "-" || omitEmpty && v.Field(i).IsZero() || opts != nil && opts.OmitEmpty && isEmptyValue(v.Field(i)) {
+				continue
+			}
+			val, err := s.export(v.Field(i), opts)
+			if err != nil {
+				return nil, err
+			}
+			out[name] = val
+		}
+		return out, nil
+	}
+	return v.Interface(), nil
+}
+
+// jsonFieldName reads the name and omitempty flag from a field's json tag
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "" {
+		return field.Name, false
+	}
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	if tag == "-" {
+		name = "-"
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			return name, true
+		}
+	}
+	return name, false
+}
+
+// exportJSONScalar formats the numbers and times the JSON options apply to
+func exportJSONScalar(v reflect.Value, opts *JSONOptions) (interface{}, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := v.Int(); opts.BigIntsAsStrings && (n > maxExactInt || n < -maxExactInt) {
+			return strconv.FormatInt(n, 10), true
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n := v.Uint(); opts.BigIntsAsStrings && n > maxExactInt {
+			return strconv.FormatUint(n, 10), true
+		}
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if opts.FloatFormat != 0 && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return json.Number(strconv.FormatFloat(f, opts.FloatFormat, opts.FloatPrecision, v.Type().Bits())), true
+		}
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok && opts.TimeLayout != "" {
+			return t.Format(opts.TimeLayout), true
+		}
+	}
+	return nil, false
+}
+
+// isEmptyValue reports whether v is nil or the zero value of its type, or
+// an empty map, slice or string
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Map, reflect.Slice:
+		return v.Len() == 0
+	case reflect.Interface:
+		return v.IsNil() || isEmptyValue(v.Elem())
+	case reflect.Ptr:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
@@ -0,0 +1,186 @@
+package goeval
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Lambda compiles src into a Go function of the given type, so a script can be
+// handed to APIs expecting callbacks. fnType is either a reflect.Type or a sample
+// function of the wanted type, and params names the arguments, which are bound in
+// a fresh child scope of s on every call.
+//
+// The script result is converted to the function results; a script returning
+// several values must return one per result. If the last result is an error it
+// receives evaluation failures, otherwise the function panics with them.
+//
+//	less, err := s.Lambda(`return a < b`, func(a, b int) bool { return false }, "a", "b")
+func (s *Scope) Lambda(src string, fnType interface{}, params ...string) (interface{}, error) {
+	typ, isType := fnType.(reflect.Type)
+	if !isType {
+		typ = reflect.TypeOf(fnType)
+	}
+	if typ == nil || typ.Kind() != reflect.Func {
+		return nil, fmt.Errorf("goeval: lambda needs a func type, not %v", typ)
+	}
+	if typ.NumIn() != len(params) {
+		return nil, fmt.Errorf("goeval: lambda of type %v needs %d parameter names, got %d", typ, typ.NumIn(), len(params))
+	}
+	body, err := s.transformed(parse(src))
+	if err != nil {
+		return nil, err
+	}
+	return s.makeFunc(typ, params, body), nil
+}
+
+// makeFunc builds a function of type typ running body in a fresh child scope of s
+// per call, with the arguments bound to params
+func (s *Scope) makeFunc(typ reflect.Type, params []string, body ast.Node) interface{} {
+	returnsErr := typ.NumOut() > 0 && typ.Out(typ.NumOut()-1) == errorType
+	fn := reflect.MakeFunc(typ, func(args []reflect.Value) []reflect.Value {
+		child := s.NewChild()
+		for i, name := range params {
+			if name != "" && name != "_" {
+				child.Vars[name] = args[i].Interface()
+			}
+		}
+		result, err := child.run(body)
+		var out []reflect.Value
+		if err == nil {
+			out, err = lambdaResults(typ, result, returnsErr)
+		}
+		if err != nil {
+			if !returnsErr {
+				panic(scriptPanic{err})
+			}
+			out = make([]reflect.Value, typ.NumOut())
+			for i := range out {
+				out[i] = reflect.Zero(typ.Out(i))
+			}
+			out[len(out)-1] = reflect.ValueOf(&err).Elem()
+		}
+		return out
+	})
+	if s.Options.Pure {
+		s.madePure(fn.Interface())
+	}
+	return fn.Interface()
+}
+
+// scriptPanic carries the error of a script function whose type has no error
+// result. Calls made by the interpreter turn it back into an error.
+type scriptPanic struct {
+	err error
+}
+
+func (p scriptPanic) Error() string {
+	return p.err.Error()
+}
+
+// callFunc calls fn, recovering the errors of script functions
+func callFunc(fn reflect.Value, args []reflect.Value) (results []reflect.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p, ok := r.(scriptPanic)
+			if !ok {
+				panic(r)
+			}
+			err = p.err
+		}
+	}()
+	return fn.Call(args), nil
+}
+
+// funcLit turns a function literal into a closure over s
+func (s *Scope) funcLit(lit *ast.FuncLit) (interface{}, error) {
+	typ, params, err := s.funcType(lit.Type)
+	if err != nil {
+		return nil, err
+	}
+	return s.makeFunc(typ, params, lit.Body), nil
+}
+
+// funcType resolves a func signature to its reflect.Type and parameter names
+func (s *Scope) funcType(ft *ast.FuncType) (reflect.Type, []string, error) {
+	var in, out []reflect.Type
+	var names []string
+	variadic := false
+	fields := func(list *ast.FieldList, dst *[]reflect.Type, named bool) error {
+		if list == nil {
+			return nil
+		}
+		for _, field := range list.List {
+			expr := field.Type
+			if ellipsis, ok := expr.(*ast.Ellipsis); ok {
+				variadic = true
+				expr = &ast.ArrayType{Elt: ellipsis.Elt}
+			}
+			t, err := s.interpret(expr)
+			if err != nil {
+				return err
+			}
+			typ, ok := t.(reflect.Type)
+			if !ok {
+				return fmt.Errorf("goeval: %s is not a type", types.ExprString(field.Type))
+			}
+			n := len(field.Names)
+			if n == 0 {
+				n = 1
+			}
+			for i := 0; i < n; i++ {
+				*dst = append(*dst, typ)
+				if named {
+					name := ""
+					if len(field.Names) > 0 {
+						name = field.Names[i].Name
+					}
+					names = append(names, name)
+				}
+			}
+		}
+		return nil
+	}
+	if err := fields(ft.Params, &in, true); err != nil {
+		return nil, nil, err
+	}
+	if err := fields(ft.Results, &out, false); err != nil {
+		return nil, nil, err
+	}
+	return reflect.FuncOf(in, out, variadic), names, nil
+}
+
+// lambdaResults converts a script result to the results of the func type typ
+func lambdaResults(typ reflect.Type, result interface{}, returnsErr bool) ([]reflect.Value, error) {
+	n := typ.NumOut()
+	if returnsErr {
+		n--
+	}
+	var values []interface{}
+	switch n {
+	case 0:
+	case 1:
+		values = []interface{}{result}
+	default:
+		multi, ok := result.([]interface{})
+		if !ok || len(multi) != n {
+			return nil, fmt.Errorf("goeval: lambda must return %d values, got %#v", n, result)
+		}
+		values = multi
+	}
+	out := make([]reflect.Value, typ.NumOut())
+	for i, v := range values {
+		rv, err := valueAs(v, typ.Out(i))
+		if err != nil {
+			return nil, err
+		}
+		out[i] = rv
+	}
+	if returnsErr {
+		out[n] = reflect.Zero(errorType)
+	}
+	return out, nil
+}
@@ -0,0 +1,106 @@
+package goeval
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Limits caps the sizes of the values scripts construct, so that a single
+// expression cannot allocate unbounded memory. 0 means no limit.
+type Limits struct {
+	// MaxSliceLen caps the length and capacity of the slices and arrays made,
+	// appended to or declared
+	MaxSliceLen int
+	// MaxMapLen caps the entries of the maps made or assigned to
+	MaxMapLen int
+	// MaxStringLen caps the bytes of the strings built by concatenation, and
+	// of those returned by calls: sprint, sprintf, the InstallStrings helpers
+	// and host functions. Concatenations and builders are checked as they
+	// grow, the results of calls once the function returned them.
+	MaxStringLen int
+}
+
+// ErrLimitExceeded is matched by the LimitError of a script exceeding Options.Limits
+var ErrLimitExceeded = errors.New("goeval: limit exceeded")
+
+// LimitError reports which limit a script exceeded
+type LimitError struct {
+	Limit     string // the Limits field
+	Size, Max int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("goeval: %s exceeded: %d > %d", e.Limit, e.Size, e.Max)
+}
+
+// Is makes errors.Is(err, ErrLimitExceeded) hold
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+func checkLimit(limit string, size, max int) error {
+	if max > 0 && size > max {
+		return &LimitError{Limit: limit, Size: size, Max: max}
+	}
+	return nil
+}
+
+// checkLen checks the length of a slice, array, map or string against the limits
+func (l Limits) checkLen(kind reflect.Kind, n int) error {
+	switch kind {
+	case reflect.Slice, reflect.Array:
+		return checkLimit("MaxSliceLen", n, l.MaxSliceLen)
+	case reflect.Map:
+		return checkLimit("MaxMapLen", n, l.MaxMapLen)
+	case reflect.String:
+		return checkLimit("MaxStringLen", n, l.MaxStringLen)
+	}
+	return nil
+}
+
+// limitedMake is the make builtin under limits: sizes are checked before
+// anything is allocated
+func (l Limits) limitedMake(t interface{}, args ...interface{}) (interface{}, error) {
+	if typ, ok := t.(reflect.Type); ok {
+		for _, arg := range args {
+			if n, err := getInteger(arg); err == nil {
+				if err := l.checkLen(typ.Kind(), n); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return Make(t, args...)
+}
+
+// limitedAppend is the append builtin under limits. Elements are checked
+// before anything is appended, and the result after, as an element of the
+// type of arr appends all of its own elements.
+func (l Limits) limitedAppend(arr interface{}, elements ...interface{}) (interface{}, error) {
+	n := len(elements)
+	if rv := reflect.ValueOf(arr); rv.Kind() == reflect.Slice {
+		n += rv.Len()
+	}
+	if err := l.checkLen(reflect.Slice, n); err != nil {
+		return nil, err
+	}
+	result, err := Append(arr, elements...)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.checkLen(reflect.Slice, reflect.ValueOf(result).Len()); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// limitedBuilder is the builder builtin under limits
+func (l Limits) limitedBuilder() *Builder {
+	return &Builder{max: l.MaxStringLen}
+}
+
+// limitedConcat is the concat builtin under limits
+func (l Limits) limitedConcat(parts ...interface{}) (string, error) {
+	return concat(l.MaxStringLen, parts)
+}
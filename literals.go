@@ -0,0 +1,91 @@
+package goeval
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// LiteralHook turns string literals of a domain into values, so that rules need
+// no constructor calls for them. It applies either to the string literals
+// tagged by a pseudo-call named Tag, eg date("2024-01-02"), or to the bare
+// string literals Pattern matches.
+type LiteralHook struct {
+	Tag     string
+	Pattern *regexp.Regexp
+	// Convert returns the value of the unquoted literal; an error fails the
+	// evaluation
+	Convert func(lit string) (interface{}, error)
+}
+
+// DurationLiterals makes string literals holding a duration, like "5s" or
+// "1h30m", time.Durations
+var DurationLiterals = LiteralHook{
+	Pattern: regexp.MustCompile(`^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`),
+	Convert: func(lit string) (interface{}, error) {
+		return time.ParseDuration(lit)
+	},
+}
+
+// DateLiterals makes the string literals tagged by tag, as in
+// tag("2024-01-02"), time.Times parsed with layout
+func DateLiterals(tag, layout string) LiteralHook {
+	return LiteralHook{Tag: tag, Convert: func(lit string) (interface{}, error) {
+		return time.Parse(layout, lit)
+	}}
+}
+
+// stringLiteral converts the string literal lit with the first untagged hook
+// matching it, if any
+func (s *Scope) stringLiteral(lit string) (interface{}, error) {
+	for _, hook := range s.Options.Literals {
+		if hook.Tag == "" && hook.Pattern != nil && hook.Pattern.MatchString(lit) {
+			v, err := hook.Convert(lit)
+			if err != nil {
+				return nil, fmt.Errorf("goeval: literal %q: %v", lit, err)
+			}
+			return v, nil
+		}
+	}
+	return lit, nil
+}
+
+// literalTag returns the hook tagging literals with name, if any
+func (s *Scope) literalTag(name string) *LiteralHook {
+	for i, hook := range s.Options.Literals {
+		if hook.Tag != "" && hook.Tag == name {
+			return &s.Options.Literals[i]
+		}
+	}
+	return nil
+}
+
+// taggedLiteral evaluates call when it tags a literal, eg date("2024-01-02")
+func (s *Scope) taggedLiteral(call *ast.CallExpr) (v interface{}, tagged bool, err error) {
+	ident, ok := call.Fun.(*ast.Ident)
+	if !ok {
+		return nil, false, nil
+	}
+	hook := s.literalTag(ident.Name)
+	if hook == nil {
+		return nil, false, nil
+	}
+	if len(call.Args) != 1 {
+		return nil, true, fmt.Errorf("goeval: %s needs a single string literal", ident.Name)
+	}
+	lit, ok := call.Args[0].(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return nil, true, fmt.Errorf("goeval: %s needs a string literal", ident.Name)
+	}
+	str, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return nil, true, err
+	}
+	if v, err = hook.Convert(str); err != nil {
+		return nil, true, fmt.Errorf("goeval: %s(%s): %v", ident.Name, lit.Value, err)
+	}
+	return v, true, nil
+}
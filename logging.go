@@ -0,0 +1,56 @@
+package goeval
+
+import (
+	"go/ast"
+	"time"
+)
+
+// Logger receives the interpreter logs. *slog.Logger satisfies it, as do
+// adapters for other structured loggers; args alternate keys and values.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// logged runs an evaluation of src, logging its outcome when a Logger is set.
+// Statements are traced at debug level by interpret.
+func (s *Scope) logged(src string, run func(*Scope) (interface{}, error)) (interface{}, error) {
+	logger := s.Options.Logger
+	if logger == nil {
+		return run(s)
+	}
+	traced := *s // shares Vars, only adds the source for positions
+	traced.tasks = s.taskGroup()
+	traced.src = &src
+	start := time.Now()
+	result, err := run(&traced)
+	if err != nil {
+		args := []interface{}{"error", err, "duration", time.Since(start)}
+		if ae, ok := err.(*AssertionError); ok {
+			line, column := position(src, ae.Pos)
+			args = append(args, "line", line, "column", column)
+		}
+		logger.Error("goeval: evaluation failed", args...)
+		return result, err
+	}
+	logger.Info("goeval: evaluated", "duration", time.Since(start))
+	return result, nil
+}
+
+// traceStmt logs a statement about to run at debug level
+func (s *Scope) traceStmt(stmt ast.Stmt) {
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		if currentScope.src != nil {
+			line, column := position(*currentScope.src, stmt.Pos())
+			s.Options.Logger.Debug("goeval: statement", "line", line, "column", column)
+			return
+		}
+	}
+	s.Options.Logger.Debug("goeval: statement", "pos", int(stmt.Pos()))
+}
+
+// logBuiltin is the log builtin: log(msg, key, value, ...) writes an info record
+func (s *Scope) logBuiltin(msg string, args ...interface{}) {
+	s.Options.Logger.Info(msg, append([]interface{}{"source", "script"}, args...)...)
+}
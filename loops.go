@@ -0,0 +1,207 @@
+package goeval
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+)
+
+// branchSignal carries a break or continue out of the statements of a loop
+// body up to the loop it applies to
+type branchSignal struct {
+	tok   token.Token
+	label string
+}
+
+func (b *branchSignal) Error() string {
+	if b.label != "" {
+		return fmt.Sprintf("goeval: %s label not defined: %s", b.tok, b.label)
+	}
+	return fmt.Sprintf("goeval: %s is not in a loop", b.tok)
+}
+
+// returnSignal carries a return statement out of the statements of a function
+// or script body up to the call running it, with the values returned
+type returnSignal struct {
+	result interface{}
+}
+
+func (r *returnSignal) Error() string {
+	return "goeval: return is not in a function"
+}
+
+// returned turns the return signal of a body into its result
+func returned(result interface{}, err error) (interface{}, error) {
+	if r, ok := err.(*returnSignal); ok {
+		return r.result, nil
+	}
+	return result, err
+}
+
+func branch(stmt *ast.BranchStmt) error {
+	switch stmt.Tok {
+	case token.BREAK, token.CONTINUE:
+		b := &branchSignal{tok: stmt.Tok}
+		if stmt.Label != nil {
+			b.label = stmt.Label.Name
+		}
+		return b
+	case token.FALLTHROUGH:
+		return errors.New("goeval: fallthrough statement out of place")
+	}
+	return fmt.Errorf("goeval: %s is not supported", stmt.Tok)
+}
+
+// loopControl handles the error of an iteration of the loop named label: it
+// tells whether the loop ends, and the error the loop returns
+func loopControl(err error, label string) (stop bool, out error) {
+	if err == nil {
+		return false, nil
+	}
+	b, ok := err.(*branchSignal)
+	if !ok || (b.label != "" && b.label != label) {
+		return true, err
+	}
+	return b.tok == token.BREAK, nil
+}
+
+// labeled runs a labeled statement, which break and continue may name
+func (s *Scope) labeled(stmt *ast.LabeledStmt) (interface{}, error) {
+	label := stmt.Label.Name
+	switch inner := stmt.Stmt.(type) {
+	case *ast.ForStmt:
+		return nil, s.forStmt(inner, label)
+	case *ast.RangeStmt:
+		return nil, s.rangeStmt(inner, label)
+	}
+	result, err := s.interpret(stmt.Stmt)
+	if b, ok := err.(*branchSignal); ok && b.tok == token.BREAK && b.label == label {
+		return nil, nil
+	}
+	return result, err
+}
+
+func (s *Scope) forStmt(stmt *ast.ForStmt, label string) error {
+	// variables declared by Init get a fresh binding in every iteration,
+	// copied from the previous one before Post runs, as in Go 1.22
+	var loopVars map[string]bool
+	cur := s.NewChild()
+	if stmt.Init != nil {
+		loopVars = definedIdents(stmt.Init)
+		if _, err := cur.interpret(stmt.Init); err != nil {
+			return err
+		}
+	}
+	ctx := s.context()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if stmt.Cond != nil {
+			ok, err := cur.condition(stmt.Cond)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+		}
+		_, err := cur.NewChild().interpret(stmt.Body)
+		if stop, err := loopControl(err, label); stop {
+			return err
+		}
+		next := s.NewChild()
+		for name := range loopVars {
+			next.Vars[name] = cur.Vars[name]
+		}
+		cur = next
+		if stmt.Post != nil {
+			if _, err := cur.interpret(stmt.Post); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Scope) rangeStmt(stmt *ast.RangeStmt, label string) error {
+	ranger, err := s.interpret(stmt.X)
+	if err != nil {
+		return err
+	}
+	var key, value string
+	for _, v := range []struct {
+		expr ast.Expr
+		name *string
+	}{{stmt.Key, &key}, {stmt.Value, &value}} {
+		if v.expr == nil {
+			continue
+		}
+		ident, ok := v.expr.(*ast.Ident)
+		if !ok {
+			return fmt.Errorf("goeval: unsupported range variable %s", types.ExprString(v.expr))
+		}
+		*v.name = ident.Name
+	}
+	if ranger == nil {
+		return nil // ranging over a nil slice or map does nothing
+	}
+	// with :=, every iteration binds key and value afresh, as in Go 1.22
+	ctx := s.context()
+	iterate := func(k, v func() interface{}) (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return true, err
+		}
+		iter := s.NewChild()
+		define := stmt.Tok == token.DEFINE
+		if key != "" && key != "_" {
+			if err := iter.setVar(key, k(), define); err != nil {
+				return true, err
+			}
+		}
+		if value != "" && value != "_" {
+			if err := iter.setVar(value, v(), define); err != nil {
+				return true, err
+			}
+		}
+		_, err := iter.interpret(stmt.Body)
+		return loopControl(err, label)
+	}
+	rv := reflect.ValueOf(ranger)
+	switch rv.Type().Kind() {
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < rv.Len(); i++ {
+			if stop, err := iterate(func() interface{} { return i }, rv.Index(i).Interface); stop {
+				return err
+			}
+		}
+	case reflect.Map:
+		keys := rv.MapKeys()
+		for _, keyV := range keys {
+			if stop, err := iterate(keyV.Interface, rv.MapIndex(keyV).Interface); stop {
+				return err
+			}
+		}
+	case reflect.Chan:
+		if rv.Type().ChanDir()&reflect.RecvDir == 0 {
+			return fmt.Errorf("goeval: cannot range over send-only channel %v", rv.Type())
+		}
+		if value != "" {
+			return fmt.Errorf("goeval: range over %v permits only one iteration variable", rv.Type())
+		}
+		for {
+			v, ok, err := chanOp(ctx, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: rv})
+			if err != nil || !ok {
+				return err
+			}
+			if stop, err := iterate(v.Interface, nil); stop {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("goeval: range unsupported on %s", rv.Type().Kind().String())
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package goeval
+
+import (
+	"go/ast"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Source provides the current text of a script
+type Source func() (string, error)
+
+// FileSource reads the script from a file
+func FileSource(path string) Source {
+	return func() (string, error) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
+
+// Manager keeps a script up to date with its source. It polls the source,
+// validates changed text and swaps it in atomically, so Run never sees a half
+// updated or broken script: when a new version fails to load the previous one
+// stays active.
+type Manager struct {
+	// Validate, when set, vets a changed source after it parsed; a non-nil error
+	// rejects it
+	Validate func(src string) error
+	// OnReload, when set, is told about every change of the source, with the
+	// error that rejected it if any
+	OnReload func(version int, err error)
+
+	scope    *Scope
+	source   Source
+	interval time.Duration
+	active   atomic.Value // *managedScript
+	rejected string       // the last source that failed to load, not retried
+	reloadMu sync.Mutex
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+type managedScript struct {
+	src     string
+	body    *ast.BlockStmt
+	version int
+}
+
+// NewManager loads the script from source, failing if it does not parse. Runs
+// evaluate it in child scopes of s. Once started, the source is polled every interval.
+func NewManager(s *Scope, source Source, interval time.Duration) (*Manager, error) {
+	m := &Manager{scope: s, source: source, interval: interval}
+	src, err := source()
+	if err != nil {
+		return nil, err
+	}
+	body, err := s.transformed(parse(src))
+	if err != nil {
+		return nil, err
+	}
+	m.active.Store(&managedScript{src: src, body: body, version: 1})
+	return m, nil
+}
+
+// Reload checks the source right away, returning the error that rejected a changed
+// script, if any. A rejected source is not loaded again until it changes.
+func (m *Manager) Reload() error {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+	current := m.active.Load().(*managedScript)
+	src, err := m.source()
+	if err != nil {
+		return err
+	}
+	if src == current.src || src == m.rejected {
+		return nil
+	}
+	version := current.version + 1
+	body, err := m.scope.transformed(parse(src))
+	if err == nil && m.Validate != nil {
+		err = m.Validate(src)
+	}
+	if err == nil {
+		m.active.Store(&managedScript{src: src, body: body, version: version})
+		m.rejected = ""
+	} else {
+		m.rejected = src
+	}
+	if m.OnReload != nil {
+		m.OnReload(version, err)
+	}
+	return err
+}
+
+// Start polls the source in the background until Stop
+func (m *Manager) Start() {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+	if m.stop != nil {
+		return
+	}
+	m.stop, m.done = make(chan struct{}), make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				_ = m.Reload() // reported through OnReload
+			}
+		}
+	}(m.stop, m.done)
+}
+
+// Stop ends the polling started by Start and waits for it to finish
+func (m *Manager) Stop() {
+	m.reloadMu.Lock()
+	stop, done := m.stop, m.done
+	m.stop, m.done = nil, nil
+	m.reloadMu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Version returns the version of the active script, counting from 1 and
+// increasing with every accepted change
+func (m *Manager) Version() int {
+	return m.active.Load().(*managedScript).version
+}
+
+// Source returns the text of the active script
+func (m *Manager) Source() string {
+	return m.active.Load().(*managedScript).src
+}
+
+// Run evaluates the active script in a fresh child scope holding vars. Runs may
+// happen concurrently, and with reloads.
+func (m *Manager) Run(vars map[string]interface{}) (interface{}, error) {
+	script := m.active.Load().(*managedScript)
+	child := m.scope.NewChild()
+	for k, v := range vars {
+		child.Vars[k] = v
+	}
+	result, err := child.runScript(script.body)
+	return result, locate(script.src, err, child.Options.VerboseErrors)
+}
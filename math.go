@@ -0,0 +1,212 @@
+package goeval
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// mathBuiltins holds the helpers installed by InstallMath
+var mathBuiltins = map[string]interface{}{
+	"abs":   Abs,
+	"ceil":  Ceil,
+	"floor": Floor,
+	"round": Round,
+	"pow":   Pow,
+	"sqrt":  Sqrt,
+	"min":   Min,
+	"max":   Max,
+	"sum":   Sum,
+	"avg":   Avg,
+}
+
+// InstallMath registers the math helpers in the scope, under their bare names
+// when namespace is empty, otherwise grouped under the namespace (math.abs(x)).
+func (s *Scope) InstallMath(namespace string) {
+	s.install(namespace, mathBuiltins)
+}
+
+// Abs returns the absolute value of an int or float
+func Abs(x interface{}) (interface{}, error) {
+	if i, isInt := asInt(x); isInt {
+		if i < 0 {
+			return -i, nil
+		}
+		return i, nil
+	}
+	f, err := numberArg(x)
+	if err != nil {
+		return nil, err
+	}
+	return math.Abs(f), nil
+}
+
+// Ceil returns the least integer value greater than or equal to x
+func Ceil(x interface{}) (interface{}, error) {
+	return roundWith(x, math.Ceil)
+}
+
+// Floor returns the greatest integer value less than or equal to x
+func Floor(x interface{}) (interface{}, error) {
+	return roundWith(x, math.Floor)
+}
+
+// Round returns the nearest integer, rounding half away from zero
+func Round(x interface{}) (interface{}, error) {
+	return roundWith(x, math.Round)
+}
+
+// Pow returns x**y as a float64
+func Pow(x, y interface{}) (interface{}, error) {
+	fx, err := numberArg(x)
+	if err != nil {
+		return nil, err
+	}
+	fy, err := numberArg(y)
+	if err != nil {
+		return nil, err
+	}
+	return math.Pow(fx, fy), nil
+}
+
+// Sqrt returns the square root of x as a float64
+func Sqrt(x interface{}) (interface{}, error) {
+	f, err := numberArg(x)
+	if err != nil {
+		return nil, err
+	}
+	return math.Sqrt(f), nil
+}
+
+// Min returns the smallest of its arguments, which may also be given as a single slice
+func Min(values ...interface{}) (interface{}, error) {
+	return pick(values, func(a, b float64) bool { return a < b })
+}
+
+// Max returns the largest of its arguments, which may also be given as a single slice
+func Max(values ...interface{}) (interface{}, error) {
+	return pick(values, func(a, b float64) bool { return a > b })
+}
+
+// Sum adds up its arguments, which may also be given as a single slice.
+// The result is an int when every value is an integer, else a float64.
+func Sum(values ...interface{}) (interface{}, error) {
+	values = spread(values)
+	intSum, floatSum, allInt := 0, 0.0, true
+	for _, v := range values {
+		if i, isInt := asInt(v); isInt {
+			intSum += i
+			floatSum += float64(i)
+			continue
+		}
+		f, err := numberArg(v)
+		if err != nil {
+			return nil, err
+		}
+		allInt = false
+		floatSum += f
+	}
+	if allInt {
+		return intSum, nil
+	}
+	return floatSum, nil
+}
+
+// Avg returns the arithmetic mean of its arguments as a float64
+func Avg(values ...interface{}) (interface{}, error) {
+	values = spread(values)
+	if len(values) == 0 {
+		return nil, errors.New("avg of no values")
+	}
+	total := 0.0
+	for _, v := range values {
+		f, err := numberArg(v)
+		if err != nil {
+			return nil, err
+		}
+		total += f
+	}
+	return total / float64(len(values)), nil
+}
+
+func roundWith(x interface{}, round func(float64) float64) (interface{}, error) {
+	if i, isInt := asInt(x); isInt {
+		return i, nil
+	}
+	f, err := numberArg(x)
+	if err != nil {
+		return nil, err
+	}
+	return round(f), nil
+}
+
+func pick(values []interface{}, better func(a, b float64) bool) (interface{}, error) {
+	values = spread(values)
+	if len(values) == 0 {
+		return nil, errors.New("no values to compare")
+	}
+	best := values[0]
+	bestF, err := numberArg(best)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range values[1:] {
+		f, err := numberArg(v)
+		if err != nil {
+			return nil, err
+		}
+		if better(f, bestF) {
+			best, bestF = v, f
+		}
+	}
+	return best, nil
+}
+
+// spread expands a single slice argument into its elements
+func spread(values []interface{}) []interface{} {
+	if len(values) != 1 {
+		return values
+	}
+	rv := reflect.ValueOf(values[0])
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return values
+	}
+	spread := make([]interface{}, rv.Len())
+	for i := range spread {
+		spread[i] = rv.Index(i).Interface()
+	}
+	return spread
+}
+
+// asInt reports the value of any integer kind as an int
+func asInt(v interface{}) (int, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int(rv.Uint()), true
+	}
+	return 0, false
+}
+
+// asFloat reports the value of any integer or float kind as a float64
+func asFloat(v interface{}) (float64, bool) {
+	if i, isInt := asInt(v); isInt {
+		return float64(i), true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+func numberArg(v interface{}) (float64, error) {
+	if f, ok := asFloat(v); ok {
+		return f, nil
+	}
+	return 0, fmt.Errorf("%#v is not a number", v)
+}
@@ -0,0 +1,81 @@
+package goeval
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Modules is a Resolver serving script modules: named sources evaluated once,
+// on first import, each in its own scope. The names a module defines starting
+// with an upper case letter make up its exported symbols. Scripts load modules
+// with import or use declarations:
+//
+//	use "pricing/common"
+//	return common.Discount(price)
+type Modules struct {
+	base    *Scope
+	mu      sync.Mutex
+	sources map[string]string
+	loaded  map[string]Namespace
+}
+
+// NewModules creates an empty module registry. Modules are evaluated in child
+// scopes of base, so they see its variables and options; base may be nil.
+func NewModules(base *Scope) *Modules {
+	if base == nil {
+		base = NewScope()
+	}
+	return &Modules{base: base, sources: map[string]string{}, loaded: map[string]Namespace{}}
+}
+
+// Register adds or replaces the source of a module. A replaced module is
+// evaluated again on its next import.
+func (m *Modules) Register(name, src string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sources[name] = src
+	delete(m.loaded, name)
+}
+
+// Resolve returns the exported symbols of the named module, evaluating it first
+// if needed
+func (m *Modules) Resolve(name string) (Namespace, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.load(name, nil)
+}
+
+// load evaluates a module with m.mu held. chain lists the modules being loaded,
+// to report import cycles.
+func (m *Modules) load(name string, chain []string) (Namespace, error) {
+	if ns, ok := m.loaded[name]; ok {
+		return ns, nil
+	}
+	for _, loading := range chain {
+		if loading == name {
+			return nil, fmt.Errorf("goeval: import cycle: %s -> %s", strings.Join(chain, " -> "), name)
+		}
+	}
+	src, ok := m.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("goeval: module %q not found", name)
+	}
+	chain = append(chain[:len(chain):len(chain)], name)
+	scope := m.base.NewChild()
+	scope.Options.Resolver = ResolverFunc(func(dep string) (Namespace, error) {
+		return m.load(dep, chain)
+	})
+	if _, err := scope.Eval(src); err != nil {
+		return nil, fmt.Errorf("goeval: module %q: %v", name, err)
+	}
+	ns := Namespace{}
+	for k, v := range scope.Vars {
+		if r := []rune(k); len(r) > 0 && unicode.IsUpper(r[0]) {
+			ns[k] = v
+		}
+	}
+	m.loaded[name] = ns
+	return ns, nil
+}
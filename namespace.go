@@ -0,0 +1,98 @@
+package goeval
+
+import (
+	"fmt"
+	"go/ast"
+	"strings"
+)
+
+// Register sets value under a dotted path, eg "billing.discount" or
+// "geo.metric.distance", creating the namespaces along the way, so that large
+// hosts can organize what they expose without collisions:
+//
+//	s.Register("geo.distance", Distance)
+//	s.Eval(`geo.distance(a, b) < 10`)
+//
+// A path without a dot sets a variable, as Set does in s.
+func (s *Scope) Register(path string, value interface{}) error {
+	i := strings.LastIndexByte(path, '.')
+	if i < 0 {
+		if path == "" {
+			return fmt.Errorf("goeval: cannot register an empty name")
+		}
+		s.Vars[path] = value
+		return nil
+	}
+	ns, err := s.namespace(path[:i], false)
+	if err != nil {
+		return err
+	}
+	name := path[i+1:]
+	if name == "" {
+		return fmt.Errorf("goeval: invalid name %q", path)
+	}
+	ns[name] = value
+	return nil
+}
+
+// namespace returns the namespace of s at the dotted path, creating it and the
+// namespaces above it when missing. Other values in the way are an error,
+// unless replace is set.
+func (s *Scope) namespace(path string, replace bool) (Namespace, error) {
+	names := strings.Split(path, ".")
+	for _, name := range names {
+		if name == "" {
+			return nil, fmt.Errorf("goeval: invalid namespace %q", path)
+		}
+	}
+	ns, ok := s.Vars[names[0]].(Namespace)
+	if !ok {
+		if v, exists := s.Vars[names[0]]; exists && !replace {
+			return nil, fmt.Errorf("goeval: %s is a %T, not a namespace", names[0], v)
+		}
+		ns = Namespace{}
+		s.Vars[names[0]] = ns
+	}
+	for i, name := range names[1:] {
+		inner, ok := ns[name].(Namespace)
+		if !ok {
+			if v, exists := ns[name]; exists && !replace {
+				return nil, fmt.Errorf("goeval: %s is a %T, not a namespace", strings.Join(names[:i+2], "."), v)
+			}
+			inner = Namespace{}
+			ns[name] = inner
+		}
+		ns = inner
+	}
+	return ns, nil
+}
+
+// lookupPath is lookup for the dotted paths of namespace members
+func (s *Scope) lookupPath(path string) (interface{}, bool) {
+	names := strings.Split(path, ".")
+	v, ok := s.lookup(names[0])
+	for _, name := range names[1:] {
+		ns, isNs := v.(Namespace)
+		if !ok || !isNs {
+			return nil, false
+		}
+		v, ok = ns[name]
+	}
+	return v, ok
+}
+
+// namespaceExpr returns the namespace an identifier or a selector chain of
+// namespace members denotes, if any
+func (s *Scope) namespaceExpr(expr ast.Expr) (Namespace, bool) {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		ns, ok := s.Get(e.Name).(Namespace)
+		return ns, ok
+	case *ast.SelectorExpr:
+		if outer, ok := s.namespaceExpr(e.X); ok {
+			ns, ok := outer[e.Sel.Name].(Namespace)
+			return ns, ok
+		}
+	}
+	return nil, false
+}
@@ -3,7 +3,9 @@ package goeval
 import (
 	"fmt"
 	"go/token"
+	"math"
 	"reflect"
+	"time"
 )
 
 var opNames = map[token.Token]string{
@@ -30,10 +32,51 @@ var opNames = map[token.Token]string{
 	token.GEQ:     `>=`,
 }
 
+// binaryOp executes a binary operation for the scripts of s, checking the
+// strings it concatenates against Options.Limits
+func (s *Scope) binaryOp(x, y interface{}, op token.Token) (interface{}, error) {
+	if op == token.ADD && s.Options.Limits.MaxStringLen > 0 {
+		xs, isString := x.(string)
+		ys, _ := y.(string)
+		if isString {
+			if err := s.Options.Limits.checkLen(reflect.String, len(xs)+len(ys)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return binaryOp(x, y, op)
+}
+
 // binaryOp executes the corresponding binary operation (+, -, etc) on two interfaces.
 func binaryOp(xI, yI interface{}, op token.Token) (interface{}, error) {
+	if (xI == nil || yI == nil) && (op == token.EQL || op == token.NEQ) {
+		// nil reaches us untyped, so compare against the nil-ness of the other side
+		bothNil := isNil(xI) && isNil(yI)
+		if op == token.EQL {
+			return bothNil, nil
+		}
+		return !bothNil, nil
+	}
+	if t, isTime := xI.(time.Time); isTime {
+		return timeOp(t, yI, op)
+	}
+	if t, isTime := yI.(time.Time); isTime && op == token.ADD {
+		return timeOp(t, xI, op) // d + t
+	}
+	if op != token.SHL && op != token.SHR {
+		var err error
+		if xI, yI, err = promoteNumbers(xI, yI); err != nil {
+			return nil, err
+		}
+	}
 	typeX := reflect.TypeOf(xI)
 	typeY := reflect.TypeOf(yI)
+	if (op == token.QUO || op == token.REM) && isIntKind(typeY) && reflect.ValueOf(yI).IsZero() {
+		return nil, fmt.Errorf("goeval: integer divide by zero")
+	}
+	if isStringKind(typeX) && isNumberKind(typeY) || isNumberKind(typeX) && isStringKind(typeY) {
+		return nil, fmt.Errorf("mismatched types %v and %v for %s", typeX, typeY, getOpName(op))
+	}
 	if typeX == typeY {
 		switch xI.(type) {
 		case string:
@@ -42,6 +85,14 @@ func binaryOp(xI, yI interface{}, op token.Token) (interface{}, error) {
 			switch op {
 			case token.ADD:
 				return x + y, nil
+			case token.LSS:
+				return x < y, nil
+			case token.GTR:
+				return x > y, nil
+			case token.LEQ:
+				return x <= y, nil
+			case token.GEQ:
+				return x >= y, nil
 			}
 		case int:
 			x := xI.(int)
@@ -464,37 +515,11 @@ func binaryOp(xI, yI interface{}, op token.Token) (interface{}, error) {
 			}
 		}
 	}
-	yUint, isUint := yI.(uint64)
-	if !isUint {
-		isUint = true
-		switch yV := yI.(type) {
-		case int:
-			yUint = uint64(yV)
-		case int8:
-			yUint = uint64(yV)
-		case int16:
-			yUint = uint64(yV)
-		case int32:
-			yUint = uint64(yV)
-		case int64:
-			yUint = uint64(yV)
-		case uint8:
-			yUint = uint64(yV)
-		case uint16:
-			yUint = uint64(yV)
-		case uint32:
-			yUint = uint64(yV)
-		case uint64:
-			yUint = yV
-		case float32:
-			yUint = uint64(yV)
-		case float64:
-			yUint = uint64(yV)
-		default:
-			isUint = false
+	if op == token.SHL || op == token.SHR {
+		yUint, err := shiftCount(yI)
+		if err != nil {
+			return nil, err
 		}
-	}
-	if isUint {
 		switch xI.(type) {
 		case int:
 			x := xI.(int)
@@ -597,8 +622,17 @@ func binaryOp(xI, yI interface{}, op token.Token) (interface{}, error) {
 			}
 		}
 	}
+	if typeX == typeY && isRealKind(typeX) && typeX != builtinTypes[typeX.Kind().String()] {
+		return namedNumberOp(xI, yI, op)
+	}
 	// Anything
 	switch op {
+	case token.EQL, token.NEQ:
+		if !isComparable(xI) || !isComparable(yI) {
+			return nil, fmt.Errorf("cannot compare %T %s %T, use equals(a, b) instead", xI, getOpName(op), yI)
+		}
+	}
+	switch op {
 	case token.EQL:
 		return xI == yI, nil
 	case token.NEQ:
@@ -607,142 +641,269 @@ func binaryOp(xI, yI interface{}, op token.Token) (interface{}, error) {
 	return nil, fmt.Errorf("unknown operation %#v between %#v and %#v", getOpName(op), xI, yI)
 }
 
-// unaryOp computes the corresponding unary (+x, -x) operation on an interface.
-func unaryOp(xI interface{}, op token.Token) (interface{}, error) {
-	switch xI.(type) {
-	case bool:
-		x := xI.(bool)
-		switch op {
-		case token.NOT:
-			return !x, nil
-		}
-	case int:
-		x := xI.(int)
-		switch op {
-		case token.ADD:
-			return +x, nil
-		case token.SUB:
-			return -x, nil
-		}
-	case int8:
-		x := xI.(int8)
-		switch op {
-		case token.ADD:
-			return +x, nil
-		case token.SUB:
-			return -x, nil
-		}
-	case int16:
-		x := xI.(int16)
-		switch op {
-		case token.ADD:
-			return +x, nil
-		case token.SUB:
-			return -x, nil
-		}
-	case int32:
-		x := xI.(int32)
-		switch op {
-		case token.ADD:
-			return +x, nil
-		case token.SUB:
-			return -x, nil
-		}
-	case int64:
-		x := xI.(int64)
+// timeOp applies op to a time.Time and y: subtracting a time gives a
+// time.Duration, adding or subtracting a duration gives a time, and times compare
+// with Before, After and Equal
+func timeOp(t time.Time, yI interface{}, op token.Token) (interface{}, error) {
+	switch y := yI.(type) {
+	case time.Time:
 		switch op {
-		case token.ADD:
-			return +x, nil
-		case token.SUB:
-			return -x, nil
-		}
-	case uint:
-		x := xI.(uint)
-		switch op {
-		case token.ADD:
-			return +x, nil
 		case token.SUB:
-			return -x, nil
+			return t.Sub(y), nil
+		case token.EQL:
+			return t.Equal(y), nil
+		case token.NEQ:
+			return !t.Equal(y), nil
+		case token.LSS:
+			return t.Before(y), nil
+		case token.GTR:
+			return t.After(y), nil
+		case token.LEQ:
+			return !t.After(y), nil
+		case token.GEQ:
+			return !t.Before(y), nil
 		}
-	case uint8:
-		x := xI.(uint8)
+	case time.Duration:
 		switch op {
 		case token.ADD:
-			return +x, nil
+			return t.Add(y), nil
 		case token.SUB:
-			return -x, nil
+			return t.Add(-y), nil
 		}
-	case uint16:
-		x := xI.(uint16)
-		switch op {
-		case token.ADD:
-			return +x, nil
-		case token.SUB:
-			return -x, nil
-		}
-	case uint32:
-		x := xI.(uint32)
-		switch op {
-		case token.ADD:
-			return +x, nil
-		case token.SUB:
-			return -x, nil
+	}
+	return nil, fmt.Errorf("invalid operation: time.Time %s %T", getOpName(op), yI)
+}
+
+// namedNumberOp applies op to two numbers of the same named type, eg
+// time.Duration, as to their underlying type. Results other than bools have
+// the named type.
+func namedNumberOp(xI, yI interface{}, op token.Token) (interface{}, error) {
+	typ := reflect.TypeOf(xI)
+	basic := builtinTypes[typ.Kind().String()]
+	result, err := binaryOp(reflect.ValueOf(xI).Convert(basic).Interface(), reflect.ValueOf(yI).Convert(basic).Interface(), op)
+	if err != nil {
+		return nil, err
+	}
+	if _, isBool := result.(bool); isBool {
+		return result, nil
+	}
+	return reflect.ValueOf(result).Convert(typ).Interface(), nil
+}
+
+// shiftCount validates the right operand of a shift, which must be a non-negative integer
+func shiftCount(yI interface{}) (uint64, error) {
+	rv := reflect.ValueOf(yI)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rv.Int() < 0 {
+			return 0, fmt.Errorf("negative shift count %v", yI)
 		}
-	case uint64:
-		x := xI.(uint64)
-		switch op {
-		case token.ADD:
-			return +x, nil
-		case token.SUB:
-			return -x, nil
+		return uint64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint(), nil
+	}
+	return 0, fmt.Errorf("invalid shift count %#v", yI)
+}
+
+// promoteNumbers brings two numeric operands of different kinds to a common kind:
+//   - a plain int is converted to the kind of the other operand, the way an
+//     untyped constant would be, failing if it does not fit (flags&4 on a uint64)
+//   - otherwise, if either operand is a float, both become float64
+//   - otherwise both become int64, or uint64 when both are unsigned
+//
+// Operands of the same type, or that are not both real numbers, are returned unchanged.
+func promoteNumbers(xI, yI interface{}) (interface{}, interface{}, error) {
+	typeX, typeY := reflect.TypeOf(xI), reflect.TypeOf(yI)
+	if typeX == typeY || !isRealKind(typeX) || !isRealKind(typeY) {
+		return xI, yI, nil
+	}
+	var err error
+	if x, isInt := xI.(int); isInt {
+		xI, err = convertInt(x, typeY)
+		return xI, yI, err
+	}
+	if y, isInt := yI.(int); isInt {
+		yI, err = convertInt(y, typeX)
+		return xI, yI, err
+	}
+	if !isIntKind(typeX) || !isIntKind(typeY) {
+		x, _ := asFloat(xI)
+		y, _ := asFloat(yI)
+		return x, y, nil
+	}
+	if isUintKind(typeX) && isUintKind(typeY) {
+		return reflect.ValueOf(xI).Uint(), reflect.ValueOf(yI).Uint(), nil
+	}
+	x, err := toInt64(xI)
+	if err != nil {
+		return nil, nil, err
+	}
+	y, err := toInt64(yI)
+	return x, y, err
+}
+
+func isRealKind(t reflect.Type) bool {
+	if isIntKind(t) {
+		return true
+	}
+	return t != nil && (t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64)
+}
+
+func isUintKind(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// toInt64 widens any integer kind to int64, failing for unsigned values above math.MaxInt64
+func toInt64(v interface{}) (int64, error) {
+	rv := reflect.ValueOf(v)
+	if isUintKind(rv.Type()) {
+		if rv.Uint() > math.MaxInt64 {
+			return 0, fmt.Errorf("%v overflows int64", v)
 		}
-	case uintptr:
-		x := xI.(uintptr)
-		switch op {
-		case token.ADD:
-			return +x, nil
-		case token.SUB:
-			return -x, nil
+		return int64(rv.Uint()), nil
+	}
+	return rv.Int(), nil
+}
+
+func isIntKind(t reflect.Type) bool {
+	if t == nil {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+func isStringKind(t reflect.Type) bool {
+	return t != nil && t.Kind() == reflect.String
+}
+
+func isNumberKind(t reflect.Type) bool {
+	if isIntKind(t) {
+		return true
+	}
+	if t == nil {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	}
+	return false
+}
+
+// convertInt converts i to the number type t, failing if the value overflows
+func convertInt(i int, t reflect.Type) (interface{}, error) {
+	v := reflect.ValueOf(i).Convert(t)
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+		// any int is representable, if not exactly
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i < 0 || v.Uint() != uint64(i) {
+			return nil, fmt.Errorf("%d overflows %v", i, t)
 		}
-	case float32:
-		x := xI.(float32)
-		switch op {
-		case token.ADD:
-			return +x, nil
-		case token.SUB:
-			return -x, nil
+	default:
+		if v.Int() != int64(i) {
+			return nil, fmt.Errorf("%d overflows %v", i, t)
 		}
-	case float64:
-		x := xI.(float64)
-		switch op {
-		case token.ADD:
-			return +x, nil
-		case token.SUB:
-			return -x, nil
+	}
+	return v.Interface(), nil
+}
+
+// deepEqualOp compares two values with reflect.DeepEqual for == and !=
+func deepEqualOp(xI, yI interface{}, op token.Token) (interface{}, error) {
+	if xI == nil || yI == nil {
+		return binaryOp(xI, yI, op)
+	}
+	switch op {
+	case token.EQL:
+		return reflect.DeepEqual(xI, yI), nil
+	case token.NEQ:
+		return !reflect.DeepEqual(xI, yI), nil
+	}
+	return binaryOp(xI, yI, op)
+}
+
+// isComparable reports whether v can be compared with == without panicking
+func isComparable(v interface{}) bool {
+	return v == nil || reflect.TypeOf(v).Comparable()
+}
+
+// isNil reports whether v is nil or a nil pointer, map, slice, chan, func or interface
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func, reflect.Interface, reflect.UnsafePointer:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// unaryOp computes a unary operation for the scripts of s, receiving from a
+// channel unless the evaluation context is done first
+func (s *Scope) unaryOp(xI interface{}, op token.Token) (interface{}, error) {
+	x := reflect.ValueOf(xI)
+	if op != token.ARROW || !x.IsValid() || x.Kind() != reflect.Chan || x.Type().ChanDir()&reflect.RecvDir == 0 {
+		return unaryOp(xI, op)
+	}
+	v, ok, err := chanOp(s.context(), reflect.SelectCase{Dir: reflect.SelectRecv, Chan: x})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return reflect.Zero(x.Type().Elem()).Interface(), nil
+	}
+	return v.Interface(), nil
+}
+
+// unaryOp computes the corresponding unary (+x, -x) operation on an interface.
+func unaryOp(xI interface{}, op token.Token) (interface{}, error) {
+	x := reflect.ValueOf(xI)
+	if !x.IsValid() {
+		return nil, fmt.Errorf("goeval: invalid operation: operator %s not defined on nil", op)
+	}
+	typ := x.Type()
+	switch op {
+	case token.NOT:
+		if typ.Kind() == reflect.Bool {
+			return reflect.ValueOf(!x.Bool()).Convert(typ).Interface(), nil
 		}
-	case complex64:
-		x := xI.(complex64)
-		switch op {
-		case token.ADD:
-			return +x, nil
-		case token.SUB:
-			return -x, nil
+		return nil, fmt.Errorf("goeval: invalid operation: operator ! not defined on %#v (%v), it needs a bool", xI, typ)
+	case token.ADD:
+		if isNumberKind(typ) {
+			return xI, nil
 		}
-	case complex128:
-		x := xI.(complex128)
-		switch op {
-		case token.ADD:
-			return +x, nil
-		case token.SUB:
-			return -x, nil
+	case token.SUB:
+		switch typ.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return reflect.ValueOf(-x.Int()).Convert(typ).Interface(), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			// wraps around like Go does for unsigned integers
+			return reflect.ValueOf(-x.Uint()).Convert(typ).Interface(), nil
+		case reflect.Float32, reflect.Float64:
+			return reflect.ValueOf(-x.Float()).Convert(typ).Interface(), nil
+		case reflect.Complex64, reflect.Complex128:
+			return reflect.ValueOf(-x.Complex()).Convert(typ).Interface(), nil
 		}
-	case reflect.Value:
-		switch op {
-		case token.AND:
-			return xI.(reflect.Value).Addr().Interface(), nil
+	case token.XOR:
+		switch {
+		case isUintKind(typ):
+			return reflect.ValueOf(^x.Uint()).Convert(typ).Interface(), nil
+		case isIntKind(typ):
+			return reflect.ValueOf(^x.Int()).Convert(typ).Interface(), nil
 		}
 	}
-	return nil, fmt.Errorf("unknown unary operation %#v on %#v", getOpName(op), xI)
+	return nil, fmt.Errorf("goeval: invalid operation: operator %s not defined on %#v (%v)", op, xI, typ)
 }
 
 func getOpName(op token.Token) string {
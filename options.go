@@ -0,0 +1,106 @@
+package goeval
+
+import (
+	"io"
+	"time"
+)
+
+// Option configures a scope created by NewScope
+//
+//	s := NewScope(WithTimeout(time.Second), WithOutput(&buf), WithVars(vars))
+type Option func(*Scope)
+
+// WithOptions sets all the options at once; later options refine them
+func WithOptions(o Options) Option {
+	return func(s *Scope) { s.Options = o }
+}
+
+// WithVars sets variables in the scope
+func WithVars(vars map[string]interface{}) Option {
+	return func(s *Scope) {
+		for k, v := range vars {
+			s.Vars[k] = v
+		}
+	}
+}
+
+// WithTimeout bounds every evaluation, see Options.Timeout
+func WithTimeout(d time.Duration) Option {
+	return func(s *Scope) { s.Options.Timeout = d }
+}
+
+// WithCallTimeout bounds every call of a host function, see Options.CallTimeout
+func WithCallTimeout(d time.Duration) Option {
+	return func(s *Scope) { s.Options.CallTimeout = d }
+}
+
+// WithMaxDepth bounds the nesting of evaluations, see Options.MaxDepth
+func WithMaxDepth(n int) Option {
+	return func(s *Scope) { s.Options.MaxDepth = n }
+}
+
+// WithLimits caps the sizes of the values scripts build, see Options.Limits
+func WithLimits(l Limits) Option {
+	return func(s *Scope) { s.Options.Limits = l }
+}
+
+// WithMaxGoroutines limits the script goroutines running at once
+func WithMaxGoroutines(n int) Option {
+	return func(s *Scope) { s.Options.MaxGoroutines = n }
+}
+
+// WithOutput sends what scripts print to w
+func WithOutput(w io.Writer) Option {
+	return func(s *Scope) { s.Options.Output = w }
+}
+
+// WithLogger logs evaluations to l, see Options.Logger
+func WithLogger(l Logger) Option {
+	return func(s *Scope) { s.Options.Logger = l }
+}
+
+// WithTracer traces evaluations with t, see Options.Tracer
+func WithTracer(t Tracer) Option {
+	return func(s *Scope) { s.Options.Tracer = t }
+}
+
+// WithQuota admits evaluations with q, see Options.Quota
+func WithQuota(q Quota) Option {
+	return func(s *Scope) { s.Options.Quota = q }
+}
+
+// WithLiterals adds literal hooks, see Options.Literals
+func WithLiterals(hooks ...LiteralHook) Option {
+	return func(s *Scope) { s.Options.Literals = append(s.Options.Literals, hooks...) }
+}
+
+// WithResolver provides the packages scripts import
+func WithResolver(r Resolver) Option {
+	return func(s *Scope) { s.Options.Resolver = r }
+}
+
+// WithLoader enables the include builtin, loading scripts with l
+func WithLoader(l Loader) Option {
+	return func(s *Scope) { s.Options.Loader = l }
+}
+
+// WithEnv enables the env builtin, exposing the variables env allows
+func WithEnv(env EnvLookup) Option {
+	return func(s *Scope) { s.Options.Env = env }
+}
+
+// WithVerboseErrors makes errors quote the failing source line, see
+// Options.VerboseErrors
+func WithVerboseErrors() Option {
+	return func(s *Scope) { s.Options.VerboseErrors = true }
+}
+
+// WithJSON sets how values are encoded as JSON, see JSONOptions
+func WithJSON(opts JSONOptions) Option {
+	return func(s *Scope) { s.Options.JSON = opts }
+}
+
+// WithPure rejects side effects, see Options.Pure
+func WithPure() Option {
+	return func(s *Scope) { s.Options.Pure = true }
+}
@@ -0,0 +1,103 @@
+package goeval
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Get walks data along a dotted/bracketed path such as "items[0].price.amount",
+// descending through maps, slices, arrays, structs and pointers. When the path
+// does not exist it returns the optional default, or nil.
+func Get(data interface{}, path string, def ...interface{}) (interface{}, error) {
+	keys, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	missing := interface{}(nil)
+	if len(def) > 0 {
+		missing = def[0]
+	}
+	cur := reflect.ValueOf(data)
+	for _, key := range keys {
+		cur = step(cur, key)
+		if !cur.IsValid() {
+			return missing, nil
+		}
+	}
+	return cur.Interface(), nil
+}
+
+// splitPath splits "a.b[0]['c d']" into a, b, 0 and c d
+func splitPath(path string) ([]string, error) {
+	var keys []string
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("get: unclosed bracket in path %q", path)
+			}
+			key := path[i+1 : i+end]
+			if unquoted, err := strconv.Unquote(key); err == nil {
+				key = unquoted
+			} else if len(key) > 1 && key[0] == '\'' && key[len(key)-1] == '\'' {
+				key = key[1 : len(key)-1]
+			}
+			keys = append(keys, key)
+			i += end + 1
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			keys = append(keys, path[i:i+end])
+			i += end
+		}
+	}
+	return keys, nil
+}
+
+// step descends one key into v, returning the invalid Value when there is nothing there
+func step(v reflect.Value, key string) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return v
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		k := reflect.ValueOf(key)
+		if v.Type().Key().Kind() != reflect.String {
+			n, err := strconv.ParseInt(key, 10, 64)
+			if err != nil {
+				return reflect.Value{}
+			}
+			k = reflect.ValueOf(n)
+		}
+		if !k.Type().ConvertibleTo(v.Type().Key()) {
+			return reflect.Value{}
+		}
+		return v.MapIndex(k.Convert(v.Type().Key()))
+	case reflect.Slice, reflect.Array:
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= v.Len() {
+			return reflect.Value{}
+		}
+		return v.Index(i)
+	case reflect.Struct:
+		field := v.FieldByName(key)
+		if !field.IsValid() || !field.CanInterface() {
+			return reflect.Value{}
+		}
+		return field
+	}
+	return reflect.Value{}
+}
@@ -0,0 +1,145 @@
+package goeval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxPlaygroundRequest bounds the size of the scripts and variables posted to
+// the playground
+const maxPlaygroundRequest = 1 << 20
+
+// PlaygroundRequest is what the playground UI posts to run a script
+type PlaygroundRequest struct {
+	Src  string                 `json:"src"`
+	Vars map[string]interface{} `json:"vars"`
+}
+
+// PlaygroundResponse is the outcome of a playground run
+type PlaygroundResponse struct {
+	Result interface{} `json:"result"`
+	Type   string      `json:"type"`
+	Output string      `json:"output"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// Playground is an http.Handler serving a page to experiment with scripts:
+// GET serves the page, a textarea for the script and one for its variables as
+// JSON, and POST runs a PlaygroundRequest. Scripts run in child scopes of
+// base, a Sandboxed preset scope when nil.
+//
+//	http.Handle("/playground", goeval.Playground(nil))
+func Playground(base *Scope) http.Handler {
+	if base == nil {
+		base = NewPresetScope(Sandboxed)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, playgroundPage)
+		case http.MethodPost:
+			var req PlaygroundRequest
+			if err := json.NewDecoder(io.LimitReader(r.Body, maxPlaygroundRequest)).Decode(&req); err != nil {
+				http.Error(w, "goeval: invalid request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(playgroundRun(r.Context(), base, req))
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// playgroundRun runs a request, until the client goes away at the latest
+func playgroundRun(ctx context.Context, base *Scope, req PlaygroundRequest) PlaygroundResponse {
+	var output bytes.Buffer
+	child := base.NewChild()
+	child.Options.Output = &output
+	for k, v := range req.Vars {
+		child.Vars[k] = v
+	}
+	result, err := child.EvalContext(ctx, req.Src)
+	resp := PlaygroundResponse{Output: output.String()}
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	resp.Type = fmt.Sprintf("%T", result)
+	if _, err := json.Marshal(result); err == nil {
+		resp.Result = result
+	} else {
+		resp.Result = fmt.Sprint(result) // funcs, channels, cyclic values...
+	}
+	return resp
+}
+
+const playgroundPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>goeval playground</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+textarea { width: 100%; font-family: monospace; font-size: 14px; }
+pre { background: #f4f4f4; padding: 1em; white-space: pre-wrap; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>goeval playground</h1>
+<p>Script</p>
+<textarea id="src" rows="14">total := 0
+for _, p := range prices {
+	total += p
+}
+total * (1 - discount)</textarea>
+<p>Variables (JSON)</p>
+<textarea id="vars" rows="6">{"prices": [10, 20.5, 4], "discount": 0.1}</textarea>
+<p><button id="run">Run</button> <small>Ctrl+Enter</small></p>
+<pre id="out"></pre>
+<script>
+const out = document.getElementById("out");
+async function run() {
+	let vars;
+	try {
+		vars = JSON.parse(document.getElementById("vars").value || "{}");
+	} catch (e) {
+		out.className = "error";
+		out.textContent = "variables: " + e.message;
+		return;
+	}
+	out.className = "";
+	out.textContent = "running...";
+	const resp = await fetch(location.pathname, {
+		method: "POST",
+		headers: {"Content-Type": "application/json"},
+		body: JSON.stringify({src: document.getElementById("src").value, vars: vars}),
+	});
+	if (!resp.ok) {
+		out.className = "error";
+		out.textContent = await resp.text();
+		return;
+	}
+	const r = await resp.json();
+	let text = r.output ? r.output + "\n" : "";
+	if (r.error) {
+		out.className = "error";
+		text += r.error;
+	} else {
+		text += JSON.stringify(r.result, null, 2) + "  (" + r.type + ")";
+	}
+	out.textContent = text;
+}
+document.getElementById("run").onclick = run;
+document.addEventListener("keydown", e => { if (e.ctrlKey && e.key === "Enter") run(); });
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,85 @@
+package goeval
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"plugin"
+	"strings"
+)
+
+// Plugin is a bundle of builtins loaded from a Go plugin, so that a running
+// service can gain functions without being rebuilt. A plugin is a main package
+// built with -buildmode=plugin that exports the variable
+//
+//	var Builtins = map[string]interface{}{"discount": Discount}
+//
+// and may export a Name string, which otherwise is the file name without its
+// extension. Plugins only load on the platforms the plugin package supports,
+// into hosts built with the same Go version and dependencies.
+type Plugin struct {
+	Name     string
+	Path     string
+	Builtins map[string]interface{}
+}
+
+// LoadPlugin opens the plugin at path and reads its builtins
+func LoadPlugin(path string) (*Plugin, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("goeval: loading plugin %s: %v", path, err)
+	}
+	return pluginBundle(path, p.Lookup)
+}
+
+// LoadPlugins loads every .so file of dir, in the order of their names. Go plugins cannot be
+// unloaded; loading a path again returns the plugin already loaded.
+func LoadPlugins(dir string) ([]*Plugin, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var plugins []*Plugin
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".so" {
+			continue
+		}
+		p, err := LoadPlugin(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, p)
+	}
+	return plugins, nil
+}
+
+// pluginBundle reads the symbols of the plugin at path through lookup
+func pluginBundle(path string, lookup func(string) (plugin.Symbol, error)) (*Plugin, error) {
+	sym, err := lookup("Builtins")
+	if err != nil {
+		return nil, fmt.Errorf("goeval: plugin %s exports no Builtins", path)
+	}
+	builtins, ok := sym.(*map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("goeval: plugin %s: Builtins is a %T, not a map[string]interface{}", path, sym)
+	}
+	p := &Plugin{
+		Name:     strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Path:     path,
+		Builtins: *builtins,
+	}
+	if sym, err := lookup("Name"); err == nil {
+		name, ok := sym.(*string)
+		if !ok {
+			return nil, fmt.Errorf("goeval: plugin %s: Name is a %T, not a string", path, sym)
+		}
+		p.Name = *name
+	}
+	return p, nil
+}
+
+// InstallPlugin registers the builtins of p in the scope, under their bare
+// names when namespace is empty, otherwise grouped under the namespace
+func (s *Scope) InstallPlugin(p *Plugin, namespace string) {
+	s.install(namespace, p.Builtins)
+}
@@ -0,0 +1,64 @@
+package goeval
+
+import "sync"
+
+// ScopePool hands out child scopes of a shared parent, recycling them so that
+// high-throughput hosts do not allocate and repopulate a scope per evaluation.
+// The parent is frozen by NewScopePool: scripts can read its variables but no
+// longer assign them, which keeps it safe to share between concurrent
+// evaluations. The host may still change it with Set.
+//
+//	pool := goeval.NewScopePool(base)
+//	s := pool.Get()
+//	defer pool.Put(s)
+//	s.Vars["order"] = order
+//	ok, err := s.Eval(`order.Total < 1000`)
+type ScopePool struct {
+	parent *Scope
+	pool   sync.Pool
+}
+
+// NewScopePool creates a pool of child scopes of parent, and freezes parent
+func NewScopePool(parent *Scope) *ScopePool {
+	parent.frozen = true
+	p := &ScopePool{parent: parent}
+	p.pool.New = func() interface{} {
+		return parent.NewChild()
+	}
+	return p
+}
+
+// Get returns an empty child scope of the parent, reused when possible
+func (p *ScopePool) Get() *Scope {
+	return p.pool.Get().(*Scope)
+}
+
+// Put resets s and returns it to the pool. s must come from Get, and must not
+// be used afterwards, nor by goroutines its scripts left running.
+func (p *ScopePool) Put(s *Scope) {
+	if s == nil || s.Parent != p.parent {
+		return
+	}
+	vars := s.Vars
+	for k := range vars {
+		delete(vars, k)
+	}
+	*s = Scope{
+		Vars:    vars,
+		Parent:  p.parent,
+		Options: p.parent.Options,
+		tasks:   p.parent.heldTasks(),
+		depth:   p.parent.depth,
+	}
+	p.pool.Put(s)
+}
+
+// Eval evaluates src in a pooled scope holding vars
+func (p *ScopePool) Eval(src string, vars map[string]interface{}) (interface{}, error) {
+	s := p.Get()
+	defer p.Put(s)
+	for k, v := range vars {
+		s.Vars[k] = v
+	}
+	return s.Eval(src)
+}
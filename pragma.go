@@ -0,0 +1,97 @@
+package goeval
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pragmaPrefix starts the comments through which a script asks for options
+const pragmaPrefix = "//goeval:"
+
+// withPragmas applies the pragmas of src, the comments starting with
+// //goeval: before its first statement, to a copy of s for one evaluation.
+// Pragmas can only tighten the options of s:
+//
+//	//goeval:timeout=500ms  bounds the evaluation, when shorter than Options.Timeout
+//	//goeval:maxDepth=100   bounds its nesting, when lower than Options.MaxDepth
+//	//goeval:pure           sets Options.Pure
+//	//goeval:verbose        sets Options.VerboseErrors
+//	//goeval:strict         fails before running when Check finds undefined names
+//
+// Scopes without pragmas are returned as they are.
+func (s *Scope) withPragmas(src string) (*Scope, error) {
+	if !strings.Contains(src, pragmaPrefix) {
+		return s, nil
+	}
+	run := *s // shares Vars, only changes the options
+	run.tasks = s.taskGroup()
+	strict := false
+	for _, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+		if !strings.HasPrefix(line, pragmaPrefix) {
+			continue
+		}
+		name, value := line[len(pragmaPrefix):], ""
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+		if err := run.pragma(name, value, &strict); err != nil {
+			return nil, fmt.Errorf("goeval: pragma %s: %v", line, err)
+		}
+	}
+	if strict {
+		if diags := run.Check(src); len(diags) > 0 {
+			return nil, fmt.Errorf("goeval: strict: %d:%d: %s", diags[0].Line, diags[0].Column, diags[0].Message)
+		}
+	}
+	return &run, nil
+}
+
+func (s *Scope) pragma(name, value string, strict *bool) error {
+	switch name {
+	case "timeout":
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		if s.Options.Timeout == 0 || d < s.Options.Timeout {
+			s.Options.Timeout = d
+		}
+		return nil
+	case "maxDepth":
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid depth %q", value)
+		}
+		max := s.Options.MaxDepth
+		if max <= 0 {
+			max = DefaultMaxDepth
+		}
+		if n < max {
+			s.Options.MaxDepth = n
+		}
+		return nil
+	}
+	if value != "" {
+		return fmt.Errorf("%s takes no value", name)
+	}
+	switch name {
+	case "pure":
+		s.Options.Pure = true
+	case "verbose":
+		s.Options.VerboseErrors = true
+	case "strict":
+		*strict = true
+	default:
+		return fmt.Errorf("unknown pragma %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package goeval
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+)
+
+// Predicate is a condition parsed once and matched against many sets of
+// variables, as for feature flags, alert conditions or row filters. Unlike an
+// Evaluator, a Predicate may be matched by several goroutines at once.
+//
+//	p, err := s.CompilePredicate(`country == "FR" && age >= 18`)
+//	ok, err := p.Match(map[string]interface{}{"country": "FR", "age": 20})
+type Predicate struct {
+	scope  *Scope
+	body   *ast.BlockStmt
+	cond   ast.Expr // the whole script, when it is a single expression
+	fields []string
+	src    string
+}
+
+// CompilePredicate parses src into a Predicate whose variables are bound in
+// child scopes of s. The script must end with the condition.
+func (s *Scope) CompilePredicate(src string) (*Predicate, error) {
+	body, err := s.transformed(parse(src))
+	if err != nil {
+		return nil, err
+	}
+	p := &Predicate{scope: s, body: body, fields: s.freeIdents(body), src: src}
+	if n := len(body.List); n > 0 {
+		if last, ok := body.List[n-1].(*ast.ExprStmt); ok {
+			if n == 1 {
+				p.cond = last.X
+			}
+			return p, nil
+		}
+	}
+	return nil, errors.New("goeval: predicate does not end with a condition")
+}
+
+// Fields lists the identifiers the condition reads without defining them, sorted
+func (p *Predicate) Fields() []string {
+	return p.fields
+}
+
+// Match evaluates the condition with vars bound as variables, failing unless
+// it evaluates to a bool
+func (p *Predicate) Match(vars map[string]interface{}) (bool, error) {
+	s := p.scope.NewChild()
+	for k, v := range vars {
+		s.Vars[k] = v
+	}
+	var result interface{}
+	var err error
+	if p.cond != nil {
+		result, err = s.run(p.cond)
+	} else {
+		result, err = s.runScript(p.body)
+	}
+	if err != nil {
+		return false, locate(p.src, err, s.Options.VerboseErrors)
+	}
+	ok, isBool := result.(bool)
+	if !isBool {
+		return false, fmt.Errorf("goeval: predicate evaluated to %T, not bool", result)
+	}
+	return ok, nil
+}
@@ -0,0 +1,53 @@
+package goeval
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Level is the safety level of a preset scope, from the most restricted up
+type Level int
+
+const (
+	// Sandboxed scopes compute only: evaluations are bounded in time, nesting,
+	// goroutines and value sizes, and what scripts print is discarded
+	Sandboxed Level = iota
+	// Standard scopes add the sync primitives and print to os.Stdout
+	Standard
+	// Trusted scopes also let the env builtin read the whole process environment
+	Trusted
+)
+
+// NewPresetScope creates a scope with the strings, math and encoding helpers,
+// under the strings, math and encoding namespaces, and the options of level; opts come last and
+// may override them. Conversions and formatting are builtins of every scope.
+func NewPresetScope(level Level, opts ...Option) *Scope {
+	s := NewScope()
+	s.InstallStrings("strings")
+	s.InstallMath("math")
+	s.InstallEncoding("encoding")
+	switch level {
+	case Sandboxed:
+		s.Options.Timeout = time.Second
+		s.Options.MaxDepth = 1000
+		s.Options.MaxGoroutines = 1
+		s.Options.LockTimeout = time.Second
+		s.Options.Output = ioutil.Discard
+		s.Options.Limits = Limits{MaxSliceLen: 1 << 20, MaxMapLen: 1 << 20, MaxStringLen: 1 << 24}
+	case Trusted:
+		s.Options.Env = os.LookupEnv
+		fallthrough
+	case Standard:
+		s.InstallSync("sync")
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// NewScopeWithStdlib creates a Standard preset scope
+func NewScopeWithStdlib(opts ...Option) *Scope {
+	return NewPresetScope(Standard, opts...)
+}
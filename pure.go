@@ -0,0 +1,210 @@
+package goeval
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrImpure is matched by the ImpureError of a pure evaluation attempting a side effect
+var ErrImpure = errors.New("goeval: side effect in a pure evaluation")
+
+// ImpureError reports what a pure evaluation was denied, see Options.Pure
+type ImpureError struct {
+	Op string
+}
+
+func (e *ImpureError) Error() string {
+	return fmt.Sprintf("goeval: %s is not allowed in a pure evaluation", e.Op)
+}
+
+// Is makes errors.Is(err, ErrImpure) hold
+func (e *ImpureError) Is(target error) bool {
+	return target == ErrImpure
+}
+
+var (
+	pureFuncs   sync.Map // code pointer -> bool
+	pureValues  sync.Map // reflect.Value -> bool, for the functions of shared code pointers
+	pureMethods sync.Map // reflect.Type -> map[string]bool, replaced on change
+	pureMu      sync.Mutex
+
+	// the functions built by reflect.MakeFunc, script functions among them,
+	// share a code pointer, and so do method values: they are told apart by
+	// value instead
+	scriptFuncPtr  = reflect.MakeFunc(reflect.TypeOf(func() {}), nil).Pointer()
+	methodValuePtr = reflect.ValueOf(time.Duration(0)).MethodByName("String").Pointer()
+)
+
+// sharedCode tells whether the functions of code pointer p are told apart by value
+func sharedCode(p uintptr) bool {
+	return p == scriptFuncPtr || p == methodValuePtr
+}
+
+func init() {
+	_ = RegisterPure(pureBuiltins...)
+	for _, bundle := range []map[string]interface{}{mathBuiltins, stringBuiltins, encodingBuiltins} {
+		for _, f := range bundle {
+			pureFuncs.Store(reflect.ValueOf(f).Pointer(), true)
+		}
+	}
+	_ = RegisterPureMethods(time.Time{}, "After", "Before", "Equal", "IsZero", "Sub", "Add", "AddDate",
+		"Truncate", "Round", "UTC", "Unix", "UnixNano", "Year", "Month", "Day", "YearDay", "Weekday",
+		"Hour", "Minute", "Second", "Nanosecond", "Format", "String")
+	_ = RegisterPureMethods(time.Duration(0), "Hours", "Minutes", "Seconds", "Milliseconds",
+		"Microseconds", "Nanoseconds", "Truncate", "Round", "String")
+}
+
+// RegisterPure declares host functions free of side effects, so that pure
+// evaluations may call them. Most builtins and the helpers of InstallMath,
+// InstallStrings and InstallEncoding are registered already. Functions built
+// with reflect.MakeFunc and method values are registered one by one, others
+// along with every value of the same function.
+func RegisterPure(fns ...interface{}) error {
+	for _, fn := range fns {
+		v := reflect.ValueOf(fn)
+		if v.Kind() != reflect.Func || v.IsNil() {
+			return fmt.Errorf("goeval: RegisterPure needs functions, not %T", fn)
+		}
+		if sharedCode(v.Pointer()) {
+			pureValues.Store(v, true)
+		} else {
+			pureFuncs.Store(v.Pointer(), true)
+		}
+	}
+	return nil
+}
+
+// RegisterPureMethods declares methods of a type free of side effects, so that
+// pure evaluations may call them. typ is a reflect.Type or a sample value; the
+// methods of time.Time and time.Duration that read them are registered already.
+func RegisterPureMethods(typ interface{}, names ...string) error {
+	t, isType := typ.(reflect.Type)
+	if !isType {
+		t = reflect.TypeOf(typ)
+	}
+	if t == nil {
+		return errors.New("goeval: RegisterPureMethods needs a type")
+	}
+	for _, name := range names {
+		if _, ok := t.MethodByName(name); !ok {
+			if _, ok := reflect.PtrTo(t).MethodByName(name); !ok {
+				return fmt.Errorf("goeval: %v has no method %s", t, name)
+			}
+		}
+	}
+	pureMu.Lock()
+	defer pureMu.Unlock()
+	methods := map[string]bool{}
+	if old, ok := pureMethods.Load(t); ok {
+		for name := range old.(map[string]bool) {
+			methods[name] = true
+		}
+	}
+	for _, name := range names {
+		methods[name] = true
+	}
+	pureMethods.Store(t, methods)
+	return nil
+}
+
+// pureMethod tells whether pure evaluations may call the method name of t,
+// which may point to the type registered
+func pureMethod(t reflect.Type, name string) bool {
+	if methods, ok := pureMethods.Load(t); ok && methods.(map[string]bool)[name] {
+		return true
+	}
+	return t.Kind() == reflect.Ptr && pureMethod(t.Elem(), name)
+}
+
+// pureFunc tells whether pure evaluations in s may call fn: a registered
+// function, or a script function or method value the pure evaluation made
+func (s *Scope) pureFunc(fn interface{}) bool {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return true // not a call, which fails on its own
+	}
+	if !sharedCode(v.Pointer()) {
+		_, ok := pureFuncs.Load(v.Pointer())
+		return ok
+	}
+	if _, ok := pureValues.Load(v); ok {
+		return true
+	}
+	if s.pureMade != nil {
+		_, ok := s.pureMade.Load(v)
+		return ok
+	}
+	return false
+}
+
+// madePure records a script function or method value made by a pure
+// evaluation, whose body runs under the same rules or whose method was
+// checked, so that the evaluation may call it. Script functions made on a
+// pure scope outside of evaluations, with Lambda, are trusted everywhere.
+func (s *Scope) madePure(fn interface{}) {
+	if s.pureMade != nil {
+		s.pureMade.Store(reflect.ValueOf(fn), true)
+	} else {
+		pureValues.Store(reflect.ValueOf(fn), true)
+	}
+}
+
+// checkPureCall fails a call in a pure evaluation unless the function and the
+// functions passed to it are pure
+func (s *Scope) checkPureCall(call *ast.CallExpr, fn interface{}, args []reflect.Value) error {
+	if !s.pureFunc(fn) {
+		return &ImpureError{Op: "calling " + types.ExprString(call.Fun)}
+	}
+	for _, arg := range args {
+		if arg.Kind() == reflect.Func && !arg.IsNil() && !s.pureFunc(arg.Interface()) {
+			return &ImpureError{Op: "passing a function with side effects to " + types.ExprString(call.Fun)}
+		}
+	}
+	return nil
+}
+
+// impureNode reports the statements and expressions a pure evaluation rejects
+func impureNode(node ast.Node) error {
+	switch n := node.(type) {
+	case *ast.AssignStmt:
+		return &ImpureError{Op: "assignment"}
+	case *ast.IncDecStmt:
+		return &ImpureError{Op: n.Tok.String() + " statement"}
+	case *ast.DeclStmt, *ast.GenDecl:
+		return &ImpureError{Op: "declaration"}
+	case *ast.ForStmt, *ast.RangeStmt:
+		return &ImpureError{Op: "loop"}
+	case *ast.GoStmt:
+		return &ImpureError{Op: "go statement"}
+	case *ast.DeferStmt:
+		return &ImpureError{Op: "defer statement"}
+	case *ast.SendStmt:
+		return &ImpureError{Op: "channel send"}
+	case *ast.UnaryExpr:
+		if n.Op == token.ARROW {
+			return &ImpureError{Op: "channel receive"}
+		}
+	}
+	return nil
+}
+
+// checkPure rejects the statements and expressions with side effects of body
+// before a pure evaluation runs it
+func checkPure(body ast.Node) error {
+	var err error
+	ast.Inspect(body, func(node ast.Node) bool {
+		if err == nil {
+			if err = impureNode(node); err != nil {
+				err = positioned(node, err)
+			}
+		}
+		return err == nil
+	})
+	return err
+}
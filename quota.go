@@ -0,0 +1,89 @@
+package goeval
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Quota decides which evaluations may run, eg to enforce the rate of
+// evaluations or the time budget of every tenant. It may be called from
+// several goroutines at once.
+type Quota interface {
+	// Admit is called before an evaluation starts; an error rejects it
+	Admit(ctx context.Context, eval QuotaEval) error
+	// Done is called once an admitted evaluation ends, with how long it ran
+	Done(eval QuotaEval, elapsed time.Duration)
+}
+
+// StatementQuota is a Quota also consulted before every statement an evaluation
+// runs, eg to stop it as soon as the budget of its tenant is spent
+type StatementQuota interface {
+	Quota
+	Statement(eval QuotaEval) error
+}
+
+// QuotaEval identifies an evaluation to a Quota
+type QuotaEval struct {
+	Tenant string // see ContextWithTenant
+	Script string // a hash of the source, the same for every evaluation of it
+}
+
+// QuotaError fails an evaluation that a Quota rejected
+type QuotaError struct {
+	QuotaEval
+	Err error // returned by the Quota
+}
+
+func (e *QuotaError) Error() string {
+	if e.Tenant == "" {
+		return fmt.Sprintf("goeval: quota exceeded: %v", e.Err)
+	}
+	return fmt.Sprintf("goeval: quota of tenant %s exceeded: %v", e.Tenant, e.Err)
+}
+
+func (e *QuotaError) Unwrap() error { return e.Err }
+
+type tenantKey struct{}
+
+// ContextWithTenant returns a copy of ctx naming the tenant its evaluations
+// are accounted to
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// evalQuota is the statement quota of an evaluation, carried by its scopes
+type evalQuota struct {
+	QuotaEval
+	quota StatementQuota
+}
+
+// admitted runs an evaluation of src once the Quota, if any, admits it
+func (s *Scope) admitted(src string, run func(*Scope) (interface{}, error)) (interface{}, error) {
+	quota := s.Options.Quota
+	if quota == nil {
+		return run(s)
+	}
+	ctx := s.context()
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	eval := QuotaEval{Tenant: tenant, Script: scriptHash(src)}
+	if err := quota.Admit(ctx, eval); err != nil {
+		return nil, &QuotaError{QuotaEval: eval, Err: err}
+	}
+	start := time.Now()
+	defer func() { quota.Done(eval, time.Since(start)) }()
+	inner := *s // shares Vars, only carries the statement quota
+	inner.tasks = s.taskGroup()
+	if stmtQuota, ok := quota.(StatementQuota); ok {
+		inner.quota = &evalQuota{QuotaEval: eval, quota: stmtQuota}
+	}
+	return run(&inner)
+}
+
+// check consults the statement quota of the evaluation before a statement
+func (q *evalQuota) check() error {
+	if err := q.quota.Statement(q.QuotaEval); err != nil {
+		return &QuotaError{QuotaEval: q.QuotaEval, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package goeval
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sync"
+)
+
+// The limits on the patterns of the regexp builtins, which scripts may build
+// from their input: Go regexps run in linear time, but the size of the
+// compiled program grows with nested repetitions like (a{1000}){1000}
+const (
+	maxPatternLen   = 4096
+	maxPatternInsts = 20000
+	regexpCacheSize = 256
+)
+
+// regexpCache holds compiled patterns, reset when it fills up
+var regexpCache = struct {
+	sync.RWMutex
+	patterns map[string]*regexp.Regexp
+}{patterns: map[string]*regexp.Regexp{}}
+
+// compilePattern compiles pattern through the cache, rejecting patterns
+// over the limits
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	regexpCache.RLock()
+	re, ok := regexpCache.patterns[pattern]
+	regexpCache.RUnlock()
+	if ok {
+		return re, nil
+	}
+	if len(pattern) > maxPatternLen {
+		return nil, fmt.Errorf("goeval: regexp pattern of %d bytes is too long", len(pattern))
+	}
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, err
+	}
+	if len(prog.Inst) > maxPatternInsts {
+		return nil, fmt.Errorf("goeval: regexp pattern %q is too complex", pattern)
+	}
+	if re, err = regexp.Compile(pattern); err != nil {
+		return nil, err
+	}
+	regexpCache.Lock()
+	if len(regexpCache.patterns) >= regexpCacheSize {
+		regexpCache.patterns = map[string]*regexp.Regexp{}
+	}
+	regexpCache.patterns[pattern] = re
+	regexpCache.Unlock()
+	return re, nil
+}
+
+// Matches reports whether s contains a match of the regular expression pattern.
+// Compiled patterns are cached.
+func Matches(s, pattern string) (bool, error) {
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(s), nil
+}
+
+// FindAll returns the successive matches of the regular expression pattern in s
+//
+//	tags := findAll(text, `#\w+`)
+func FindAll(s, pattern string) ([]string, error) {
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return re.FindAllString(s, -1), nil
+}
+
+// ReplaceRegex replaces the matches of the regular expression pattern in s with
+// repl, in which $1 or ${name} stand for the text of the submatches
+//
+//	masked := replaceRegex(card, `\d{12}(\d{4})`, "************$1")
+func ReplaceRegex(s, pattern, repl string) (string, error) {
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(s, repl), nil
+}
@@ -0,0 +1,84 @@
+package goeval
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// registry holds the builtins and types of every scope of the process. A
+// registry is never modified once published: registering copies it, so that
+// lookups take no lock and scopes can keep a snapshot.
+type registry struct {
+	funcs map[string]interface{}
+	types map[string]reflect.Type
+}
+
+var (
+	registryMu     sync.Mutex   // serializes the registrations
+	globalRegistry atomic.Value // *registry
+)
+
+func init() {
+	globalRegistry.Store(&registry{funcs: builtins, types: builtinTypes})
+}
+
+// currentRegistry returns the registry s uses: the snapshot of the nearest
+// scope holding one, otherwise the process-wide registry
+func (s *Scope) currentRegistry() *registry {
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		if currentScope.registry != nil {
+			return currentScope.registry
+		}
+	}
+	return globalRegistry.Load().(*registry)
+}
+
+// RegisterBuiltin adds or replaces the builtin name for every scope of the
+// process, except those holding a snapshot of the builtins. Unlike SetBuiltin,
+// which is local to a scope tree, it is meant for init code, and is safe for
+// concurrent use with registrations and evaluations.
+func RegisterBuiltin(name string, fn interface{}) error {
+	if name == "" {
+		return fmt.Errorf("goeval: cannot register a builtin without a name")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	old := globalRegistry.Load().(*registry)
+	funcs := make(map[string]interface{}, len(old.funcs)+1)
+	for k, v := range old.funcs {
+		funcs[k] = v
+	}
+	funcs[name] = fn
+	globalRegistry.Store(&registry{funcs: funcs, types: old.types})
+	return nil
+}
+
+// RegisterBuiltinType adds the predeclared type name for every scope of the
+// process, like RegisterBuiltin. The types of the language cannot be replaced.
+func RegisterBuiltinType(name string, typ reflect.Type) error {
+	if _, predeclared := builtinTypes[name]; predeclared {
+		return fmt.Errorf("goeval: cannot replace the predeclared type %s", name)
+	}
+	if name == "" || typ == nil {
+		return fmt.Errorf("goeval: cannot register type %q as %v", name, typ)
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	old := globalRegistry.Load().(*registry)
+	types := make(map[string]reflect.Type, len(old.types)+1)
+	for k, v := range old.types {
+		types[k] = v
+	}
+	types[name] = typ
+	globalRegistry.Store(&registry{funcs: old.funcs, types: types})
+	return nil
+}
+
+// WithBuiltinsSnapshot makes the scope and its children keep the builtins and
+// types registered so far, unaffected by later RegisterBuiltin and
+// RegisterBuiltinType calls
+func WithBuiltinsSnapshot() Option {
+	return func(s *Scope) { s.registry = globalRegistry.Load().(*registry) }
+}
@@ -0,0 +1,89 @@
+package goeval
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// ScanRow reads the current row of rows into a record keyed by column name.
+// []byte values, as drivers return for text columns, become strings.
+func ScanRow(rows *sql.Rows) (map[string]interface{}, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(columns))
+	ptrs := make([]interface{}, len(columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+	record := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		if b, ok := values[i].([]byte); ok {
+			values[i] = string(b)
+		}
+		record[column] = values[i]
+	}
+	return record, nil
+}
+
+// ScanRows reads the remaining rows of rows into records, and closes it
+func ScanRows(rows *sql.Rows) ([]map[string]interface{}, error) {
+	defer rows.Close()
+	var records []map[string]interface{}
+	for rows.Next() {
+		record, err := ScanRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// EachRow evaluates the script for every remaining row of rows, the columns
+// bound as variables, and hands the record and result to fn. It stops at the
+// first error, of the script or of fn, and closes rows.
+func (e *Evaluator) EachRow(rows *sql.Rows, fn func(record map[string]interface{}, result interface{}) error) error {
+	defer rows.Close()
+	for rows.Next() {
+		record, err := ScanRow(rows)
+		if err != nil {
+			return err
+		}
+		result, err := e.Process(record)
+		if err != nil {
+			return err
+		}
+		if err := fn(record, result); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// FilterRecords returns the records for which the script, a condition,
+// evaluates to true
+//
+//	e, _ := s.Compile(`age >= 18 && country == "FR"`)
+//	adults, err := e.FilterRecords(records)
+func (e *Evaluator) FilterRecords(records []map[string]interface{}) ([]map[string]interface{}, error) {
+	var kept []map[string]interface{}
+	for i, record := range records {
+		result, err := e.Process(record)
+		if err != nil {
+			return nil, fmt.Errorf("goeval: record %d: %v", i, err)
+		}
+		keep, ok := result.(bool)
+		if !ok {
+			return nil, fmt.Errorf("goeval: record %d: condition evaluated to %T, not bool", i, result)
+		}
+		if keep {
+			kept = append(kept, record)
+		}
+	}
+	return kept, nil
+}
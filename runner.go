@@ -0,0 +1,62 @@
+package goeval
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// TestResult is the outcome of one script test file run by RunTests
+type TestResult struct {
+	File   string
+	Err    error // nil when the test passed
+	Line   int   // position of the failed assertion, 0 when unknown
+	Column int
+}
+
+// Passed reports whether the test ran without error
+func (r TestResult) Passed() bool {
+	return r.Err == nil
+}
+
+func (r TestResult) String() string {
+	if r.Passed() {
+		return "PASS " + r.File
+	}
+	if r.Line > 0 {
+		return fmt.Sprintf("FAIL %s:%d:%d: %v", r.File, r.Line, r.Column, r.Err)
+	}
+	return fmt.Sprintf("FAIL %s: %v", r.File, r.Err)
+}
+
+// RunTests runs every test_*.gos script in dir, each in a fresh scope prepared
+// by setup, which may be nil. A test fails when its script returns an error,
+// typically from assert or assertEqual.
+func RunTests(dir string, setup func(*Scope)) ([]TestResult, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "test_*.gos"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	results := make([]TestResult, 0, len(files))
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			return results, err
+		}
+		s := NewScope()
+		if setup != nil {
+			setup(s)
+		}
+		result := TestResult{File: file}
+		_, result.Err = s.Eval(string(src))
+		var ae *AssertionError
+		if errors.As(result.Err, &ae) {
+			result.Line, result.Column = position(string(src), ae.Pos)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,301 @@
+package goeval
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job is a script run repeatedly by a Scheduler, either every interval or at
+// the times matched by a cron expression
+type Job struct {
+	Name string
+	Src  string
+	// Every runs the job at this interval, counted from the end of the previous run
+	Every time.Duration
+	// Cron runs the job at the minutes matched by a standard five field cron
+	// expression: minute hour day-of-month month day-of-week, eg "*/15 9-17 * * 1-5"
+	Cron string
+	// Timeout, when set, cancels the context of a run after this long; loops
+	// and context aware host functions stop at cancellation
+	Timeout time.Duration
+	// Vars are set in the fresh child scope of every run
+	Vars map[string]interface{}
+	// Options, when set, replace the options of the scheduler scope for the
+	// runs of the job, eg to sandbox it
+	Options *Options
+}
+
+// Scheduler runs scripts on intervals or cron schedules, each run in a fresh
+// child scope of its scope. Runs of one job never overlap.
+type Scheduler struct {
+	// OnResult, when set, receives the outcome of every scheduled run. It may
+	// be called from several goroutines at once.
+	OnResult func(job string, result interface{}, err error)
+
+	scope  *Scope
+	mu     sync.Mutex
+	jobs   map[string]*scheduledJob
+	ctx    context.Context // set while started
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type scheduledJob struct {
+	Job
+	body *ast.BlockStmt
+	cron *cronSchedule
+	stop chan struct{}
+}
+
+// NewScheduler creates a stopped scheduler running jobs in child scopes of s
+func NewScheduler(s *Scope) *Scheduler {
+	return &Scheduler{scope: s, jobs: map[string]*scheduledJob{}}
+}
+
+// Add registers a job, failing if its script does not parse, its schedule is
+// invalid or its name is taken. Jobs added to a started scheduler start right away.
+func (sc *Scheduler) Add(job Job) error {
+	j := &scheduledJob{Job: job}
+	switch {
+	case job.Every > 0 && job.Cron != "":
+		return fmt.Errorf("goeval: job %q has both an interval and a cron schedule", job.Name)
+	case job.Cron != "":
+		cron, err := parseCron(job.Cron)
+		if err != nil {
+			return fmt.Errorf("goeval: job %q: %v", job.Name, err)
+		}
+		j.cron = cron
+	case job.Every <= 0:
+		return fmt.Errorf("goeval: job %q has no schedule", job.Name)
+	}
+	body, err := sc.scope.transformed(parse(job.Src))
+	if err != nil {
+		return fmt.Errorf("goeval: job %q: %v", job.Name, err)
+	}
+	j.body = body
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if _, exists := sc.jobs[job.Name]; exists {
+		return fmt.Errorf("goeval: job %q already scheduled", job.Name)
+	}
+	sc.jobs[job.Name] = j
+	if sc.ctx != nil {
+		sc.start(j)
+	}
+	return nil
+}
+
+// Remove unschedules a job. A run in progress completes.
+func (sc *Scheduler) Remove(name string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if j, ok := sc.jobs[name]; ok {
+		if j.stop != nil {
+			close(j.stop)
+		}
+		delete(sc.jobs, name)
+	}
+}
+
+// Start runs the jobs on their schedules until Stop
+func (sc *Scheduler) Start() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.ctx != nil {
+		return
+	}
+	sc.ctx, sc.cancel = context.WithCancel(context.Background())
+	for _, j := range sc.jobs {
+		sc.start(j)
+	}
+}
+
+// Stop ends the schedules, cancels the runs in progress and waits for them
+func (sc *Scheduler) Stop() {
+	sc.mu.Lock()
+	if sc.ctx == nil {
+		sc.mu.Unlock()
+		return
+	}
+	sc.cancel()
+	sc.ctx, sc.cancel = nil, nil
+	for _, j := range sc.jobs {
+		close(j.stop)
+		j.stop = nil
+	}
+	sc.mu.Unlock()
+	sc.wg.Wait()
+}
+
+// Run runs a job right away, outside of its schedule
+func (sc *Scheduler) Run(ctx context.Context, name string) (interface{}, error) {
+	sc.mu.Lock()
+	j, ok := sc.jobs[name]
+	sc.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("goeval: no job %q", name)
+	}
+	return sc.run(ctx, j)
+}
+
+// start runs the schedule of j in a goroutine; sc.mu must be held
+func (sc *Scheduler) start(j *scheduledJob) {
+	j.stop = make(chan struct{})
+	sc.wg.Add(1)
+	go func(ctx context.Context, stop chan struct{}) {
+		defer sc.wg.Done()
+		for {
+			timer := time.NewTimer(time.Until(j.next(time.Now())))
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			result, err := sc.run(ctx, j)
+			if sc.OnResult != nil {
+				sc.OnResult(j.Name, result, err)
+			}
+		}
+	}(sc.ctx, j.stop)
+}
+
+func (sc *Scheduler) run(ctx context.Context, j *scheduledJob) (interface{}, error) {
+	if j.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.Timeout)
+		defer cancel()
+	}
+	child := sc.scope.NewChild()
+	if j.Options != nil {
+		child.Options = *j.Options
+	}
+	for k, v := range j.Vars {
+		child.Vars[k] = v
+	}
+	child.ctx = ctx
+	return child.observed(j.Src, func(s *Scope) (interface{}, error) {
+		return s.runScript(j.body)
+	})
+}
+
+// next returns the time of the run following now
+func (j *scheduledJob) next(now time.Time) time.Time {
+	if j.cron == nil {
+		return now.Add(j.Every)
+	}
+	return j.cron.next(now)
+}
+
+// cronSchedule holds the values matched by each field of a cron expression
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+	anyDom, anyDow                bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{{"minute", 0, 59}, {"hour", 0, 23}, {"day of month", 1, 31}, {"month", 1, 12}, {"day of week", 0, 7}}
+
+// parseCron parses a five field cron expression. Fields are *, a value, a
+// range a-b, or lists of those, each optionally with a /step.
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression %q needs %d fields", expr, len(cronFields))
+	}
+	sets := make([]map[int]bool, len(fields))
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("cron %s %q: %v", cronFields[i].name, field, err)
+		}
+		sets[i] = set
+	}
+	if sets[4][7] {
+		sets[4][0] = true // both 0 and 7 are Sunday
+	}
+	return &cronSchedule{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		anyDom: fields[2] == "*", anyDow: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			step, part = n, part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			} else if step > 1 {
+				hi = max // n/step counts from n
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%d-%d out of range %d-%d", lo, hi, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// next returns the first matching minute after t, or t plus five years when
+// none comes, eg for February 30
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case !c.month[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !c.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !c.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return limit
+}
+
+// matchDay follows cron: when both day fields are restricted, either may match
+func (c *cronSchedule) matchDay(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	}
+	return dom || dow
+}
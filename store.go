@@ -0,0 +1,242 @@
+package goeval
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Store persists the variables of a scope, so that several processes can share
+// long-lived session state
+type Store interface {
+	Get(name string) (val interface{}, exists bool, err error)
+	Set(name string, val interface{}) error
+	Delete(name string) error
+	List() ([]string, error)
+}
+
+// NewStoreScope creates a scope backed by store. Its variables are loaded from
+// the store, and the assignments scripts make to them are written through;
+// Refresh picks up changes made by others, Flush saves changes made by the host
+// with Set or made in place, eg to a map.
+func NewStoreScope(store Store) (*Scope, error) {
+	s := NewScope()
+	s.store = store
+	if err := s.Refresh(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Refresh reloads the variables of a store backed scope. Variables no longer in
+// the store are removed.
+func (s *Scope) Refresh() error {
+	if s.store == nil {
+		return nil
+	}
+	names, err := s.store.List()
+	if err != nil {
+		return err
+	}
+	vars := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		val, exists, err := s.store.Get(name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			vars[name] = val
+		}
+	}
+	s.Vars = vars
+	return nil
+}
+
+// Flush writes every variable of a store backed scope to its store
+func (s *Scope) Flush() error {
+	if s.store == nil {
+		return nil
+	}
+	for name, val := range s.Vars {
+		if err := s.store.Set(name, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MemoryStore is a Store keeping values in memory, shared by the scopes of one process
+type MemoryStore struct {
+	mu   sync.RWMutex
+	vars map[string]interface{}
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{vars: map[string]interface{}{}}
+}
+
+// Get returns a stored value
+func (m *MemoryStore) Get(name string) (interface{}, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	val, exists := m.vars[name]
+	return val, exists, nil
+}
+
+// Set stores a value
+func (m *MemoryStore) Set(name string, val interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.vars[name] = val
+	return nil
+}
+
+// Delete removes a value
+func (m *MemoryStore) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.vars, name)
+	return nil
+}
+
+// List returns the stored names, sorted
+func (m *MemoryStore) List() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.vars))
+	for name := range m.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// SQLStore is a Store keeping JSON encoded values in a database table created as
+//
+//	CREATE TABLE goeval_vars (name VARCHAR(255) PRIMARY KEY, value TEXT NOT NULL)
+//
+// Values come back as decoded JSON: numbers as int when integral, else
+// float64, objects as map[string]interface{}, arrays as []interface{}.
+type SQLStore struct {
+	DB    *sql.DB
+	Table string // goeval_vars when empty
+	// Dollar selects $1 style placeholders, as used by PostgreSQL, instead of ?
+	Dollar bool
+}
+
+func (st *SQLStore) query(q string) string {
+	table := st.Table
+	if table == "" {
+		table = "goeval_vars"
+	}
+	q = fmt.Sprintf(q, table)
+	if !st.Dollar {
+		return q
+	}
+	var buf bytes.Buffer
+	n := 0
+	for _, c := range q {
+		if c == '?' {
+			n++
+			buf.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
+
+// Get loads and decodes a value
+func (st *SQLStore) Get(name string) (interface{}, bool, error) {
+	var raw string
+	err := st.DB.QueryRow(st.query("SELECT value FROM %s WHERE name = ?"), name).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	val, err := decodeStored([]byte(raw))
+	return val, err == nil, err
+}
+
+// Set encodes and saves a value, replacing any previous one
+func (st *SQLStore) Set(name string, val interface{}) error {
+	raw, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Errorf("goeval: cannot store %s: %v", name, err)
+	}
+	tx, err := st.DB.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(st.query("DELETE FROM %s WHERE name = ?"), name); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(st.query("INSERT INTO %s (name, value) VALUES (?, ?)"), name, string(raw)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes a value
+func (st *SQLStore) Delete(name string) error {
+	_, err := st.DB.Exec(st.query("DELETE FROM %s WHERE name = ?"), name)
+	return err
+}
+
+// List returns the stored names, sorted
+func (st *SQLStore) List() ([]string, error) {
+	rows, err := st.DB.Query(st.query("SELECT name FROM %s ORDER BY name"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// decodeStored decodes JSON, turning integral numbers into ints
+func decodeStored(raw []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return fromJSONNumbers(v), nil
+}
+
+func fromJSONNumbers(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if i, err := strconv.Atoi(x.String()); err == nil {
+			return i
+		}
+		f, _ := x.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = fromJSONNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range x {
+			x[i] = fromJSONNumbers(e)
+		}
+	}
+	return v
+}
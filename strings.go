@@ -0,0 +1,133 @@
+package goeval
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// stringBuiltins holds the helpers installed by InstallStrings
+var stringBuiltins = map[string]interface{}{
+	"contains":  strings.Contains,
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"index":     strings.Index,
+	"split":     strings.Split,
+	"join":      strings.Join,
+	"replace":   strings.ReplaceAll,
+	"trim":      strings.TrimSpace,
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"format":    fmt.Sprintf,
+	"runeLen":   RuneLen,
+	"runeAt":    RuneAt,
+	"runeSlice": RuneSlice,
+}
+
+// InstallStrings registers the string helpers in the scope. With an empty namespace
+// they are available under their bare names (contains(s, "x")), otherwise under the
+// given namespace (strings.contains(s, "x")).
+func (s *Scope) InstallStrings(namespace string) {
+	s.install(namespace, stringBuiltins)
+}
+
+// RuneLen is like Len but counts the runes of strings rather than their bytes
+func RuneLen(v interface{}) (interface{}, error) {
+	if str, ok := v.(string); ok {
+		return utf8.RuneCountInString(str), nil
+	}
+	return Len(v)
+}
+
+// RuneAt returns the i-th rune of s as a string
+func RuneAt(s string, i int) (string, error) {
+	runes := []rune(s)
+	if i < 0 || i >= len(runes) {
+		return "", fmt.Errorf("rune index %d out of range [0:%d]", i, len(runes))
+	}
+	return string(runes[i]), nil
+}
+
+// RuneSlice returns the runes of s from low up to, but excluding, high
+func RuneSlice(s string, low, high int) (string, error) {
+	runes := []rune(s)
+	if low < 0 || high > len(runes) || low > high {
+		return "", fmt.Errorf("rune slice bounds [%d:%d] out of range [0:%d]", low, high, len(runes))
+	}
+	return string(runes[low:high]), nil
+}
+
+// Builder builds a string in pieces, without the quadratic copying of
+// s = s + x in a loop. Scripts make one with the builder builtin:
+//
+//	b := builder()
+//	for _, name := range names {
+//		b.Write(name, "\n")
+//	}
+//	b.String()
+type Builder struct {
+	sb  strings.Builder
+	max int // Limits.MaxStringLen, 0 for no limit
+}
+
+// NewBuilder returns an empty Builder, the builder builtin
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Write appends parts: strings and byte slices as they are, other values
+// formatted as with sprint
+func (b *Builder) Write(parts ...interface{}) error {
+	for _, part := range parts {
+		switch p := part.(type) {
+		case string:
+			b.sb.WriteString(p)
+		case []byte:
+			b.sb.Write(p)
+		default:
+			fmt.Fprint(&b.sb, p)
+		}
+		if err := checkLimit("MaxStringLen", b.sb.Len(), b.max); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String returns the string built so far
+func (b *Builder) String() string {
+	return b.sb.String()
+}
+
+// Len returns the bytes written so far
+func (b *Builder) Len() int {
+	return b.sb.Len()
+}
+
+// Reset empties the builder
+func (b *Builder) Reset() {
+	b.sb.Reset()
+}
+
+// Concat joins parts into a string as Builder.Write does, in one allocation
+// when they are all strings
+//
+//	label := concat(first, " ", last, " (", age, ")")
+func Concat(parts ...interface{}) (string, error) {
+	return concat(0, parts)
+}
+
+func concat(max int, parts []interface{}) (string, error) {
+	n := 0
+	for _, part := range parts {
+		if p, ok := part.(string); ok {
+			n += len(p)
+		}
+	}
+	b := &Builder{max: max}
+	b.sb.Grow(n)
+	if err := b.Write(parts...); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
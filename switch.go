@@ -0,0 +1,92 @@
+package goeval
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+)
+
+// switchStmt runs a switch: the first clause whose expression equals the tag,
+// or is true for a switch without tag, runs, else the default clause. A
+// fallthrough ending a clause goes on with the next one, and an unlabeled
+// break ends the switch.
+func (s *Scope) switchStmt(stmt *ast.SwitchStmt) (interface{}, error) {
+	cur := s.NewChild()
+	if stmt.Init != nil {
+		if _, err := cur.interpret(stmt.Init); err != nil {
+			return nil, err
+		}
+	}
+	var tag interface{} = true
+	if stmt.Tag != nil {
+		var err error
+		if tag, err = cur.interpret(stmt.Tag); err != nil {
+			return nil, err
+		}
+	}
+	clauses := make([]*ast.CaseClause, len(stmt.Body.List))
+	def := -1
+	for i, st := range stmt.Body.List {
+		clauses[i] = st.(*ast.CaseClause)
+		if clauses[i].List == nil {
+			def = i
+		}
+	}
+	chosen, err := cur.matchCase(clauses, tag, stmt.Tag == nil)
+	if err != nil {
+		return nil, err
+	}
+	if chosen < 0 {
+		chosen = def
+	}
+	var result interface{}
+	for i := chosen; i >= 0 && i < len(clauses); i++ {
+		body, fallsThrough := clauses[i].Body, false
+		if n := len(body); n > 0 {
+			if b, ok := body[n-1].(*ast.BranchStmt); ok && b.Tok == token.FALLTHROUGH {
+				if i == len(clauses)-1 {
+					return nil, fmt.Errorf("goeval: cannot fallthrough final case in switch")
+				}
+				body, fallsThrough = body[:n-1], true
+			}
+		}
+		result, err = cur.NewChild().interpret(&ast.BlockStmt{List: body})
+		if b, ok := err.(*branchSignal); ok && b.tok == token.BREAK && b.label == "" {
+			return nil, nil
+		}
+		if err != nil || !fallsThrough {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// matchCase returns the index of the first clause with an expression equal
+// to tag, or -1. Values of different types are not equal, as for interfaces.
+func (s *Scope) matchCase(clauses []*ast.CaseClause, tag interface{}, boolean bool) (int, error) {
+	for i, clause := range clauses {
+		for _, expr := range clause.List {
+			v, err := s.interpret(expr)
+			if err != nil {
+				return -1, err
+			}
+			if boolean {
+				if _, ok := v.(bool); !ok {
+					return -1, &PosError{Pos: expr.Pos(), Err: fmt.Errorf("goeval: non-bool case %T in switch without expression", v)}
+				}
+			}
+			eq, err := binaryOp(tag, v, token.EQL)
+			if err != nil {
+				if tag != nil && v != nil && reflect.TypeOf(tag) != reflect.TypeOf(v) {
+					continue
+				}
+				return -1, err
+			}
+			if eq == true {
+				return i, nil
+			}
+		}
+	}
+	return -1, nil
+}
@@ -0,0 +1,157 @@
+package goeval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultLockTimeout bounds the waits of the script sync primitives when
+// Options.LockTimeout is not set
+const DefaultLockTimeout = 30 * time.Second
+
+// InstallSync registers constructors for the script sync primitives, under their
+// bare names when namespace is empty, otherwise grouped under the namespace:
+//
+//	mu := sync.newMutex()
+//	wg := sync.newWaitGroup()
+//	once := sync.newOnce()
+//
+// Their blocking methods give up with an error once the lock timeout passes or
+// the context of the evaluation calling them is done, so a deadlocked script
+// cannot hang the host. Scripts do not pass the context, the interpreter does.
+func (s *Scope) InstallSync(namespace string) {
+	s.install(namespace, map[string]interface{}{
+		"newMutex":     func() *Mutex { return &Mutex{waiter: s.waiter(), ch: make(chan struct{}, 1)} },
+		"newWaitGroup": func() *WaitGroup { return &WaitGroup{waiter: s.waiter()} },
+		"newOnce":      func() *Once { return &Once{mu: Mutex{waiter: s.waiter(), ch: make(chan struct{}, 1)}} },
+	})
+}
+
+// waiter bounds blocking operations by the lock timeout
+type waiter struct {
+	timeout time.Duration
+}
+
+func (s *Scope) waiter() waiter {
+	timeout := s.Options.LockTimeout
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+	return waiter{timeout: timeout}
+}
+
+// wait blocks until ready is closed or receives, failing with an error naming op
+// on timeout or when ctx is done
+func (w waiter) wait(ctx context.Context, op string, ready <-chan struct{}) error {
+	timer := time.NewTimer(w.timeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("goeval: %s timed out after %v, possible deadlock", op, w.timeout)
+	case <-ctx.Done():
+		return fmt.Errorf("goeval: %s: %w", op, ctx.Err())
+	}
+}
+
+// Mutex is a mutual exclusion lock for scripts
+type Mutex struct {
+	waiter
+	ch chan struct{}
+}
+
+// Lock acquires the mutex, waiting at most the lock timeout or until ctx is done
+func (m *Mutex) Lock(ctx context.Context) error {
+	select {
+	case m.ch <- struct{}{}:
+		return nil
+	default:
+	}
+	timer := time.NewTimer(m.timeout)
+	defer timer.Stop()
+	select {
+	case m.ch <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("goeval: Lock timed out after %v, possible deadlock", m.timeout)
+	case <-ctx.Done():
+		return fmt.Errorf("goeval: Lock: %w", ctx.Err())
+	}
+}
+
+// Unlock releases the mutex
+func (m *Mutex) Unlock() error {
+	select {
+	case <-m.ch:
+		return nil
+	default:
+		return errors.New("goeval: Unlock of unlocked mutex")
+	}
+}
+
+// WaitGroup waits for a collection of script goroutines to finish
+type WaitGroup struct {
+	waiter
+	mu    sync.Mutex
+	count int
+	zero  chan struct{}
+}
+
+// Add adds delta to the counter
+func (wg *WaitGroup) Add(delta int) error {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	if wg.count+delta < 0 {
+		return errors.New("goeval: negative WaitGroup counter")
+	}
+	if wg.count == 0 && delta > 0 {
+		wg.zero = make(chan struct{})
+	}
+	wg.count += delta
+	if wg.count == 0 && wg.zero != nil {
+		close(wg.zero)
+		wg.zero = nil
+	}
+	return nil
+}
+
+// Done decrements the counter
+func (wg *WaitGroup) Done() error {
+	return wg.Add(-1)
+}
+
+// Wait blocks until the counter is zero, at most the lock timeout or until ctx
+// is done
+func (wg *WaitGroup) Wait(ctx context.Context) error {
+	wg.mu.Lock()
+	zero := wg.zero
+	wg.mu.Unlock()
+	if zero == nil {
+		return nil
+	}
+	return wg.wait(ctx, "Wait", zero)
+}
+
+// Once runs a function only once
+type Once struct {
+	mu   Mutex
+	done bool
+}
+
+// Do calls f unless Do was called before. Concurrent callers wait for the first
+// call to return, at most the lock timeout or until ctx is done.
+func (o *Once) Do(ctx context.Context, f func()) error {
+	if err := o.mu.Lock(ctx); err != nil {
+		return err
+	}
+	defer o.mu.Unlock()
+	if !o.done {
+		o.done = true
+		f()
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package goeval
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"go/ast"
+	"go/types"
+	"time"
+)
+
+// Tracer opens spans for evaluations and the function calls they make. It is
+// small enough to adapt any tracing library, eg an OpenTelemetry trace.Tracer:
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, goeval.Span) {
+//		ctx, span := t.tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is one traced operation
+type Span interface {
+	// SetAttribute records a key/value pair, with values of type string, int,
+	// int64, float64 or bool
+	SetAttribute(key string, value interface{})
+	// RecordError marks the span as failed
+	RecordError(err error)
+	End()
+}
+
+// scriptHash identifies a script in traces without recording its text
+func scriptHash(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	return hex.EncodeToString(sum[:8])
+}
+
+// observed runs an evaluation of src with the quota, tracing and logging configured,
+// locating the PosError it may fail with
+func (s *Scope) observed(src string, run func(*Scope) (interface{}, error)) (interface{}, error) {
+	return s.admitted(src, func(s *Scope) (interface{}, error) {
+		return s.traced(src, func(s *Scope) (interface{}, error) {
+			return s.logged(src, func(s *Scope) (interface{}, error) {
+				result, err := run(s)
+				return result, locate(src, err, s.Options.VerboseErrors)
+			})
+		})
+	})
+}
+
+// traced runs an evaluation of src in a goeval.Eval span when a Tracer is set.
+// The span context becomes the evaluation context, so call spans and context
+// aware host functions see it.
+func (s *Scope) traced(src string, run func(*Scope) (interface{}, error)) (interface{}, error) {
+	tracer := s.Options.Tracer
+	if tracer == nil {
+		return run(s)
+	}
+	ctx, span := tracer.Start(s.context(), "goeval.Eval")
+	defer span.End()
+	span.SetAttribute("goeval.script.hash", scriptHash(src))
+	span.SetAttribute("goeval.script.length", len(src))
+	inner := *s // shares Vars, only carries the span context
+	inner.tasks = s.taskGroup()
+	inner.ctx = ctx
+	start := time.Now()
+	result, err := run(&inner)
+	span.SetAttribute("goeval.duration_ms", float64(time.Since(start))/float64(time.Millisecond))
+	if err != nil {
+		span.RecordError(err)
+	}
+	return result, err
+}
+
+func noTrace(error) {}
+
+// traceCall opens a span for a function call when a Tracer is set, returning
+// the function ending it
+func (s *Scope) traceCall(fun ast.Expr) func(err error) {
+	tracer := s.Options.Tracer
+	if tracer == nil {
+		return noTrace
+	}
+	_, span := tracer.Start(s.context(), "goeval.call "+types.ExprString(fun))
+	return func(err error) {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,62 @@
+package goeval
+
+import (
+	"fmt"
+	"go/ast"
+)
+
+// Transform rewrites the syntax tree of a script between parsing and
+// interpreting, eg to rename deprecated functions, inject instrumentation or
+// enforce a policy. It may modify body in place or return another tree, and
+// fails the evaluation with an error. See Options.Transforms.
+type Transform func(body *ast.BlockStmt) (*ast.BlockStmt, error)
+
+// WithTransforms appends transforms, applied in order, see Options.Transforms
+func WithTransforms(transforms ...Transform) Option {
+	return func(s *Scope) { s.Options.Transforms = append(s.Options.Transforms, transforms...) }
+}
+
+// transformed applies Options.Transforms in order to a freshly parsed body,
+// passing the parse error through
+func (s *Scope) transformed(body *ast.BlockStmt, err error) (*ast.BlockStmt, error) {
+	if err != nil {
+		return nil, err
+	}
+	for i, transform := range s.Options.Transforms {
+		if body, err = transform(body); err != nil {
+			return nil, err
+		}
+		if body == nil {
+			return nil, fmt.Errorf("goeval: transform %d returned no script", i)
+		}
+	}
+	return body, nil
+}
+
+// parseEval parses src for an evaluation in s, from the cache unless
+// transforms apply, as the trees they rewrite are not shared
+func (s *Scope) parseEval(src string) (*ast.BlockStmt, error) {
+	if len(s.Options.Transforms) == 0 {
+		return parseCached(src)
+	}
+	return s.transformed(parse(src))
+}
+
+// RenameFuncs is a Transform renaming the functions called by scripts, by
+// the old names of renames, eg to keep deprecated names working
+func RenameFuncs(renames map[string]string) Transform {
+	return func(body *ast.BlockStmt) (*ast.BlockStmt, error) {
+		ast.Inspect(body, func(n ast.Node) bool {
+			if call, ok := n.(*ast.CallExpr); ok {
+				if ident, ok := call.Fun.(*ast.Ident); ok {
+					if name, renamed := renames[ident.Name]; renamed {
+						ident.Name = name
+						ident.Obj = nil // the new name is resolved like a free identifier
+					}
+				}
+			}
+			return true
+		})
+		return body, nil
+	}
+}
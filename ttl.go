@@ -0,0 +1,97 @@
+package goeval
+
+import (
+	"sync"
+	"time"
+)
+
+// SetWithTTL sets a variable in s itself which expires after d: once the
+// deadline passes, the variable is no longer visible and Get evicts it, so
+// that cached lookups or session values do not pile up in long-lived scopes.
+// Assignments keep the deadline until it passes; d <= 0 removes it.
+func (s *Scope) SetWithTTL(name string, val interface{}, d time.Duration) {
+	s.Vars[name] = val
+	if d <= 0 {
+		delete(s.expiry, name)
+	} else {
+		if s.expiry == nil {
+			s.expiry = map[string]time.Time{}
+		}
+		s.expiry[name] = time.Now().Add(d)
+	}
+	s.notifyWatches(name)
+}
+
+// TTL returns the time left before the variable of s expires, and false when
+// it has no deadline
+func (s *Scope) TTL(name string) (time.Duration, bool) {
+	deadline, ok := s.expiry[name]
+	if !ok {
+		return 0, false
+	}
+	left := time.Until(deadline)
+	if left < 0 {
+		left = 0
+	}
+	return left, true
+}
+
+// expired reports whether the variable of s is past its deadline
+func (s *Scope) expired(name string) bool {
+	if s.expiry == nil {
+		return false
+	}
+	deadline, ok := s.expiry[name]
+	return ok && !time.Now().Before(deadline)
+}
+
+// evict removes the variable of s if it expired
+func (s *Scope) evict(name string) bool {
+	if !s.expired(name) {
+		return false
+	}
+	delete(s.Vars, name)
+	delete(s.expiry, name)
+	return true
+}
+
+// renew drops the deadline of an expired variable being assigned again
+func (s *Scope) renew(name string) {
+	if s.expired(name) {
+		delete(s.expiry, name)
+	}
+}
+
+// Expire removes the expired variables of s and returns how many it removed.
+// Like Set, it must not run concurrently with evaluations in s.
+func (s *Scope) Expire() (n int) {
+	for name := range s.expiry {
+		if s.evict(name) {
+			n++
+		}
+	}
+	return
+}
+
+// StartJanitor calls Expire every interval until stop is called, for scopes
+// idle long enough that lazy eviction never runs. The janitor holds mu while
+// expiring, the lock the caller holds around its evaluations and Sets in s.
+func (s *Scope) StartJanitor(interval time.Duration, mu sync.Locker) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				mu.Lock()
+				s.Expire()
+				mu.Unlock()
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,216 @@
+package goeval
+
+import (
+	"errors"
+	"go/ast"
+	"go/token"
+	"strconv"
+	"sync/atomic"
+)
+
+// value is the internal representation of the operands and results of binary
+// expressions. Ints, float64s and bools are held unboxed, so that nested
+// arithmetic and comparisons only box their final result; other values are
+// held boxed, as the interpreter returns them.
+type value struct {
+	kind valueKind
+	n    int64 // ints, and bools as 0 or 1
+	f    float64
+	x    interface{} // boxedValue
+}
+
+type valueKind uint8
+
+const (
+	boxedValue valueKind = iota
+	intValue
+	floatValue
+	boolValue
+)
+
+// unboxed returns the value of v
+func unboxed(v interface{}) value {
+	switch t := v.(type) {
+	case int:
+		return value{kind: intValue, n: int64(t)}
+	case float64:
+		return value{kind: floatValue, f: t}
+	case bool:
+		if t {
+			return value{kind: boolValue, n: 1}
+		}
+		return value{kind: boolValue}
+	}
+	return value{x: v}
+}
+
+// boxed returns v as the interpreter returns values
+func (v value) boxed() interface{} {
+	switch v.kind {
+	case intValue:
+		return int(v.n)
+	case floatValue:
+		return v.f
+	case boolValue:
+		return v.n != 0
+	}
+	return v.x
+}
+
+func boolOf(b bool) value {
+	if b {
+		return value{kind: boolValue, n: 1}
+	}
+	return value{kind: boolValue}
+}
+
+var errDivideByZero = errors.New("goeval: integer divide by zero")
+
+// binaryValue computes op on unboxed operands of the same kind, as binaryOp
+// does on their boxed forms; ok is false for the operands and operators it
+// leaves to binaryOp
+func binaryValue(x, y value, op token.Token) (v value, ok bool, err error) {
+	if x.kind != y.kind {
+		return value{}, false, nil
+	}
+	switch x.kind {
+	case intValue:
+		a, b := int(x.n), int(y.n) // wrapping around like int does
+		switch op {
+		case token.ADD:
+			return value{kind: intValue, n: int64(a + b)}, true, nil
+		case token.SUB:
+			return value{kind: intValue, n: int64(a - b)}, true, nil
+		case token.MUL:
+			return value{kind: intValue, n: int64(a * b)}, true, nil
+		case token.QUO, token.REM:
+			if b == 0 {
+				return value{}, true, errDivideByZero
+			}
+			if op == token.QUO {
+				return value{kind: intValue, n: int64(a / b)}, true, nil
+			}
+			return value{kind: intValue, n: int64(a % b)}, true, nil
+		case token.AND:
+			return value{kind: intValue, n: int64(a & b)}, true, nil
+		case token.OR:
+			return value{kind: intValue, n: int64(a | b)}, true, nil
+		case token.XOR:
+			return value{kind: intValue, n: int64(a ^ b)}, true, nil
+		case token.AND_NOT:
+			return value{kind: intValue, n: int64(a &^ b)}, true, nil
+		case token.EQL:
+			return boolOf(a == b), true, nil
+		case token.NEQ:
+			return boolOf(a != b), true, nil
+		case token.LSS:
+			return boolOf(a < b), true, nil
+		case token.GTR:
+			return boolOf(a > b), true, nil
+		case token.LEQ:
+			return boolOf(a <= b), true, nil
+		case token.GEQ:
+			return boolOf(a >= b), true, nil
+		}
+	case floatValue:
+		a, b := x.f, y.f
+		switch op {
+		case token.ADD:
+			return value{kind: floatValue, f: a + b}, true, nil
+		case token.SUB:
+			return value{kind: floatValue, f: a - b}, true, nil
+		case token.MUL:
+			return value{kind: floatValue, f: a * b}, true, nil
+		case token.QUO:
+			return value{kind: floatValue, f: a / b}, true, nil
+		case token.EQL:
+			return boolOf(a == b), true, nil
+		case token.NEQ:
+			return boolOf(a != b), true, nil
+		case token.LSS:
+			return boolOf(a < b), true, nil
+		case token.GTR:
+			return boolOf(a > b), true, nil
+		case token.LEQ:
+			return boolOf(a <= b), true, nil
+		case token.GEQ:
+			return boolOf(a >= b), true, nil
+		}
+	case boolValue:
+		a, b := x.n != 0, y.n != 0
+		switch op {
+		case token.LAND:
+			return boolOf(a && b), true, nil
+		case token.LOR:
+			return boolOf(a || b), true, nil
+		case token.EQL:
+			return boolOf(a == b), true, nil
+		case token.NEQ:
+			return boolOf(a != b), true, nil
+		}
+	}
+	return value{}, false, nil
+}
+
+// binaryExpr evaluates a binary expression to a value, its operands too when
+// they are binary expressions or number literals
+func (s *Scope) binaryExpr(expr *ast.BinaryExpr) (value, error) {
+	x, err := s.operand(expr.X)
+	if err != nil {
+		return value{}, err
+	}
+	y, err := s.operand(expr.Y)
+	if err != nil {
+		return value{}, err
+	}
+	if v, ok, err := binaryValue(x, y, expr.Op); ok {
+		return v, err
+	}
+	xI, yI := x.boxed(), y.boxed()
+	if s.Options.DeepEqual && (!isComparable(xI) || !isComparable(yI)) {
+		r, err := deepEqualOp(xI, yI, expr.Op)
+		return unboxed(r), err
+	}
+	r, err := s.binaryOp(xI, yI, expr.Op)
+	return unboxed(r), err
+}
+
+// operand evaluates an operand of a binary expression. Explain records every
+// node, so that its operands are interpreted one by one.
+func (s *Scope) operand(expr ast.Expr) (value, error) {
+	if s.explain == nil {
+		switch expr.(type) {
+		case *ast.BinaryExpr, *ast.ParenExpr:
+			// nested like interpreted nodes
+			if s.depth != nil {
+				defer atomic.AddInt32(s.depth, -1)
+				if atomic.AddInt32(s.depth, 1) > s.maxDepth() {
+					return value{}, ErrTooDeep
+				}
+			}
+		}
+		switch e := expr.(type) {
+		case *ast.BinaryExpr:
+			v, err := s.binaryExpr(e)
+			if err != nil && s.Options.VerboseErrors {
+				err = positioned(e, err)
+			}
+			return v, err
+		case *ast.ParenExpr:
+			return s.operand(e.X)
+		case *ast.BasicLit:
+			switch e.Kind {
+			case token.INT:
+				if n, err := strconv.ParseInt(e.Value, 0, 64); err == nil && int64(int(n)) == n {
+					return value{kind: intValue, n: n}, nil
+				}
+			case token.FLOAT:
+				if f, err := strconv.ParseFloat(e.Value, 64); err == nil {
+					return value{kind: floatValue, f: f}, nil
+				}
+			}
+		}
+	}
+	v, err := s.interpret(expr)
+	return unboxed(v), err
+}
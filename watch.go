@@ -0,0 +1,119 @@
+package goeval
+
+import (
+	"reflect"
+	"sync"
+)
+
+// WatchFunc is called when the value of a watched expression changes, with
+// its new and previous values; err is set when the re-evaluation failed
+type WatchFunc func(val, old interface{}, err error)
+
+// Watch is an expression re-evaluated whenever a variable it reads is set,
+// to keep derived values over a scope up to date, see Scope.Watch
+type Watch struct {
+	mu      sync.Mutex
+	scope   *Scope
+	eval    *Evaluator
+	fn      WatchFunc
+	val     interface{}
+	err     error
+	running bool // an update is evaluating the expression
+	dirty   bool // a dependency was set during the running update
+}
+
+// maxWatchRounds bounds the re-evaluations of one update, in case the
+// expression keeps setting its own dependencies
+const maxWatchRounds = 100
+
+// Watch evaluates src and re-evaluates it whenever a variable it reads without
+// defining is set with Set, SetWithTTL or by a script, in s or in a child of
+// s, calling fn when the value changes, as compared by reflect.DeepEqual.
+// Variables set in a parent of s directly are not seen.
+//
+//	w, err := s.Watch(`price * quantity`, func(val, old interface{}, err error) {
+//		fmt.Println("total", old, "->", val)
+//	})
+func (s *Scope) Watch(src string, fn WatchFunc) (*Watch, error) {
+	e, err := s.Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	w := &Watch{scope: s, eval: e, fn: fn}
+	w.val, w.err = e.Process(nil)
+	if s.watches == nil {
+		s.watches = map[string][]*Watch{}
+	}
+	for _, name := range e.Fields() {
+		s.watches[name] = append(s.watches[name], w)
+	}
+	return w, nil
+}
+
+// Value returns the value of the expression at its last evaluation
+func (w *Watch) Value() (interface{}, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.val, w.err
+}
+
+// Deps lists the variables the expression depends on, sorted
+func (w *Watch) Deps() []string {
+	return w.eval.Fields()
+}
+
+// Stop unregisters the watch; fn is not called anymore
+func (w *Watch) Stop() {
+	for _, name := range w.eval.Fields() {
+		watches := w.scope.watches[name]
+		for i, other := range watches {
+			if other == w {
+				w.scope.watches[name] = append(watches[:i:i], watches[i+1:]...)
+				break
+			}
+		}
+		if len(w.scope.watches[name]) == 0 {
+			delete(w.scope.watches, name)
+		}
+	}
+}
+
+// update re-evaluates the expression, calling fn if its value changed. An
+// update requested while one runs, by the expression itself or another
+// goroutine, makes the running one evaluate again.
+func (w *Watch) update() {
+	w.mu.Lock()
+	if w.running {
+		w.dirty = true
+		w.mu.Unlock()
+		return
+	}
+	w.running = true
+	for rounds := 0; rounds < maxWatchRounds; rounds++ {
+		w.dirty = false
+		w.mu.Unlock()
+		val, err := w.eval.Process(nil)
+		w.mu.Lock()
+		old, oldErr := w.val, w.err
+		w.val, w.err = val, err
+		if !reflect.DeepEqual(val, old) || (err == nil) != (oldErr == nil) {
+			w.mu.Unlock()
+			w.fn(val, old, err)
+			w.mu.Lock()
+		}
+		if !w.dirty {
+			break
+		}
+	}
+	w.running = false
+	w.mu.Unlock()
+}
+
+// notifyWatches updates the watches of s and its parents depending on name
+func (s *Scope) notifyWatches(name string) {
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		for _, w := range currentScope.watches[name] {
+			w.update()
+		}
+	}
+}
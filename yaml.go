@@ -0,0 +1,157 @@
+package goeval
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GetYAML encodes the named variable as YAML. Values go through the same
+// marshalers and json struct tags as GetJSON.
+func (s *Scope) GetYAML(name string) ([]byte, error) {
+	return s.toYAML(s.Get(name))
+}
+
+// GetYamlString encodes the named variable as YAML, returning "null" on failure
+func (s *Scope) GetYamlString(name string) string {
+	b, err := s.GetYAML(name)
+	if err != nil {
+		return "null\n"
+	}
+	return string(b)
+}
+
+// ExportYAML encodes every variable visible from the scope as a YAML mapping.
+// Inner scopes shadow outer ones.
+func (s *Scope) ExportYAML() ([]byte, error) {
+	vars := map[string]interface{}{}
+	for currentScope := s; currentScope != nil; currentScope = currentScope.Parent {
+		for k, v := range currentScope.Vars {
+			if _, shadowed := vars[k]; !shadowed {
+				vars[k] = v
+			}
+		}
+	}
+	return s.toYAML(vars)
+}
+
+func (s *Scope) toYAML(v interface{}) ([]byte, error) {
+	v, err := s.exportValue(v)
+	if err != nil {
+		return nil, err
+	}
+	// round trip through JSON to get a plain tree honouring json tags and marshalers
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	writeYAML(buf, tree, 0)
+	return buf.Bytes(), nil
+}
+
+// writeYAML writes a decoded JSON tree in YAML block style
+func writeYAML(buf *bytes.Buffer, v interface{}, indent int) {
+	pad := strings.Repeat("  ", indent)
+	switch x := v.(type) {
+	case map[string]interface{}:
+		if len(x) == 0 {
+			buf.WriteString("{}\n")
+			return
+		}
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			buf.WriteString(pad + yamlScalar(k) + ":")
+			writeYAMLChild(buf, x[k], indent)
+		}
+	case []interface{}:
+		if len(x) == 0 {
+			buf.WriteString("[]\n")
+			return
+		}
+		for _, item := range x {
+			buf.WriteString(pad + "-")
+			writeYAMLChild(buf, item, indent)
+		}
+	default:
+		buf.WriteString(yamlScalar(v) + "\n")
+	}
+}
+
+// writeYAMLChild writes the value of a mapping entry or sequence item
+func writeYAMLChild(buf *bytes.Buffer, v interface{}, indent int) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		if len(x) > 0 {
+			buf.WriteString("\n")
+			writeYAML(buf, x, indent+1)
+			return
+		}
+	case []interface{}:
+		if len(x) > 0 {
+			buf.WriteString("\n")
+			writeYAML(buf, x, indent+1)
+			return
+		}
+	}
+	buf.WriteString(" ")
+	writeYAML(buf, v, indent+1)
+}
+
+func yamlScalar(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(x)
+	case json.Number:
+		return x.String()
+	case map[string]interface{}:
+		return "{}"
+	case []interface{}:
+		return "[]"
+	case string:
+		if yamlPlain(x) {
+			return x
+		}
+		return strconv.Quote(x)
+	}
+	return strconv.Quote(fmt.Sprint(v))
+}
+
+// yamlPlain reports whether s can be written unquoted without changing its meaning
+func yamlPlain(s string) bool {
+	if s == "" || s[0] == ' ' || s[len(s)-1] == ' ' {
+		return false
+	}
+	switch strings.ToLower(s) {
+	case "null", "~", "true", "false", "yes", "no", "on", "off", "y", "n":
+		return false
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		case r >= '0' && r <= '9', r == ' ', r == '.', r == '/':
+		case r == '-' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}